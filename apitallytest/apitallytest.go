@@ -0,0 +1,316 @@
+// Package apitallytest provides helpers for integration tests of applications using the
+// Apitally middleware, to assert the requests and errors it captures without sending
+// any data to Apitally.
+//
+// Install must be called before the middleware is created, e.g.:
+//
+//	func TestGetItems(t *testing.T) {
+//		apitallytest.Install(t)
+//		r := setupRouter() // registers the Apitally middleware
+//
+//		w := httptest.NewRecorder()
+//		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+//
+//		apitallytest.AssertRequestCounted(t, http.MethodGet, "/items", http.StatusOK)
+//	}
+//
+// As there's a single Apitally client per process, tests using Install must not run
+// in parallel.
+package apitallytest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apitally/apitally-go/internal"
+)
+
+type (
+	// CountedRequest is an aggregate of requests counted by the middleware, as sent to
+	// the hub in one sync.
+	CountedRequest = internal.RequestsItem
+
+	// LoggedRequest is a request logged by the middleware.
+	LoggedRequest = internal.RequestLogItem
+
+	// ServerError is an aggregate of server errors captured by the middleware, as sent
+	// to the hub in one sync.
+	ServerError = internal.ServerErrorsItem
+)
+
+var (
+	currentHub *Hub
+	mutex      sync.Mutex
+)
+
+// Hub is a mock of the Apitally hub, recording the data sent by the client.
+type Hub struct {
+	endpoints      []string
+	requests       []CountedRequest
+	loggedRequests []LoggedRequest
+	serverErrors   []ServerError
+	mutex          sync.Mutex
+}
+
+// Install resets the Apitally client and sets up a mock hub, which the client initialized
+// next (i.e. by the middleware) sends data to instead of Apitally. The client is shut
+// down and reset when the test finishes.
+func Install(t testing.TB) *Hub {
+	t.Helper()
+	resetClient()
+
+	hub := &Hub{}
+	internal.SetTransport(hub)
+	mutex.Lock()
+	currentHub = hub
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		resetClient()
+		internal.SetTransport(nil)
+		mutex.Lock()
+		currentHub = nil
+		mutex.Unlock()
+	})
+	return hub
+}
+
+func resetClient() {
+	if client := internal.GetApitallyClient(); client != nil {
+		client.Shutdown()
+	}
+	internal.ResetApitallyClient()
+}
+
+// Endpoints returns the hub endpoints the client sent requests to, in order, e.g.
+// "startup", "sync" and "log".
+func (h *Hub) Endpoints() []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return slices.Clone(h.endpoints)
+}
+
+// RoundTrip implements http.RoundTripper, decoding the data sent by the client.
+func (h *Hub) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	endpoint := path.Base(req.URL.Path)
+	statusCode := http.StatusAccepted
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.endpoints = append(h.endpoints, endpoint)
+	switch endpoint {
+	case "sync":
+		var payload internal.SyncPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			statusCode = http.StatusUnprocessableEntity
+			break
+		}
+		h.requests = append(h.requests, payload.Requests...)
+		h.serverErrors = append(h.serverErrors, payload.ServerErrors...)
+	case "log":
+		if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+			// The client falls back to JSON for subsequent request logs
+			statusCode = http.StatusUnsupportedMediaType
+			break
+		}
+		items, err := decodeLogItems(body)
+		if err != nil {
+			statusCode = http.StatusUnprocessableEntity
+			break
+		}
+		h.loggedRequests = append(h.loggedRequests, items...)
+	}
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Body:       http.NoBody,
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+// decodeLogItems decodes gzipped request log data with one JSON item per line.
+func decodeLogItems(body []byte) ([]LoggedRequest, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var items []LoggedRequest
+	decoder := json.NewDecoder(reader)
+	for {
+		var item LoggedRequest
+		if err := decoder.Decode(&item); errors.Is(err, io.EOF) {
+			return items, nil
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// collect flushes the data captured by the client since the last sync to the hub. Logged
+// requests that haven't been written to a file yet are taken from the client directly.
+func (h *Hub) collect() {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return
+	}
+	_ = client.Flush()
+	loggedRequests := client.RequestLogger.GetPendingWrites()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.loggedRequests = append(h.loggedRequests, loggedRequests...)
+}
+
+func getHub(t testing.TB) *Hub {
+	t.Helper()
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if currentHub == nil {
+		t.Errorf("apitallytest.Install must be called before asserting captured data")
+	}
+	return currentHub
+}
+
+// CountedRequests returns the requests counted by the middleware since Install was
+// called, aggregated by consumer, method, path and status code for each sync.
+func CountedRequests(t testing.TB) []CountedRequest {
+	t.Helper()
+	hub := getHub(t)
+	if hub == nil {
+		return nil
+	}
+	hub.collect()
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return slices.Clone(hub.requests)
+}
+
+// LoggedRequests returns the requests logged by the middleware since Install was called.
+func LoggedRequests(t testing.TB) []LoggedRequest {
+	t.Helper()
+	hub := getHub(t)
+	if hub == nil {
+		return nil
+	}
+	hub.collect()
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return slices.Clone(hub.loggedRequests)
+}
+
+// ServerErrors returns the server errors captured by the middleware since Install was
+// called, aggregated by consumer, method, path and error for each sync.
+func ServerErrors(t testing.TB) []ServerError {
+	t.Helper()
+	hub := getHub(t)
+	if hub == nil {
+		return nil
+	}
+	hub.collect()
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return slices.Clone(hub.serverErrors)
+}
+
+// AssertRequestCounted asserts that a request with the given method, path (the route
+// pattern, e.g. "/items/{id}") and status code was counted by the middleware.
+func AssertRequestCounted(t testing.TB, method, path string, statusCode int) bool {
+	t.Helper()
+	requests := CountedRequests(t)
+	for _, request := range requests {
+		if request.Method == method && request.Path == path && request.StatusCode == statusCode {
+			return true
+		}
+	}
+
+	captured := make([]string, len(requests))
+	for i, request := range requests {
+		captured[i] = fmt.Sprintf("%s %s %d", request.Method, request.Path, request.StatusCode)
+	}
+	t.Errorf("Request %s %s with status code %d wasn't counted%s", method, path, statusCode, describeCaptured(captured))
+	return false
+}
+
+// AssertRequestLogged asserts that a request with the given method, path (the route
+// pattern, e.g. "/items/{id}") and status code was logged by the middleware.
+func AssertRequestLogged(t testing.TB, method, path string, statusCode int) bool {
+	t.Helper()
+	items := LoggedRequests(t)
+	for _, item := range items {
+		if item.Request.Method == method && item.Request.Path == path && item.Response.StatusCode == statusCode {
+			return true
+		}
+	}
+
+	captured := make([]string, len(items))
+	for i, item := range items {
+		captured[i] = fmt.Sprintf("%s %s %d", item.Request.Method, item.Request.Path, item.Response.StatusCode)
+	}
+	t.Errorf("Request %s %s with status code %d wasn't logged%s", method, path, statusCode, describeCaptured(captured))
+	return false
+}
+
+// AssertServerError asserts that a server error of the given type (e.g.
+// "errors.errorString") was captured by the middleware for a request with the given
+// method and path.
+func AssertServerError(t testing.TB, method, path, errorType string) bool {
+	t.Helper()
+	serverErrors := ServerErrors(t)
+	for _, serverError := range serverErrors {
+		if serverError.Method == method && serverError.Path == path && serverError.Type == errorType {
+			return true
+		}
+	}
+
+	captured := make([]string, len(serverErrors))
+	for i, serverError := range serverErrors {
+		captured[i] = fmt.Sprintf("%s %s %s", serverError.Method, serverError.Path, serverError.Type)
+	}
+	t.Errorf("Server error %s for %s %s wasn't captured%s", errorType, method, path, describeCaptured(captured))
+	return false
+}
+
+// describeCaptured lists the captured data in a failure message, or explains why nothing
+// was captured if the client isn't initialized or disabled.
+func describeCaptured(captured []string) string {
+	if len(captured) > 0 {
+		return "\nCaptured:\n  " + strings.Join(captured, "\n  ")
+	}
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return " (the Apitally client isn't initialized, the middleware must be created after apitallytest.Install)"
+	}
+	if !client.IsEnabled() {
+		return " (the Apitally client is disabled, check the client ID and env in the config)"
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package apitallytest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/apitally/apitally-go/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func initClient(requestLoggingEnabled bool) *internal.ApitallyClient {
+	config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	config.Env = "test"
+	config.RequestLogging.Enabled = requestLoggingEnabled
+	config.DisableSync = true
+	return internal.InitApitallyClient(*config)
+}
+
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, format)
+}
+
+func TestApitallytest(t *testing.T) {
+	t.Run("CountedRequests", func(t *testing.T) {
+		hub := Install(t)
+		client := initClient(false)
+		client.SetStartupData([]common.PathInfo{}, map[string]string{}, "test")
+
+		client.RequestCounter.AddRequest(internal.RequestInfo{Method: "GET", Path: "/items", StatusCode: 200})
+		assert.True(t, AssertRequestCounted(t, "GET", "/items", 200))
+
+		client.RequestCounter.AddRequest(internal.RequestInfo{Method: "GET", Path: "/items", StatusCode: 200})
+		client.RequestCounter.AddRequest(internal.RequestInfo{Method: "POST", Path: "/items", StatusCode: 201})
+		assert.True(t, AssertRequestCounted(t, "POST", "/items", 201))
+
+		requests := CountedRequests(t)
+		assert.Len(t, requests, 3)
+		assert.Equal(t, []string{"startup", "sync", "sync", "sync"}, hub.Endpoints())
+	})
+
+	t.Run("LoggedRequests", func(t *testing.T) {
+		Install(t)
+		client := initClient(true)
+
+		request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/items/{id}", URL: "http://test/items/1"}
+		response := &common.Response{StatusCode: 404, ResponseTime: 0.1}
+		client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		assert.True(t, AssertRequestLogged(t, "GET", "/items/{id}", 404))
+		assert.Len(t, LoggedRequests(t), 1)
+	})
+
+	t.Run("ServerErrors", func(t *testing.T) {
+		Install(t)
+		client := initClient(false)
+
+		client.ServerErrorCounter.AddServerError("", "GET", "/items", errors.New("test"), "")
+		assert.True(t, AssertServerError(t, "GET", "/items", "errors.errorString"))
+		assert.Len(t, ServerErrors(t), 1)
+	})
+
+	t.Run("FailedAssertions", func(t *testing.T) {
+		Install(t)
+		client := initClient(false)
+		client.RequestCounter.AddRequest(internal.RequestInfo{Method: "GET", Path: "/items", StatusCode: 200})
+
+		recorder := &recordingT{TB: t}
+		assert.False(t, AssertRequestCounted(recorder, "GET", "/items", 500))
+		assert.False(t, AssertRequestLogged(recorder, "GET", "/items", 200))
+		assert.False(t, AssertServerError(recorder, "GET", "/items", "errors.errorString"))
+		assert.Len(t, recorder.errors, 3)
+	})
+
+	t.Run("NotInstalled", func(t *testing.T) {
+		recorder := &recordingT{TB: t}
+		assert.Nil(t, CountedRequests(recorder))
+		assert.Len(t, recorder.errors, 1)
+	})
+}
@@ -0,0 +1,128 @@
+package apitally
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/apitally/apitally-go/internal"
+)
+
+// UpdateConfig applies changes to the client ID, env and request logging config
+// (including masking rules) at runtime, without restarting the application.
+// All other config fields are left unchanged.
+func UpdateConfig(config *Config) error {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return ErrNotInitialized
+	}
+	return client.UpdateConfig(*config)
+}
+
+// Flush immediately sends aggregated metrics and request logs to Apitally, instead of
+// waiting for the next sync. Errors can be checked using errors.Is, e.g. against
+// ErrHubUnreachable or ErrPaymentRequired.
+func Flush() error {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return ErrNotInitialized
+	}
+	return client.Flush()
+}
+
+// GetStatus returns the status of the Apitally client, including the time of the last
+// successful sync, queued data and counters of dropped items.
+func GetStatus() (Status, error) {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return Status{}, ErrNotInitialized
+	}
+	return client.Status(), nil
+}
+
+// GetSnapshot returns a copy of the metrics aggregated since the last sync (i.e. within
+// the last minute) and of the consumers seen since startup, e.g. to show recent traffic
+// on an admin page or health endpoint. It doesn't affect the data sent to Apitally.
+func GetSnapshot() (Snapshot, error) {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return Snapshot{}, ErrNotInitialized
+	}
+	return client.Snapshot(), nil
+}
+
+// Diagnose checks for common misconfigurations, such as recovery middleware registered
+// after the Apitally middleware, and returns the findings. The same checks are run at
+// startup, logging a warning for each finding.
+func Diagnose() ([]Finding, error) {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return nil, ErrNotInitialized
+	}
+	return client.Diagnose(), nil
+}
+
+// Heartbeat reports that the scheduled job with the given name (e.g. a cron job) has
+// run. Configure the expected intervals between heartbeats using
+// Config.HeartbeatIntervals, so that missed runs can trigger alerts.
+func Heartbeat(jobName string) error {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return ErrNotInitialized
+	}
+	client.HeartbeatRegistry.AddHeartbeat(jobName)
+	return nil
+}
+
+// ForgetConsumer removes the consumer with the given identifier from the client and
+// requests Apitally to delete it along with its data, e.g. to comply with a request
+// for erasure under the GDPR. The consumer is deleted with the next sync.
+func ForgetConsumer(identifier string) error {
+	client := internal.GetApitallyClient()
+	if client == nil {
+		return ErrNotInitialized
+	}
+	client.ForgetConsumer(identifier)
+	return nil
+}
+
+// TrackTask runs fn and counts it as an execution of the background task with the given
+// name (e.g. a cron job or queue consumer), along with its duration and whether it
+// failed (returned an error) or panicked. Panics are re-raised. The task is run without
+// being tracked if the client isn't initialized.
+func TrackTask(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	client := internal.GetApitallyClient()
+	if client == nil || !client.IsEnabled() {
+		return fn(ctx)
+	}
+	return client.TaskCounter.Track(ctx, name, fn)
+}
+
+// TrackMessage runs fn to process a message consumed from a queue or topic and counts
+// it for the given messaging system (e.g. "kafka", "nats" or "sqs") and queue, along
+// with its processing duration and whether it failed (returned an error) or panicked.
+// Panics are re-raised. Call it for each message, e.g. within the loop over the
+// messages of a claim in a sarama ConsumeClaim or a NATS message handler.
+func TrackMessage(ctx context.Context, system, queue string, fn func(ctx context.Context) error) error {
+	client := internal.GetApitallyClient()
+	if client == nil || !client.IsEnabled() {
+		return fn(ctx)
+	}
+	return client.MessageCounter.Track(ctx, system, queue, fn)
+}
+
+// WrapMessageHandler returns a message handler that tracks each message processed by
+// the given handler using TrackMessage, for consumer libraries that take a handler
+// function with a context and message.
+func WrapMessageHandler[M any](system, queue string, handler func(ctx context.Context, message M) error) func(ctx context.Context, message M) error {
+	return func(ctx context.Context, message M) error {
+		return TrackMessage(ctx, system, queue, func(ctx context.Context) error {
+			return handler(ctx, message)
+		})
+	}
+}
+
+// StatusHandler returns an http.Handler responding with the status of the Apitally
+// client as JSON, which can be exposed on an internal endpoint for monitoring.
+func StatusHandler() http.Handler {
+	return internal.StatusHandler()
+}
@@ -13,7 +13,6 @@ import (
 	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
 	"github.com/go-chi/chi/v5"
-	"github.com/go-playground/validator/v10"
 )
 
 type contextKey string
@@ -21,6 +20,8 @@ type contextKey string
 const (
 	validationErrorsKey contextKey = "ApitallyValidationErrors"
 	consumerKey         contextKey = "ApitallyConsumer"
+	rejectionStageKey   contextKey = "ApitallyRejectionStage"
+	requestTagsKey      contextKey = "ApitallyRequestTags"
 )
 
 // Middleware returns the Apitally middleware for Chi.
@@ -30,6 +31,9 @@ const (
 //   - Reference: https://docs.apitally.io/reference/go
 func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 	client := internal.InitApitallyClient(*config)
+	client.SetMiddlewareCheck(func() []common.Finding {
+		return internal.CheckMiddlewareOrder(getMiddlewareNames(r), "middleware.Recoverer")
+	})
 
 	// Sync should only be disabled for testing purposes
 	if !config.DisableSync {
@@ -38,13 +42,14 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 		// Delay startup data collection to ensure all routes are registered
 		go func() {
 			time.Sleep(time.Second)
-			client.SetStartupData(getRoutes(r), getVersions(config.AppVersion), "go:chi")
+			client.SetStartupData(getRoutes(r, config), getVersions(config.AppVersion), "go:chi")
+			client.LogDiagnostics()
 		}()
 	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !client.IsEnabled() || r.Method == "OPTIONS" {
+			if !client.IsEnabled() || config.IsExcludedMethod(r.Method) {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -56,6 +61,9 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 			// Start log capture
 			logHandle := client.LogCollector.StartCapture(spanHandle.Context())
 
+			// Start profiling of slow requests, if enabled
+			profileHandle := client.RequestLogger.StartProfile()
+
 			// Inject context into request
 			r = r.WithContext(logHandle.Context())
 
@@ -63,46 +71,73 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 			requestSize := common.ParseContentLength(r.Header.Get("Content-Length"))
 
 			// Cache request body if needed
-			var requestBody []byte
+			var requestBody *bytes.Buffer
 			var requestReader *common.RequestReader
-			captureRequestBody := client.Config.RequestLogging != nil &&
-				client.Config.RequestLogging.Enabled &&
-				client.Config.RequestLogging.LogRequestBody &&
-				client.RequestLogger.IsSupportedContentType(r.Header.Get("Content-Type"))
-
-			if r.Body != nil && requestSize <= common.MaxBodySize {
-				if captureRequestBody {
-					// Capture the body for logging
-					var err error
-					requestBody, err = io.ReadAll(r.Body)
-					if err == nil {
-						r.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-						requestSize = int64(len(requestBody))
+			captureRequestBody := client.RequestLogger.ShouldLogRequestBody(r.Header.Get("Content-Type"))
+
+			// GraphQL and JSON-RPC requests are captured to determine the operation
+			captureGraphQL := r.Method == http.MethodPost && config.IsGraphQLPath(r.URL.Path)
+			captureJSONRPC := r.Method == http.MethodPost && config.IsJSONRPCPath(r.URL.Path)
+			maxBodySize := client.RequestLogger.MaxBodySize()
+			if captureGraphQL || captureJSONRPC {
+				maxBodySize = max(maxBodySize, common.MaxOperationBodySize)
+			}
+
+			if r.Body != nil && requestSize <= int64(maxBodySize) {
+				if captureRequestBody || captureGraphQL || captureJSONRPC {
+					// Capture the body for logging or to determine the operation
+					requestBody = common.GetBodyBuffer()
+					if _, err := requestBody.ReadFrom(r.Body); err == nil {
+						r.Body = io.NopCloser(bytes.NewReader(requestBody.Bytes()))
+						requestSize = int64(requestBody.Len())
 					}
 				} else if requestSize == -1 {
 					// Only measure request body size
 					requestReader = &common.RequestReader{Reader: r.Body}
 					r.Body = requestReader
 				}
+			} else if r.Body != nil && captureRequestBody && client.RequestLogger.TruncateLargeBodies() {
+				// Capture only the beginning of the body for logging
+				requestBody = common.GetBodyBuffer()
+				r.Body = common.ReadBodyPrefix(r.Body, requestBody, client.RequestLogger.MaxBodySize())
 			}
 
 			// Prepare response writer to capture body if needed
-			var responseBody bytes.Buffer
+			var responseBody *bytes.Buffer
+			captureResponseBody := client.RequestLogger.ShouldLogResponseBody()
+			if captureResponseBody || config.ValidationErrorsFromResponses || captureGraphQL {
+				responseBody = common.GetBodyBuffer()
+			}
 			rw := &common.ResponseWriter{
-				ResponseWriter: w,
-				Body:           &responseBody,
-				CaptureBody: client.Config.RequestLogging != nil &&
-					client.Config.RequestLogging.Enabled &&
-					client.Config.RequestLogging.LogResponseBody,
+				ResponseWriter:         w,
+				Body:                   responseBody,
+				CaptureBody:            captureResponseBody,
+				CaptureProblemDetails:  config.ValidationErrorsFromResponses,
+				CaptureGraphQL:         captureGraphQL,
 				IsSupportedContentType: client.RequestLogger.IsSupportedContentType,
+				MaxBodySize:            client.RequestLogger.MaxBodySize(),
+				TruncateBody:           client.RequestLogger.TruncateLargeBodies(),
 			}
 
 			start := time.Now()
 
 			defer func() {
 				duration := time.Since(start)
-				routePattern := getRoutePattern(r)
+				routePattern := config.NormalizePath(getRoutePattern(r))
 				statusCode := rw.Status()
+				if routePattern == "" {
+					routePattern = config.UnmatchedRoutePath
+				} else if captureGraphQL && requestBody != nil {
+					// Aggregate GraphQL requests by operation
+					if operation, ok := common.ParseGraphQLOperation(requestBody.Bytes()); ok {
+						routePattern = operation.Path(routePattern)
+					}
+				} else if captureJSONRPC && requestBody != nil {
+					// Aggregate JSON-RPC requests by method
+					if method, ok := common.ParseJSONRPCMethod(requestBody.Bytes()); ok {
+						routePattern = common.JSONRPCMethodPath(routePattern, method)
+					}
+				}
 
 				// End span collection and get spans
 				spanHandle.SetName(fmt.Sprintf("%s %s", r.Method, routePattern))
@@ -111,6 +146,9 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 				// End log capture and get logs
 				logs := logHandle.End()
 
+				// End profiling and get profiles captured if the request was slow
+				profile := profileHandle.End()
+
 				// Update request size from reader if needed
 				if requestReader != nil && requestSize == -1 {
 					requestSize = requestReader.Size()
@@ -124,22 +162,34 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 					panicValue = r
 					statusCode = http.StatusInternalServerError
 					stackTrace = string(debug.Stack())
-					if err, ok := r.(error); ok {
-						recoveredErr = err
-					} else {
-						recoveredErr = fmt.Errorf("%v", r)
-					}
+					recoveredErr = common.NewPanicError(r)
+				}
+
+				// Check if the request context was canceled before the handler finished,
+				// e.g. because the client disconnected or a timeout was exceeded
+				ctxErr := r.Context().Err()
+				canceled := ctxErr != nil
+				timedOut := errors.Is(ctxErr, context.DeadlineExceeded)
+				counterStatusCode := statusCode
+				if canceled && recoveredErr == nil {
+					counterStatusCode = common.StatusClientClosedRequest
 				}
 
 				// Get consumer info if available
 				var consumerIdentifier string
 				if consumer := r.Context().Value(consumerKey); consumer != nil {
-					if consumerObj := internal.ConsumerFromStringOrObject(consumer); consumerObj != nil {
+					if consumerObj := client.ConsumerFromStringOrObject(consumer); consumerObj != nil {
 						consumerIdentifier = consumerObj.Identifier
 						client.ConsumerRegistry.AddOrUpdateConsumer(consumerObj)
 					}
 				}
 
+				// Get rejection stage if marked by an upstream middleware
+				rejectionStage, _ := r.Context().Value(rejectionStageKey).(string)
+
+				// Get request tags if set
+				requestTags, _ := r.Context().Value(requestTagsKey).(map[string]string)
+
 				// Determine response size
 				responseSize := common.ParseContentLength(rw.Header().Get("Content-Length"))
 				if responseSize == -1 {
@@ -147,32 +197,40 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 				}
 
 				// Count request
-				if routePattern != "" {
-					client.RequestCounter.AddRequest(
-						consumerIdentifier,
-						r.Method,
-						routePattern,
-						statusCode,
-						float64(duration.Milliseconds()),
-						requestSize,
-						responseSize,
-					)
+				if routePattern != "" && !config.IsExcludedFromMetrics(routePattern) {
+					client.RequestCounter.AddRequest(internal.RequestInfo{
+						Consumer:       consumerIdentifier,
+						Method:         r.Method,
+						Path:           routePattern,
+						StatusCode:     counterStatusCode,
+						ResponseTime:   duration.Seconds() * 1000.0,
+						RequestSize:    requestSize,
+						ResponseSize:   responseSize,
+						RejectionStage: rejectionStage,
+						Tags:           requestTags,
+						APIVersion:     config.GetAPIVersion(routePattern, r.Header),
+						TrafficClass:   internal.ClassifyTraffic(config, r.Method, routePattern, r.Header.Get),
+					})
 
 					// Count validation errors if any
-					if valErrValue := r.Context().Value(validationErrorsKey); valErrValue != nil {
-						validationErrors, ok := valErrValue.(validator.ValidationErrors)
-						if ok {
-							for _, fieldError := range validationErrors {
-								client.ValidationErrorCounter.AddValidationError(
-									consumerIdentifier,
-									r.Method,
-									routePattern,
-									fieldError.Field(),
-									common.TruncateValidationErrorMessage(fieldError.Error()),
-									fieldError.Tag(),
-								)
-							}
-						}
+					validationErrors, _ := r.Context().Value(validationErrorsKey).([]common.ValidationError)
+					if len(validationErrors) == 0 && config.ValidationErrorsFromResponses && responseBody != nil &&
+						common.IsValidationProblemDetails(statusCode, rw.Header().Get("Content-Type")) {
+						validationErrors = common.ParseProblemDetails(responseBody.Bytes())
+					}
+					if len(validationErrors) == 0 && captureGraphQL && responseBody != nil &&
+						common.IsGraphQLResponseContentType(rw.Header().Get("Content-Type")) {
+						validationErrors = common.ParseGraphQLErrors(responseBody.Bytes())
+					}
+					for _, validationError := range validationErrors {
+						client.ValidationErrorCounter.AddValidationError(
+							consumerIdentifier,
+							r.Method,
+							routePattern,
+							validationError.Location,
+							validationError.Message,
+							validationError.Type,
+						)
 					}
 
 					// Count server error if any
@@ -185,28 +243,51 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 							stackTrace,
 						)
 					}
+
+					// Count timeout if any
+					if timedOut {
+						client.TimeoutCounter.AddTimeout(consumerIdentifier, r.Method, routePattern)
+					}
+
+					// Count user agent if enabled
+					if config.CountUserAgents {
+						client.UserAgentCounter.AddUserAgent(r.Method, routePattern, r.UserAgent())
+					}
+
+					// Count rate limit headers if enabled
+					if config.CountRateLimits {
+						client.RateLimitCounter.AddRateLimit(consumerIdentifier, statusCode, rw.Header().Get)
+					}
 				}
 
 				// Log request if enabled
-				if client.Config.RequestLogging != nil && client.Config.RequestLogging.Enabled {
+				if client.RequestLogger.ShouldLogRequest() {
 					request := common.Request{
-						Timestamp: float64(time.Now().UnixMilli()) / 1000.0,
-						Consumer:  consumerIdentifier,
-						Method:    r.Method,
-						Path:      routePattern,
-						URL:       common.GetFullURL(r),
-						Headers:   common.TransformHeaders(r.Header),
-						Size:      requestSize,
-						Body:      requestBody,
+						Timestamp:  float64(time.Now().UnixMilli()) / 1000.0,
+						Consumer:   consumerIdentifier,
+						Method:     r.Method,
+						Path:       routePattern,
+						URL:        common.GetFullURL(r),
+						Headers:    common.TransformHeaders(r.Header),
+						Size:       requestSize,
+						RemoteAddr: r.RemoteAddr,
+						Canceled:   canceled,
+						TimedOut:   timedOut,
+						Tags:       requestTags,
 					}
 					response := common.Response{
 						StatusCode:   statusCode,
-						ResponseTime: float64(duration.Milliseconds()) / 1000.0,
+						ResponseTime: duration.Seconds(),
 						Headers:      common.TransformHeaders(rw.Header()),
 						Size:         responseSize,
-						Body:         responseBody.Bytes(),
+						Streaming:    rw.IsStreaming(),
 					}
-					client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID)
+					request.SetBodyBuffer(requestBody)
+					response.SetBodyBuffer(responseBody)
+					client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID, profile)
+				} else {
+					common.PutBodyBuffer(requestBody)
+					common.PutBodyBuffer(responseBody)
 				}
 
 				// Re-panic if there was a panic
@@ -220,16 +301,21 @@ func Middleware(r chi.Router, config *Config) func(http.Handler) http.Handler {
 	}
 }
 
+// CaptureValidationError captures validation errors returned by a validation library,
+// so they are counted by Apitally. Errors of go-playground/validator, ozzo-validation,
+// go-playground/form and encoding/json are supported, other errors are ignored.
 func CaptureValidationError(r *http.Request, err error) {
-	if err == nil {
-		return
-	}
+	CaptureValidationErrors(r, common.ParseValidationErrors(err))
+}
 
-	var validationErrors validator.ValidationErrors
-	if errors.As(err, &validationErrors) {
-		ctx := r.Context()
-		*r = *r.WithContext(context.WithValue(ctx, validationErrorsKey, validationErrors))
+// CaptureValidationErrors captures validation errors of any validation approach, in
+// addition to previously captured ones, so they are counted by Apitally.
+func CaptureValidationErrors(r *http.Request, validationErrors []ValidationError) {
+	if len(validationErrors) == 0 {
+		return
 	}
+	existing, _ := r.Context().Value(validationErrorsKey).([]common.ValidationError)
+	*r = *r.WithContext(context.WithValue(r.Context(), validationErrorsKey, append(existing, validationErrors...)))
 }
 
 func SetConsumerIdentifier(r *http.Request, consumerIdentifier string) {
@@ -241,3 +327,19 @@ func SetConsumer(r *http.Request, consumer common.Consumer) {
 	ctx := r.Context()
 	*r = *r.WithContext(context.WithValue(ctx, consumerKey, consumer))
 }
+
+// MarkRejected marks the request as rejected before reaching the handler, e.g. by an
+// authentication middleware responding with 401 or 403. Such requests are aggregated
+// under the given stage (e.g. "auth") so they don't inflate endpoint error rates.
+func MarkRejected(r *http.Request, stage string) {
+	ctx := r.Context()
+	*r = *r.WithContext(context.WithValue(ctx, rejectionStageKey, stage))
+}
+
+// SetRequestTags sets tags for the request (e.g. tenant tier, region or API version),
+// by which request metrics can be broken down. The tags are merged with any tags set
+// before. At most 10 tags are kept, so their values should come from a small set.
+func SetRequestTags(r *http.Request, tags map[string]string) {
+	existing, _ := r.Context().Value(requestTagsKey).(map[string]string)
+	*r = *r.WithContext(context.WithValue(r.Context(), requestTagsKey, internal.MergeTags(existing, tags)))
+}
@@ -2,14 +2,19 @@ package apitally
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -172,6 +177,201 @@ func TestMiddleware(t *testing.T) {
 		}))
 	})
 
+	t.Run("CaptureValidationErrors", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.Post("/items", func(w http.ResponseWriter, r *http.Request) {
+			CaptureValidationErrors(r, []ValidationError{{Location: "body.name", Message: "is too short", Type: "min_length"}})
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/items", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		assert.Len(t, validationErrors, 1)
+		assert.Equal(t, "/items", validationErrors[0].Path)
+		assert.Equal(t, []string{"body", "name"}, validationErrors[0].Loc)
+		assert.Equal(t, "is too short", validationErrors[0].Msg)
+		assert.Equal(t, "min_length", validationErrors[0].Type)
+	})
+
+	t.Run("ValidationErrorJSONFieldNames", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		validate := validator.New()
+		validate.RegisterTagNameFunc(JSONFieldName)
+		r.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+			type Address struct {
+				City string `json:"city" validate:"required"`
+			}
+			var req struct {
+				Name    string  `json:"name" validate:"required"`
+				Address Address `json:"address"`
+			}
+			if err := validate.Struct(req); err != nil {
+				CaptureValidationError(r, err)
+			}
+			w.WriteHeader(http.StatusBadRequest)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/users", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		locs := [][]string{}
+		for _, validationError := range validationErrors {
+			locs = append(locs, validationError.Loc)
+		}
+		assert.ElementsMatch(t, [][]string{{"name"}, {"address", "city"}}, locs)
+	})
+
+	t.Run("ValidationErrorsFromResponses", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.ValidationErrorsFromResponses = true
+		config.DisableSync = true
+
+		r := chi.NewRouter()
+		r.Use(Middleware(r, config))
+		r.Post("/items", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(`{"status":422,"errors":[{"message":"expected length >= 3","location":"body.name","value":"ab"}]}`))
+		})
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/items", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		assert.Len(t, validationErrors, 1)
+		assert.Equal(t, "/items", validationErrors[0].Path)
+		assert.Equal(t, []string{"body", "name"}, validationErrors[0].Loc)
+		assert.Equal(t, "expected length >= 3", validationErrors[0].Msg)
+	})
+
+	t.Run("GraphQL", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.GraphQLPaths = []string{"/graphql"}
+		config.DisableSync = true
+
+		r := chi.NewRouter()
+		r.Use(Middleware(r, config))
+		r.Post("/graphql", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			if bytes.Contains(body, []byte("GetUser")) {
+				w.Write([]byte(`{"errors":[{"message":"User not found","path":["user"],"extensions":{"code":"NOT_FOUND"}}],"data":null}`))
+			} else {
+				w.Write([]byte(`{"data":{"items":[]}}`))
+			}
+		})
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		for _, body := range []string{
+			`{"query":"query GetUser { user { name } }"}`,
+			`{"query":"{ items { id } }"}`,
+		} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 2)
+		paths := []string{requests[0].Path, requests[1].Path}
+		assert.ElementsMatch(t, []string{"/graphql#GetUser", "/graphql#query"}, paths)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		assert.Len(t, validationErrors, 1)
+		assert.Equal(t, "/graphql#GetUser", validationErrors[0].Path)
+		assert.Equal(t, []string{"user"}, validationErrors[0].Loc)
+		assert.Equal(t, "User not found", validationErrors[0].Msg)
+		assert.Equal(t, "NOT_FOUND", validationErrors[0].Type)
+	})
+
+	t.Run("JSONRPC", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.JSONRPCPaths = []string{"/rpc"}
+		config.DisableSync = true
+
+		r := chi.NewRouter()
+		r.Use(Middleware(r, config))
+		r.Post("/rpc", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"jsonrpc":"2.0","result":"0x1","id":1}`))
+		})
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		for _, body := range []string{
+			`{"jsonrpc":"2.0","method":"eth_chainId","id":1}`,
+			`{"jsonrpc":"2.0","method":"eth_chainId","id":2}`,
+			`{"jsonrpc":"2.0","method":"eth_blockNumber","id":3}`,
+			`not json`,
+		} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 3)
+		assert.True(t, slices.ContainsFunc(requests, func(r internal.RequestsItem) bool {
+			return r.Path == "/rpc#eth_chainId" && r.RequestCount == 2
+		}))
+		assert.True(t, slices.ContainsFunc(requests, func(r internal.RequestsItem) bool {
+			return r.Path == "/rpc#eth_blockNumber" && r.RequestCount == 1
+		}))
+		assert.True(t, slices.ContainsFunc(requests, func(r internal.RequestsItem) bool {
+			return r.Path == "/rpc" && r.RequestCount == 1
+		}))
+	})
+
+	t.Run("TrafficClass", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		for _, userAgent := range []string{"curl/8.4.0", "Mozilla/5.0 (compatible; bingbot/2.0)"} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+			req.Header.Set("User-Agent", userAgent)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 2)
+		trafficClasses := []string{requests[0].TrafficClass, requests[1].TrafficClass}
+		assert.ElementsMatch(t, []string{"", internal.TrafficClassBot}, trafficClasses)
+	})
+
 	t.Run("ServerErrorCounter", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		r := setupTestApp(false)
@@ -188,11 +388,56 @@ func TestMiddleware(t *testing.T) {
 
 		assert.Equal(t, "GET", errors[0].Method)
 		assert.Equal(t, "/error", errors[0].Path)
-		assert.Equal(t, "errors.errorString", errors[0].Type)
+		assert.Equal(t, "string", errors[0].Type)
 		assert.Equal(t, "test panic", errors[0].Message)
 		assert.Contains(t, errors[0].StackTrace, "panic")
 	})
 
+	t.Run("RejectedRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.Get("/protected", func(w http.ResponseWriter, r *http.Request) {
+			MarkRejected(r, "auth")
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "/protected", requests[0].Path)
+		assert.Equal(t, http.StatusUnauthorized, requests[0].StatusCode)
+		assert.Equal(t, "auth", requests[0].RejectionStage)
+	})
+
+	t.Run("RequestTags", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.Get("/tagged", func(w http.ResponseWriter, r *http.Request) {
+			SetRequestTags(r, map[string]string{"tier": "gold"})
+			SetRequestTags(r, map[string]string{"region": "eu"})
+			w.WriteHeader(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tagged", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, map[string]string{"tier": "gold", "region": "eu"}, requests[0].Tags)
+	})
+
 	t.Run("RequestLogger", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		r := setupTestApp(true)
@@ -265,9 +510,125 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, "GET", errorLogItem.Request.Method)
 		assert.Equal(t, "/error", errorLogItem.Request.Path)
 		assert.Equal(t, 500, errorLogItem.Response.StatusCode)
+		assert.Greater(t, errorLogItem.Response.ResponseTime, 0.0)
 		assert.NotNil(t, errorLogItem.Exception)
-		assert.Equal(t, "errors.errorString", errorLogItem.Exception.Type)
+		assert.Equal(t, "string", errorLogItem.Exception.Type)
 		assert.Equal(t, "test panic", errorLogItem.Exception.Message)
 		assert.Contains(t, errorLogItem.Exception.StackTrace, "panic")
 	})
+
+	t.Run("StreamingResponse", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 1000; i++ {
+				fmt.Fprintf(w, "data: event %d\n\n", i)
+				w.(http.Flusher).Flush()
+			}
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, w.Flushed)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Response.Streaming)
+		assert.Equal(t, int64(w.Body.Len()), logItems[0].Response.Size)
+		assert.Len(t, logItems[0].Response.Body, common.MaxStreamingBodySize)
+		assert.True(t, strings.HasPrefix(w.Body.String(), string(logItems[0].Response.Body)))
+	})
+
+	t.Run("CanceledRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		// Simulate the client disconnecting while the handler is running
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r.Get("/canceled", func(w http.ResponseWriter, r *http.Request) {
+			cancel()
+			w.WriteHeader(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/canceled", nil).WithContext(ctx)
+		r.ServeHTTP(w, req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, common.StatusClientClosedRequest, requests[0].StatusCode)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Request.Canceled)
+		assert.Equal(t, http.StatusOK, logItems[0].Response.StatusCode)
+	})
+
+	t.Run("TimedOutRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.Get("/timeout", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/timeout", nil).WithContext(ctx)
+		r.ServeHTTP(w, req)
+
+		timeouts := c.TimeoutCounter.GetAndResetTimeouts()
+		assert.Len(t, timeouts, 1)
+		assert.Equal(t, "/timeout", timeouts[0].Path)
+		assert.Equal(t, 1, timeouts[0].TimeoutCount)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Request.TimedOut)
+		assert.True(t, logItems[0].Request.Canceled)
+	})
+
+	t.Run("UnmatchedRoute", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/nope/123", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "GET", requests[0].Method)
+		assert.Equal(t, common.DefaultUnmatchedRoutePath, requests[0].Path)
+		assert.Equal(t, http.StatusNotFound, requests[0].StatusCode)
+	})
+
+	t.Run("ExcludedMethod", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "/hello", nil)
+		r.ServeHTTP(w, req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 0)
+	})
 }
@@ -9,8 +9,56 @@ type Config = common.Config
 type RequestLoggingConfig = common.RequestLoggingConfig
 type Request = common.Request
 type Response = common.Response
+type Status = common.Status
+type Snapshot = common.Snapshot
+type Logger = common.Logger
+type ValidationError = common.ValidationError
+type Finding = common.Finding
+type Condition = common.Condition
 
 // NewConfig creates a new Apitally configuration with sensible defaults.
 //
 // See reference: https://docs.apitally.io/reference/go
 var NewConfig = common.NewConfig
+
+// JSONFieldName returns the name of a struct field in JSON. Register it using
+// RegisterTagNameFunc of go-playground/validator, so that validation errors refer to
+// fields by their names in JSON.
+var JSONFieldName = common.JSONFieldName
+
+// HashConsumerIdentifier returns the identifier of a consumer as it appears in requests
+// if Config.ConsumerHashKey is set, e.g. to match consumers using ConsumerIn.
+var HashConsumerIdentifier = common.HashConsumerIdentifier
+
+// Functions that can be used as Config.APIVersionCallback, to determine the API version
+// of requests from the path (e.g. "/v2/items") or Accept header (e.g.
+// "application/vnd.example.v2+json").
+var (
+	APIVersionFromPath         = common.APIVersionFromPath
+	APIVersionFromAcceptHeader = common.APIVersionFromAcceptHeader
+)
+
+// Conditions that can be composed and set as RequestLoggingConfig.LogCondition, to only
+// log requests matching them, e.g. LogIf(SlowerThan(500*time.Millisecond)).
+var (
+	LogIf         = common.LogIf
+	AnyOf         = common.AnyOf
+	Not           = common.Not
+	StatusBetween = common.StatusBetween
+	SlowerThan    = common.SlowerThan
+	PathMatches   = common.PathMatches
+	ConsumerIn    = common.ConsumerIn
+	Sampled       = common.Sampled
+)
+
+// Errors returned by the SDK, which can be checked using errors.Is.
+var (
+	ErrInvalidClientID = common.ErrInvalidClientID
+	ErrInvalidEnv      = common.ErrInvalidEnv
+	ErrInvalidHubURL   = common.ErrInvalidHubURL
+	ErrNotInitialized  = common.ErrNotInitialized
+	ErrHubUnreachable  = common.ErrHubUnreachable
+	ErrPaymentRequired = common.ErrPaymentRequired
+	ErrUnauthorized    = common.ErrUnauthorized
+	ErrQueueFull       = common.ErrQueueFull
+)
@@ -6,16 +6,17 @@ import (
 	"strings"
 
 	"github.com/apitally/apitally-go/common"
+	"github.com/apitally/apitally-go/internal"
 	"github.com/go-chi/chi/v5"
 )
 
-func getRoutes(r chi.Router) []common.PathInfo {
+func getRoutes(r chi.Router, config *Config) []common.PathInfo {
 	var paths []common.PathInfo
 	walkFn := func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
 		if method != "OPTIONS" && method != "HEAD" {
 			paths = append(paths, common.PathInfo{
 				Method: method,
-				Path:   route,
+				Path:   config.NormalizePath(route),
 			})
 		}
 		return nil
@@ -24,6 +25,15 @@ func getRoutes(r chi.Router) []common.PathInfo {
 	return paths
 }
 
+func getMiddlewareNames(r chi.Router) []string {
+	middlewares := r.Middlewares()
+	names := make([]string, len(middlewares))
+	for i, middleware := range middlewares {
+		names[i] = internal.FuncName(middleware)
+	}
+	return names
+}
+
 func getVersions(appVersion string) map[string]string {
 	// Chi currently doesn't expose version info
 	versions := map[string]string{
@@ -32,6 +42,9 @@ func getVersions(appVersion string) map[string]string {
 	}
 	if appVersion != "" {
 		versions["app"] = strings.TrimSpace(appVersion)
+	} else if buildInfo := common.GetBuildInfo(); buildInfo != nil && buildInfo.ModuleVersion != "" {
+		// Fall back to the version of the main module, if installed with a version
+		versions["app"] = buildInfo.ModuleVersion
 	}
 	return versions
 }
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Maximum length of API versions, longer versions are truncated
+const maxAPIVersionLength = 32
+
+var (
+	pathVersionRegexp      = regexp.MustCompile(`^v\d+(\.\d+)*$`)
+	mediaTypeVersionRegexp = regexp.MustCompile(`\.(v\d+(?:\.\d+)*)(?:\+|$)`)
+	versionParamRegexp     = regexp.MustCompile(`;\s*version=([^\s;,]+)`)
+)
+
+// APIVersionFromPath returns the API version from the first segment of the path that
+// looks like a version (e.g. "v2" for "/api/v2/items"), or an empty string. It can be
+// used as Config.APIVersionCallback.
+func APIVersionFromPath(path string, header http.Header) string {
+	for _, segment := range strings.Split(path, "/") {
+		if pathVersionRegexp.MatchString(segment) {
+			return segment
+		}
+	}
+	return ""
+}
+
+// APIVersionFromAcceptHeader returns the API version from a vendor media type in the
+// Accept header (e.g. "v2" for "application/vnd.example.v2+json") or from its version
+// parameter (e.g. "2" for "application/json; version=2"), or an empty string. It can
+// be used as Config.APIVersionCallback.
+func APIVersionFromAcceptHeader(path string, header http.Header) string {
+	accept := header.Get("Accept")
+	if match := mediaTypeVersionRegexp.FindStringSubmatch(accept); match != nil {
+		return match[1]
+	}
+	if match := versionParamRegexp.FindStringSubmatch(accept); match != nil {
+		return strings.Trim(match[1], `"`)
+	}
+	return ""
+}
+
+// GetAPIVersion returns the API version of a request using the APIVersionCallback, or
+// an empty string if it isn't set.
+func (c *Config) GetAPIVersion(path string, header http.Header) string {
+	if c.APIVersionCallback == nil {
+		return ""
+	}
+	version := strings.TrimSpace(c.APIVersionCallback(path, header))
+	if len(version) > maxAPIVersionLength {
+		version = version[:maxAPIVersionLength]
+	}
+	// The version may reference memory owned by the framework, e.g. with Fiber
+	return strings.Clone(version)
+}
@@ -0,0 +1,44 @@
+package common
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIVersionFromPath(t *testing.T) {
+	assert.Equal(t, "v2", APIVersionFromPath("/api/v2/items/{id}", nil))
+	assert.Equal(t, "v1.1", APIVersionFromPath("/v1.1/items", nil))
+	assert.Equal(t, "", APIVersionFromPath("/items/v", nil))
+	assert.Equal(t, "", APIVersionFromPath("/vendors", nil))
+}
+
+func TestAPIVersionFromAcceptHeader(t *testing.T) {
+	for accept, version := range map[string]string{
+		"application/vnd.example.v2+json":       "v2",
+		"application/vnd.example.v3":            "v3",
+		"application/json; version=2":           "2",
+		`application/json;version="2024-01-01"`: "2024-01-01",
+		"application/json":                      "",
+		"":                                      "",
+	} {
+		header := http.Header{}
+		header.Set("Accept", accept)
+		assert.Equal(t, version, APIVersionFromAcceptHeader("/items", header), accept)
+	}
+}
+
+func TestConfigGetAPIVersion(t *testing.T) {
+	config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	assert.Equal(t, "", config.GetAPIVersion("/v2/items", nil))
+
+	config.APIVersionCallback = APIVersionFromPath
+	assert.Equal(t, "v2", config.GetAPIVersion("/v2/items", nil))
+
+	config.APIVersionCallback = func(path string, header http.Header) string {
+		return strings.Repeat("v", 100)
+	}
+	assert.Len(t, config.GetAPIVersion("/items", nil), maxAPIVersionLength)
+}
@@ -0,0 +1,32 @@
+package common
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Buffers that have grown beyond this size are not returned to the pool, so it doesn't
+// hold on to the memory of exceptionally large bodies.
+const maxPooledBufferSize = 4 * MaxBodySize
+
+var bodyBufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// GetBodyBuffer returns an empty buffer from the pool for capturing a request or
+// response body. It should be attached to the logged request or response using
+// SetBodyBuffer, which hands it back to the pool after the request has been logged.
+func GetBodyBuffer() *bytes.Buffer {
+	return bodyBufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBodyBuffer returns the buffer to the pool. It must not be used afterwards.
+func PutBodyBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bodyBufferPool.Put(buf)
+}
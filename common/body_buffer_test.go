@@ -0,0 +1,39 @@
+package common
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyBuffer(t *testing.T) {
+	t.Run("SetAndReleaseBody", func(t *testing.T) {
+		buf := GetBodyBuffer()
+		assert.Equal(t, 0, buf.Len())
+		buf.WriteString(`{"key":"value"}`)
+
+		request := &Request{}
+		request.SetBodyBuffer(buf)
+		assert.Equal(t, []byte(`{"key":"value"}`), request.Body)
+
+		request.ReleaseBody()
+		assert.Nil(t, request.Body)
+		assert.Equal(t, 0, buf.Len())
+
+		// Nil buffers are ignored
+		response := &Response{Body: []byte("test")}
+		response.SetBodyBuffer(nil)
+		assert.Equal(t, []byte("test"), response.Body)
+		response.ReleaseBody()
+		assert.Nil(t, response.Body)
+	})
+
+	t.Run("DiscardLargeBuffers", func(t *testing.T) {
+		buf := bytes.NewBuffer(make([]byte, 0, maxPooledBufferSize+1))
+		buf.WriteString("test")
+		PutBodyBuffer(buf)
+		assert.Equal(t, 4, buf.Len())
+		PutBodyBuffer(nil)
+	})
+}
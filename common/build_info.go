@@ -0,0 +1,51 @@
+package common
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// BuildInfo describes the build of the application's main module, as embedded in the
+// binary by the Go toolchain. VCS information is only available when built from a
+// repository checkout with `go build` (not `go run` or `go test`).
+type BuildInfo struct {
+	ModuleVersion string `json:"module_version,omitempty"`
+	Revision      string `json:"revision,omitempty"`
+	Time          string `json:"time,omitempty"`
+	Modified      bool   `json:"modified,omitempty"`
+}
+
+var getBuildInfoOnce = sync.OnceValue(func() *BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	return parseBuildInfo(info)
+})
+
+// GetBuildInfo returns the build info of the application, or nil if it isn't available.
+func GetBuildInfo() *BuildInfo {
+	return getBuildInfoOnce()
+}
+
+func parseBuildInfo(info *debug.BuildInfo) *BuildInfo {
+	buildInfo := &BuildInfo{}
+	// Binaries built from a local checkout report "(devel)" rather than a version
+	if version := info.Main.Version; version != "(devel)" {
+		buildInfo.ModuleVersion = version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			buildInfo.Revision = setting.Value
+		case "vcs.time":
+			buildInfo.Time = setting.Value
+		case "vcs.modified":
+			buildInfo.Modified = setting.Value == "true"
+		}
+	}
+	if *buildInfo == (BuildInfo{}) {
+		return nil
+	}
+	return buildInfo
+}
@@ -0,0 +1,33 @@
+package common
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBuildInfo(t *testing.T) {
+	t.Run("Module", func(t *testing.T) {
+		info := &debug.BuildInfo{
+			Main: debug.Module{Path: "example.com/app", Version: "v1.2.3"},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs", Value: "git"},
+				{Key: "vcs.revision", Value: "0123456789abcdef0123456789abcdef01234567"},
+				{Key: "vcs.time", Value: "2024-01-01T12:00:00Z"},
+				{Key: "vcs.modified", Value: "true"},
+			},
+		}
+		assert.Equal(t, &BuildInfo{
+			ModuleVersion: "v1.2.3",
+			Revision:      "0123456789abcdef0123456789abcdef01234567",
+			Time:          "2024-01-01T12:00:00Z",
+			Modified:      true,
+		}, parseBuildInfo(info))
+	})
+
+	t.Run("Devel", func(t *testing.T) {
+		info := &debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "(devel)"}}
+		assert.Nil(t, parseBuildInfo(info))
+	})
+}
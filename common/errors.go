@@ -0,0 +1,15 @@
+package common
+
+import "errors"
+
+// Errors returned by the SDK, which can be checked using errors.Is.
+var (
+	ErrInvalidClientID = errors.New("invalid client ID (expecting hexadecimal UUID format)")
+	ErrInvalidEnv      = errors.New("invalid env (expecting 1-32 alphanumeric characters and hyphens only)")
+	ErrInvalidHubURL   = errors.New("invalid hub URL (expecting http, https or unix scheme)")
+	ErrNotInitialized  = errors.New("apitally middleware not initialized")
+	ErrHubUnreachable  = errors.New("apitally hub unreachable")
+	ErrPaymentRequired = errors.New("apitally hub responded with payment required")
+	ErrUnauthorized    = errors.New("apitally hub rejected the client ID as unauthorized")
+	ErrQueueFull       = errors.New("sync data queue is full")
+)
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxOperationBodySize is the maximum size of GraphQL and JSON-RPC request bodies parsed
+// for the operation, independent of the maximum size of logged bodies.
+const MaxOperationBodySize = 100_000 // 100 KB
+
+var graphQLNamePattern = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]{0,99}$`)
+
+// GraphQLOperation is the operation executed by a GraphQL request.
+type GraphQLOperation struct {
+	// Operation type, i.e. query, mutation or subscription. Empty if the document
+	// isn't part of the request, e.g. when using persisted queries.
+	Type string
+
+	// Operation name, empty for anonymous operations
+	Name string
+}
+
+// Path returns the path under which requests executing the operation are aggregated,
+// e.g. "/graphql#GetUser", or "/graphql#query" for anonymous operations.
+func (o GraphQLOperation) Path(path string) string {
+	if o.Name != "" {
+		return path + "#" + o.Name
+	}
+	if o.Type != "" {
+		return path + "#" + o.Type
+	}
+	return path
+}
+
+// IsGraphQLResponseContentType returns whether the content type is used for GraphQL
+// responses, which may contain errors.
+func IsGraphQLResponseContentType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "application/graphql-response+json")
+}
+
+// ParseGraphQLOperation extracts the operation from the body of a GraphQL request,
+// using the operation name if given, or the only operation in the document otherwise.
+// The returned bool is false if the body can't be parsed or the operation can't be
+// determined, e.g. for batched requests.
+func ParseGraphQLOperation(body []byte) (GraphQLOperation, bool) {
+	var request struct {
+		Query         string `json:"query"`
+		OperationName string `json:"operationName"`
+	}
+	if len(body) == 0 || len(body) > MaxOperationBodySize || json.Unmarshal(body, &request) != nil {
+		return GraphQLOperation{}, false
+	}
+
+	operations := parseGraphQLOperations(request.Query)
+	if request.OperationName != "" {
+		// Names are validated to prevent arbitrary values from being used as paths
+		if !graphQLNamePattern.MatchString(request.OperationName) {
+			return GraphQLOperation{}, false
+		}
+		for _, operation := range operations {
+			if operation.Name == request.OperationName {
+				return operation, true
+			}
+		}
+		return GraphQLOperation{Name: request.OperationName}, true
+	}
+	if len(operations) != 1 {
+		return GraphQLOperation{}, false
+	}
+	return operations[0], true
+}
+
+// parseGraphQLOperations returns the operations defined in a GraphQL document. Only
+// top-level definitions are considered, so it's not necessary to fully parse the
+// document.
+func parseGraphQLOperations(document string) []GraphQLOperation {
+	var operations []GraphQLOperation
+	depth := 0
+	inDefinition := false
+	expectName := false
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == '#':
+			// Skip comment
+			for i < len(document) && document[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '"':
+			i = skipGraphQLString(document, i)
+			expectName = false
+			continue
+		case c == '_' || ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z'):
+			start := i
+			for i < len(document) && isGraphQLNameChar(document[i]) {
+				i++
+			}
+			name := document[start:i]
+			if depth == 0 {
+				if expectName {
+					operations[len(operations)-1].Name = name
+				} else if !inDefinition {
+					if name == "query" || name == "mutation" || name == "subscription" {
+						operations = append(operations, GraphQLOperation{Type: name})
+						expectName = true
+					}
+					inDefinition = true
+					continue
+				}
+			}
+			expectName = false
+			continue
+		case c == '{' || c == '(' || c == '[':
+			if c == '{' && depth == 0 && !inDefinition {
+				// Query shorthand without keyword
+				operations = append(operations, GraphQLOperation{Type: "query"})
+				inDefinition = true
+			}
+			depth++
+			expectName = false
+		case c == '}' || c == ')' || c == ']':
+			if depth > 0 {
+				depth--
+			}
+			if c == '}' && depth == 0 {
+				inDefinition = false
+			}
+			expectName = false
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+		default:
+			expectName = false
+		}
+		i++
+	}
+	return operations
+}
+
+// skipGraphQLString returns the index after the string or block string starting at i.
+func skipGraphQLString(document string, i int) int {
+	if strings.HasPrefix(document[i:], `"""`) {
+		if end := strings.Index(document[i+3:], `"""`); end >= 0 {
+			return i + 3 + end + 3
+		}
+		return len(document)
+	}
+	for i++; i < len(document); i++ {
+		switch document[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(document)
+}
+
+func isGraphQLNameChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z')
+}
+
+// ParseGraphQLErrors extracts errors from the body of a GraphQL response as validation
+// errors, located by the response path of the failed field, if any, and typed by their
+// error code (extensions.code), if any. Nil is returned if the body can't be parsed or
+// contains no errors.
+func ParseGraphQLErrors(body []byte) []ValidationError {
+	var response struct {
+		Errors []struct {
+			Message    string `json:"message"`
+			Path       []any  `json:"path"`
+			Extensions struct {
+				Code string `json:"code"`
+			} `json:"extensions"`
+		} `json:"errors"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &response) != nil {
+		return nil
+	}
+
+	var validationErrors []ValidationError
+	for _, e := range response.Errors {
+		if e.Message == "" {
+			continue
+		}
+		segments := make([]string, len(e.Path))
+		for i, segment := range e.Path {
+			segments[i] = fmt.Sprint(segment)
+		}
+		errorType := e.Extensions.Code
+		if errorType == "" {
+			errorType = "graphql_error"
+		}
+		validationErrors = append(validationErrors, ValidationError{
+			Location: strings.Join(segments, "."),
+			Message:  e.Message,
+			Type:     errorType,
+		})
+	}
+	return validationErrors
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphQLOperationPath(t *testing.T) {
+	assert.Equal(t, "/graphql#GetUser", GraphQLOperation{Type: "query", Name: "GetUser"}.Path("/graphql"))
+	assert.Equal(t, "/graphql#mutation", GraphQLOperation{Type: "mutation"}.Path("/graphql"))
+	assert.Equal(t, "/graphql", GraphQLOperation{}.Path("/graphql"))
+}
+
+func TestIsGraphQLResponseContentType(t *testing.T) {
+	assert.True(t, IsGraphQLResponseContentType("application/json; charset=utf-8"))
+	assert.True(t, IsGraphQLResponseContentType("application/graphql-response+json"))
+	assert.False(t, IsGraphQLResponseContentType("text/html"))
+}
+
+func TestParseGraphQLOperation(t *testing.T) {
+	t.Run("NamedOperation", func(t *testing.T) {
+		body := `{"query": "query GetUser($id: ID!) { user(id: $id) { name } }", "variables": {"id": "1"}}`
+		operation, ok := ParseGraphQLOperation([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Type: "query", Name: "GetUser"}, operation)
+	})
+
+	t.Run("OperationName", func(t *testing.T) {
+		body := `{
+			"query": "# Comment with query Fake\n query GetUser { user { name } }\n mutation UpdateUser { updateUser(name: \"mutation X\") { name } }",
+			"operationName": "UpdateUser"
+		}`
+		operation, ok := ParseGraphQLOperation([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Type: "mutation", Name: "UpdateUser"}, operation)
+	})
+
+	t.Run("PersistedQuery", func(t *testing.T) {
+		body := `{"operationName": "GetUser", "extensions": {"persistedQuery": {"version": 1, "sha256Hash": "abc"}}}`
+		operation, ok := ParseGraphQLOperation([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Name: "GetUser"}, operation)
+	})
+
+	t.Run("AnonymousOperation", func(t *testing.T) {
+		operation, ok := ParseGraphQLOperation([]byte(`{"query": "{ user { name } }"}`))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Type: "query"}, operation)
+
+		operation, ok = ParseGraphQLOperation([]byte(`{"query": "mutation @test { logout }"}`))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Type: "mutation"}, operation)
+	})
+
+	t.Run("Fragments", func(t *testing.T) {
+		body := `{"query": "fragment UserFields on User { name } query GetUser { user { ...UserFields } }"}`
+		operation, ok := ParseGraphQLOperation([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Type: "query", Name: "GetUser"}, operation)
+	})
+
+	t.Run("BlockString", func(t *testing.T) {
+		body := `{"query": "mutation AddNote { addNote(text: \"\"\"\nquery X { }\n\"\"\") { id } }"}`
+		operation, ok := ParseGraphQLOperation([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, GraphQLOperation{Type: "mutation", Name: "AddNote"}, operation)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		invalidBodies := []string{
+			``,
+			`not json`,
+			`[{"query": "query A { a }"}]`,
+			`{"query": "query A { a } query B { b }"}`,
+			`{"query": "{ a }", "operationName": "invalid name"}`,
+			`{"query": "` + strings.Repeat(" ", MaxOperationBodySize) + `{ a }"}`,
+		}
+		for _, body := range invalidBodies {
+			_, ok := ParseGraphQLOperation([]byte(body))
+			assert.False(t, ok, body)
+		}
+	})
+}
+
+func TestParseGraphQLErrors(t *testing.T) {
+	body := `{
+		"errors": [
+			{"message": "User not found", "path": ["user", 0, "name"], "extensions": {"code": "NOT_FOUND"}},
+			{"message": "Cannot query field \"foo\" on type \"Query\"."},
+			{"path": ["ignored"]}
+		],
+		"data": null
+	}`
+	assert.Equal(t, []ValidationError{
+		{Location: "user.0.name", Message: "User not found", Type: "NOT_FOUND"},
+		{Location: "", Message: `Cannot query field "foo" on type "Query".`, Type: "graphql_error"},
+	}, ParseGraphQLErrors([]byte(body)))
+
+	assert.Nil(t, ParseGraphQLErrors([]byte(`{"data": {"user": {"name": "John"}}}`)))
+	assert.Nil(t, ParseGraphQLErrors([]byte(`not json`)))
+}
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+)
+
+var jsonRPCMethodPattern = regexp.MustCompile(`^[A-Za-z0-9_.:/-]{1,100}$`)
+
+type jsonRPCRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+}
+
+// JSONRPCMethodPath returns the path under which requests calling the JSON-RPC method
+// are aggregated, e.g. "/rpc#eth_getBalance".
+func JSONRPCMethodPath(path string, method string) string {
+	return path + "#" + method
+}
+
+// ParseJSONRPCMethod extracts the method from the body of a JSON-RPC 2.0 request. Batch
+// requests are supported if all calls use the same method. The returned bool is false
+// if the body can't be parsed or the method can't be determined.
+func ParseJSONRPCMethod(body []byte) (string, bool) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || len(body) > MaxOperationBodySize {
+		return "", false
+	}
+
+	var requests []jsonRPCRequest
+	if body[0] == '[' {
+		if json.Unmarshal(body, &requests) != nil || len(requests) == 0 {
+			return "", false
+		}
+	} else {
+		var request jsonRPCRequest
+		if json.Unmarshal(body, &request) != nil {
+			return "", false
+		}
+		requests = append(requests, request)
+	}
+
+	method := requests[0].Method
+	for _, request := range requests {
+		if request.JSONRPC != "2.0" || request.Method != method {
+			return "", false
+		}
+	}
+
+	// Methods are validated to prevent arbitrary values from being used as paths
+	if !jsonRPCMethodPattern.MatchString(method) {
+		return "", false
+	}
+	return method, true
+}
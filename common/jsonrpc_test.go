@@ -0,0 +1,50 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONRPCMethodPath(t *testing.T) {
+	assert.Equal(t, "/rpc#eth_getBalance", JSONRPCMethodPath("/rpc", "eth_getBalance"))
+}
+
+func TestParseJSONRPCMethod(t *testing.T) {
+	t.Run("Request", func(t *testing.T) {
+		body := `{"jsonrpc": "2.0", "method": "eth_getBalance", "params": ["0x407d73d8a49eeb85d32cf465507dd71d507100c1", "latest"], "id": 1}`
+		method, ok := ParseJSONRPCMethod([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, "eth_getBalance", method)
+	})
+
+	t.Run("Notification", func(t *testing.T) {
+		method, ok := ParseJSONRPCMethod([]byte(`{"jsonrpc": "2.0", "method": "system.update"}`))
+		assert.True(t, ok)
+		assert.Equal(t, "system.update", method)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		body := ` [{"jsonrpc": "2.0", "method": "eth_call", "id": 1}, {"jsonrpc": "2.0", "method": "eth_call", "id": 2}]`
+		method, ok := ParseJSONRPCMethod([]byte(body))
+		assert.True(t, ok)
+		assert.Equal(t, "eth_call", method)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		invalidBodies := []string{
+			``,
+			`not json`,
+			`[]`,
+			`{"method": "eth_call", "id": 1}`,
+			`{"jsonrpc": "1.0", "method": "eth_call", "id": 1}`,
+			`{"jsonrpc": "2.0", "id": 1}`,
+			`{"jsonrpc": "2.0", "method": "invalid method", "id": 1}`,
+			`[{"jsonrpc": "2.0", "method": "eth_call", "id": 1}, {"jsonrpc": "2.0", "method": "eth_chainId", "id": 2}]`,
+		}
+		for _, body := range invalidBodies {
+			_, ok := ParseJSONRPCMethod([]byte(body))
+			assert.False(t, ok, body)
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"math/rand"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Condition decides whether a request is logged. Conditions can be combined using
+// LogIf, AnyOf and Not, and set as RequestLoggingConfig.LogCondition.
+type Condition func(request *Request, response *Response) bool
+
+// LogIf returns a condition matching requests that match all of the given conditions,
+// e.g. LogIf(StatusBetween(500, 599), ConsumerIn("acme")). It matches all requests if
+// no conditions are given.
+func LogIf(conditions ...Condition) Condition {
+	return func(request *Request, response *Response) bool {
+		for _, condition := range conditions {
+			if !condition(request, response) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf returns a condition matching requests that match any of the given conditions.
+func AnyOf(conditions ...Condition) Condition {
+	return func(request *Request, response *Response) bool {
+		for _, condition := range conditions {
+			if condition(request, response) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a condition matching requests that don't match the given condition.
+func Not(condition Condition) Condition {
+	return func(request *Request, response *Response) bool {
+		return !condition(request, response)
+	}
+}
+
+// StatusBetween returns a condition matching responses with a status code between from
+// and to, inclusive, e.g. StatusBetween(400, 599) for all errors.
+func StatusBetween(from, to int) Condition {
+	return func(request *Request, response *Response) bool {
+		return response.StatusCode >= from && response.StatusCode <= to
+	}
+}
+
+// SlowerThan returns a condition matching requests with a response time above the
+// given threshold, e.g. SlowerThan(500 * time.Millisecond).
+func SlowerThan(threshold time.Duration) Condition {
+	return func(request *Request, response *Response) bool {
+		return response.ResponseTime > threshold.Seconds()
+	}
+}
+
+// PathMatches returns a condition matching requests with a path matching any of the
+// given patterns.
+func PathMatches(patterns ...*regexp.Regexp) Condition {
+	return func(request *Request, response *Response) bool {
+		path := request.Path
+		if path == "" {
+			if parsedURL, err := url.Parse(request.URL); err == nil {
+				path = parsedURL.Path
+			}
+		}
+		for _, pattern := range patterns {
+			if pattern.MatchString(path) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ConsumerIn returns a condition matching requests of any of the given consumers, by
+// identifier. If Config.ConsumerHashKey is set, requests only have hashed identifiers,
+// so pass those instead, e.g. ConsumerIn(HashConsumerIdentifier("acme", key)).
+func ConsumerIn(identifiers ...string) Condition {
+	return func(request *Request, response *Response) bool {
+		return slices.Contains(identifiers, request.Consumer)
+	}
+}
+
+// Sampled returns a condition matching a random fraction of requests, between 0 and 1,
+// e.g. AnyOf(StatusBetween(500, 599), Sampled(0.1)) to log all server errors, but only
+// 10% of other requests.
+func Sampled(rate float64) Condition {
+	return func(request *Request, response *Response) bool {
+		return rate >= 1 || rand.Float64() < rate
+	}
+}
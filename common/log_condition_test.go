@@ -0,0 +1,47 @@
+package common
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogCondition(t *testing.T) {
+	request := &Request{Method: "GET", Path: "/items/{id}", URL: "http://localhost/items/1", Consumer: "acme"}
+	response := &Response{StatusCode: 503, ResponseTime: 0.75}
+
+	t.Run("Conditions", func(t *testing.T) {
+		assert.True(t, StatusBetween(500, 599)(request, response))
+		assert.False(t, StatusBetween(400, 499)(request, response))
+		assert.True(t, SlowerThan(500*time.Millisecond)(request, response))
+		assert.False(t, SlowerThan(time.Second)(request, response))
+		assert.True(t, PathMatches(regexp.MustCompile(`^/items/`))(request, response))
+		assert.False(t, PathMatches(regexp.MustCompile(`^/users/`))(request, response))
+		assert.True(t, PathMatches(regexp.MustCompile(`^/items/1$`))(&Request{URL: "http://localhost/items/1"}, response))
+		assert.True(t, ConsumerIn("other", "acme")(request, response))
+		assert.False(t, ConsumerIn("other")(request, response))
+		assert.True(t, Sampled(1)(request, response))
+		assert.False(t, Sampled(0)(request, response))
+	})
+
+	t.Run("Composition", func(t *testing.T) {
+		assert.True(t, LogIf()(request, response))
+		assert.True(t, LogIf(StatusBetween(500, 599), SlowerThan(500*time.Millisecond))(request, response))
+		assert.False(t, LogIf(StatusBetween(500, 599), ConsumerIn("other"))(request, response))
+		assert.True(t, AnyOf(ConsumerIn("other"), StatusBetween(500, 599))(request, response))
+		assert.False(t, AnyOf()(request, response))
+		assert.False(t, Not(ConsumerIn("acme"))(request, response))
+	})
+
+	t.Run("HashedConsumer", func(t *testing.T) {
+		// With a hash key, requests have hashed identifiers, which raw identifiers don't match
+		key := []byte("secret")
+		hashedRequest := &Request{Consumer: "1d16fd7e96e8a9681f283b8a251822ffdc71e2a5bda0c5267a044874fa21b82b"}
+		assert.False(t, ConsumerIn("user-1")(hashedRequest, response))
+		assert.True(t, ConsumerIn(HashConsumerIdentifier("user-1", key))(hashedRequest, response))
+		assert.True(t, ConsumerIn(HashConsumerIdentifier(" user-1 ", key))(hashedRequest, response))
+		assert.False(t, ConsumerIn(HashConsumerIdentifier("user-1", []byte("other")))(hashedRequest, response))
+	})
+}
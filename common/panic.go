@@ -0,0 +1,44 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// PanicError is a recovered panic value that isn't an error, such as a string or a
+// struct. It keeps the original value, so errors can be grouped by its type rather
+// than by the type of a generic error.
+type PanicError struct {
+	Value any
+}
+
+// NewPanicError returns the recovered panic value as an error, wrapping it in a
+// PanicError unless it's an error already.
+func NewPanicError(value any) error {
+	if err, ok := value.(error); ok {
+		return err
+	}
+	return &PanicError{Value: value}
+}
+
+// Error renders the panic value, including struct field names. Panics in String
+// methods of the value are caught by the fmt package.
+func (e *PanicError) Error() string {
+	if s, ok := e.Value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%+v", e.Value)
+}
+
+// TypeName returns the name of the panic value's type (e.g. "string" or
+// "main.customPanic"), without the pointer prefix.
+func (e *PanicError) TypeName() string {
+	valueType := reflect.TypeOf(e.Value)
+	if valueType == nil {
+		return "nil"
+	}
+	if valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	return valueType.String()
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testPanicValue struct {
+	Code   int
+	Reason string
+}
+
+type panickingStringer struct{}
+
+func (panickingStringer) String() string {
+	panic("boom")
+}
+
+func TestNewPanicError(t *testing.T) {
+	t.Run("Error", func(t *testing.T) {
+		err := errors.New("test error")
+		assert.Same(t, err, NewPanicError(err))
+	})
+
+	t.Run("String", func(t *testing.T) {
+		err := NewPanicError("test panic")
+		var panicErr *PanicError
+		assert.ErrorAs(t, err, &panicErr)
+		assert.Equal(t, "test panic", err.Error())
+		assert.Equal(t, "string", panicErr.TypeName())
+	})
+
+	t.Run("Struct", func(t *testing.T) {
+		err := NewPanicError(&testPanicValue{Code: 42, Reason: "invalid state"})
+		var panicErr *PanicError
+		assert.ErrorAs(t, err, &panicErr)
+		assert.Equal(t, "&{Code:42 Reason:invalid state}", err.Error())
+		assert.Equal(t, "common.testPanicValue", panicErr.TypeName())
+	})
+
+	t.Run("PanickingStringer", func(t *testing.T) {
+		err := NewPanicError(panickingStringer{})
+		assert.Contains(t, err.Error(), "PANIC=String method: boom")
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		assert.Equal(t, "nil", (&PanicError{}).TypeName())
+	})
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// problemDetails contains the members of problem details responses (RFC 9457) that
+// describe validation errors. Different conventions are in use for these extension
+// members, so the most common ones are supported.
+type problemDetails struct {
+	// Huma and RFC 9457 style, e.g. {"message": "...", "location": "body.name"} or
+	// {"detail": "...", "pointer": "#/name"}
+	Errors []struct {
+		Message  string `json:"message"`
+		Detail   string `json:"detail"`
+		Location string `json:"location"`
+		Pointer  string `json:"pointer"`
+	} `json:"errors"`
+
+	// RFC 7807 style, e.g. {"name": "name", "reason": "..."}
+	InvalidParams []struct {
+		Name   string `json:"name"`
+		Reason string `json:"reason"`
+	} `json:"invalid-params"`
+}
+
+// IsValidationProblemDetails returns whether a response with the given status code and
+// content type is a problem details response that may contain validation errors.
+func IsValidationProblemDetails(statusCode int, contentType string) bool {
+	if statusCode != http.StatusBadRequest && statusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(contentType), "application/problem+json")
+}
+
+// ParseProblemDetails extracts validation errors from the body of a problem details
+// response. Nil is returned if the body can't be parsed or contains no validation
+// errors.
+func ParseProblemDetails(body []byte) []ValidationError {
+	var details problemDetails
+	if len(body) == 0 || json.Unmarshal(body, &details) != nil {
+		return nil
+	}
+
+	var validationErrors []ValidationError
+	for _, e := range details.Errors {
+		location := e.Location
+		if location == "" {
+			location = jsonPointerToLocation(e.Pointer)
+		}
+		message := e.Message
+		if message == "" {
+			message = e.Detail
+		}
+		if location == "" && message == "" {
+			continue
+		}
+		validationErrors = append(validationErrors, ValidationError{
+			Location: location,
+			Message:  message,
+			Type:     "invalid",
+		})
+	}
+	for _, p := range details.InvalidParams {
+		if p.Name == "" && p.Reason == "" {
+			continue
+		}
+		validationErrors = append(validationErrors, ValidationError{
+			Location: p.Name,
+			Message:  p.Reason,
+			Type:     "invalid",
+		})
+	}
+	return validationErrors
+}
+
+// jsonPointerToLocation converts a JSON pointer (e.g. "#/address/city") to a location
+// with nested fields separated by dots (e.g. "address.city").
+func jsonPointerToLocation(pointer string) string {
+	pointer = strings.TrimPrefix(strings.TrimPrefix(pointer, "#"), "/")
+	if pointer == "" {
+		return ""
+	}
+	segments := strings.Split(pointer, "/")
+	for i, segment := range segments {
+		segments[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(segment)
+	}
+	return strings.Join(segments, ".")
+}
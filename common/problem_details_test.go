@@ -0,0 +1,54 @@
+package common
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidationProblemDetails(t *testing.T) {
+	assert.True(t, IsValidationProblemDetails(http.StatusUnprocessableEntity, "application/problem+json"))
+	assert.True(t, IsValidationProblemDetails(http.StatusBadRequest, "application/problem+json; charset=utf-8"))
+	assert.False(t, IsValidationProblemDetails(http.StatusInternalServerError, "application/problem+json"))
+	assert.False(t, IsValidationProblemDetails(http.StatusUnprocessableEntity, "application/json"))
+}
+
+func TestParseProblemDetails(t *testing.T) {
+	t.Run("Huma", func(t *testing.T) {
+		body := `{
+			"title": "Unprocessable Entity",
+			"status": 422,
+			"detail": "validation failed",
+			"errors": [
+				{"message": "expected required property name to be present", "location": "body.name", "value": {}},
+				{"message": "expected number >= 0", "location": "body.age", "value": -1}
+			]
+		}`
+		assert.Equal(t, []ValidationError{
+			{Location: "body.name", Message: "expected required property name to be present", Type: "invalid"},
+			{Location: "body.age", Message: "expected number >= 0", Type: "invalid"},
+		}, ParseProblemDetails([]byte(body)))
+	})
+
+	t.Run("JSONPointer", func(t *testing.T) {
+		body := `{"status": 422, "errors": [{"detail": "must be a positive integer", "pointer": "#/address/zip~1code"}]}`
+		assert.Equal(t, []ValidationError{
+			{Location: "address.zip/code", Message: "must be a positive integer", Type: "invalid"},
+		}, ParseProblemDetails([]byte(body)))
+	})
+
+	t.Run("InvalidParams", func(t *testing.T) {
+		body := `{"type": "https://example.net/validation-error", "invalid-params": [{"name": "age", "reason": "must be a positive integer"}]}`
+		assert.Equal(t, []ValidationError{
+			{Location: "age", Message: "must be a positive integer", Type: "invalid"},
+		}, ParseProblemDetails([]byte(body)))
+	})
+
+	t.Run("NoValidationErrors", func(t *testing.T) {
+		assert.Nil(t, ParseProblemDetails([]byte(`{"title": "Bad Request", "status": 400}`)))
+		assert.Nil(t, ParseProblemDetails([]byte(`{"errors": {"name": "required"}}`)))
+		assert.Nil(t, ParseProblemDetails([]byte(`not json`)))
+		assert.Nil(t, ParseProblemDetails(nil))
+	})
+}
@@ -1,6 +1,9 @@
 package common
 
-import "io"
+import (
+	"bytes"
+	"io"
+)
 
 type RequestReader struct {
 	Reader io.ReadCloser
@@ -20,3 +23,14 @@ func (rr *RequestReader) Close() error {
 func (rr *RequestReader) Size() int64 {
 	return rr.size
 }
+
+// ReadBodyPrefix reads up to maxSize bytes of the body into buf, e.g. to log the
+// beginning of bodies that are too large to be logged entirely. The returned reader
+// yields the full body, starting with the bytes read into buf.
+func ReadBodyPrefix(body io.ReadCloser, buf *bytes.Buffer, maxSize int) io.ReadCloser {
+	buf.ReadFrom(io.LimitReader(body, int64(maxSize)))
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf.Bytes()), body), body}
+}
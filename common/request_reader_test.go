@@ -1,6 +1,7 @@
 package common
 
 import (
+	"bytes"
 	"io"
 	"strings"
 	"testing"
@@ -29,3 +30,17 @@ func TestRequestReader(t *testing.T) {
 	err = reader.Close()
 	assert.NoError(t, err)
 }
+
+func TestReadBodyPrefix(t *testing.T) {
+	body := io.NopCloser(strings.NewReader("0123456789"))
+	buf := &bytes.Buffer{}
+
+	reader := ReadBodyPrefix(body, buf, 4)
+	assert.Equal(t, "0123", buf.String())
+
+	// The reader still yields the full body
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.NoError(t, reader.Close())
+}
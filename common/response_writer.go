@@ -6,22 +6,46 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"strings"
 )
 
 const (
-	MaxBodySize = 50_000 // 50 KB (uncompressed)
+	MaxBodySize          = 50_000    // 50 KB (uncompressed), unless configured otherwise
+	MaxBodySizeLimit     = 1_000_000 // 1 MB, upper bound for RequestLoggingConfig.MaxBodySize
+	MaxStreamingBodySize = 4_096     // Only the beginning of streaming responses is captured
 )
 
+// IsStreamingContentType returns whether the content type is used for streaming
+// responses, such as Server-Sent Events.
+func IsStreamingContentType(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "text/event-stream")
+}
+
 type ResponseWriter struct {
 	http.ResponseWriter
 	Body                   *bytes.Buffer
 	CaptureBody            bool
 	IsSupportedContentType func(string) bool
 
+	// Capture the body of problem details responses with validation errors, even if
+	// CaptureBody is false (see IsValidationProblemDetails)
+	CaptureProblemDetails bool
+
+	// Capture the body of GraphQL responses, even if CaptureBody is false (see
+	// IsGraphQLResponseContentType)
+	CaptureGraphQL bool
+
+	// Maximum size of the captured body, defaults to MaxBodySize
+	MaxBodySize int
+
+	// Keep the beginning of bodies exceeding the maximum size, instead of discarding them
+	TruncateBody bool
+
 	statusCode        int
 	size              int64
 	shouldCaptureBody *bool
 	exceededMaxSize   bool
+	streaming         bool
 }
 
 func (w *ResponseWriter) WriteHeader(statusCode int) {
@@ -31,12 +55,27 @@ func (w *ResponseWriter) WriteHeader(statusCode int) {
 
 func (w *ResponseWriter) Write(b []byte) (int, error) {
 	if w.shouldCaptureBody == nil {
+		contentType := w.Header().Get("Content-Type")
+		isStreamingContentType := IsStreamingContentType(contentType)
+		if isStreamingContentType {
+			w.streaming = true
+		}
 		w.shouldCaptureBody = new(bool)
-		*w.shouldCaptureBody = w.CaptureBody && w.IsSupportedContentType(w.Header().Get("Content-Type"))
+		*w.shouldCaptureBody = (w.CaptureBody && (isStreamingContentType || w.IsSupportedContentType(contentType))) ||
+			(w.CaptureProblemDetails && IsValidationProblemDetails(w.Status(), contentType)) ||
+			(w.CaptureGraphQL && IsGraphQLResponseContentType(contentType))
 	}
 	if *w.shouldCaptureBody && w.Body != nil && !w.exceededMaxSize {
-		if w.Body.Len()+len(b) <= MaxBodySize {
+		if w.streaming {
+			// Only capture the beginning of streaming responses
+			if remaining := MaxStreamingBodySize - w.Body.Len(); remaining > 0 {
+				w.Body.Write(b[:min(len(b), remaining)])
+			}
+		} else if w.Body.Len()+len(b) <= w.maxBodySize() {
 			w.Body.Write(b)
+		} else if w.TruncateBody {
+			w.Body.Write(b[:w.maxBodySize()-w.Body.Len()])
+			w.exceededMaxSize = true
 		} else {
 			w.Body.Reset()
 			w.exceededMaxSize = true
@@ -47,6 +86,13 @@ func (w *ResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+func (w *ResponseWriter) maxBodySize() int {
+	if w.MaxBodySize > 0 {
+		return w.MaxBodySize
+	}
+	return MaxBodySize
+}
+
 func (w *ResponseWriter) Status() int {
 	if w.statusCode == 0 {
 		return http.StatusOK
@@ -58,11 +104,23 @@ func (w *ResponseWriter) Size() int64 {
 	return w.size
 }
 
+// IsStreaming returns whether the response was streamed, i.e. it has a streaming
+// content type or was flushed by the handler.
+func (w *ResponseWriter) IsStreaming() bool {
+	return w.streaming
+}
+
 // The below methods ensure that optional interfaces (Flusher, Hijacker, Pusher) implemented by the
 // underlying ResponseWriter are still accessible when wrapped, preventing middleware from breaking
 // advanced HTTP features like WebSockets, Server-Sent Events, and HTTP/2 Server Push.
 
 func (w *ResponseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		if w.Body != nil && w.Body.Len() > MaxStreamingBodySize {
+			w.Body.Truncate(MaxStreamingBodySize)
+		}
+	}
 	if f, ok := w.ResponseWriter.(http.Flusher); ok {
 		f.Flush()
 	}
@@ -92,4 +92,119 @@ func TestResponseWriter(t *testing.T) {
 		assert.Empty(t, body.String()) // Body should be reset when max size exceeded
 		assert.Equal(t, int64(MaxBodySize+1), rw.Size())
 	})
+
+	t.Run("CustomMaxBodySize", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		body := &bytes.Buffer{}
+		rw := &ResponseWriter{
+			ResponseWriter: recorder,
+			Body:           body,
+			CaptureBody:    true,
+			MaxBodySize:    MaxBodySize + 10,
+			IsSupportedContentType: func(contentType string) bool {
+				return true
+			},
+		}
+
+		rw.Write(bytes.Repeat([]byte("a"), MaxBodySize+1))
+		assert.Equal(t, MaxBodySize+1, body.Len())
+		rw.Write(bytes.Repeat([]byte("a"), 10))
+		assert.Empty(t, body.String())
+	})
+
+	t.Run("TruncateBody", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		body := &bytes.Buffer{}
+		rw := &ResponseWriter{
+			ResponseWriter: recorder,
+			Body:           body,
+			CaptureBody:    true,
+			MaxBodySize:    10,
+			TruncateBody:   true,
+			IsSupportedContentType: func(contentType string) bool {
+				return true
+			},
+		}
+
+		rw.Write([]byte("0123456"))
+		rw.Write([]byte("789abc"))
+		rw.Write([]byte("def"))
+		assert.Equal(t, "0123456789", body.String())
+		assert.Equal(t, int64(16), rw.Size())
+		assert.Equal(t, "0123456789abcdef", recorder.Body.String())
+	})
+
+	t.Run("StreamingContentType", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		body := &bytes.Buffer{}
+		rw := &ResponseWriter{
+			ResponseWriter: recorder,
+			Body:           body,
+			CaptureBody:    true,
+			IsSupportedContentType: func(contentType string) bool {
+				return contentType == "application/json"
+			},
+		}
+
+		// Event streams are captured up to MaxStreamingBodySize, without being reset
+		rw.Header().Set("Content-Type", "text/event-stream")
+		event := []byte("data: " + string(bytes.Repeat([]byte("a"), 1000)) + "\n\n")
+		for i := 0; i < 100; i++ {
+			rw.Write(event)
+		}
+		assert.True(t, rw.IsStreaming())
+		assert.Equal(t, MaxStreamingBodySize, body.Len())
+		assert.Equal(t, int64(100*len(event)), rw.Size())
+	})
+
+	t.Run("StreamingFlush", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		body := &bytes.Buffer{}
+		rw := &ResponseWriter{
+			ResponseWriter: recorder,
+			Body:           body,
+			CaptureBody:    true,
+			IsSupportedContentType: func(contentType string) bool {
+				return true
+			},
+		}
+
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+		rw.Write(bytes.Repeat([]byte("a"), MaxStreamingBodySize+100))
+		assert.False(t, rw.IsStreaming())
+
+		// Flushing marks the response as streaming and truncates the captured body
+		rw.Flush()
+		rw.Write([]byte("more"))
+		assert.True(t, rw.IsStreaming())
+		assert.True(t, recorder.Flushed)
+		assert.Equal(t, MaxStreamingBodySize, body.Len())
+	})
+}
+
+func TestResponseWriterCaptureProblemDetails(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		statusCode  int
+		contentType string
+		captured    bool
+	}{
+		{"ProblemDetails", http.StatusUnprocessableEntity, "application/problem+json", true},
+		{"OtherStatus", http.StatusNotFound, "application/problem+json", false},
+		{"OtherContentType", http.StatusUnprocessableEntity, "application/json", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			rw := &ResponseWriter{
+				ResponseWriter:         httptest.NewRecorder(),
+				Body:                   body,
+				CaptureProblemDetails:  true,
+				IsSupportedContentType: func(string) bool { return true },
+			}
+			rw.Header().Set("Content-Type", tc.contentType)
+			rw.WriteHeader(tc.statusCode)
+			rw.Write([]byte(`{"errors":[]}`))
+			assert.Equal(t, tc.captured, body.Len() > 0)
+		})
+	}
 }
@@ -1,6 +1,20 @@
 package common
 
-import "regexp"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var envRegexp = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
 
 type Request struct {
 	Timestamp float64     `json:"timestamp"`
@@ -11,6 +25,51 @@ type Request struct {
 	Size      int64       `json:"size,omitempty"`
 	Consumer  string      `json:"consumer,omitempty"`
 	Body      []byte      `json:"body,omitempty"`
+
+	// Whether the request context was canceled before the handler finished, e.g.
+	// because the client disconnected or a timeout was exceeded
+	Canceled bool `json:"canceled,omitempty"`
+
+	// Whether the request context deadline was exceeded before the handler finished
+	TimedOut bool `json:"timed_out,omitempty"`
+
+	// Original content encoding of the body, if it was decompressed for logging
+	BodyEncoding string `json:"body_encoding,omitempty"`
+
+	// Whether the body was truncated to the maximum size, in which case Size is the
+	// original size
+	BodyTruncated bool `json:"body_truncated,omitempty"`
+
+	// Network address of the direct peer (e.g. "192.0.2.1:1234"), used to determine
+	// the client IP if enabled. It is not logged itself.
+	RemoteAddr string `json:"-"`
+
+	// IP address of the client, if LogClientIP is enabled
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// Tags of the request, e.g. its tenant tier or region
+	Tags map[string]string `json:"tags,omitempty"`
+
+	bodyBuffer *bytes.Buffer
+}
+
+// SetBodyBuffer sets the body to the content of a buffer obtained using GetBodyBuffer.
+// The buffer is returned to the pool by ReleaseBody. Nil buffers are ignored.
+func (r *Request) SetBodyBuffer(buf *bytes.Buffer) {
+	if buf == nil {
+		return
+	}
+	r.Body = buf.Bytes()
+	r.bodyBuffer = buf
+}
+
+// ReleaseBody clears the body and returns its buffer to the pool, if any.
+func (r *Request) ReleaseBody() {
+	r.Body = nil
+	if r.bodyBuffer != nil {
+		PutBodyBuffer(r.bodyBuffer)
+		r.bodyBuffer = nil
+	}
 }
 
 type Response struct {
@@ -19,12 +78,48 @@ type Response struct {
 	Headers      [][2]string `json:"headers"`
 	Size         int64       `json:"size,omitempty"`
 	Body         []byte      `json:"body,omitempty"`
+
+	// Original content encoding of the body, if it was decompressed for logging
+	BodyEncoding string `json:"body_encoding,omitempty"`
+
+	// Whether the body was truncated to the maximum size, in which case Size is the
+	// original size
+	BodyTruncated bool `json:"body_truncated,omitempty"`
+
+	// Whether the response was streamed, e.g. Server-Sent Events. At most the first
+	// MaxStreamingBodySize bytes of the body are captured.
+	Streaming bool `json:"streaming,omitempty"`
+
+	bodyBuffer *bytes.Buffer
+}
+
+// SetBodyBuffer sets the body to the content of a buffer obtained using GetBodyBuffer.
+// The buffer is returned to the pool by ReleaseBody. Nil buffers are ignored.
+func (r *Response) SetBodyBuffer(buf *bytes.Buffer) {
+	if buf == nil {
+		return
+	}
+	r.Body = buf.Bytes()
+	r.bodyBuffer = buf
+}
+
+// ReleaseBody clears the body and returns its buffer to the pool, if any.
+func (r *Response) ReleaseBody() {
+	r.Body = nil
+	if r.bodyBuffer != nil {
+		PutBodyBuffer(r.bodyBuffer)
+		r.bodyBuffer = nil
+	}
 }
 
 type Consumer struct {
 	Identifier string `json:"identifier"`
 	Name       string `json:"name,omitempty"`
 	Group      string `json:"group,omitempty"`
+
+	// Tags of the consumer, e.g. its tenant tier or region. At most 10 tags are kept,
+	// with keys of up to 32 and values of up to 64 characters.
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 type PathInfo struct {
@@ -41,14 +136,186 @@ type RequestLoggingConfig struct {
 	LogResponseBody          bool
 	LogPanic                 bool
 	CaptureLogs              bool
-	CaptureTraces             bool
+	CaptureTraces            bool
 	MaskQueryParams          []*regexp.Regexp
 	MaskHeaders              []*regexp.Regexp
 	MaskBodyFields           []*regexp.Regexp
 	MaskRequestBodyCallback  func(request *Request) []byte
 	MaskResponseBodyCallback func(request *Request, response *Response) []byte
-	ExcludePaths             []*regexp.Regexp
-	ExcludeCallback          func(request *Request, response *Response) bool
+
+	// Include a cURL command reproducing the request in each logged request, so failing
+	// requests can easily be reproduced. The command is built after masking, so masked
+	// values need to be replaced before running it.
+	IncludeCurlCommand bool
+
+	// Only log query params with these names (e.g. page, limit or sort) in the URL of
+	// logged requests, instead of all query params. This avoids logging PII in query
+	// params that weren't explicitly approved. Included query params are still masked.
+	LogOnlyQueryParams []string
+
+	// Only log request and response headers with names matching any of these patterns
+	// (e.g. Content-Type, Cache-Control or X-RateLimit-*), instead of all headers. This
+	// reduces the size of logged requests and the risk of logging PII. Included headers
+	// are still masked.
+	IncludeRequestHeaders  []*regexp.Regexp
+	IncludeResponseHeaders []*regexp.Regexp
+
+	// Exclude frames of functions matching any of these patterns (e.g. of other
+	// middleware) from the structured stack traces of logged panics. Frames of the Go
+	// runtime, net/http and the Apitally middleware are excluded by default, unless
+	// DisableDefaultStackFrameExclusions is set.
+	ExcludeStackFrames                 []*regexp.Regexp
+	DisableDefaultStackFrameExclusions bool
+
+	// Mask segments of the logged URL path matching any of these patterns, in addition
+	// to segments that look like email addresses. The callback may return a masked path
+	// for the request, or an empty string to keep it. The route pattern is not masked.
+	MaskPathSegments         []*regexp.Regexp
+	MaskPathSegmentsCallback func(request *Request) string
+
+	ExcludePaths    []*regexp.Regexp
+	ExcludeCallback func(request *Request, response *Response) bool
+
+	// Only log requests matching this condition, which can be composed from conditions
+	// on status codes, response times, paths, consumers and sampling, e.g.
+	// LogIf(SlowerThan(500*time.Millisecond), Not(ConsumerIn("monitoring"))).
+	// Exclusions still apply.
+	LogCondition Condition
+
+	// Exclude requests with a User-Agent header matching any of these patterns.
+	ExcludeUserAgents []*regexp.Regexp
+
+	// Disable the built-in exclusion of health checks, probes and similar requests
+	// (e.g. /health, /ready, /robots.txt or kube-probe user agents), so that only
+	// ExcludePaths and ExcludeUserAgents apply.
+	DisableDefaultExclusions bool
+
+	// Only attach captured logs and spans (see CaptureLogs and CaptureTraces) to logged
+	// requests that took longer than this threshold or returned a server error (5xx),
+	// and discard them for all other requests. Keeps logged requests small, while still
+	// providing details for bad requests. Attached to all requests if 0.
+	TailCaptureThreshold time.Duration
+
+	// Capture a goroutine dump of requests still running after this threshold and
+	// attach it to the logged request as a pprof profile, e.g. to debug requests waiting
+	// for locks or slow downstream calls. If ProfileCPU is set, a CPU profile is also
+	// recorded from the threshold until the request completes (at most 10 seconds).
+	// Profiles are captured at most once per ProfileInterval (default 1 minute) across
+	// all requests, as capturing them has a noticeable overhead. Disabled if 0.
+	ProfileThreshold time.Duration
+	ProfileCPU       bool
+	ProfileInterval  time.Duration
+
+	// Stop capturing bodies and only log 10% of requests while the resident memory
+	// (RSS) of the process exceeds this many bytes, as measured with every sync, to
+	// relieve memory pressure. Logging is restored once memory usage drops below 90% of
+	// the threshold. Disabled if 0.
+	DegradeAboveMemory int64
+
+	// Maximum number of requests logged per consumer per minute (e.g. 100), so that a
+	// single noisy consumer can't crowd out the logs of all other consumers. Requests
+	// without a consumer are not limited. Unlimited if 0.
+	MaxRequestsPerConsumer int
+
+	// Maximum number of logged headers (default 100) and length of logged header values
+	// in bytes (default 2048). Longer values are truncated.
+	MaxHeaders      int
+	MaxHeaderLength int
+
+	// Replace email addresses, IBANs, credit card numbers and phone numbers in logged
+	// header values and bodies with placeholders, such as <email>.
+	MaskPII bool
+
+	// Additional content types for which bodies are logged, matched as prefixes.
+	// JSON, NDJSON, form, XML and plain text bodies are always supported.
+	AllowedContentTypes []string
+
+	// Logged requests are buffered in memory and written to a temporary file every
+	// WriteInterval (default 1 second), or as soon as WriteBatchSize requests are
+	// buffered (disabled if 0, at most 100). Changes only take effect after a restart.
+	WriteInterval  time.Duration
+	WriteBatchSize int
+
+	// Number of goroutines (at most 8) that mask, encode and write logged requests as
+	// soon as they are buffered, instead of the maintenance goroutine processing them
+	// every WriteInterval. Spreads the work for large bodies at high request volumes.
+	// Disabled if 0. Changes only take effect after a restart.
+	WriteWorkers int
+
+	// Flush and sync the file to disk after each write, so that on a crash at most the
+	// requests buffered since the last write are lost. Otherwise, written data may
+	// remain in memory until the file is rotated before the next sync with the hub.
+	SyncOnWrite bool
+
+	// Directory for temporary log files, e.g. a dedicated volume in containers with a
+	// small or read-only /tmp. Defaults to the system's temporary directory.
+	TempDir string
+
+	// Maximum total size in bytes of log files on disk (default 100 MB). If exceeded,
+	// the oldest files are deleted before they are sent to the hub.
+	MaxDiskUsage int64
+
+	// Encrypt log files with a key that is only kept in memory, so that request and
+	// response data is never written to disk in plaintext. Files are decrypted while
+	// being sent to the hub.
+	EncryptTempFiles bool
+
+	// Directory to move log files to instead of deleting them when too many are waiting
+	// to be sent, e.g. during extended hub outages. Spooled files are sent oldest first
+	// once the hub is reachable again. They are deleted once their total size exceeds
+	// SpoolMaxSize (default 1 GB) or they are older than SpoolTTL (default 24 hours).
+	// Spooled files don't survive restarts. Changes only take effect after a restart.
+	SpoolDir     string
+	SpoolMaxSize int64
+	SpoolTTL     time.Duration
+
+	// Maximum size in bytes of logged request and response bodies (default 50 KB, at
+	// most 1 MB). Larger bodies are replaced with a placeholder, unless
+	// TruncateLargeBodies is set.
+	MaxBodySize int
+
+	// Log the beginning of bodies exceeding MaxBodySize, instead of replacing them with
+	// a placeholder. Truncated bodies are marked as such, with their original size.
+	TruncateLargeBodies bool
+
+	// Encode logged requests with MessagePack instead of JSON, which takes less CPU time
+	// and produces smaller files at high request volumes. Falls back to JSON if the hub
+	// doesn't accept MessagePack.
+	MsgpackEncoding bool
+
+	// Combine small log files into uploads of up to 1 MB (compressed), instead of
+	// uploading each file in a separate request. Reduces the number of requests to the
+	// hub for services that rotate files frequently.
+	BatchUploads bool
+
+	// Number of log files (or batches, see BatchUploads) uploaded in parallel, e.g. 3 for
+	// instances logging several MB per minute. Defaults to 1 (sequential uploads), and
+	// is capped at 10.
+	UploadConcurrency int
+
+	// Maximum combined rate of request log uploads in bytes per second, across parallel
+	// uploads and flushes, so uploads don't compete with production traffic during
+	// traffic spikes. Unlimited if 0.
+	UploadBandwidthLimit int64
+
+	// Log the IP address of the client. The Forwarded, X-Forwarded-For and
+	// CF-Connecting-IP headers are only taken into account for requests from one of
+	// the TrustedProxies. If AnonymizeClientIP is set, the last octet of IPv4 addresses
+	// and the last 80 bits of IPv6 addresses are zeroed.
+	LogClientIP       bool
+	TrustedProxies    []netip.Prefix
+	AnonymizeClientIP bool
+
+	// Run the request logging pipeline, including masking, but instead of sending logged
+	// requests to Apitally, append them as lines of JSON to DryRunFile and/or pass them
+	// to DryRunCallback, once per sync. Allows reviewing exactly what would be sent
+	// before enabling request logging in production. If DryRunHARFile is set, logged
+	// requests are also added to that file in the HTTP Archive (HAR) format, so they
+	// can be replayed in tools like Postman or Insomnia while debugging.
+	DryRun         bool
+	DryRunFile     string
+	DryRunCallback func(item []byte)
+	DryRunHARFile  string
 }
 
 func NewRequestLoggingConfig() *RequestLoggingConfig {
@@ -61,24 +328,419 @@ func NewRequestLoggingConfig() *RequestLoggingConfig {
 		LogResponseBody:    false,
 		LogPanic:           true,
 		CaptureLogs:        false,
-		CaptureTraces:       false,
+		CaptureTraces:      false,
 	}
 }
 
+// Status describes the state of the Apitally client at a point in time.
+type Status struct {
+	Enabled                 bool       `json:"enabled"`
+	LastSuccessfulSync      *time.Time `json:"last_successful_sync"`
+	QueuedSyncPayloads      int        `json:"queued_sync_payloads"`
+	PendingLogFiles         int        `json:"pending_log_files"`
+	RequestLoggingEnabled   bool       `json:"request_logging_enabled"`
+	RequestLoggingSuspended bool       `json:"request_logging_suspended"`
+	RequestLoggingSaturated bool       `json:"request_logging_saturated"`
+	RequestLoggingDegraded  bool       `json:"request_logging_degraded"`
+	HubReachable            bool       `json:"hub_reachable"`
+	DroppedSyncPayloads     int64      `json:"dropped_sync_payloads"` // since startup
+	DroppedLogItems         int64      `json:"dropped_log_items"`     // since startup
+	SkippedLogItems         int64      `json:"skipped_log_items"`     // since startup
+}
+
+// Snapshot is a copy of the metrics aggregated by the Apitally client since the last
+// sync (i.e. within the last minute) and of the consumers seen since startup. Taking a
+// snapshot doesn't affect the data sent to Apitally.
+type Snapshot struct {
+	Since            time.Time                `json:"since"`
+	Requests         []RequestMetrics         `json:"requests"`
+	ValidationErrors []ValidationErrorMetrics `json:"validation_errors"`
+	ServerErrors     []ServerErrorMetrics     `json:"server_errors"`
+	Consumers        []Consumer               `json:"consumers"`
+}
+
+// RequestMetrics aggregates requests by consumer, method, path, status code and traffic
+// class. Response time percentiles are estimated in milliseconds.
+type RequestMetrics struct {
+	Consumer        string  `json:"consumer,omitempty"`
+	Method          string  `json:"method"`
+	Path            string  `json:"path"`
+	StatusCode      int     `json:"status_code"`
+	TrafficClass    string  `json:"traffic_class,omitempty"`
+	RequestCount    int     `json:"request_count"`
+	RequestSizeSum  int64   `json:"request_size_sum"`
+	ResponseSizeSum int64   `json:"response_size_sum"`
+	ResponseTimeP50 float64 `json:"response_time_p50"`
+	ResponseTimeP95 float64 `json:"response_time_p95"`
+	ResponseTimeP99 float64 `json:"response_time_p99"`
+}
+
+// ValidationErrorMetrics aggregates validation errors by consumer, method, path and
+// error.
+type ValidationErrorMetrics struct {
+	Consumer   string   `json:"consumer,omitempty"`
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Location   []string `json:"location"`
+	Message    string   `json:"message"`
+	Type       string   `json:"type"`
+	ErrorCount int      `json:"error_count"`
+}
+
+// ServerErrorMetrics aggregates server errors by consumer, method, path and error.
+type ServerErrorMetrics struct {
+	Consumer   string `json:"consumer,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+	ErrorCount int    `json:"error_count"`
+}
+
+// Codes of findings returned by Diagnose.
+const (
+	FindingRecoveryAfterMiddleware = "recovery_after_middleware"
+	FindingMissingRecovery         = "missing_recovery"
+)
+
+// Finding is a common misconfiguration detected by Diagnose, with an actionable message.
+type Finding struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Logger is the minimal interface of the logger used by the SDK, which is satisfied
+// by *slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// HubRequestConfig configures requests to the Apitally hub by type of payload.
+type HubRequestConfig struct {
+	Startup HubRequestSettings // Startup data, sent once
+	Sync    HubRequestSettings // Aggregated metrics, sent every sync interval
+	Log     HubRequestSettings // Request log files, each up to about 1 MB compressed
+}
+
+// HubRequestSettings configure the timeout and retries of requests to the Apitally hub.
+// Zero values use the defaults.
+type HubRequestSettings struct {
+	// Timeout of each attempt. Defaults to 10 seconds, or 30 seconds for request logs.
+	Timeout time.Duration
+
+	// Maximum number of retries of failed attempts, with exponential backoff. Defaults
+	// to 3. Retries are disabled if negative.
+	MaxRetries int
+}
+
+// HubTransportConfig tunes connections to the Apitally hub, e.g. for strict egress
+// proxies or many instances. Zero values use the defaults.
+type HubTransportConfig struct {
+	// Maximum number of idle (keep-alive) connections. Defaults to 100.
+	MaxIdleConns int
+
+	// Time after which idle connections are closed. Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+
+	// Use HTTP/1.1 only. By default, HTTP/2 is used if supported by the hub or proxy.
+	DisableHTTP2 bool
+
+	// Open a new connection for each request.
+	DisableKeepAlives bool
+}
+
+// LocalPrecedence marks settings that can't be adjusted at runtime through the
+// Apitally dashboard. By default, all settings can be adjusted remotely.
+type LocalPrecedence struct {
+	RequestLogging bool // Enabling or disabling request logging
+	SampleRate     bool // Request logging sample rate
+	SyncInterval   bool // Interval for syncing data with the hub
+}
+
+// TrafficClassRule assigns a traffic class (e.g. "synthetic") to requests matching all
+// of its conditions. Conditions that aren't set match any request. An empty class marks
+// matching requests as real usage, overriding the built-in classification.
+type TrafficClassRule struct {
+	Class     string
+	Method    string         // HTTP method, matched case-insensitively
+	Path      *regexp.Regexp // Matched against the route pattern
+	UserAgent *regexp.Regexp
+}
+
+// Matches returns whether a request with the given method, route pattern and user
+// agent matches all conditions of the rule.
+func (r TrafficClassRule) Matches(method, path, userAgent string) bool {
+	return (r.Method == "" || strings.EqualFold(r.Method, method)) &&
+		(r.Path == nil || r.Path.MatchString(path)) &&
+		(r.UserAgent == nil || r.UserAgent.MatchString(userAgent))
+}
+
 type Config struct {
 	ClientID       string
 	Env            string
 	AppVersion     string
 	RequestLogging *RequestLoggingConfig
 
+	// Metadata describing this instance of the application, which is added to or
+	// overrides the automatically detected metadata (hostname, container ID, Kubernetes
+	// pod and namespace, and cloud region), e.g. {"region": "eu-west-1"}. Empty values
+	// remove detected metadata.
+	InstanceMetadata map[string]string
+
+	// Logger for messages from the SDK, e.g. a *slog.Logger. Defaults to a text logger
+	// writing to stdout.
+	Logger Logger
+
+	// Path of a .prom file to write aggregated metrics to after each sync interval,
+	// e.g. for the textfile collector of Prometheus node_exporter. Disabled if empty.
+	PrometheusTextfilePath string
+
+	// Directory to persist sync payloads in until they have been sent, so aggregated
+	// metrics survive restarts and hub outages. Disabled if empty.
+	SyncQueueDir string
+
+	// Maximum age of queued sync payloads before they are discarded. Defaults to 1 hour.
+	SyncQueueRetention time.Duration
+
+	// Interval for syncing aggregated data with the hub, in seconds. Defaults to 60
+	// and must be at least 10. A shorter interval is used during the first hour.
+	SyncIntervalSeconds int
+
+	// Skip syncing with the hub in intervals without any activity (requests, tasks,
+	// messages, heartbeats etc.), reducing traffic for idle instances. By default, data
+	// is synced in every interval, so the dashboard can distinguish instances without
+	// traffic from instances that are down.
+	SkipIdleSyncs bool
+
+	// Timeouts and retries of requests to the hub by type of payload, e.g. to allow
+	// more time for uploading request logs over slow connections.
+	HubRequests HubRequestConfig
+
+	// Base URL of the Apitally hub, or of a local agent that buffers and forwards data
+	// to the hub, e.g. "http://localhost:8126" or "unix:///run/apitally/agent.sock" to
+	// connect through a Unix domain socket. Defaults to the APITALLY_HUB_BASE_URL
+	// environment variable, or https://hub.apitally.io if not set.
+	HubURL string
+
+	// Tuning of connections to the hub. Ignored if a custom HTTP client or transport is
+	// used.
+	HubTransport HubTransportConfig
+
+	// Function called right before data is sent to Apitally, given the type of payload
+	// ("startup", "sync" or "log") and its body, e.g. to audit or redact outbound data.
+	// The returned body is sent instead, or nothing if nil is returned. Bodies of "log"
+	// payloads are passed uncompressed, with one logged request per line.
+	BeforeSendCallback func(payloadType string, body []byte) []byte
+
+	// Function returning a fingerprint by which server errors are grouped, given the
+	// error and stack trace, e.g. to group all database timeouts regardless of the
+	// query. Errors are still grouped by consumer and endpoint. If the function returns
+	// an empty string, errors are grouped by type, message and stack trace.
+	ErrorFingerprintCallback func(err error, stackTrace string) string
+
+	// Response time targets per endpoint, keyed by method and path pattern (e.g.
+	// "GET /items/{id}") or by path pattern only, for all methods. Requests to these
+	// endpoints are additionally counted as satisfied (up to the target), tolerating
+	// (up to 4x the target) or frustrated, e.g. to calculate an Apdex score.
+	PerformanceTargets map[string]time.Duration
+
+	// Function returning the API version of a request given its route pattern and
+	// headers, by which requests are additionally counted to track the adoption of
+	// versions, e.g. APIVersionFromPath or APIVersionFromAcceptHeader. Versions should
+	// come from a small set, as each one is counted separately.
+	APIVersionCallback func(path string, header http.Header) string
+
+	// Expected intervals between heartbeats of scheduled jobs (e.g. cron jobs), keyed by
+	// job name, so that missed runs can trigger alerts. Jobs report heartbeats using
+	// Heartbeat.
+	HeartbeatIntervals map[string]time.Duration
+
+	// Path under which requests not matching any route (e.g. 404s) are counted,
+	// instead of being dropped. Defaults to DefaultUnmatchedRoutePath when using
+	// NewConfig. Such requests are not counted if empty.
+	UnmatchedRoutePath string
+
+	// Function to normalize route patterns before requests are counted and logged, and
+	// before routes are sent to Apitally at startup, e.g. to merge versioned routes
+	// under a single path. Not applied to UnmatchedRoutePath.
+	PathNormalizer func(path string) string
+
+	// HTTP methods of requests to exclude from counting and logging. Defaults to
+	// OPTIONS when using NewConfig, so CORS preflight requests are ignored.
+	ExcludeMethods []string
+
+	// Exclude requests to paths matching any of these patterns (e.g. health checks)
+	// from metrics, i.e. request counts, response times and errors. The patterns are
+	// matched against the route pattern. Such requests may still be logged.
+	ExcludePathsFromMetrics []*regexp.Regexp
+
+	// Hash consumer identifiers with HMAC-SHA256 using this key before they leave the
+	// process, so that raw identifiers (e.g. user IDs) never reach Apitally. Names and
+	// groups of consumers are still sent if set, so leave them empty to keep consumers
+	// anonymous. Changing the key makes the same consumers appear as new ones. Conditions
+	// and callbacks only see hashed identifiers, so use HashConsumerIdentifier to match
+	// consumers in them.
+	ConsumerHashKey []byte
+
+	// Maximum number of consumers with a name, group or tags to keep in memory, so
+	// memory stays bounded for APIs with many distinct consumers. The least recently
+	// seen consumers are evicted first, and sent to Apitally again when seen the next
+	// time. Defaults to 100,000 if 0. If ConsumerTTL is set, consumers not seen for that
+	// long are evicted as well.
+	MaxConsumers int
+	ConsumerTTL  time.Duration
+
+	// Count requests per endpoint by user agent family (e.g. browsers, bots and client
+	// libraries or SDKs) and version, to see which clients use the API.
+	CountUserAgents bool
+
+	// Sample the number of goroutines with every sync and report a warning to Apitally
+	// if it grew with each of the last 5 syncs (by at least 50 in total), along with the
+	// functions that created most goroutines, to catch goroutine leaks early.
+	DetectGoroutineLeaks bool
+
+	// Count responses with rate limit headers (X-RateLimit-Remaining, RateLimit-Remaining
+	// and Retry-After) or status 429 per consumer, along with the lowest remaining quota
+	// and longest Retry-After, to see which consumers are hitting rate limits.
+	CountRateLimits bool
+
+	// Extract validation errors from problem details responses (RFC 9457, formerly RFC
+	// 7807) with status 400 or 422, e.g. as returned by Huma, if none were captured
+	// using CaptureValidationError. This is useful for frameworks that don't use a
+	// supported validation library.
+	ValidationErrorsFromResponses bool
+
+	// Paths of GraphQL endpoints (e.g. "/graphql"), which would otherwise aggregate all
+	// requests under a single path. POST requests to these paths are aggregated by
+	// operation instead (e.g. "/graphql#GetUser"), parsed from request bodies up to
+	// MaxOperationBodySize. Errors in GraphQL responses are counted as validation errors.
+	GraphQLPaths []string
+
+	// Paths of JSON-RPC 2.0 endpoints (e.g. "/rpc"), which would otherwise aggregate all
+	// requests under a single path. POST requests to these paths are aggregated by method
+	// instead (e.g. "/rpc#eth_getBalance"), parsed from request bodies up to
+	// MaxOperationBodySize.
+	JSONRPCPaths []string
+
+	// Rules to classify requests, applied before the built-in classification of CORS
+	// preflight requests, health checks and bots. Requests are counted by traffic class,
+	// so dashboards can filter synthetic traffic from real usage. The first matching
+	// rule applies.
+	TrafficClassRules []TrafficClassRule
+
+	// Settings for which the local config takes precedence over configuration
+	// directives from the Apitally hub.
+	LocalPrecedence LocalPrecedence
+
 	// For testing purposes
 	DisableSync bool
 }
 
+// DefaultUnmatchedRoutePath is the path under which requests not matching any route
+// are counted by default.
+const DefaultUnmatchedRoutePath = "__unmatched__"
+
 func NewConfig(clientID string) *Config {
 	return &Config{
-		ClientID:       clientID,
-		Env:            "dev",
-		RequestLogging: NewRequestLoggingConfig(),
+		ClientID:           clientID,
+		Env:                "dev",
+		RequestLogging:     NewRequestLoggingConfig(),
+		UnmatchedRoutePath: DefaultUnmatchedRoutePath,
+		ExcludeMethods:     []string{http.MethodOptions},
+	}
+}
+
+// GetMaxBodySize returns the maximum size of logged bodies, which is MaxBodySize if set,
+// capped at MaxBodySizeLimit.
+func (c *RequestLoggingConfig) GetMaxBodySize() int {
+	if c == nil || c.MaxBodySize <= 0 {
+		return MaxBodySize
+	}
+	return min(c.MaxBodySize, MaxBodySizeLimit)
+}
+
+// Validate checks the client ID, env and hub URL. The returned error wraps
+// ErrInvalidClientID, ErrInvalidEnv and/or ErrInvalidHubURL.
+func (c *Config) Validate() error {
+	var errs []error
+	if _, err := uuid.Parse(c.ClientID); err != nil {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidClientID, c.ClientID))
+	}
+	if !envRegexp.MatchString(c.Env) {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidEnv, c.Env))
+	}
+	if c.HubURL != "" && !isValidHubURL(c.HubURL) {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrInvalidHubURL, c.HubURL))
+	}
+	return errors.Join(errs...)
+}
+
+func isValidHubURL(hubURL string) bool {
+	u, err := url.Parse(hubURL)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return u.Host != ""
+	case "unix":
+		return u.Path != ""
+	default:
+		return false
+	}
+}
+
+// IsExcludedFromMetrics returns whether requests to the given route pattern should be
+// excluded from metrics.
+func (c *Config) IsExcludedFromMetrics(path string) bool {
+	for _, pattern := range c.ExcludePathsFromMetrics {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsGraphQLPath returns whether requests to the given path should be aggregated by
+// GraphQL operation.
+func (c *Config) IsGraphQLPath(path string) bool {
+	for _, graphQLPath := range c.GraphQLPaths {
+		if path == graphQLPath {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJSONRPCPath returns whether requests to the given path should be aggregated by
+// JSON-RPC method.
+func (c *Config) IsJSONRPCPath(path string) bool {
+	for _, jsonRPCPath := range c.JSONRPCPaths {
+		if path == jsonRPCPath {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizePath returns the route pattern as normalized by PathNormalizer, if set.
+func (c *Config) NormalizePath(path string) string {
+	if c.PathNormalizer == nil || path == "" {
+		return path
+	}
+	return c.PathNormalizer(path)
+}
+
+// IsExcludedMethod returns whether requests with the given HTTP method should be
+// excluded from counting and logging.
+func (c *Config) IsExcludedMethod(method string) bool {
+	for _, excludedMethod := range c.ExcludeMethods {
+		if strings.EqualFold(method, excludedMethod) {
+			return true
+		}
 	}
+	return false
 }
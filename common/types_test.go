@@ -1,6 +1,8 @@
 package common
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,6 +13,8 @@ func TestNewConfig(t *testing.T) {
 
 	assert.Equal(t, "test-client-id", config.ClientID)
 	assert.Equal(t, "dev", config.Env)
+	assert.Equal(t, DefaultUnmatchedRoutePath, config.UnmatchedRoutePath)
+	assert.Equal(t, []string{"OPTIONS"}, config.ExcludeMethods)
 
 	assert.NotNil(t, config.RequestLogging)
 	assert.False(t, config.RequestLogging.Enabled)
@@ -21,3 +25,90 @@ func TestNewConfig(t *testing.T) {
 	assert.False(t, config.RequestLogging.LogResponseBody)
 	assert.True(t, config.RequestLogging.LogPanic)
 }
+
+func TestConfigValidate(t *testing.T) {
+	config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	assert.NoError(t, config.Validate())
+
+	config.ClientID = "invalid"
+	assert.ErrorIs(t, config.Validate(), ErrInvalidClientID)
+	assert.NotErrorIs(t, config.Validate(), ErrInvalidEnv)
+
+	config.Env = "invalid_env"
+	assert.ErrorIs(t, config.Validate(), ErrInvalidClientID)
+	assert.ErrorIs(t, config.Validate(), ErrInvalidEnv)
+
+	config = NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	for _, hubURL := range []string{"http://localhost:8126", "https://hub.example.com/", "unix:///run/apitally.sock"} {
+		config.HubURL = hubURL
+		assert.NoError(t, config.Validate(), hubURL)
+	}
+	for _, hubURL := range []string{"localhost:8126", "ftp://localhost", "http://", "unix://"} {
+		config.HubURL = hubURL
+		assert.ErrorIs(t, config.Validate(), ErrInvalidHubURL, hubURL)
+	}
+}
+
+func TestConfigIsExcludedMethod(t *testing.T) {
+	config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	assert.True(t, config.IsExcludedMethod("OPTIONS"))
+	assert.False(t, config.IsExcludedMethod("GET"))
+
+	config.ExcludeMethods = []string{"head", "OPTIONS"}
+	assert.True(t, config.IsExcludedMethod("HEAD"))
+	assert.True(t, config.IsExcludedMethod("OPTIONS"))
+
+	config.ExcludeMethods = nil
+	assert.False(t, config.IsExcludedMethod("OPTIONS"))
+}
+
+func TestConfigIsExcludedFromMetrics(t *testing.T) {
+	config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	assert.False(t, config.IsExcludedFromMetrics("/healthz"))
+
+	config.ExcludePathsFromMetrics = []*regexp.Regexp{regexp.MustCompile(`^/healthz?$`)}
+	assert.True(t, config.IsExcludedFromMetrics("/health"))
+	assert.True(t, config.IsExcludedFromMetrics("/healthz"))
+	assert.False(t, config.IsExcludedFromMetrics("/items/{id}"))
+}
+
+func TestConfigNormalizePath(t *testing.T) {
+	config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	assert.Equal(t, "/v1/items/{id}", config.NormalizePath("/v1/items/{id}"))
+
+	config.PathNormalizer = func(path string) string {
+		return strings.Replace(path, "/v1/", "/", 1)
+	}
+	assert.Equal(t, "/items/{id}", config.NormalizePath("/v1/items/{id}"))
+	assert.Equal(t, "", config.NormalizePath(""))
+}
+
+func TestTrafficClassRuleMatches(t *testing.T) {
+	rule := TrafficClassRule{Class: "synthetic"}
+	assert.True(t, rule.Matches("GET", "/items", ""))
+
+	rule = TrafficClassRule{
+		Class:     "synthetic",
+		Method:    "get",
+		Path:      regexp.MustCompile(`^/items`),
+		UserAgent: regexp.MustCompile(`^Checkly/`),
+	}
+	assert.True(t, rule.Matches("GET", "/items/{id}", "Checkly/1.0"))
+	assert.False(t, rule.Matches("POST", "/items/{id}", "Checkly/1.0"))
+	assert.False(t, rule.Matches("GET", "/users", "Checkly/1.0"))
+	assert.False(t, rule.Matches("GET", "/items/{id}", "curl/8.0"))
+}
+
+func TestRequestLoggingConfigGetMaxBodySize(t *testing.T) {
+	config := NewRequestLoggingConfig()
+	assert.Equal(t, MaxBodySize, config.GetMaxBodySize())
+
+	config.MaxBodySize = 4_000
+	assert.Equal(t, 4_000, config.GetMaxBodySize())
+
+	config.MaxBodySize = 10_000_000
+	assert.Equal(t, MaxBodySizeLimit, config.GetMaxBodySize())
+
+	config = nil
+	assert.Equal(t, MaxBodySize, config.GetMaxBodySize())
+}
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -8,6 +11,11 @@ import (
 	"strings"
 )
 
+// StatusClientClosedRequest is counted instead of the response status code for requests
+// whose context was canceled before the handler finished, following the convention of
+// nginx's 499 status code.
+const StatusClientClosedRequest = 499
+
 func TruncateValidationErrorMessage(msg string) string {
 	re := regexp.MustCompile(`^Key: '.+' Error:(.+)$`)
 	matches := re.FindStringSubmatch(msg)
@@ -43,3 +51,16 @@ func TransformHeaders(header http.Header) [][2]string {
 	}
 	return headers
 }
+
+// HashConsumerIdentifier returns the identifier of a consumer as it appears in requests
+// if Config.ConsumerHashKey is set to key, after normalizing it like the SDK does. Use it
+// to match consumers with hashed identifiers, e.g. using ConsumerIn.
+func HashConsumerIdentifier(identifier string, key []byte) string {
+	identifier = strings.TrimSpace(identifier)
+	if len(identifier) > 128 {
+		identifier = identifier[:128]
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(identifier))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,163 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ValidationError describes a single validation error, e.g. of a field in the request
+// body. It can be used to report errors of any validation library.
+type ValidationError struct {
+	// Location of the invalid field, with nested fields separated by dots
+	// (e.g. "address.city")
+	Location string
+
+	Message string
+
+	// Type of the error, e.g. the validation rule that failed (e.g. "required")
+	Type string
+}
+
+// fieldError is implemented by validator.FieldError of go-playground/validator.
+type fieldError interface {
+	Field() string
+	Namespace() string
+	StructNamespace() string
+	Tag() string
+	Error() string
+}
+
+// codedError is implemented by validation.Error of ozzo-validation.
+type codedError interface {
+	Code() string
+	Error() string
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// JSONFieldName returns the name of a struct field in JSON. Register it with
+// RegisterTagNameFunc of go-playground/validator, so that the locations of validation
+// errors match the field names sent by clients (e.g. "name" rather than "Name").
+func JSONFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// ParseValidationErrors converts an error returned by a validation library into
+// validation errors. Supported are:
+//   - validator.ValidationErrors of go-playground/validator
+//   - validation.Errors of ozzo-validation, including nested errors
+//   - form.DecodeErrors of go-playground/form
+//   - *json.UnmarshalTypeError of encoding/json
+//
+// Wrapped errors are unwrapped. Nil is returned if the error isn't supported.
+func ParseValidationErrors(err error) []ValidationError {
+	if err == nil {
+		return nil
+	}
+
+	var unmarshalTypeError *json.UnmarshalTypeError
+	if errors.As(err, &unmarshalTypeError) {
+		return []ValidationError{{
+			Location: unmarshalTypeError.Field,
+			Message:  fmt.Sprintf("cannot unmarshal %s into value of type %s", unmarshalTypeError.Value, unmarshalTypeError.Type),
+			Type:     "type_error",
+		}}
+	}
+
+	if validationErrors := parseValidationErrors(err, ""); len(validationErrors) > 0 {
+		return validationErrors
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return ParseValidationErrors(e.Unwrap())
+	case interface{ Unwrap() []error }:
+		var validationErrors []ValidationError
+		for _, err := range e.Unwrap() {
+			validationErrors = append(validationErrors, ParseValidationErrors(err)...)
+		}
+		return validationErrors
+	}
+	return nil
+}
+
+// parseValidationErrors uses reflection to support validation libraries without
+// depending on them: a slice of field errors (go-playground/validator) or a map of
+// field names to errors (ozzo-validation, go-playground/form).
+func parseValidationErrors(err error, prefix string) []ValidationError {
+	v := reflect.ValueOf(err)
+	switch {
+	case v.Kind() == reflect.Slice:
+		var validationErrors []ValidationError
+		for i := 0; i < v.Len(); i++ {
+			fe, ok := v.Index(i).Interface().(fieldError)
+			if !ok {
+				return nil
+			}
+			validationErrors = append(validationErrors, ValidationError{
+				Location: prefix + getFieldErrorLocation(fe),
+				Message:  TruncateValidationErrorMessage(fe.Error()),
+				Type:     fe.Tag(),
+			})
+		}
+		return validationErrors
+
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String && v.Type().Elem() == errorType:
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		slices.Sort(keys)
+
+		var validationErrors []ValidationError
+		for _, key := range keys {
+			fieldErr, _ := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())).Interface().(error)
+			if fieldErr == nil {
+				continue
+			}
+			if nested := parseValidationErrors(fieldErr, prefix+key+"."); len(nested) > 0 {
+				validationErrors = append(validationErrors, nested...)
+				continue
+			}
+			errType := "invalid"
+			if ce, ok := fieldErr.(codedError); ok && ce.Code() != "" {
+				errType = ce.Code()
+			}
+			validationErrors = append(validationErrors, ValidationError{
+				Location: prefix + key,
+				Message:  fieldErr.Error(),
+				Type:     errType,
+			})
+		}
+		return validationErrors
+	}
+	return nil
+}
+
+// getFieldErrorLocation returns the full path of the field without the name of the
+// top-level struct (e.g. "address.city"), or the field name if it has no namespace.
+// The namespace only starts with the name of the top-level struct if the struct type
+// is named, in which case it's the same in the struct namespace.
+func getFieldErrorLocation(fe fieldError) string {
+	namespace := fe.Namespace()
+	if namespace == "" {
+		return fe.Field()
+	}
+	typeName, location, found := strings.Cut(namespace, ".")
+	structTypeName, _, _ := strings.Cut(fe.StructNamespace(), ".")
+	if found && typeName == structTypeName {
+		return location
+	}
+	return namespace
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Mimic the error types of validation libraries, which aren't dependencies
+
+type testFieldError struct {
+	namespace       string
+	structNamespace string
+	field           string
+	tag             string
+}
+
+func (e testFieldError) Field() string           { return e.field }
+func (e testFieldError) Namespace() string       { return e.namespace }
+func (e testFieldError) StructNamespace() string { return e.structNamespace }
+func (e testFieldError) Tag() string             { return e.tag }
+func (e testFieldError) Error() string {
+	return fmt.Sprintf("Key: 'Item.%s' Error:Field validation for '%s' failed on the '%s' tag", e.field, e.field, e.tag)
+}
+
+type testFieldErrors []testFieldError
+
+func (e testFieldErrors) Error() string { return "validation failed" }
+
+type testCodedError struct {
+	code    string
+	message string
+}
+
+func (e testCodedError) Code() string  { return e.code }
+func (e testCodedError) Error() string { return e.message }
+
+type testErrorMap map[string]error
+
+func (e testErrorMap) Error() string { return "validation failed" }
+
+func TestParseValidationErrors(t *testing.T) {
+	t.Run("FieldErrors", func(t *testing.T) {
+		err := testFieldErrors{
+			{namespace: "Item.name", structNamespace: "Item.Name", field: "name", tag: "required"},
+			{namespace: "address.city", structNamespace: "Address.City", field: "city", tag: "min"},
+			{field: "Age", tag: "min"},
+		}
+		assert.Equal(t, []ValidationError{
+			{Location: "name", Message: "Field validation for 'name' failed on the 'required' tag", Type: "required"},
+			{Location: "address.city", Message: "Field validation for 'city' failed on the 'min' tag", Type: "min"},
+			{Location: "Age", Message: "Field validation for 'Age' failed on the 'min' tag", Type: "min"},
+		}, ParseValidationErrors(err))
+	})
+
+	t.Run("ErrorMap", func(t *testing.T) {
+		err := testErrorMap{
+			"name": testCodedError{code: "validation_required", message: "cannot be blank"},
+			"address": testErrorMap{
+				"city": errors.New("must be a valid city"),
+			},
+			"age": nil,
+		}
+		assert.Equal(t, []ValidationError{
+			{Location: "address.city", Message: "must be a valid city", Type: "invalid"},
+			{Location: "name", Message: "cannot be blank", Type: "validation_required"},
+		}, ParseValidationErrors(err))
+	})
+
+	t.Run("JSONUnmarshalTypeError", func(t *testing.T) {
+		var v struct {
+			Age int `json:"age"`
+		}
+		err := json.Unmarshal([]byte(`{"age": "ten"}`), &v)
+		assert.Equal(t, []ValidationError{
+			{Location: "age", Message: "cannot unmarshal string into value of type int", Type: "type_error"},
+		}, ParseValidationErrors(err))
+	})
+
+	t.Run("Wrapped", func(t *testing.T) {
+		err := fmt.Errorf("invalid request: %w", testFieldErrors{{field: "Name", tag: "required"}})
+		assert.Len(t, ParseValidationErrors(err), 1)
+
+		err = errors.Join(errors.New("other"), testErrorMap{"name": errors.New("invalid")})
+		assert.Len(t, ParseValidationErrors(err), 1)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		assert.Nil(t, ParseValidationErrors(nil))
+		assert.Nil(t, ParseValidationErrors(errors.New("invalid")))
+	})
+}
+
+func TestJSONFieldName(t *testing.T) {
+	type item struct {
+		Name    string `json:"name,omitempty"`
+		Comment string `json:"-"`
+		Count   int
+	}
+	itemType := reflect.TypeOf(item{})
+	assert.Equal(t, "name", JSONFieldName(itemType.Field(0)))
+	assert.Equal(t, "", JSONFieldName(itemType.Field(1)))
+	assert.Equal(t, "Count", JSONFieldName(itemType.Field(2)))
+}
@@ -0,0 +1,92 @@
+package apitally
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apitally/apitally-go/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	t.Run("UpdateConfig", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		err := UpdateConfig(config)
+		assert.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.Equal(t, "/hello", logItems[0].Request.Path)
+
+		config.Env = "invalid_env"
+		err = UpdateConfig(config)
+		assert.ErrorIs(t, err, ErrInvalidEnv)
+	})
+
+	t.Run("Forwarding", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		// Behavior is tested in the internal package, so only check that calls reach the client
+		assert.NoError(t, Heartbeat("nightly-report"))
+		assert.NoError(t, ForgetConsumer("tester"))
+		assert.NoError(t, TrackTask(context.Background(), "cleanup", func(ctx context.Context) error { return nil }))
+		handler := WrapMessageHandler("kafka", "orders", func(ctx context.Context, message string) error { return nil })
+		assert.NoError(t, handler(context.Background(), "valid"))
+
+		assert.Len(t, c.HeartbeatRegistry.GetAndResetHeartbeats(), 1)
+		assert.Equal(t, []string{"tester"}, c.ConsumerRegistry.GetAndResetDeletedConsumers())
+		assert.Len(t, c.TaskCounter.GetAndResetTasks(), 1)
+		assert.Len(t, c.MessageCounter.GetAndResetMessages(), 1)
+	})
+
+	t.Run("NotInitialized", func(t *testing.T) {
+		internal.ResetApitallyClient()
+
+		assert.ErrorIs(t, UpdateConfig(NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")), ErrNotInitialized)
+		assert.ErrorIs(t, Flush(), ErrNotInitialized)
+		assert.ErrorIs(t, Heartbeat("nightly-report"), ErrNotInitialized)
+		assert.ErrorIs(t, ForgetConsumer("tester"), ErrNotInitialized)
+		assert.NoError(t, TrackTask(context.Background(), "cleanup", func(ctx context.Context) error { return nil }))
+		_, err := GetStatus()
+		assert.ErrorIs(t, err, ErrNotInitialized)
+		_, err = GetSnapshot()
+		assert.ErrorIs(t, err, ErrNotInitialized)
+		_, err = Diagnose()
+		assert.ErrorIs(t, err, ErrNotInitialized)
+	})
+
+	t.Run("Status", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		status, err := GetStatus()
+		assert.NoError(t, err)
+		assert.True(t, status.Enabled)
+		assert.False(t, status.RequestLoggingEnabled)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/apitally-status", nil)
+		StatusHandler().ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"hub_reachable":true`)
+	})
+}
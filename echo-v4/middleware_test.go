@@ -2,13 +2,17 @@ package apitally
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
@@ -161,6 +165,30 @@ func TestMiddleware(t *testing.T) {
 		}))
 	})
 
+	t.Run("CaptureValidationErrors", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		e.POST("/items", func(c echo.Context) error {
+			CaptureValidationErrors(c, []ValidationError{{Location: "body.name", Message: "is too short", Type: "min_length"}})
+			return c.NoContent(http.StatusUnprocessableEntity)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/items", nil)
+		e.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		assert.Len(t, validationErrors, 1)
+		assert.Equal(t, "/items", validationErrors[0].Path)
+		assert.Equal(t, []string{"body", "name"}, validationErrors[0].Loc)
+		assert.Equal(t, "is too short", validationErrors[0].Msg)
+		assert.Equal(t, "min_length", validationErrors[0].Type)
+	})
+
 	t.Run("ServerErrorCounter", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		e := setupTestApp(false)
@@ -177,11 +205,56 @@ func TestMiddleware(t *testing.T) {
 
 		assert.Equal(t, "GET", errors[0].Method)
 		assert.Equal(t, "/error", errors[0].Path)
-		assert.Equal(t, "errors.errorString", errors[0].Type)
+		assert.Equal(t, "string", errors[0].Type)
 		assert.Equal(t, "test panic", errors[0].Message)
 		assert.Contains(t, errors[0].StackTrace, "panic")
 	})
 
+	t.Run("RejectedRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		e.GET("/protected", func(c echo.Context) error {
+			MarkRejected(c, "auth")
+			return c.NoContent(http.StatusUnauthorized)
+		})
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "/protected", requests[0].Path)
+		assert.Equal(t, http.StatusUnauthorized, requests[0].StatusCode)
+		assert.Equal(t, "auth", requests[0].RejectionStage)
+	})
+
+	t.Run("RequestTags", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		e.GET("/tagged", func(c echo.Context) error {
+			SetRequestTags(c, map[string]string{"tier": "gold"})
+			SetRequestTags(c, map[string]string{"region": "eu"})
+			return c.NoContent(http.StatusOK)
+		})
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tagged", nil)
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, map[string]string{"tier": "gold", "region": "eu"}, requests[0].Tags)
+	})
+
 	t.Run("RequestLogger", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		e := setupTestApp(true)
@@ -254,9 +327,152 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, "GET", errorLogItem.Request.Method)
 		assert.Equal(t, "/error", errorLogItem.Request.Path)
 		assert.Equal(t, 500, errorLogItem.Response.StatusCode)
+		assert.Greater(t, errorLogItem.Response.ResponseTime, 0.0)
 		assert.NotNil(t, errorLogItem.Exception)
-		assert.Equal(t, "errors.errorString", errorLogItem.Exception.Type)
+		assert.Equal(t, "string", errorLogItem.Exception.Type)
 		assert.Equal(t, "test panic", errorLogItem.Exception.Message)
 		assert.Contains(t, errorLogItem.Exception.StackTrace, "panic")
 	})
+
+	t.Run("CanceledRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		// Simulate the client disconnecting while the handler is running
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		e.GET("/canceled", func(c echo.Context) error {
+			cancel()
+			return c.NoContent(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/canceled", nil).WithContext(ctx)
+		e.ServeHTTP(w, req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, common.StatusClientClosedRequest, requests[0].StatusCode)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Request.Canceled)
+		assert.Equal(t, http.StatusOK, logItems[0].Response.StatusCode)
+	})
+
+	t.Run("TimedOutRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		e.GET("/timeout", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/timeout", nil).WithContext(ctx)
+		e.ServeHTTP(w, req)
+
+		timeouts := c.TimeoutCounter.GetAndResetTimeouts()
+		assert.Len(t, timeouts, 1)
+		assert.Equal(t, "/timeout", timeouts[0].Path)
+		assert.Equal(t, 1, timeouts[0].TimeoutCount)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Request.TimedOut)
+		assert.True(t, logItems[0].Request.Canceled)
+	})
+
+	t.Run("ErrorHandler", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		errTeapot := errors.New("teapot")
+		e.GET("/conflict", func(c echo.Context) error {
+			return echo.NewHTTPError(http.StatusConflict, "already exists")
+		})
+		e.GET("/teapot", func(c echo.Context) error {
+			return errTeapot
+		})
+		defaultErrorHandler := e.HTTPErrorHandler
+		e.HTTPErrorHandler = func(err error, c echo.Context) {
+			// Custom error handler rewriting the status for some errors, which skips
+			// committed responses as recommended by Echo
+			if c.Response().Committed {
+				return
+			}
+			if errors.Is(err, errTeapot) {
+				c.JSON(http.StatusTeapot, map[string]string{"error": err.Error()})
+				return
+			}
+			defaultErrorHandler(err, c)
+		}
+
+		for _, tt := range []struct {
+			path       string
+			statusCode int
+			body       string
+		}{
+			{"/conflict", http.StatusConflict, "already exists"},
+			{"/teapot", http.StatusTeapot, "teapot"},
+		} {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tt.statusCode, rec.Code)
+
+			// Response is only written once, even though the error bubbles up
+			assert.Equal(t, 1, strings.Count(rec.Body.String(), tt.body))
+
+			requests := c.RequestCounter.GetAndResetRequests()
+			assert.Len(t, requests, 1)
+			assert.Equal(t, tt.path, requests[0].Path)
+			assert.Equal(t, tt.statusCode, requests[0].StatusCode)
+
+			logItems := c.RequestLogger.GetPendingWrites()
+			assert.Len(t, logItems, 1)
+			assert.Equal(t, tt.statusCode, logItems[0].Response.StatusCode)
+			assert.Contains(t, string(logItems[0].Response.Body), tt.body)
+		}
+	})
+
+	t.Run("UnmatchedRoute", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/nope/123", nil)
+		e.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "GET", requests[0].Method)
+		assert.Equal(t, common.DefaultUnmatchedRoutePath, requests[0].Path)
+		assert.Equal(t, http.StatusNotFound, requests[0].StatusCode)
+	})
+
+	t.Run("ExcludedMethod", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		e := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "/hello", nil)
+		e.ServeHTTP(w, req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 0)
+	})
 }
@@ -2,6 +2,7 @@ package apitally
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -11,7 +12,6 @@ import (
 
 	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
-	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v5"
 )
 
@@ -30,13 +30,14 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 		// Delay startup data collection to ensure all routes are registered
 		go func() {
 			time.Sleep(time.Second)
-			client.SetStartupData(getRoutes(e), getVersions(config.AppVersion), "go:echo")
+			client.SetStartupData(getRoutes(e, config), getVersions(config.AppVersion), "go:echo")
+			client.LogDiagnostics()
 		}()
 	}
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c *echo.Context) error {
-			if !client.IsEnabled() || c.Request().Method == "OPTIONS" {
+		return func(c *echo.Context) (err error) {
+			if !client.IsEnabled() || config.IsExcludedMethod(c.Request().Method) {
 				return next(c)
 			}
 
@@ -47,6 +48,9 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 			// Start log capture
 			logHandle := client.LogCollector.StartCapture(spanHandle.Context())
 
+			// Start profiling of slow requests, if enabled
+			profileHandle := client.RequestLogger.StartProfile()
+
 			// Inject context into request
 			c.SetRequest(c.Request().WithContext(logHandle.Context()))
 
@@ -54,38 +58,52 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 			requestSize := common.ParseContentLength(c.Request().Header.Get("Content-Length"))
 
 			// Cache request body if needed
-			var requestBody []byte
+			var requestBody *bytes.Buffer
 			var requestReader *common.RequestReader
-			captureRequestBody := client.Config.RequestLogging != nil &&
-				client.Config.RequestLogging.Enabled &&
-				client.Config.RequestLogging.LogRequestBody &&
-				client.RequestLogger.IsSupportedContentType(c.Request().Header.Get("Content-Type"))
-
-			if c.Request().Body != nil && requestSize <= common.MaxBodySize {
-				if captureRequestBody {
-					// Capture the body for logging
-					var err error
-					requestBody, err = io.ReadAll(c.Request().Body)
-					if err == nil {
-						c.Request().Body = io.NopCloser(bytes.NewBuffer(requestBody))
-						requestSize = int64(len(requestBody))
+			captureRequestBody := client.RequestLogger.ShouldLogRequestBody(c.Request().Header.Get("Content-Type"))
+
+			// GraphQL and JSON-RPC requests are captured to determine the operation
+			captureGraphQL := c.Request().Method == http.MethodPost && config.IsGraphQLPath(c.Request().URL.Path)
+			captureJSONRPC := c.Request().Method == http.MethodPost && config.IsJSONRPCPath(c.Request().URL.Path)
+			maxBodySize := client.RequestLogger.MaxBodySize()
+			if captureGraphQL || captureJSONRPC {
+				maxBodySize = max(maxBodySize, common.MaxOperationBodySize)
+			}
+
+			if c.Request().Body != nil && requestSize <= int64(maxBodySize) {
+				if captureRequestBody || captureGraphQL || captureJSONRPC {
+					// Capture the body for logging or to determine the operation
+					requestBody = common.GetBodyBuffer()
+					if _, err := requestBody.ReadFrom(c.Request().Body); err == nil {
+						c.Request().Body = io.NopCloser(bytes.NewReader(requestBody.Bytes()))
+						requestSize = int64(requestBody.Len())
 					}
 				} else if requestSize == -1 {
 					// Only measure request body size
 					requestReader = &common.RequestReader{Reader: c.Request().Body}
 					c.Request().Body = requestReader
 				}
+			} else if c.Request().Body != nil && captureRequestBody && client.RequestLogger.TruncateLargeBodies() {
+				// Capture only the beginning of the body for logging
+				requestBody = common.GetBodyBuffer()
+				c.Request().Body = common.ReadBodyPrefix(c.Request().Body, requestBody, client.RequestLogger.MaxBodySize())
 			}
 
 			// Prepare response writer to capture body if needed
-			var responseBody bytes.Buffer
+			var responseBody *bytes.Buffer
+			captureResponseBody := client.RequestLogger.ShouldLogResponseBody()
+			if captureResponseBody || config.ValidationErrorsFromResponses || captureGraphQL {
+				responseBody = common.GetBodyBuffer()
+			}
 			rw := &common.ResponseWriter{
-				ResponseWriter: c.Response(),
-				Body:           &responseBody,
-				CaptureBody: client.Config.RequestLogging != nil &&
-					client.Config.RequestLogging.Enabled &&
-					client.Config.RequestLogging.LogResponseBody,
+				ResponseWriter:         c.Response(),
+				Body:                   responseBody,
+				CaptureBody:            captureResponseBody,
+				CaptureProblemDetails:  config.ValidationErrorsFromResponses,
+				CaptureGraphQL:         captureGraphQL,
 				IsSupportedContentType: client.RequestLogger.IsSupportedContentType,
+				MaxBodySize:            client.RequestLogger.MaxBodySize(),
+				TruncateBody:           client.RequestLogger.TruncateLargeBodies(),
 			}
 			c.SetResponse(rw)
 
@@ -93,8 +111,26 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 
 			defer func() {
 				duration := time.Since(start)
-				routePattern := c.Path()
+				routePattern := config.NormalizePath(c.Path())
 				statusCode := rw.Status()
+				if res, err := echo.UnwrapResponse(c.Response()); err == nil && res.Committed {
+					// The status of Echo's response is final, even if a later middleware
+					// or the error handler rewrote it after the wrapped writer saw it
+					statusCode = res.Status
+				}
+				if routePattern == "" {
+					routePattern = config.UnmatchedRoutePath
+				} else if captureGraphQL && requestBody != nil {
+					// Aggregate GraphQL requests by operation
+					if operation, ok := common.ParseGraphQLOperation(requestBody.Bytes()); ok {
+						routePattern = operation.Path(routePattern)
+					}
+				} else if captureJSONRPC && requestBody != nil {
+					// Aggregate JSON-RPC requests by method
+					if method, ok := common.ParseJSONRPCMethod(requestBody.Bytes()); ok {
+						routePattern = common.JSONRPCMethodPath(routePattern, method)
+					}
+				}
 
 				// End span collection and get spans
 				spanHandle.SetName(fmt.Sprintf("%s %s", c.Request().Method, routePattern))
@@ -103,6 +139,9 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 				// End log capture and get logs
 				logs := logHandle.End()
 
+				// End profiling and get profiles captured if the request was slow
+				profile := profileHandle.End()
+
 				// Update request size from reader if needed
 				if requestReader != nil && requestSize == -1 {
 					requestSize = requestReader.Size()
@@ -116,22 +155,34 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 					panicValue = r
 					statusCode = http.StatusInternalServerError
 					stackTrace = string(debug.Stack())
-					if err, ok := r.(error); ok {
-						recoveredErr = err
-					} else {
-						recoveredErr = fmt.Errorf("%v", r)
-					}
+					recoveredErr = common.NewPanicError(r)
+				}
+
+				// Check if the request context was canceled before the handler finished,
+				// e.g. because the client disconnected or a timeout was exceeded
+				ctxErr := c.Request().Context().Err()
+				canceled := ctxErr != nil
+				timedOut := errors.Is(ctxErr, context.DeadlineExceeded)
+				counterStatusCode := statusCode
+				if canceled && recoveredErr == nil {
+					counterStatusCode = common.StatusClientClosedRequest
 				}
 
 				// Get consumer info if available
 				var consumerIdentifier string
 				if consumer := c.Get("ApitallyConsumer"); consumer != nil {
-					if consumerObj := internal.ConsumerFromStringOrObject(consumer); consumerObj != nil {
+					if consumerObj := client.ConsumerFromStringOrObject(consumer); consumerObj != nil {
 						consumerIdentifier = consumerObj.Identifier
 						client.ConsumerRegistry.AddOrUpdateConsumer(consumerObj)
 					}
 				}
 
+				// Get rejection stage if marked by an upstream middleware
+				rejectionStage, _ := c.Get("ApitallyRejectionStage").(string)
+
+				// Get request tags if set
+				requestTags, _ := c.Get("ApitallyRequestTags").(map[string]string)
+
 				// Determine response size
 				responseSize := common.ParseContentLength(c.Response().Header().Get("Content-Length"))
 				if responseSize == -1 {
@@ -139,32 +190,40 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 				}
 
 				// Count request
-				if routePattern != "" {
-					client.RequestCounter.AddRequest(
-						consumerIdentifier,
-						c.Request().Method,
-						routePattern,
-						statusCode,
-						float64(duration.Milliseconds()),
-						requestSize,
-						responseSize,
-					)
+				if routePattern != "" && !config.IsExcludedFromMetrics(routePattern) {
+					client.RequestCounter.AddRequest(internal.RequestInfo{
+						Consumer:       consumerIdentifier,
+						Method:         c.Request().Method,
+						Path:           routePattern,
+						StatusCode:     counterStatusCode,
+						ResponseTime:   duration.Seconds() * 1000.0,
+						RequestSize:    requestSize,
+						ResponseSize:   responseSize,
+						RejectionStage: rejectionStage,
+						Tags:           requestTags,
+						APIVersion:     config.GetAPIVersion(routePattern, c.Request().Header),
+						TrafficClass:   internal.ClassifyTraffic(config, c.Request().Method, routePattern, c.Request().Header.Get),
+					})
 
 					// Count validation errors if any
-					if valErrValue := c.Get("ApitallyValidationErrors"); valErrValue != nil {
-						validationErrors, ok := valErrValue.(validator.ValidationErrors)
-						if ok {
-							for _, fieldError := range validationErrors {
-								client.ValidationErrorCounter.AddValidationError(
-									consumerIdentifier,
-									c.Request().Method,
-									routePattern,
-									fieldError.Field(),
-									common.TruncateValidationErrorMessage(fieldError.Error()),
-									fieldError.Tag(),
-								)
-							}
-						}
+					validationErrors, _ := c.Get("ApitallyValidationErrors").([]common.ValidationError)
+					if len(validationErrors) == 0 && config.ValidationErrorsFromResponses && responseBody != nil &&
+						common.IsValidationProblemDetails(statusCode, rw.Header().Get("Content-Type")) {
+						validationErrors = common.ParseProblemDetails(responseBody.Bytes())
+					}
+					if len(validationErrors) == 0 && captureGraphQL && responseBody != nil &&
+						common.IsGraphQLResponseContentType(rw.Header().Get("Content-Type")) {
+						validationErrors = common.ParseGraphQLErrors(responseBody.Bytes())
+					}
+					for _, validationError := range validationErrors {
+						client.ValidationErrorCounter.AddValidationError(
+							consumerIdentifier,
+							c.Request().Method,
+							routePattern,
+							validationError.Location,
+							validationError.Message,
+							validationError.Type,
+						)
 					}
 
 					// Count server error if any
@@ -177,28 +236,51 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 							stackTrace,
 						)
 					}
+
+					// Count timeout if any
+					if timedOut {
+						client.TimeoutCounter.AddTimeout(consumerIdentifier, c.Request().Method, routePattern)
+					}
+
+					// Count user agent if enabled
+					if config.CountUserAgents {
+						client.UserAgentCounter.AddUserAgent(c.Request().Method, routePattern, c.Request().UserAgent())
+					}
+
+					// Count rate limit headers if enabled
+					if config.CountRateLimits {
+						client.RateLimitCounter.AddRateLimit(consumerIdentifier, statusCode, rw.Header().Get)
+					}
 				}
 
 				// Log request if enabled
-				if client.Config.RequestLogging != nil && client.Config.RequestLogging.Enabled {
+				if client.RequestLogger.ShouldLogRequest() {
 					request := common.Request{
-						Timestamp: float64(time.Now().UnixMilli()) / 1000.0,
-						Consumer:  consumerIdentifier,
-						Method:    c.Request().Method,
-						Path:      routePattern,
-						URL:       common.GetFullURL(c.Request()),
-						Headers:   common.TransformHeaders(c.Request().Header),
-						Size:      requestSize,
-						Body:      requestBody,
+						Timestamp:  float64(time.Now().UnixMilli()) / 1000.0,
+						Consumer:   consumerIdentifier,
+						Method:     c.Request().Method,
+						Path:       routePattern,
+						URL:        common.GetFullURL(c.Request()),
+						Headers:    common.TransformHeaders(c.Request().Header),
+						Size:       requestSize,
+						RemoteAddr: c.Request().RemoteAddr,
+						Canceled:   canceled,
+						TimedOut:   timedOut,
+						Tags:       requestTags,
 					}
 					response := common.Response{
 						StatusCode:   statusCode,
-						ResponseTime: float64(duration.Milliseconds()) / 1000.0,
+						ResponseTime: duration.Seconds(),
 						Headers:      common.TransformHeaders(c.Response().Header()),
 						Size:         responseSize,
-						Body:         responseBody.Bytes(),
+						Streaming:    rw.IsStreaming(),
 					}
-					client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID)
+					request.SetBodyBuffer(requestBody)
+					response.SetBodyBuffer(responseBody)
+					client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID, profile)
+				} else {
+					common.PutBodyBuffer(requestBody)
+					common.PutBodyBuffer(responseBody)
 				}
 
 				// Re-panic if there was a panic
@@ -207,20 +289,33 @@ func Middleware(e *echo.Echo, config *Config) echo.MiddlewareFunc {
 				}
 			}()
 
-			return next(c)
+			err = next(c)
+			if err != nil {
+				// Let the error handler write the response now, so its final status
+				// code and body are captured. Echo's default error handler ignores the
+				// error when it bubbles up, as the response is committed then.
+				c.Echo().HTTPErrorHandler(c, err)
+			}
+			return err
 		}
 	}
 }
 
+// CaptureValidationError captures validation errors returned by a validation library,
+// so they are counted by Apitally. Errors of go-playground/validator, ozzo-validation,
+// go-playground/form and encoding/json are supported, other errors are ignored.
 func CaptureValidationError(c *echo.Context, err error) {
-	if err == nil {
-		return
-	}
+	CaptureValidationErrors(c, common.ParseValidationErrors(err))
+}
 
-	var validationErrors validator.ValidationErrors
-	if errors.As(err, &validationErrors) {
-		c.Set("ApitallyValidationErrors", validationErrors)
+// CaptureValidationErrors captures validation errors of any validation approach, in
+// addition to previously captured ones, so they are counted by Apitally.
+func CaptureValidationErrors(c *echo.Context, validationErrors []ValidationError) {
+	if len(validationErrors) == 0 {
+		return
 	}
+	existing, _ := c.Get("ApitallyValidationErrors").([]common.ValidationError)
+	c.Set("ApitallyValidationErrors", append(existing, validationErrors...))
 }
 
 func SetConsumerIdentifier(c *echo.Context, consumerIdentifier string) {
@@ -230,3 +325,18 @@ func SetConsumerIdentifier(c *echo.Context, consumerIdentifier string) {
 func SetConsumer(c *echo.Context, consumer common.Consumer) {
 	c.Set("ApitallyConsumer", consumer)
 }
+
+// MarkRejected marks the request as rejected before reaching the handler, e.g. by an
+// authentication middleware responding with 401 or 403. Such requests are aggregated
+// under the given stage (e.g. "auth") so they don't inflate endpoint error rates.
+func MarkRejected(c *echo.Context, stage string) {
+	c.Set("ApitallyRejectionStage", stage)
+}
+
+// SetRequestTags sets tags for the request (e.g. tenant tier, region or API version),
+// by which request metrics can be broken down. The tags are merged with any tags set
+// before. At most 10 tags are kept, so their values should come from a small set.
+func SetRequestTags(c *echo.Context, tags map[string]string) {
+	existing, _ := c.Get("ApitallyRequestTags").(map[string]string)
+	c.Set("ApitallyRequestTags", internal.MergeTags(existing, tags))
+}
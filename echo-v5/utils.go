@@ -8,7 +8,7 @@ import (
 	"github.com/labstack/echo/v5"
 )
 
-func getRoutes(e *echo.Echo) []common.PathInfo {
+func getRoutes(e *echo.Echo, config *Config) []common.PathInfo {
 	routes := e.Router().Routes()
 	paths := make([]common.PathInfo, 0, len(routes))
 
@@ -16,7 +16,7 @@ func getRoutes(e *echo.Echo) []common.PathInfo {
 		if route.Method != "OPTIONS" && route.Method != "HEAD" {
 			paths = append(paths, common.PathInfo{
 				Method: route.Method,
-				Path:   route.Path,
+				Path:   config.NormalizePath(route.Path),
 			})
 		}
 	}
@@ -33,6 +33,9 @@ func getVersions(appVersion string) map[string]string {
 
 	if appVersion != "" {
 		versions["app"] = strings.TrimSpace(appVersion)
+	} else if buildInfo := common.GetBuildInfo(); buildInfo != nil && buildInfo.ModuleVersion != "" {
+		// Fall back to the version of the main module, if installed with a version
+		versions["app"] = buildInfo.ModuleVersion
 	}
 
 	return versions
@@ -18,7 +18,7 @@ func TestUtils(t *testing.T) {
 			})
 		})
 
-		routes := getRoutes(e)
+		routes := getRoutes(e, &Config{})
 		assert.Equal(t, 1, len(routes))
 		assert.Equal(t, "GET", routes[0].Method)
 		assert.Equal(t, "/hello", routes[0].Path)
@@ -17,7 +17,7 @@ func TestUtils(t *testing.T) {
 			})
 		})
 
-		routes := getRoutes(app)
+		routes := getRoutes(app, &Config{})
 		assert.Equal(t, 1, len(routes))
 		assert.Equal(t, "GET", routes[0].Method)
 		assert.Equal(t, "/hello", routes[0].Path)
@@ -1,6 +1,7 @@
 package apitally
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -12,7 +13,6 @@ import (
 
 	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
-	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v3"
 )
 
@@ -23,19 +23,23 @@ import (
 //   - Reference: https://docs.apitally.io/reference/go
 func Middleware(app *fiber.App, config *Config) fiber.Handler {
 	client := internal.InitApitallyClient(*config)
+	client.SetMiddlewareCheck(func() []common.Finding {
+		return internal.CheckMiddlewareOrder(getMiddlewareNames(app), "recover.New()")
+	})
 
 	// Sync should only be disabled for testing purposes
 	if !config.DisableSync {
 		client.StartSync()
 
 		app.Hooks().OnListen(func(data fiber.ListenData) error {
-			client.SetStartupData(getRoutes(app), getVersions(config.AppVersion), "go:fiber")
+			client.SetStartupData(getRoutes(app, config), getVersions(config.AppVersion), "go:fiber")
+			client.LogDiagnostics()
 			return nil
 		})
 	}
 
-	return func(c fiber.Ctx) error {
-		if !client.IsEnabled() {
+	return func(c fiber.Ctx) (err error) {
+		if !client.IsEnabled() || config.IsExcludedMethod(c.Method()) {
 			return c.Next()
 		}
 
@@ -46,6 +50,9 @@ func Middleware(app *fiber.App, config *Config) fiber.Handler {
 		// Start log capture
 		logHandle := client.LogCollector.StartCapture(spanHandle.Context())
 
+		// Start profiling of slow requests, if enabled
+		profileHandle := client.RequestLogger.StartProfile()
+
 		// Inject context into request
 		c.SetContext(logHandle.Context())
 
@@ -54,33 +61,59 @@ func Middleware(app *fiber.App, config *Config) fiber.Handler {
 
 		// Cache request body if needed
 		var requestBody []byte
-		if requestSize <= common.MaxBodySize &&
-			(requestSize == -1 ||
-				(client.Config.RequestLogging != nil &&
-					client.Config.RequestLogging.Enabled &&
-					client.Config.RequestLogging.LogRequestBody &&
-					client.RequestLogger.IsSupportedContentType(c.Get("Content-Type")))) {
+		maxBodySize := client.RequestLogger.MaxBodySize()
+		if requestSize <= int64(maxBodySize) &&
+			(requestSize == -1 || client.RequestLogger.ShouldLogRequestBody(c.Get("Content-Type"))) {
 			requestBody = slices.Clone(c.Request().Body())
 			if requestSize == -1 {
 				requestSize = int64(len(requestBody))
 			}
+		} else if client.RequestLogger.TruncateLargeBodies() && client.RequestLogger.ShouldLogRequestBody(c.Get("Content-Type")) {
+			// Capture only the beginning of the body for logging
+			body := c.Request().Body()
+			requestBody = slices.Clone(body[:min(len(body), maxBodySize)])
 		}
 
 		// Cache request data before c.Next() as Fiber v3 uses zero-copy
 		// strings that become invalid when the context is recycled
 		fullURL := strings.Clone(c.FullURL())
 		var requestHeaders [][2]string
-		if client.Config.RequestLogging != nil && client.Config.RequestLogging.Enabled {
+		if client.RequestLogger.IsEnabled() {
 			requestHeaders = transformHeaders(c.GetReqHeaders())
 		}
 
+		// GraphQL and JSON-RPC requests are aggregated by operation
+		captureGraphQL := c.Method() == http.MethodPost && config.IsGraphQLPath(c.Path())
+		captureJSONRPC := c.Method() == http.MethodPost && config.IsJSONRPCPath(c.Path())
+
+		// Remember the route of this middleware to detect requests not matching any route
+		middlewareRoute := c.Route()
+
 		start := time.Now()
 
 		defer func() {
 			duration := time.Since(start)
 			statusCode := int(c.Response().StatusCode())
 			method := string(c.Route().Method)
-			path := string(c.Route().Path)
+			path := config.NormalizePath(string(c.Route().Path))
+			if c.Route() == middlewareRoute {
+				// No route matched, so the returned error (e.g. 404) is only turned
+				// into a response by the error handler after this middleware
+				method = c.Method()
+				path = config.UnmatchedRoutePath
+				var fiberErr *fiber.Error
+				if errors.As(err, &fiberErr) {
+					statusCode = fiberErr.Code
+				}
+			} else if captureGraphQL {
+				if operation, ok := common.ParseGraphQLOperation(c.Request().Body()); ok {
+					path = operation.Path(path)
+				}
+			} else if captureJSONRPC {
+				if method, ok := common.ParseJSONRPCMethod(c.Request().Body()); ok {
+					path = common.JSONRPCMethodPath(path, method)
+				}
+			}
 
 			// End span collection and get spans
 			spanHandle.SetName(fmt.Sprintf("%s %s", method, path))
@@ -89,9 +122,8 @@ func Middleware(app *fiber.App, config *Config) fiber.Handler {
 			// End log capture and get logs
 			logs := logHandle.End()
 
-			if method == "OPTIONS" {
-				return
-			}
+			// End profiling and get profiles captured if the request was slow
+			profile := profileHandle.End()
 
 			// Capture error from panic if any
 			var panicValue any
@@ -101,62 +133,86 @@ func Middleware(app *fiber.App, config *Config) fiber.Handler {
 				panicValue = r
 				statusCode = http.StatusInternalServerError
 				stackTrace = string(debug.Stack())
-				if err, ok := r.(error); ok {
-					recoveredErr = err
-				} else {
-					recoveredErr = fmt.Errorf("%v", r)
-				}
+				recoveredErr = common.NewPanicError(r)
+			}
+
+			// Check if the request context was canceled before the handler finished,
+			// e.g. because the client disconnected or a timeout was exceeded
+			ctxErr := c.Context().Err()
+			canceled := ctxErr != nil
+			timedOut := errors.Is(ctxErr, context.DeadlineExceeded)
+			counterStatusCode := statusCode
+			if canceled && recoveredErr == nil {
+				counterStatusCode = common.StatusClientClosedRequest
 			}
 
 			// Get consumer info if available
 			var consumerIdentifier string
 			if consumer := c.Locals("ApitallyConsumer"); consumer != nil {
-				if consumerObj := internal.ConsumerFromStringOrObject(consumer); consumerObj != nil {
+				if consumerObj := client.ConsumerFromStringOrObject(consumer); consumerObj != nil {
 					consumerIdentifier = consumerObj.Identifier
 					client.ConsumerRegistry.AddOrUpdateConsumer(consumerObj)
 				}
 			}
 
+			// Get rejection stage if marked by an upstream middleware
+			rejectionStage, _ := c.Locals("ApitallyRejectionStage").(string)
+
+			// Get request tags if set
+			requestTags, _ := c.Locals("ApitallyRequestTags").(map[string]string)
+
 			// Determine response size
 			responseSize := common.ParseContentLength(c.GetRespHeader("Content-Length"))
 
 			// Cache response body if needed
 			var responseBody []byte
-			if responseSize == -1 ||
-				(client.Config.RequestLogging != nil &&
-					client.Config.RequestLogging.Enabled &&
-					client.Config.RequestLogging.LogResponseBody) {
+			streaming := c.Response().IsBodyStream()
+			if streaming {
+				// Reading the body of a streaming response would consume the entire stream
+			} else if responseSize == -1 || client.RequestLogger.ShouldLogResponseBody() {
 				responseBody = slices.Clone(c.Response().Body())
 				responseSize = int64(len(responseBody))
 			}
 
 			// Count request
-			if path != "" {
-				client.RequestCounter.AddRequest(
-					consumerIdentifier,
-					method,
-					path,
-					statusCode,
-					float64(duration.Milliseconds()),
-					requestSize,
-					responseSize,
-				)
+			if path != "" && !config.IsExcludedFromMetrics(path) {
+				var apiVersion string
+				if config.APIVersionCallback != nil {
+					apiVersion = config.GetAPIVersion(path, http.Header(c.GetReqHeaders()))
+				}
+				client.RequestCounter.AddRequest(internal.RequestInfo{
+					Consumer:       consumerIdentifier,
+					Method:         method,
+					Path:           path,
+					StatusCode:     counterStatusCode,
+					ResponseTime:   duration.Seconds() * 1000.0,
+					RequestSize:    requestSize,
+					ResponseSize:   responseSize,
+					RejectionStage: rejectionStage,
+					Tags:           requestTags,
+					APIVersion:     apiVersion,
+					TrafficClass:   internal.ClassifyTraffic(config, method, path, func(key string) string { return c.Get(key) }),
+				})
 
 				// Count validation errors if any
-				if valErrValue := c.Locals("ApitallyValidationErrors"); valErrValue != nil {
-					validationErrors, ok := valErrValue.(validator.ValidationErrors)
-					if ok {
-						for _, fieldError := range validationErrors {
-							client.ValidationErrorCounter.AddValidationError(
-								consumerIdentifier,
-								method,
-								path,
-								fieldError.Field(),
-								common.TruncateValidationErrorMessage(fieldError.Error()),
-								fieldError.Tag(),
-							)
-						}
-					}
+				validationErrors, _ := c.Locals("ApitallyValidationErrors").([]common.ValidationError)
+				if len(validationErrors) == 0 && config.ValidationErrorsFromResponses && !streaming &&
+					common.IsValidationProblemDetails(statusCode, c.GetRespHeader("Content-Type")) {
+					validationErrors = common.ParseProblemDetails(c.Response().Body())
+				}
+				if len(validationErrors) == 0 && captureGraphQL && !streaming &&
+					common.IsGraphQLResponseContentType(c.GetRespHeader("Content-Type")) {
+					validationErrors = common.ParseGraphQLErrors(c.Response().Body())
+				}
+				for _, validationError := range validationErrors {
+					client.ValidationErrorCounter.AddValidationError(
+						consumerIdentifier,
+						method,
+						path,
+						validationError.Location,
+						validationError.Message,
+						validationError.Type,
+					)
 				}
 
 				// Count server error if any
@@ -169,28 +225,48 @@ func Middleware(app *fiber.App, config *Config) fiber.Handler {
 						stackTrace,
 					)
 				}
+
+				// Count timeout if any
+				if timedOut {
+					client.TimeoutCounter.AddTimeout(consumerIdentifier, method, path)
+				}
+
+				// Count user agent if enabled
+				if config.CountUserAgents {
+					client.UserAgentCounter.AddUserAgent(method, path, c.Get("User-Agent"))
+				}
+
+				// Count rate limit headers if enabled
+				if config.CountRateLimits {
+					client.RateLimitCounter.AddRateLimit(consumerIdentifier, statusCode, func(key string) string { return c.GetRespHeader(key) })
+				}
 			}
 
 			// Log request if enabled
-			if client.Config.RequestLogging != nil && client.Config.RequestLogging.Enabled {
+			if client.RequestLogger.ShouldLogRequest() {
 				request := common.Request{
-					Timestamp: float64(time.Now().UnixMilli()) / 1000.0,
-					Consumer:  consumerIdentifier,
-					Method:    method,
-					Path:      path,
-					URL:       fullURL,
-					Headers:   requestHeaders,
-					Size:      requestSize,
-					Body:      requestBody,
+					Timestamp:  float64(time.Now().UnixMilli()) / 1000.0,
+					Consumer:   consumerIdentifier,
+					Method:     method,
+					Path:       path,
+					URL:        fullURL,
+					Headers:    requestHeaders,
+					Size:       requestSize,
+					RemoteAddr: c.RequestCtx().RemoteAddr().String(),
+					Canceled:   canceled,
+					TimedOut:   timedOut,
+					Tags:       requestTags,
+					Body:       requestBody,
 				}
 				response := common.Response{
 					StatusCode:   statusCode,
-					ResponseTime: float64(duration.Milliseconds()) / 1000.0,
+					ResponseTime: duration.Seconds(),
 					Headers:      transformHeaders(c.GetRespHeaders()),
 					Size:         responseSize,
 					Body:         responseBody,
+					Streaming:    streaming,
 				}
-				client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID)
+				client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID, profile)
 			}
 
 			// Re-panic if there was a panic
@@ -203,15 +279,21 @@ func Middleware(app *fiber.App, config *Config) fiber.Handler {
 	}
 }
 
+// CaptureValidationError captures validation errors returned by a validation library,
+// so they are counted by Apitally. Errors of go-playground/validator, ozzo-validation,
+// go-playground/form and encoding/json are supported, other errors are ignored.
 func CaptureValidationError(c fiber.Ctx, err error) {
-	if err == nil {
-		return
-	}
+	CaptureValidationErrors(c, common.ParseValidationErrors(err))
+}
 
-	var validationErrors validator.ValidationErrors
-	if errors.As(err, &validationErrors) {
-		c.Locals("ApitallyValidationErrors", validationErrors)
+// CaptureValidationErrors captures validation errors of any validation approach, in
+// addition to previously captured ones, so they are counted by Apitally.
+func CaptureValidationErrors(c fiber.Ctx, validationErrors []ValidationError) {
+	if len(validationErrors) == 0 {
+		return
 	}
+	existing, _ := c.Locals("ApitallyValidationErrors").([]common.ValidationError)
+	c.Locals("ApitallyValidationErrors", append(existing, validationErrors...))
 }
 
 func SetConsumerIdentifier(c fiber.Ctx, consumerIdentifier string) {
@@ -221,3 +303,18 @@ func SetConsumerIdentifier(c fiber.Ctx, consumerIdentifier string) {
 func SetConsumer(c fiber.Ctx, consumer common.Consumer) {
 	c.Locals("ApitallyConsumer", consumer)
 }
+
+// MarkRejected marks the request as rejected before reaching the handler, e.g. by an
+// authentication middleware responding with 401 or 403. Such requests are aggregated
+// under the given stage (e.g. "auth") so they don't inflate endpoint error rates.
+func MarkRejected(c fiber.Ctx, stage string) {
+	c.Locals("ApitallyRejectionStage", stage)
+}
+
+// SetRequestTags sets tags for the request (e.g. tenant tier, region or API version),
+// by which request metrics can be broken down. The tags are merged with any tags set
+// before. At most 10 tags are kept, so their values should come from a small set.
+func SetRequestTags(c fiber.Ctx, tags map[string]string) {
+	existing, _ := c.Locals("ApitallyRequestTags").(map[string]string)
+	c.Locals("ApitallyRequestTags", internal.MergeTags(existing, tags))
+}
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v3"
@@ -155,6 +156,29 @@ func TestMiddleware(t *testing.T) {
 		}))
 	})
 
+	t.Run("CaptureValidationErrors", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		app := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		app.Post("/items", func(c fiber.Ctx) error {
+			CaptureValidationErrors(c, []ValidationError{{Location: "body.name", Message: "is too short", Type: "min_length"}})
+			return c.SendStatus(http.StatusUnprocessableEntity)
+		})
+
+		req := httptest.NewRequest(http.MethodPost, "/items", nil)
+		resp, _ := app.Test(req)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		assert.Len(t, validationErrors, 1)
+		assert.Equal(t, "/items", validationErrors[0].Path)
+		assert.Equal(t, []string{"body", "name"}, validationErrors[0].Loc)
+		assert.Equal(t, "is too short", validationErrors[0].Msg)
+		assert.Equal(t, "min_length", validationErrors[0].Type)
+	})
+
 	t.Run("ServerErrorCounter", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		app := setupTestApp(false)
@@ -170,11 +194,79 @@ func TestMiddleware(t *testing.T) {
 
 		assert.Equal(t, "GET", errors[0].Method)
 		assert.Equal(t, "/error", errors[0].Path)
-		assert.Equal(t, "errors.errorString", errors[0].Type)
+		assert.Equal(t, "string", errors[0].Type)
 		assert.Equal(t, "test panic", errors[0].Message)
 		assert.Contains(t, errors[0].StackTrace, "panic")
 	})
 
+	t.Run("RejectedRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		app := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		app.Get("/protected", func(c fiber.Ctx) error {
+			MarkRejected(c, "auth")
+			return c.SendStatus(http.StatusUnauthorized)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		resp, _ := app.Test(req)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "/protected", requests[0].Path)
+		assert.Equal(t, http.StatusUnauthorized, requests[0].StatusCode)
+		assert.Equal(t, "auth", requests[0].RejectionStage)
+	})
+
+	t.Run("RequestTags", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		app := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		app.Get("/tagged", func(c fiber.Ctx) error {
+			SetRequestTags(c, map[string]string{"tier": "gold"})
+			SetRequestTags(c, map[string]string{"region": "eu"})
+			return c.SendStatus(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/tagged", nil)
+		resp, _ := app.Test(req)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, map[string]string{"tier": "gold", "region": "eu"}, requests[0].Tags)
+	})
+
+	t.Run("APIVersion", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.APIVersionCallback = APIVersionFromAcceptHeader
+		config.DisableSync = true
+
+		app := fiber.New()
+		app.Use(Middleware(app, config))
+		app.Get("/items", func(c fiber.Ctx) error {
+			return c.SendStatus(http.StatusOK)
+		})
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("Accept", "application/vnd.example.v2+json")
+		resp, _ := app.Test(req)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "v2", requests[0].APIVersion)
+	})
+
 	t.Run("RequestLogger", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		app := setupTestApp(true)
@@ -243,9 +335,40 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, "GET", errorLogItem.Request.Method)
 		assert.Equal(t, "/error", errorLogItem.Request.Path)
 		assert.Equal(t, 500, errorLogItem.Response.StatusCode)
+		assert.Greater(t, errorLogItem.Response.ResponseTime, 0.0)
 		assert.NotNil(t, errorLogItem.Exception)
-		assert.Equal(t, "errors.errorString", errorLogItem.Exception.Type)
+		assert.Equal(t, "string", errorLogItem.Exception.Type)
 		assert.Equal(t, "test panic", errorLogItem.Exception.Message)
 		assert.Contains(t, errorLogItem.Exception.StackTrace, "panic")
 	})
+
+	t.Run("UnmatchedRoute", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		app := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		req := httptest.NewRequest(http.MethodGet, "/nope/123", nil)
+		resp, _ := app.Test(req)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "GET", requests[0].Method)
+		assert.Equal(t, common.DefaultUnmatchedRoutePath, requests[0].Path)
+		assert.Equal(t, http.StatusNotFound, requests[0].StatusCode)
+	})
+
+	t.Run("ExcludedMethod", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		app := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		req := httptest.NewRequest(http.MethodOptions, "/hello", nil)
+		_, _ = app.Test(req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 0)
+	})
 }
@@ -6,12 +6,13 @@ import (
 	"strings"
 
 	"github.com/apitally/apitally-go/common"
+	"github.com/apitally/apitally-go/internal"
 	"github.com/gofiber/fiber/v3"
 )
 
 var excludedMethods = []string{"HEAD", "OPTIONS", "CONNECT", "TRACE"}
 
-func getRoutes(app *fiber.App) []common.PathInfo {
+func getRoutes(app *fiber.App, config *Config) []common.PathInfo {
 	fiberRoutes := app.GetRoutes()
 	paths := make([]common.PathInfo, 0, len(fiberRoutes))
 
@@ -19,7 +20,7 @@ func getRoutes(app *fiber.App) []common.PathInfo {
 		if !slices.Contains(excludedMethods, route.Method) && route.Path != "/" {
 			paths = append(paths, common.PathInfo{
 				Method: route.Method,
-				Path:   route.Path,
+				Path:   config.NormalizePath(route.Path),
 			})
 		}
 	}
@@ -27,6 +28,23 @@ func getRoutes(app *fiber.App) []common.PathInfo {
 	return paths
 }
 
+func getMiddlewareNames(app *fiber.App) []string {
+	stack := app.Stack()
+	if len(stack) == 0 {
+		return nil
+	}
+
+	// Middleware is added to the stacks of all methods, so one is enough. Handlers of
+	// routes are included too, as they're run in the order of registration.
+	var names []string
+	for _, route := range stack[0] {
+		for _, handler := range route.Handlers {
+			names = append(names, internal.FuncName(handler))
+		}
+	}
+	return names
+}
+
 func getVersions(appVersion string) map[string]string {
 	versions := map[string]string{
 		"go":       runtime.Version(),
@@ -36,6 +54,9 @@ func getVersions(appVersion string) map[string]string {
 
 	if appVersion != "" {
 		versions["app"] = strings.TrimSpace(appVersion)
+	} else if buildInfo := common.GetBuildInfo(); buildInfo != nil && buildInfo.ModuleVersion != "" {
+		// Fall back to the version of the main module, if installed with a version
+		versions["app"] = buildInfo.ModuleVersion
 	}
 
 	return versions
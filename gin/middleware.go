@@ -2,6 +2,7 @@ package apitally
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -12,26 +13,46 @@ import (
 	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 )
 
 type responseWriter struct {
 	gin.ResponseWriter
 	size                   int64
 	body                   *bytes.Buffer
+	captureBody            bool
+	captureProblemDetails  bool
+	captureGraphQL         bool
 	shouldCaptureBody      *bool
 	isSupportedContentType func(string) bool
+	maxBodySize            int
+	truncateBody           bool
 	exceededMaxSize        bool
+	streaming              bool
 }
 
 func (w *responseWriter) Write(b []byte) (int, error) {
 	if w.shouldCaptureBody == nil {
+		contentType := w.Header().Get("Content-Type")
+		isStreamingContentType := common.IsStreamingContentType(contentType)
+		if isStreamingContentType {
+			w.streaming = true
+		}
 		w.shouldCaptureBody = new(bool)
-		*w.shouldCaptureBody = w.isSupportedContentType(w.Header().Get("Content-Type"))
+		*w.shouldCaptureBody = (w.captureBody && (isStreamingContentType || w.isSupportedContentType(contentType))) ||
+			(w.captureProblemDetails && common.IsValidationProblemDetails(w.Status(), contentType)) ||
+			(w.captureGraphQL && common.IsGraphQLResponseContentType(contentType))
 	}
 	if *w.shouldCaptureBody && !w.exceededMaxSize {
-		if w.body.Len()+len(b) <= common.MaxBodySize {
+		if w.streaming {
+			// Only capture the beginning of streaming responses
+			if remaining := common.MaxStreamingBodySize - w.body.Len(); remaining > 0 {
+				w.body.Write(b[:min(len(b), remaining)])
+			}
+		} else if w.body.Len()+len(b) <= w.maxBodySize {
 			w.body.Write(b)
+		} else if w.truncateBody {
+			w.body.Write(b[:w.maxBodySize-w.body.Len()])
+			w.exceededMaxSize = true
 		} else {
 			w.body.Reset()
 			w.exceededMaxSize = true
@@ -42,10 +63,26 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// WriteString overrides the method of the embedded writer, which would otherwise bypass
+// Write, e.g. for Server-Sent Events.
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
 func (w *responseWriter) Size() int {
 	return int(w.size)
 }
 
+func (w *responseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		if w.body.Len() > common.MaxStreamingBodySize {
+			w.body.Truncate(common.MaxStreamingBodySize)
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
 // Middleware returns the Apitally middleware for Gin.
 //
 // For more information, see:
@@ -53,6 +90,9 @@ func (w *responseWriter) Size() int {
 //   - Reference: https://docs.apitally.io/reference/go
 func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 	client := internal.InitApitallyClient(*config)
+	client.SetMiddlewareCheck(func() []common.Finding {
+		return internal.CheckMiddlewareOrder(getMiddlewareNames(r), "gin.Recovery()")
+	})
 
 	// Sync should only be disabled for testing purposes
 	if !config.DisableSync {
@@ -61,12 +101,13 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 		// Delay startup data collection to ensure all routes are registered
 		go func() {
 			time.Sleep(time.Second)
-			client.SetStartupData(getRoutes(r), getVersions(config.AppVersion), "go:gin")
+			client.SetStartupData(getRoutes(r, config), getVersions(config.AppVersion), "go:gin")
+			client.LogDiagnostics()
 		}()
 	}
 
 	return func(c *gin.Context) {
-		if !client.IsEnabled() || c.Request.Method == "OPTIONS" {
+		if !client.IsEnabled() || config.IsExcludedMethod(c.Request.Method) {
 			c.Next()
 			return
 		}
@@ -78,50 +119,69 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 		// Start log capture
 		logHandle := client.LogCollector.StartCapture(spanHandle.Context())
 
+		// Start profiling of slow requests, if enabled
+		profileHandle := client.RequestLogger.StartProfile()
+
 		// Inject context into request
 		c.Request = c.Request.WithContext(logHandle.Context())
 
-		// Get route pattern
-		routePattern := c.FullPath()
+		// Get route pattern, or a synthetic path if no route matched
+		routePattern := config.NormalizePath(normalizeWildcards(c.FullPath()))
+		if routePattern == "" {
+			routePattern = config.UnmatchedRoutePath
+		}
 
 		// Determine request size
 		requestSize := common.ParseContentLength(c.Request.Header.Get("Content-Length"))
 
 		// Cache request body if needed
-		var requestBody []byte
+		var requestBody *bytes.Buffer
 		var requestReader *common.RequestReader
-		captureRequestBody := client.Config.RequestLogging != nil &&
-			client.Config.RequestLogging.Enabled &&
-			client.Config.RequestLogging.LogRequestBody &&
-			client.RequestLogger.IsSupportedContentType(c.Request.Header.Get("Content-Type"))
-
-		if c.Request.Body != nil && requestSize <= common.MaxBodySize {
-			if captureRequestBody {
-				// Capture the body for logging
-				var err error
-				requestBody, err = io.ReadAll(c.Request.Body)
-				if err == nil {
-					c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-					requestSize = int64(len(requestBody))
+		captureRequestBody := client.RequestLogger.ShouldLogRequestBody(c.Request.Header.Get("Content-Type"))
+
+		// GraphQL and JSON-RPC requests are captured to determine the operation
+		captureGraphQL := c.Request.Method == http.MethodPost && config.IsGraphQLPath(c.Request.URL.Path)
+		captureJSONRPC := c.Request.Method == http.MethodPost && config.IsJSONRPCPath(c.Request.URL.Path)
+		maxBodySize := client.RequestLogger.MaxBodySize()
+		if captureGraphQL || captureJSONRPC {
+			maxBodySize = max(maxBodySize, common.MaxOperationBodySize)
+		}
+
+		if c.Request.Body != nil && requestSize <= int64(maxBodySize) {
+			if captureRequestBody || captureGraphQL || captureJSONRPC {
+				// Capture the body for logging or to determine the operation
+				requestBody = common.GetBodyBuffer()
+				if _, err := requestBody.ReadFrom(c.Request.Body); err == nil {
+					c.Request.Body = io.NopCloser(bytes.NewReader(requestBody.Bytes()))
+					requestSize = int64(requestBody.Len())
 				}
 			} else if requestSize == -1 {
 				// Only measure request body size
 				requestReader = &common.RequestReader{Reader: c.Request.Body}
 				c.Request.Body = requestReader
 			}
+		} else if c.Request.Body != nil && captureRequestBody && client.RequestLogger.TruncateLargeBodies() {
+			// Capture only the beginning of the body for logging
+			requestBody = common.GetBodyBuffer()
+			c.Request.Body = common.ReadBodyPrefix(c.Request.Body, requestBody, client.RequestLogger.MaxBodySize())
 		}
 
 		// Prepare response writer to capture body if needed
-		var responseBody bytes.Buffer
+		var responseBody *bytes.Buffer
 		var originalWriter gin.ResponseWriter
-		if client.Config.RequestLogging != nil &&
-			client.Config.RequestLogging.Enabled &&
-			client.Config.RequestLogging.LogResponseBody {
+		captureResponseBody := client.RequestLogger.ShouldLogResponseBody()
+		if captureResponseBody || config.ValidationErrorsFromResponses || captureGraphQL {
+			responseBody = common.GetBodyBuffer()
 			originalWriter = c.Writer
 			c.Writer = &responseWriter{
 				ResponseWriter:         c.Writer,
-				body:                   &responseBody,
+				body:                   responseBody,
+				captureBody:            captureResponseBody,
+				captureProblemDetails:  config.ValidationErrorsFromResponses,
+				captureGraphQL:         captureGraphQL,
 				isSupportedContentType: client.RequestLogger.IsSupportedContentType,
+				maxBodySize:            client.RequestLogger.MaxBodySize(),
+				truncateBody:           client.RequestLogger.TruncateLargeBodies(),
 			}
 		}
 
@@ -131,6 +191,19 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 			duration := time.Since(start)
 			statusCode := c.Writer.Status()
 
+			// Aggregate GraphQL and JSON-RPC requests by operation
+			if requestBody != nil && routePattern != config.UnmatchedRoutePath {
+				if captureGraphQL {
+					if operation, ok := common.ParseGraphQLOperation(requestBody.Bytes()); ok {
+						routePattern = operation.Path(routePattern)
+					}
+				} else if captureJSONRPC {
+					if method, ok := common.ParseJSONRPCMethod(requestBody.Bytes()); ok {
+						routePattern = common.JSONRPCMethodPath(routePattern, method)
+					}
+				}
+			}
+
 			// End span collection and get spans
 			spanHandle.SetName(fmt.Sprintf("%s %s", c.Request.Method, routePattern))
 			spans := spanHandle.End()
@@ -138,6 +211,9 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 			// End log capture and get logs
 			logs := logHandle.End()
 
+			// End profiling and get profiles captured if the request was slow
+			profile := profileHandle.End()
+
 			// Update request size from reader if needed
 			if requestReader != nil && requestSize == -1 {
 				requestSize = requestReader.Size()
@@ -151,22 +227,34 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 				panicValue = r
 				statusCode = http.StatusInternalServerError
 				stackTrace = string(debug.Stack())
-				if err, ok := r.(error); ok {
-					recoveredErr = err
-				} else {
-					recoveredErr = fmt.Errorf("%v", r)
-				}
+				recoveredErr = common.NewPanicError(r)
+			}
+
+			// Check if the request context was canceled before the handler finished,
+			// e.g. because the client disconnected or a timeout was exceeded
+			ctxErr := c.Request.Context().Err()
+			canceled := ctxErr != nil
+			timedOut := errors.Is(ctxErr, context.DeadlineExceeded)
+			counterStatusCode := statusCode
+			if canceled && recoveredErr == nil {
+				counterStatusCode = common.StatusClientClosedRequest
 			}
 
 			// Get consumer info if available
 			var consumerIdentifier string
 			if c, exists := c.Get("ApitallyConsumer"); exists {
-				if consumer := internal.ConsumerFromStringOrObject(c); consumer != nil {
+				if consumer := client.ConsumerFromStringOrObject(c); consumer != nil {
 					consumerIdentifier = consumer.Identifier
 					client.ConsumerRegistry.AddOrUpdateConsumer(consumer)
 				}
 			}
 
+			// Get rejection stage if marked by an upstream middleware
+			rejectionStage := c.GetString("ApitallyRejectionStage")
+
+			// Get request tags if set
+			requestTags := c.GetStringMapString("ApitallyRequestTags")
+
 			// Determine response size
 			responseSize := common.ParseContentLength(c.Writer.Header().Get("Content-Length"))
 			if responseSize == -1 {
@@ -174,32 +262,40 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 			}
 
 			// Count request
-			if routePattern != "" {
-				client.RequestCounter.AddRequest(
-					consumerIdentifier,
-					c.Request.Method,
-					routePattern,
-					statusCode,
-					float64(duration.Milliseconds()),
-					requestSize,
-					responseSize,
-				)
+			if routePattern != "" && !config.IsExcludedFromMetrics(routePattern) {
+				client.RequestCounter.AddRequest(internal.RequestInfo{
+					Consumer:       consumerIdentifier,
+					Method:         c.Request.Method,
+					Path:           routePattern,
+					StatusCode:     counterStatusCode,
+					ResponseTime:   duration.Seconds() * 1000.0,
+					RequestSize:    requestSize,
+					ResponseSize:   responseSize,
+					RejectionStage: rejectionStage,
+					Tags:           requestTags,
+					APIVersion:     config.GetAPIVersion(routePattern, c.Request.Header),
+					TrafficClass:   internal.ClassifyTraffic(config, c.Request.Method, routePattern, c.Request.Header.Get),
+				})
 
 				// Count validation errors if any
-				if valErrValue, exists := c.Get("ApitallyValidationErrors"); exists && valErrValue != nil {
-					validationErrors, ok := valErrValue.(validator.ValidationErrors)
-					if ok {
-						for _, fieldError := range validationErrors {
-							client.ValidationErrorCounter.AddValidationError(
-								consumerIdentifier,
-								c.Request.Method,
-								routePattern,
-								fieldError.Field(),
-								common.TruncateValidationErrorMessage(fieldError.Error()),
-								fieldError.Tag(),
-							)
-						}
-					}
+				validationErrors, _ := c.Value("ApitallyValidationErrors").([]common.ValidationError)
+				if len(validationErrors) == 0 && config.ValidationErrorsFromResponses && responseBody != nil &&
+					common.IsValidationProblemDetails(statusCode, c.Writer.Header().Get("Content-Type")) {
+					validationErrors = common.ParseProblemDetails(responseBody.Bytes())
+				}
+				if len(validationErrors) == 0 && captureGraphQL && responseBody != nil &&
+					common.IsGraphQLResponseContentType(c.Writer.Header().Get("Content-Type")) {
+					validationErrors = common.ParseGraphQLErrors(responseBody.Bytes())
+				}
+				for _, validationError := range validationErrors {
+					client.ValidationErrorCounter.AddValidationError(
+						consumerIdentifier,
+						c.Request.Method,
+						routePattern,
+						validationError.Location,
+						validationError.Message,
+						validationError.Type,
+					)
 				}
 
 				// Count server error if any
@@ -212,28 +308,54 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 						stackTrace,
 					)
 				}
+
+				// Count timeout if any
+				if timedOut {
+					client.TimeoutCounter.AddTimeout(consumerIdentifier, c.Request.Method, routePattern)
+				}
+
+				// Count user agent if enabled
+				if config.CountUserAgents {
+					client.UserAgentCounter.AddUserAgent(c.Request.Method, routePattern, c.Request.UserAgent())
+				}
+
+				// Count rate limit headers if enabled
+				if config.CountRateLimits {
+					client.RateLimitCounter.AddRateLimit(consumerIdentifier, statusCode, c.Writer.Header().Get)
+				}
 			}
 
 			// Log request if enabled
-			if client.Config.RequestLogging != nil && client.Config.RequestLogging.Enabled {
+			if client.RequestLogger.ShouldLogRequest() {
 				request := common.Request{
-					Timestamp: float64(time.Now().UnixMilli()) / 1000.0,
-					Consumer:  consumerIdentifier,
-					Method:    c.Request.Method,
-					Path:      routePattern,
-					URL:       common.GetFullURL(c.Request),
-					Headers:   common.TransformHeaders(c.Request.Header),
-					Size:      requestSize,
-					Body:      requestBody,
+					Timestamp:  float64(time.Now().UnixMilli()) / 1000.0,
+					Consumer:   consumerIdentifier,
+					Method:     c.Request.Method,
+					Path:       routePattern,
+					URL:        common.GetFullURL(c.Request),
+					Headers:    common.TransformHeaders(c.Request.Header),
+					Size:       requestSize,
+					RemoteAddr: c.Request.RemoteAddr,
+					Canceled:   canceled,
+					TimedOut:   timedOut,
+					Tags:       requestTags,
 				}
 				response := common.Response{
 					StatusCode:   statusCode,
-					ResponseTime: float64(duration.Milliseconds()) / 1000.0,
+					ResponseTime: duration.Seconds(),
 					Headers:      common.TransformHeaders(c.Writer.Header()),
 					Size:         responseSize,
-					Body:         responseBody.Bytes(),
+					Streaming:    common.IsStreamingContentType(c.Writer.Header().Get("Content-Type")),
+				}
+				if rw, ok := c.Writer.(*responseWriter); ok && rw.streaming {
+					response.Streaming = true
 				}
-				client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID)
+				request.SetBodyBuffer(requestBody)
+				response.SetBodyBuffer(responseBody)
+				client.RequestLogger.LogRequest(&request, &response, recoveredErr, stackTrace, logs, spans, traceID, profile)
+			} else {
+				common.PutBodyBuffer(requestBody)
+				common.PutBodyBuffer(responseBody)
 			}
 
 			// Restore original writer if needed
@@ -254,16 +376,21 @@ func Middleware(r *gin.Engine, config *Config) gin.HandlerFunc {
 // Alias for backwards compatibility
 var ApitallyMiddleware = Middleware
 
+// CaptureValidationError captures validation errors returned by a validation library,
+// so they are counted by Apitally. Errors of go-playground/validator, ozzo-validation,
+// go-playground/form and encoding/json are supported, other errors are ignored.
 func CaptureValidationError(c *gin.Context, err error) {
-	if err == nil {
-		return
-	}
+	CaptureValidationErrors(c, common.ParseValidationErrors(err))
+}
 
-	var validationErrors validator.ValidationErrors
-	if errors.As(err, &validationErrors) {
-		// Store validation errors in the context for middleware
-		c.Set("ApitallyValidationErrors", validationErrors)
+// CaptureValidationErrors captures validation errors of any validation approach, in
+// addition to previously captured ones, so they are counted by Apitally.
+func CaptureValidationErrors(c *gin.Context, validationErrors []ValidationError) {
+	if len(validationErrors) == 0 {
+		return
 	}
+	existing, _ := c.Value("ApitallyValidationErrors").([]common.ValidationError)
+	c.Set("ApitallyValidationErrors", append(existing, validationErrors...))
 }
 
 func SetConsumerIdentifier(c *gin.Context, consumerIdentifier string) {
@@ -273,3 +400,17 @@ func SetConsumerIdentifier(c *gin.Context, consumerIdentifier string) {
 func SetConsumer(c *gin.Context, consumer common.Consumer) {
 	c.Set("ApitallyConsumer", consumer)
 }
+
+// MarkRejected marks the request as rejected before reaching the handler, e.g. by an
+// authentication middleware responding with 401 or 403. Such requests are aggregated
+// under the given stage (e.g. "auth") so they don't inflate endpoint error rates.
+func MarkRejected(c *gin.Context, stage string) {
+	c.Set("ApitallyRejectionStage", stage)
+}
+
+// SetRequestTags sets tags for the request (e.g. tenant tier, region or API version),
+// by which request metrics can be broken down. The tags are merged with any tags set
+// before. At most 10 tags are kept, so their values should come from a small set.
+func SetRequestTags(c *gin.Context, tags map[string]string) {
+	c.Set("ApitallyRequestTags", internal.MergeTags(c.GetStringMapString("ApitallyRequestTags"), tags))
+}
@@ -2,13 +2,17 @@ package apitally
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/apitally/apitally-go/common"
 	"github.com/apitally/apitally-go/internal"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -158,6 +162,30 @@ func TestMiddleware(t *testing.T) {
 		}))
 	})
 
+	t.Run("CaptureValidationErrors", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.POST("/items", func(c *gin.Context) {
+			CaptureValidationErrors(c, []ValidationError{{Location: "body.name", Message: "is too short", Type: "min_length"}})
+			c.Status(http.StatusUnprocessableEntity)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/items", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		validationErrors := c.ValidationErrorCounter.GetAndResetValidationErrors()
+		assert.Len(t, validationErrors, 1)
+		assert.Equal(t, "/items", validationErrors[0].Path)
+		assert.Equal(t, []string{"body", "name"}, validationErrors[0].Loc)
+		assert.Equal(t, "is too short", validationErrors[0].Msg)
+		assert.Equal(t, "min_length", validationErrors[0].Type)
+	})
+
 	t.Run("ServerErrorCounter", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		r := setupTestApp(false)
@@ -174,11 +202,56 @@ func TestMiddleware(t *testing.T) {
 
 		assert.Equal(t, "GET", errors[0].Method)
 		assert.Equal(t, "/error", errors[0].Path)
-		assert.Equal(t, "errors.errorString", errors[0].Type)
+		assert.Equal(t, "string", errors[0].Type)
 		assert.Equal(t, "test panic", errors[0].Message)
 		assert.Contains(t, errors[0].StackTrace, "panic")
 	})
 
+	t.Run("RejectedRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.GET("/protected", func(c *gin.Context) {
+			MarkRejected(c, "auth")
+			c.AbortWithStatus(http.StatusUnauthorized)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "/protected", requests[0].Path)
+		assert.Equal(t, http.StatusUnauthorized, requests[0].StatusCode)
+		assert.Equal(t, "auth", requests[0].RejectionStage)
+	})
+
+	t.Run("RequestTags", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.GET("/tagged", func(c *gin.Context) {
+			SetRequestTags(c, map[string]string{"tier": "gold"})
+			SetRequestTags(c, map[string]string{"region": "eu"})
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/tagged", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, map[string]string{"tier": "gold", "region": "eu"}, requests[0].Tags)
+	})
+
 	t.Run("RequestLogger", func(t *testing.T) {
 		internal.ResetApitallyClient()
 		r := setupTestApp(true)
@@ -251,9 +324,157 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, "GET", errorLogItem.Request.Method)
 		assert.Equal(t, "/error", errorLogItem.Request.Path)
 		assert.Equal(t, 500, errorLogItem.Response.StatusCode)
+		assert.Greater(t, errorLogItem.Response.ResponseTime, 0.0)
 		assert.NotNil(t, errorLogItem.Exception)
-		assert.Equal(t, "errors.errorString", errorLogItem.Exception.Type)
+		assert.Equal(t, "string", errorLogItem.Exception.Type)
 		assert.Equal(t, "test panic", errorLogItem.Exception.Message)
 		assert.Contains(t, errorLogItem.Exception.StackTrace, "panic")
 	})
+
+	t.Run("StreamingResponse", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.GET("/events", func(c *gin.Context) {
+			for i := 0; i < 1000; i++ {
+				c.SSEvent("message", fmt.Sprintf("event %d", i))
+				c.Writer.Flush()
+			}
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Response.Streaming)
+		assert.Equal(t, int64(w.Body.Len()), logItems[0].Response.Size)
+		assert.Len(t, logItems[0].Response.Body, common.MaxStreamingBodySize)
+		assert.True(t, strings.HasPrefix(w.Body.String(), string(logItems[0].Response.Body)))
+	})
+
+	t.Run("CanceledRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		// Simulate the client disconnecting while the handler is running
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r.GET("/canceled", func(c *gin.Context) {
+			cancel()
+			c.Status(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/canceled", nil).WithContext(ctx)
+		r.ServeHTTP(w, req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, common.StatusClientClosedRequest, requests[0].StatusCode)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Request.Canceled)
+		assert.Equal(t, http.StatusOK, logItems[0].Response.StatusCode)
+	})
+
+	t.Run("TimedOutRequest", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(true)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		r.GET("/timeout", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/timeout", nil).WithContext(ctx)
+		r.ServeHTTP(w, req)
+
+		timeouts := c.TimeoutCounter.GetAndResetTimeouts()
+		assert.Len(t, timeouts, 1)
+		assert.Equal(t, "/timeout", timeouts[0].Path)
+		assert.Equal(t, 1, timeouts[0].TimeoutCount)
+
+		logItems := c.RequestLogger.GetPendingWrites()
+		assert.Len(t, logItems, 1)
+		assert.True(t, logItems[0].Request.TimedOut)
+		assert.True(t, logItems[0].Request.Canceled)
+	})
+
+	t.Run("UnmatchedRoute", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/nope/123", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "GET", requests[0].Method)
+		assert.Equal(t, common.DefaultUnmatchedRoutePath, requests[0].Path)
+		assert.Equal(t, http.StatusNotFound, requests[0].StatusCode)
+	})
+
+	t.Run("NormalizedPath", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		config := NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.DisableSync = true
+		config.PathNormalizer = func(path string) string {
+			return strings.TrimPrefix(path, "/v1")
+		}
+		r := gin.New()
+		r.Use(Middleware(r, config))
+		v1 := r.Group("/v1")
+		v1.GET("/items/:id", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		r.GET("/files/*filepath", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		for _, path := range []string{"/v1/items/1", "/files/a/b.txt"} {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		paths := []string{}
+		for _, request := range requests {
+			paths = append(paths, request.Path)
+		}
+		assert.ElementsMatch(t, []string{"/items/:id", "/files/*"}, paths)
+	})
+
+	t.Run("ExcludedMethod", func(t *testing.T) {
+		internal.ResetApitallyClient()
+		r := setupTestApp(false)
+		c := internal.GetApitallyClient()
+		defer c.Shutdown()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodOptions, "/hello", nil)
+		r.ServeHTTP(w, req)
+
+		requests := c.RequestCounter.GetAndResetRequests()
+		assert.Len(t, requests, 0)
+	})
 }
@@ -5,10 +5,11 @@ import (
 	"strings"
 
 	"github.com/apitally/apitally-go/common"
+	"github.com/apitally/apitally-go/internal"
 	"github.com/gin-gonic/gin"
 )
 
-func getRoutes(r *gin.Engine) []common.PathInfo {
+func getRoutes(r *gin.Engine, config *Config) []common.PathInfo {
 	routes := r.Routes()
 	paths := make([]common.PathInfo, 0, len(routes))
 
@@ -16,7 +17,7 @@ func getRoutes(r *gin.Engine) []common.PathInfo {
 		if route.Method != "OPTIONS" && route.Method != "HEAD" {
 			paths = append(paths, common.PathInfo{
 				Method: route.Method,
-				Path:   route.Path,
+				Path:   config.NormalizePath(normalizeWildcards(route.Path)),
 			})
 		}
 	}
@@ -24,6 +25,14 @@ func getRoutes(r *gin.Engine) []common.PathInfo {
 	return paths
 }
 
+func getMiddlewareNames(r *gin.Engine) []string {
+	names := make([]string, len(r.Handlers))
+	for i, handler := range r.Handlers {
+		names[i] = internal.FuncName(handler)
+	}
+	return names
+}
+
 func getVersions(appVersion string) map[string]string {
 	versions := map[string]string{
 		"go":       runtime.Version(),
@@ -33,7 +42,20 @@ func getVersions(appVersion string) map[string]string {
 
 	if appVersion != "" {
 		versions["app"] = strings.TrimSpace(appVersion)
+	} else if buildInfo := common.GetBuildInfo(); buildInfo != nil && buildInfo.ModuleVersion != "" {
+		// Fall back to the version of the main module, if installed with a version
+		versions["app"] = buildInfo.ModuleVersion
 	}
 
 	return versions
 }
+
+// normalizeWildcards strips the name of a trailing catch-all parameter from a route
+// pattern (e.g. /static/*filepath becomes /static/*), so that the pattern doesn't depend
+// on how the parameter happens to be named.
+func normalizeWildcards(path string) string {
+	if i := strings.LastIndex(path, "/*"); i >= 0 {
+		return path[:i+2]
+	}
+	return path
+}
@@ -2,6 +2,7 @@ package apitally
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -18,12 +19,33 @@ func TestUtils(t *testing.T) {
 			})
 		})
 
-		routes := getRoutes(r)
+		routes := getRoutes(r, &Config{})
 		assert.Equal(t, 1, len(routes))
 		assert.Equal(t, "GET", routes[0].Method)
 		assert.Equal(t, "/hello", routes[0].Path)
 	})
 
+	t.Run("GetRoutesNormalized", func(t *testing.T) {
+		r := gin.New()
+		v1 := r.Group("/v1")
+		v1.GET("/items/:id", func(c *gin.Context) {})
+		r.Static("/static", ".")
+
+		config := &Config{PathNormalizer: func(path string) string {
+			return strings.TrimPrefix(path, "/v1")
+		}}
+		routes := getRoutes(r, config)
+		assert.Equal(t, 2, len(routes))
+		assert.Equal(t, "/items/:id", routes[0].Path)
+		assert.Equal(t, "/static/*", routes[1].Path)
+	})
+
+	t.Run("NormalizeWildcards", func(t *testing.T) {
+		assert.Equal(t, "/static/*", normalizeWildcards("/static/*filepath"))
+		assert.Equal(t, "/*", normalizeWildcards("/*path"))
+		assert.Equal(t, "/items/:id", normalizeWildcards("/items/:id"))
+	})
+
 	t.Run("GetVersions", func(t *testing.T) {
 		appVersion := "1.0.0"
 		versions := getVersions(appVersion)
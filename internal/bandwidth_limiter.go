@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const bandwidthLimiterChunkSize = 32 * 1024
+
+// bandwidthLimiter limits the combined rate at which data is read through its readers,
+// e.g. to limit the bandwidth used by all uploads of the client. Each read reserves the
+// time it takes to transfer the data at the given rate, and waits for previous
+// reservations.
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+	next           time.Time
+	mutex          sync.Mutex
+}
+
+// newBandwidthLimiter returns a limiter for the given rate, which is unlimited if 0.
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	return &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+// SetRate changes the rate of the limiter, which is unlimited if 0. Readers created
+// before are limited by the new rate from their next read.
+func (l *bandwidthLimiter) SetRate(bytesPerSecond int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.bytesPerSecond = bytesPerSecond
+}
+
+// Reader returns a reader limited by the limiter.
+func (l *bandwidthLimiter) Reader(r io.ReadCloser) io.ReadCloser {
+	return limitedReader{r, l}
+}
+
+func (l *bandwidthLimiter) wait(n int) {
+	l.mutex.Lock()
+	if l.bytesPerSecond <= 0 {
+		l.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.mutex.Unlock()
+
+	time.Sleep(delay)
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (r limitedReader) Read(p []byte) (int, error) {
+	if len(p) > bandwidthLimiterChunkSize {
+		p = p[:bandwidthLimiterChunkSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	t.Run("Unlimited", func(t *testing.T) {
+		limiter := newBandwidthLimiter(0)
+		data := make([]byte, 100*bandwidthLimiterChunkSize)
+
+		start := time.Now()
+		content, err := io.ReadAll(limiter.Reader(io.NopCloser(bytes.NewReader(data))))
+		assert.NoError(t, err)
+		assert.Len(t, content, len(data))
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("Limited", func(t *testing.T) {
+		limiter := newBandwidthLimiter(10 * bandwidthLimiterChunkSize)
+		data := make([]byte, 3*bandwidthLimiterChunkSize)
+
+		// The second and third chunk wait for the reservations of the previous chunks
+		start := time.Now()
+		content, err := io.ReadAll(limiter.Reader(io.NopCloser(bytes.NewReader(data))))
+		assert.NoError(t, err)
+		assert.Len(t, content, len(data))
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("SharedAcrossReaders", func(t *testing.T) {
+		limiter := newBandwidthLimiter(0)
+		limiter.SetRate(10 * bandwidthLimiterChunkSize)
+		data := make([]byte, bandwidthLimiterChunkSize)
+
+		// Readers wait for reservations of other readers
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			_, err := io.ReadAll(limiter.Reader(io.NopCloser(bytes.NewReader(data))))
+			assert.NoError(t, err)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	})
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// errCorruptLogFile is returned if log files can't be decompressed, so that retrying
+// won't help.
+var errCorruptLogFile = errors.New("corrupt log file")
+
+// beforeSend passes the body of a payload to Config.BeforeSendCallback, if set, and
+// returns the body to send instead. The returned bool is false if the callback vetoed
+// sending the payload.
+func (c *ApitallyClient) beforeSend(payloadType string, body []byte) ([]byte, bool) {
+	c.configMutex.RLock()
+	callback := c.Config.BeforeSendCallback
+	c.configMutex.RUnlock()
+
+	if callback == nil {
+		return body, true
+	}
+	body = callback(payloadType, body)
+	return body, body != nil
+}
+
+// beforeSendLog is like beforeSend for the gzipped content of log files, which the
+// callback receives uncompressed. The returned body is compressed again, or nil if no
+// callback is set, in which case the files should be sent as is. Errors wrap
+// errCorruptLogFile if the files can't be decompressed.
+func (c *ApitallyClient) beforeSendLog(upload *logUpload) ([]byte, bool, error) {
+	c.configMutex.RLock()
+	hasCallback := c.Config.BeforeSendCallback != nil
+	c.configMutex.RUnlock()
+
+	if !hasCallback {
+		return nil, true, nil
+	}
+
+	content, err := upload.GetReader()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get log file reader: %w", err)
+	}
+	defer content.Close()
+	gzipReader, err := gzip.NewReader(content)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", errCorruptLogFile, err)
+	}
+	defer gzipReader.Close()
+	body, err := io.ReadAll(gzipReader)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", errCorruptLogFile, err)
+	}
+
+	body, ok := c.beforeSend("log", body)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	if _, err := gzipWriter.Write(body); err != nil {
+		return nil, false, fmt.Errorf("failed to compress log data: %w", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, false, fmt.Errorf("failed to compress log data: %w", err)
+	}
+	return buf.Bytes(), true, nil
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+)
+
+var bodyCompressed = []byte("<compressed>")
+
+// decompressBody decompresses a gzip or deflate encoded body. Returns the placeholder
+// for compressed bodies if the decompressed body would exceed the maximum size, can't
+// be decoded, or uses another encoding, such as br.
+func decompressBody(body []byte, encoding string, maxSize int) []byte {
+	var reader io.Reader
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// Deflate is supposed to be zlib wrapped, but some clients send raw deflate
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	case "", "identity":
+		return body
+	default:
+		return bodyCompressed
+	}
+	if err != nil {
+		return bodyCompressed
+	}
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil || len(decompressed) > maxSize {
+		return bodyCompressed
+	}
+	return decompressed
+}
+
+func getContentEncoding(headers [][2]string) string {
+	for _, header := range headers {
+		if strings.EqualFold(header[0], "Content-Encoding") {
+			return header[1]
+		}
+	}
+	return ""
+}
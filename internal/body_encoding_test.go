@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func compress(t *testing.T, data []byte, newWriter func(io.Writer) io.WriteCloser) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	_, err := w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	body := []byte(`{"hello":"world"}`)
+	gzipBody := compress(t, body, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	zlibBody := compress(t, body, func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	flateBody := compress(t, body, func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+
+	t.Run("SupportedEncodings", func(t *testing.T) {
+		assert.Equal(t, body, decompressBody(gzipBody, "gzip", common.MaxBodySize))
+		assert.Equal(t, body, decompressBody(gzipBody, "X-GZIP", common.MaxBodySize))
+		assert.Equal(t, body, decompressBody(zlibBody, "deflate", common.MaxBodySize))
+		assert.Equal(t, body, decompressBody(flateBody, "deflate", common.MaxBodySize))
+		assert.Equal(t, body, decompressBody(body, "identity", common.MaxBodySize))
+	})
+
+	t.Run("Placeholder", func(t *testing.T) {
+		// Too large after decompression
+		largeBody := compress(t, bytes.Repeat([]byte("a"), common.MaxBodySize+1), func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+		assert.Equal(t, bodyCompressed, decompressBody(largeBody, "gzip", common.MaxBodySize))
+		assert.Len(t, decompressBody(largeBody, "gzip", common.MaxBodySize+1), common.MaxBodySize+1)
+
+		// Invalid or truncated data
+		assert.Equal(t, bodyCompressed, decompressBody(body, "gzip", common.MaxBodySize))
+		assert.Equal(t, bodyCompressed, decompressBody(gzipBody[:len(gzipBody)-10], "gzip", common.MaxBodySize))
+
+		// Unsupported encoding
+		assert.Equal(t, bodyCompressed, decompressBody(body, "br", common.MaxBodySize))
+	})
+
+	t.Run("LogRequest", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestBody = true
+		config.LogResponseBody = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Method:  "POST",
+			Path:    "/test",
+			URL:     "http://localhost:8000/test",
+			Headers: [][2]string{{"Content-Type", "application/json"}, {"Content-Encoding", "gzip"}},
+			Body:    compress(t, []byte(`{"password":"secret"}`), func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }),
+		}
+		response := &common.Response{
+			StatusCode: 200,
+			Headers:    [][2]string{{"Content-Type", "application/json"}, {"Content-Encoding", "deflate"}},
+			Body:       zlibBody,
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		loggedRequest := items[0]["request"].(map[string]any)
+		loggedResponse := items[0]["response"].(map[string]any)
+
+		// Bodies are base64 encoded in the JSON output
+		assert.Equal(t, "eyJwYXNzd29yZCI6IioqKioqKiJ9", loggedRequest["body"]) // {"password":"******"}
+		assert.Equal(t, "gzip", loggedRequest["body_encoding"])
+		assert.Equal(t, "eyJoZWxsbyI6IndvcmxkIn0=", loggedResponse["body"]) // {"hello":"world"}
+		assert.Equal(t, "deflate", loggedResponse["body_encoding"])
+	})
+}
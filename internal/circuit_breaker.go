@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	circuitBreakerThreshold  = 3
+	circuitBreakerMinBackoff = time.Minute
+	circuitBreakerMaxBackoff = 30 * time.Minute
+)
+
+// CircuitBreaker stops requests to the hub after repeated failures. While open, it
+// backs off exponentially with jitter, so that many instances don't retry in lockstep
+// when the hub recovers from an outage. Once the backoff has elapsed, a single probe
+// request is allowed through, which either closes the circuit or opens it again.
+type CircuitBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+	mutex     sync.Mutex
+}
+
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{}
+}
+
+// Allow reports whether a request to the hub may be sent. Each allowed request must be
+// followed by a call to RecordSuccess or RecordFailure.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.failures < circuitBreakerThreshold {
+		return true
+	}
+	if cb.probing || time.Now().Before(cb.openUntil) {
+		return false
+	}
+	cb.probing = true
+	return true
+}
+
+// RecordSuccess closes the circuit. Returns true if it was open before.
+func (cb *CircuitBreaker) RecordSuccess() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	wasOpen := cb.failures >= circuitBreakerThreshold
+	cb.failures = 0
+	cb.openUntil = time.Time{}
+	cb.probing = false
+	return wasOpen
+}
+
+// RecordFailure counts a failed request and opens the circuit once the threshold is
+// reached. Returns the backoff duration if the circuit was opened, or 0 otherwise.
+func (cb *CircuitBreaker) RecordFailure() time.Duration {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failures++
+	cb.probing = false
+	if cb.failures < circuitBreakerThreshold {
+		return 0
+	}
+
+	backoff := getBackoff(cb.failures - circuitBreakerThreshold)
+	cb.openUntil = time.Now().Add(backoff)
+	return backoff
+}
+
+// OpenUntil returns the time until which the circuit is open, or the zero time if
+// it is closed.
+func (cb *CircuitBreaker) OpenUntil() time.Time {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.failures < circuitBreakerThreshold {
+		return time.Time{}
+	}
+	return cb.openUntil
+}
+
+// getBackoff returns the exponential backoff for the given attempt, capped at the
+// maximum, with jitter of up to half the duration.
+func getBackoff(attempt int) time.Duration {
+	backoff := circuitBreakerMaxBackoff
+	if attempt < 16 {
+		backoff = min(circuitBreakerMaxBackoff, circuitBreakerMinBackoff<<attempt)
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
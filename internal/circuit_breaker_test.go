@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("OpenAfterRepeatedFailures", func(t *testing.T) {
+		cb := NewCircuitBreaker()
+
+		for i := 0; i < circuitBreakerThreshold-1; i++ {
+			assert.True(t, cb.Allow())
+			assert.Equal(t, time.Duration(0), cb.RecordFailure())
+		}
+		assert.True(t, cb.OpenUntil().IsZero())
+
+		assert.True(t, cb.Allow())
+		backoff := cb.RecordFailure()
+		assert.GreaterOrEqual(t, backoff, circuitBreakerMinBackoff/2)
+		assert.LessOrEqual(t, backoff, circuitBreakerMinBackoff)
+		assert.False(t, cb.Allow())
+		assert.True(t, cb.OpenUntil().After(time.Now()))
+
+		assert.True(t, cb.RecordSuccess())
+		assert.True(t, cb.Allow())
+		assert.True(t, cb.OpenUntil().IsZero())
+	})
+
+	t.Run("SingleProbeAfterBackoff", func(t *testing.T) {
+		cb := NewCircuitBreaker()
+		for i := 0; i < circuitBreakerThreshold; i++ {
+			cb.RecordFailure()
+		}
+
+		// Simulate the backoff having elapsed
+		cb.openUntil = time.Now().Add(-time.Second)
+		assert.True(t, cb.Allow())
+		assert.False(t, cb.Allow())
+
+		// Failed probe opens the circuit again with a longer backoff
+		backoff := cb.RecordFailure()
+		assert.GreaterOrEqual(t, backoff, circuitBreakerMinBackoff)
+		assert.False(t, cb.Allow())
+	})
+
+	t.Run("BackoffCapped", func(t *testing.T) {
+		for attempt := 0; attempt < 100; attempt++ {
+			backoff := getBackoff(attempt)
+			assert.Greater(t, backoff, time.Duration(0))
+			assert.LessOrEqual(t, backoff, circuitBreakerMaxBackoff)
+		}
+		assert.GreaterOrEqual(t, getBackoff(100), circuitBreakerMaxBackoff/2)
+	})
+}
@@ -3,15 +3,18 @@ package internal
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/apitally/apitally-go/common"
@@ -20,22 +23,54 @@ import (
 )
 
 const (
-	syncInterval                = 60 * time.Second
+	defaultSyncInterval         = 60 * time.Second
 	initialSyncInterval         = 10 * time.Second
 	initialSyncIntervalDuration = time.Hour
 	maxQueueTime                = time.Hour
 	maxQueueSize                = 400
+	maxSyncPayloadSize          = 1 << 20 // 1 MB
+	minSyncInterval             = 10 * time.Second
+	maxRemoteSyncInterval       = 10 * time.Minute
+	maxHubRetryAfter            = time.Hour
+	defaultHubRequestTimeout    = 10 * time.Second
+	defaultHubLogRequestTimeout = 30 * time.Second
+	maxLogUploadsPerSync        = 10
 )
 
 type SyncPayload struct {
+	SchemaVersion    int                    `json:"schema_version"`
 	Timestamp        float64                `json:"timestamp"`
 	InstanceUUID     string                 `json:"instance_uuid"`
 	MessageUUID      string                 `json:"message_uuid"`
+	WorkerSlot       *int                   `json:"worker_slot,omitempty"`
+	Uptime           float64                `json:"uptime"` // in seconds
 	Requests         []RequestsItem         `json:"requests"`
 	ValidationErrors []ValidationErrorsItem `json:"validation_errors,omitempty"`
 	ServerErrors     []ServerErrorsItem     `json:"server_errors,omitempty"`
-	Consumers        []*common.Consumer     `json:"consumers,omitempty"`
+	Timeouts         []TimeoutsItem         `json:"timeouts,omitempty"`
+	UserAgents       []UserAgentsItem       `json:"user_agents,omitempty"`
+	RateLimits       []RateLimitsItem       `json:"rate_limits,omitempty"`
+	Tasks            []TasksItem            `json:"tasks,omitempty"`
+	Messages         []MessagesItem         `json:"messages,omitempty"`
+	Heartbeats       []HeartbeatsItem       `json:"heartbeats,omitempty"`
+	Consumers        []ConsumersItem        `json:"consumers,omitempty"`
+	DeletedConsumers []string               `json:"deleted_consumers,omitempty"`
 	Resources        *ResourceUsage         `json:"resources,omitempty"`
+	GoroutineLeak    *GoroutineLeakItem     `json:"goroutine_leak,omitempty"`
+	SdkStats         *SdkStats              `json:"sdk_stats,omitempty"`
+}
+
+// SdkStats holds counters of the SDK itself for the sync interval, so that data loss
+// within the SDK is visible in the dashboard.
+type SdkStats struct {
+	DroppedSyncPayloads int64 `json:"dropped_sync_payloads"`
+	DroppedLogItems     int64 `json:"dropped_log_items"`
+	SkippedLogItems     int64 `json:"skipped_log_items"`
+	ThrottledLogItems   int64 `json:"throttled_log_items"`
+	RotatedLogFiles     int64 `json:"rotated_log_files"`
+	DeletedLogFiles     int64 `json:"deleted_log_files"`
+	HubRequestFailures  int64 `json:"hub_request_failures"`
+	EvictedConsumers    int64 `json:"evicted_consumers"`
 }
 
 type StartupPayload struct {
@@ -44,6 +79,26 @@ type StartupPayload struct {
 	Paths        []common.PathInfo `json:"paths"`
 	Versions     map[string]string `json:"versions"`
 	Client       string            `json:"client"`
+	Build        *common.BuildInfo `json:"build,omitempty"`
+	Instance     map[string]string `json:"instance,omitempty"`
+}
+
+// HubResponse is the optional JSON body of a response from the hub, containing
+// instructions for the client. Fields that are not set are ignored.
+type HubResponse struct {
+	Config                     *RemoteConfig `json:"config,omitempty"`
+	RetryAfter                 *float64      `json:"retry_after,omitempty"`                   // in seconds
+	SuspendRequestLoggingUntil *float64      `json:"suspend_request_logging_until,omitempty"` // Unix timestamp
+	ResendStartupData          bool          `json:"resend_startup_data,omitempty"`           // e.g. if the instance record expired
+}
+
+// RemoteConfig holds configuration directives from the hub, allowing request logging
+// volume to be controlled from the dashboard. Fields that are not set are ignored.
+type RemoteConfig struct {
+	RequestLoggingEnabled    *bool    `json:"request_logging_enabled,omitempty"`
+	RequestLoggingSampleRate *float64 `json:"request_logging_sample_rate,omitempty"`
+	SuspendRequestLoggingFor *float64 `json:"suspend_request_logging_for,omitempty"` // in seconds
+	SyncInterval             *float64 `json:"sync_interval,omitempty"`               // in seconds
 }
 
 type HubRequestStatus int
@@ -52,23 +107,69 @@ const (
 	HubRequestStatusOK HubRequestStatus = iota
 	HubRequestStatusValidationError
 	HubRequestStatusInvalidClientId
+	HubRequestStatusUnauthorized
 	HubRequestStatusPaymentRequired
 	HubRequestStatusRetryableError
+	HubRequestStatusUnsupportedMediaType
 )
 
+var (
+	errHubValidation           = errors.New("apitally hub rejected the payload")
+	errHubUnsupportedMediaType = errors.New("apitally hub rejected the payload encoding")
+)
+
+// Err returns the error corresponding to the status, or nil if the request succeeded.
+func (s HubRequestStatus) Err() error {
+	switch s {
+	case HubRequestStatusValidationError:
+		return errHubValidation
+	case HubRequestStatusInvalidClientId:
+		return common.ErrInvalidClientID
+	case HubRequestStatusUnauthorized:
+		return common.ErrUnauthorized
+	case HubRequestStatusPaymentRequired:
+		return common.ErrPaymentRequired
+	case HubRequestStatusRetryableError:
+		return common.ErrHubUnreachable
+	case HubRequestStatusUnsupportedMediaType:
+		return errHubUnsupportedMediaType
+	default:
+		return nil
+	}
+}
+
 type ApitallyClient struct {
-	enabled             bool
+	enabled             atomic.Bool
 	instanceUUID        string
+	instanceSlot        int
 	instanceLockRelease func()
+	startTime           time.Time
 	httpClient          *retryablehttp.Client
+	hubHTTPClients      map[string]*retryablehttp.Client
+	hubBaseURL          string
+	logUploadLimiter    *bandwidthLimiter
 	syncDataChan        chan SyncPayload
+	syncQueue           *SyncQueue
+	syncQueueRetention  time.Duration
+	syncInterval        time.Duration
 	syncStarted         bool
-	syncStopped         bool
+	syncStopped         atomic.Bool
 	startupData         *StartupPayload
 	startupDataSent     bool
-	logger              *slog.Logger
+	logger              common.Logger
 	done                chan struct{}
+	retryAfter          time.Time
+	remoteSyncInterval  time.Duration
+	circuitBreaker      *CircuitBreaker
+	lastSyncTime        time.Time
+	lastResetTime       time.Time
+	droppedPayloads     atomic.Int64
+	hubRequestFailures  atomic.Int64
+	reportedSdkStats    SdkStats
+	middlewareCheck     func() []common.Finding
+	remoteMutex         sync.Mutex
 	mutex               sync.Mutex
+	configMutex         sync.RWMutex
 
 	Config                 common.Config
 	RequestCounter         *RequestCounter
@@ -77,13 +178,22 @@ type ApitallyClient struct {
 	SpanCollector          *SpanCollector
 	ValidationErrorCounter *ValidationErrorCounter
 	ServerErrorCounter     *ServerErrorCounter
+	TimeoutCounter         *TimeoutCounter
+	UserAgentCounter       *UserAgentCounter
+	RateLimitCounter       *RateLimitCounter
+	TaskCounter            *TaskCounter
+	MessageCounter         *MessageCounter
+	HeartbeatRegistry      *HeartbeatRegistry
 	ConsumerRegistry       *ConsumerRegistry
 	ResourceMonitor        *ResourceMonitor
+	GoroutineMonitor       *GoroutineMonitor
+	PrometheusWriter       *PrometheusTextfileWriter
 }
 
 var (
-	instance *ApitallyClient
-	mutex    sync.Mutex
+	instance  *ApitallyClient
+	transport http.RoundTripper
+	mutex     sync.Mutex
 )
 
 func GetApitallyClient() *ApitallyClient {
@@ -97,6 +207,15 @@ func ResetApitallyClient() {
 	instance = nil
 }
 
+// SetTransport sets the transport used by clients initialized afterwards to send data to
+// the hub, e.g. a mock hub for testing. If nil, the default transport is used.
+func SetTransport(t http.RoundTripper) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	transport = t
+}
+
 func InitApitallyClient(config common.Config) *ApitallyClient {
 	return InitApitallyClientWithHTTPClient(config, nil)
 }
@@ -109,82 +228,251 @@ func InitApitallyClientWithHTTPClient(config common.Config, httpClient *retryabl
 		return instance
 	}
 
+	if httpClient == nil && transport != nil {
+		httpClient = getHttpClient()
+		httpClient.HTTPClient.Transport = transport
+	}
 	instance = newApitallyClient(config, httpClient)
 	return instance
 }
 
 func newApitallyClient(config common.Config, httpClient *retryablehttp.Client) *ApitallyClient {
-	logLevel := slog.LevelInfo
-	if parseBoolEnv("APITALLY_DEBUG") {
-		logLevel = slog.LevelDebug
-	}
-	loggerOpts := &slog.HandlerOptions{
-		Level: logLevel,
-	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
+	logger := getLogger(config.Logger)
 
 	enabled := true
-	if !isValidClientId(config.ClientID) {
+	if err := config.Validate(); err != nil {
 		enabled = false
-		logger.Error("Invalid Apitally client ID (expecting hexadecimal UUID format)", "clientId", config.ClientID)
-	}
-	if !isValidEnv(config.Env) {
-		enabled = false
-		logger.Error("Invalid Apitally env (expecting 1-32 alphanumeric characters and hyphens only)", "env", config.Env)
+		logger.Error("Invalid Apitally config", "error", err)
 	}
 
+	hubBaseURL, socketPath := getHubBaseURL(config.HubURL)
 	if httpClient == nil {
 		httpClient = getHttpClient()
+		if t, ok := httpClient.HTTPClient.Transport.(*http.Transport); ok {
+			tuneHubTransport(t, config.HubTransport)
+			if socketPath != "" {
+				dialUnixSocket(t, socketPath)
+			}
+		}
 	}
 
-	instanceUUID, instanceLockRelease := GetOrCreateInstanceUUID(config.ClientID, config.Env)
+	instanceUUID, instanceSlot, instanceLockRelease := GetOrCreateInstanceUUID(config.ClientID, config.Env)
 
 	client := &ApitallyClient{
-		enabled:             enabled,
 		instanceUUID:        instanceUUID,
+		instanceSlot:        instanceSlot,
 		instanceLockRelease: instanceLockRelease,
+		startTime:           time.Now(),
+		lastResetTime:       time.Now(),
 		httpClient:          httpClient,
+		hubBaseURL:          hubBaseURL,
 		syncDataChan:        make(chan SyncPayload, maxQueueSize),
-		logger:              logger.With("component", "apitally"),
+		logger:              logger,
 		done:                make(chan struct{}),
+		circuitBreaker:      NewCircuitBreaker(),
+		logUploadLimiter:    newBandwidthLimiter(0),
+	}
+	client.enabled.Store(enabled)
+
+	client.hubHTTPClients = map[string]*retryablehttp.Client{
+		"startup": newHubHTTPClient(httpClient, config.HubRequests.Startup, defaultHubRequestTimeout),
+		"sync":    newHubHTTPClient(httpClient, config.HubRequests.Sync, defaultHubRequestTimeout),
+		"log":     newHubHTTPClient(httpClient, config.HubRequests.Log, defaultHubLogRequestTimeout),
 	}
 
 	client.Config = config
 	client.RequestCounter = NewRequestCounter()
+	client.RequestCounter.SetPerformanceTargets(config.PerformanceTargets)
 	client.ValidationErrorCounter = NewValidationErrorCounter()
 	client.ServerErrorCounter = NewServerErrorCounter()
+	client.ServerErrorCounter.SetFingerprintCallback(config.ErrorFingerprintCallback)
+	client.TimeoutCounter = NewTimeoutCounter()
+	client.UserAgentCounter = NewUserAgentCounter()
+	client.RateLimitCounter = NewRateLimitCounter()
+	client.TaskCounter = NewTaskCounter()
+	client.MessageCounter = NewMessageCounter()
+	client.HeartbeatRegistry = NewHeartbeatRegistry()
+	client.HeartbeatRegistry.SetExpectedIntervals(config.HeartbeatIntervals)
 	client.ConsumerRegistry = NewConsumerRegistry()
+	client.ConsumerRegistry.SetLimits(config.MaxConsumers, config.ConsumerTTL)
 	client.RequestLogger = NewRequestLogger(config.RequestLogging)
+	client.RequestLogger.SetLogger(logger)
+	if err := client.RequestLogger.InitSpool(); err != nil {
+		logger.Warn("Failed to initialize request log spool", "error", err)
+	}
 	client.LogCollector = NewLogCollector(config.RequestLogging != nil && config.RequestLogging.Enabled && config.RequestLogging.CaptureLogs)
 	client.SpanCollector = NewSpanCollector(config.RequestLogging != nil && config.RequestLogging.Enabled && config.RequestLogging.CaptureTraces)
 	client.ResourceMonitor = NewResourceMonitor()
+	if config.DetectGoroutineLeaks {
+		client.GoroutineMonitor = NewGoroutineMonitor()
+	}
+	if config.PrometheusTextfilePath != "" {
+		client.PrometheusWriter = NewPrometheusTextfileWriter(config.PrometheusTextfilePath)
+	}
+
+	client.syncInterval = defaultSyncInterval
+	if config.SyncIntervalSeconds > 0 {
+		client.syncInterval = time.Duration(config.SyncIntervalSeconds) * time.Second
+		if client.syncInterval < minSyncInterval {
+			client.logger.Warn("Apitally sync interval too short, using minimum", "interval", minSyncInterval)
+			client.syncInterval = minSyncInterval
+		}
+	}
+
+	client.syncQueueRetention = maxQueueTime
+	if config.SyncQueueRetention > 0 {
+		client.syncQueueRetention = config.SyncQueueRetention
+	}
+	if config.SyncQueueDir != "" {
+		client.initSyncQueue(config.SyncQueueDir)
+	}
 
 	return client
 }
 
+// initSyncQueue sets up the disk-backed sync queue and restores payloads persisted by
+// a previous run of this instance.
+func (c *ApitallyClient) initSyncQueue(dir string) {
+	syncQueue, err := NewSyncQueue(dir, c.syncQueueRetention)
+	if err != nil {
+		c.logger.Warn("Failed to initialize sync queue", "error", err)
+		return
+	}
+	c.syncQueue = syncQueue
+
+	payloads, err := syncQueue.Load(c.instanceUUID)
+	if err != nil {
+		c.logger.Warn("Failed to load persisted sync data", "error", err)
+		return
+	}
+	for _, payload := range payloads {
+		select {
+		case c.syncDataChan <- payload:
+		default:
+			c.droppedPayloads.Add(1)
+			syncQueue.Remove(payload)
+		}
+	}
+	if len(payloads) > 0 {
+		c.logger.Debug("Restored persisted sync data", "count", len(payloads))
+	}
+}
+
+// getLogger returns the logger from the config or, if not set, a text logger writing
+// to stdout. Debug messages are only logged if the APITALLY_DEBUG env var is set.
+func getLogger(logger common.Logger) common.Logger {
+	if logger == nil {
+		logLevel := slog.LevelInfo
+		if parseBoolEnv("APITALLY_DEBUG") {
+			logLevel = slog.LevelDebug
+		}
+		loggerOpts := &slog.HandlerOptions{
+			Level: logLevel,
+		}
+		logger = slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
+	}
+	if slogLogger, ok := logger.(*slog.Logger); ok {
+		return slogLogger.With("component", "apitally")
+	}
+	return logger
+}
+
 func (c *ApitallyClient) IsEnabled() bool {
-	return c.enabled
+	return c.enabled.Load()
+}
+
+// UpdateConfig applies a new client ID, env, request logging config (including
+// masking rules) and performance targets at runtime. Other config fields are left
+// unchanged. Changing the client ID or env starts a new instance and discards data
+// queued for the previous one.
+func (c *ApitallyClient) UpdateConfig(config common.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	c.configMutex.Lock()
+	identityChanged := config.ClientID != c.Config.ClientID || config.Env != c.Config.Env
+	c.Config.ClientID = config.ClientID
+	c.Config.Env = config.Env
+	c.Config.RequestLogging = config.RequestLogging
+	c.Config.PerformanceTargets = config.PerformanceTargets
+	c.Config.HeartbeatIntervals = config.HeartbeatIntervals
+	if identityChanged {
+		c.instanceLockRelease()
+		c.instanceUUID, c.instanceSlot, c.instanceLockRelease = GetOrCreateInstanceUUID(config.ClientID, config.Env)
+	}
+	instanceUUID := c.instanceUUID
+	c.configMutex.Unlock()
+
+	c.RequestLogger.UpdateConfig(config.RequestLogging)
+	c.RequestCounter.SetPerformanceTargets(config.PerformanceTargets)
+	c.HeartbeatRegistry.SetExpectedIntervals(config.HeartbeatIntervals)
+
+	if identityChanged {
+		c.logger.Info("Apitally client ID or env changed, starting new instance", "env", config.Env)
+
+		// Queued data belongs to the previous client ID and env
+		for len(c.syncDataChan) > 0 {
+			c.syncQueue.Remove(<-c.syncDataChan)
+		}
+		c.RequestLogger.Clear()
+
+		// Startup data must be sent again for the new instance
+		c.mutex.Lock()
+		if c.startupData != nil {
+			c.startupData.InstanceUUID = instanceUUID
+			c.startupData.MessageUUID = uuid.New().String()
+		}
+		c.startupDataSent = false
+		c.mutex.Unlock()
+	}
+
+	return nil
+}
+
+func (c *ApitallyClient) getInstanceUUID() string {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	return c.instanceUUID
+}
+
+// getWorkerSlot returns the slot of the instance lock, which identifies the worker among
+// the processes of the app on the host, or nil if no slot was acquired.
+func (c *ApitallyClient) getWorkerSlot() *int {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+
+	if c.instanceSlot < 0 {
+		return nil
+	}
+	slot := c.instanceSlot
+	return &slot
 }
 
 func (c *ApitallyClient) SetStartupData(paths []common.PathInfo, versions map[string]string, client string) {
+	c.configMutex.RLock()
+	instanceMetadata := getInstanceMetadata(c.Config.InstanceMetadata)
+	c.configMutex.RUnlock()
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.startupData = &StartupPayload{
-		InstanceUUID: c.instanceUUID,
+		InstanceUUID: c.getInstanceUUID(),
 		MessageUUID:  uuid.New().String(),
 		Paths:        paths,
 		Versions:     versions,
 		Client:       client,
+		Build:        common.GetBuildInfo(),
+		Instance:     instanceMetadata,
 	}
 }
 
 func (c *ApitallyClient) getHubUrl(endpoint string, query string) string {
-	baseURL := "https://hub.apitally.io"
-	if envURL := os.Getenv("APITALLY_HUB_BASE_URL"); envURL != "" {
-		baseURL = envURL
-	}
-	url := fmt.Sprintf("%s/v2/%s/%s/%s", baseURL, c.Config.ClientID, c.Config.Env, endpoint)
+	c.configMutex.RLock()
+	url := fmt.Sprintf("%s/v2/%s/%s/%s", c.hubBaseURL, c.Config.ClientID, c.Config.Env, endpoint)
+	c.configMutex.RUnlock()
 	if query != "" {
 		url += "?" + query
 	}
@@ -214,7 +502,7 @@ func (c *ApitallyClient) sync() {
 }
 
 func (c *ApitallyClient) StartSync() {
-	if !c.enabled {
+	if !c.enabled.Load() {
 		return
 	}
 
@@ -226,7 +514,9 @@ func (c *ApitallyClient) StartSync() {
 		c.sync()
 
 		// Use initial sync interval for the first hour
-		ticker := time.NewTicker(initialSyncInterval)
+		defaultInterval := min(initialSyncInterval, c.syncInterval)
+		interval := c.getSyncInterval(defaultInterval)
+		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
 		// Start the initialTimer for the initial sync interval
@@ -239,24 +529,48 @@ func (c *ApitallyClient) StartSync() {
 				c.sync()
 			case <-initialTimer.C:
 				// Switch to regular sync interval
-				ticker.Stop()
-				ticker = time.NewTicker(syncInterval)
+				defaultInterval = c.syncInterval
 			case <-c.done:
 				return
 			}
+
+			// The sync interval may have been adjusted by the hub
+			nextInterval := c.getSyncInterval(defaultInterval)
+
+			// Wait for the backoff to elapse if the hub is unreachable
+			if openUntil := c.circuitBreaker.OpenUntil(); !openUntil.IsZero() {
+				nextInterval = max(nextInterval, time.Until(openUntil))
+			}
+
+			if nextInterval != interval {
+				interval = nextInterval
+				ticker.Reset(interval)
+			}
 		}
 	}()
 }
 
+// getSyncInterval returns the sync interval set by the hub, if any, or the given default.
+func (c *ApitallyClient) getSyncInterval(defaultInterval time.Duration) time.Duration {
+	c.remoteMutex.Lock()
+	defer c.remoteMutex.Unlock()
+
+	if c.remoteSyncInterval > 0 {
+		return c.remoteSyncInterval
+	}
+	return defaultInterval
+}
+
+// stopSync stops the sync loop. It may be called concurrently, e.g. by several senders
+// receiving an error response at the same time, but only closes the channel once.
 func (c *ApitallyClient) stopSync() {
-	if !c.syncStopped {
+	if c.syncStopped.CompareAndSwap(false, true) {
 		close(c.done)
-		c.syncStopped = true
 	}
 }
 
 func (c *ApitallyClient) Shutdown() {
-	c.enabled = false
+	c.enabled.Store(false)
 	c.stopSync()
 
 	if c.syncStarted {
@@ -266,7 +580,23 @@ func (c *ApitallyClient) Shutdown() {
 
 	c.RequestLogger.Close()
 	c.httpClient.HTTPClient.CloseIdleConnections()
+
+	c.configMutex.Lock()
 	c.instanceLockRelease()
+	c.configMutex.Unlock()
+}
+
+// Flush immediately sends startup data, aggregated metrics and request logs to the
+// hub, instead of waiting for the next sync. Errors can be checked using errors.Is,
+// e.g. against common.ErrHubUnreachable.
+func (c *ApitallyClient) Flush() error {
+	c.configMutex.RLock()
+	err := c.Config.Validate()
+	c.configMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return errors.Join(c.sendStartupData(), c.sendSyncData(), c.sendLogData())
 }
 
 func (c *ApitallyClient) sendStartupData() error {
@@ -278,48 +608,128 @@ func (c *ApitallyClient) sendStartupData() error {
 	}
 
 	c.logger.Debug("Sending startup data to Apitally hub")
-	jsonData, err := json.Marshal(c.startupData)
+	jsonData, err := hubSerializer.Marshal(c.startupData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal startup data: %w", err)
 	}
+	jsonData, ok := c.beforeSend("startup", jsonData)
+	if !ok {
+		c.logger.Debug("Skipping startup data vetoed by callback")
+		c.startupDataSent = true
+		return nil
+	}
 
 	url := c.getHubUrl("startup", "")
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := retryablehttp.NewRequest("POST", url, jsonData)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", hubSerializer.ContentType())
 
-	status := c.sendHubRequest(req)
+	var hubResponse HubResponse
+	status := c.sendHubRequest("startup", req, &hubResponse)
+	c.applyHubResponse(&hubResponse)
 	if status == HubRequestStatusOK {
 		c.startupDataSent = true
-		c.startupData = nil
 	}
 
-	return nil
+	return status.Err()
+}
+
+// resendStartupData marks the startup data as not sent, so it is sent again with the
+// next sync, e.g. because the hub lost it.
+func (c *ApitallyClient) resendStartupData() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.startupData == nil || !c.startupDataSent {
+		return
+	}
+	c.logger.Info("Resending startup data as requested by Apitally hub")
+	c.startupData.MessageUUID = uuid.New().String()
+	c.startupDataSent = false
 }
 
 func (c *ApitallyClient) sendSyncData() error {
+	c.remoteMutex.Lock()
+	c.lastResetTime = time.Now()
+	c.remoteMutex.Unlock()
+
 	newPayload := SyncPayload{
+		SchemaVersion:    syncPayloadSchemaVersion,
 		Timestamp:        float64(time.Now().Unix()),
-		InstanceUUID:     c.instanceUUID,
+		InstanceUUID:     c.getInstanceUUID(),
 		MessageUUID:      uuid.New().String(),
+		WorkerSlot:       c.getWorkerSlot(),
+		Uptime:           time.Since(c.startTime).Seconds(),
 		Requests:         c.RequestCounter.GetAndResetRequests(),
 		ValidationErrors: c.ValidationErrorCounter.GetAndResetValidationErrors(),
 		ServerErrors:     c.ServerErrorCounter.GetAndResetServerErrors(),
+		Timeouts:         c.TimeoutCounter.GetAndResetTimeouts(),
+		UserAgents:       c.UserAgentCounter.GetAndResetUserAgents(),
+		RateLimits:       c.RateLimitCounter.GetAndResetRateLimits(),
+		Tasks:            c.TaskCounter.GetAndResetTasks(),
+		Messages:         c.MessageCounter.GetAndResetMessages(),
+		Heartbeats:       c.HeartbeatRegistry.GetAndResetHeartbeats(),
 		Consumers:        c.ConsumerRegistry.GetAndResetUpdatedConsumers(),
+		DeletedConsumers: c.ConsumerRegistry.GetAndResetDeletedConsumers(),
 		Resources:        c.ResourceMonitor.GetCpuMemoryUsage(),
+		GoroutineLeak:    c.GoroutineMonitor.Check(),
+		SdkStats:         c.getSdkStats(),
 	}
 
-	select {
-	case c.syncDataChan <- newPayload:
-		// Successfully queued the payload
-	default:
-		c.logger.Warn("Sync data channel is full, dropping payload")
-		return fmt.Errorf("sync data channel is full")
+	removeDeletedConsumers(&newPayload)
+	c.checkMemoryPressure(newPayload.Resources)
+	if leak := newPayload.GoroutineLeak; leak != nil {
+		c.logger.Warn("Number of goroutines keeps growing, possibly due to a goroutine leak", "count", leak.Count, "growth", leak.Growth)
+	}
+
+	if overflowCount := c.RequestCounter.GetAndResetOverflowCount(); overflowCount > 0 {
+		c.logger.Warn("Too many distinct endpoints or consumers, aggregating excess requests", "count", overflowCount, "limit", maxRequestCounterKeys)
+	}
+
+	if overflowCount := c.ServerErrorCounter.GetAndResetOverflowCount(); overflowCount > 0 {
+		c.logger.Warn("Too many distinct server errors, counting excess errors without details", "count", overflowCount, "limit", maxServerErrorKeys)
+	}
+
+	if newPayload.SdkStats != nil && newPayload.SdkStats.SkippedLogItems > 0 {
+		c.logger.Warn("Request logging saturated, only counting excess requests in metrics", "count", newPayload.SdkStats.SkippedLogItems)
+	}
+
+	if c.PrometheusWriter != nil {
+		if err := c.PrometheusWriter.Write(newPayload); err != nil {
+			c.logger.Warn("Failed to write Prometheus textfile", "error", err)
+		}
+	}
+
+	c.configMutex.RLock()
+	skipIdleSyncs := c.Config.SkipIdleSyncs
+	c.configMutex.RUnlock()
+
+	if skipIdleSyncs && isIdleSyncPayload(newPayload) {
+		c.logger.Debug("Skipping sync data without activity")
+	} else {
+		chunks := splitSyncPayload(newPayload, maxSyncPayloadSize)
+		if len(chunks) > 1 {
+			c.logger.Debug("Splitting sync data into multiple requests", "chunks", len(chunks))
+		}
+		for i, chunk := range chunks {
+			select {
+			case c.syncDataChan <- chunk:
+				// Successfully queued the payload
+				if err := c.syncQueue.Add(chunk); err != nil {
+					c.logger.Warn("Failed to persist sync data", "error", err)
+				}
+			default:
+				c.logger.Warn("Sync data channel is full, dropping payload")
+				c.droppedPayloads.Add(int64(len(chunks) - i))
+				return common.ErrQueueFull
+			}
+		}
 	}
 
 	// Process queued payloads
+	var syncErr error
 	for i := 0; ; i++ {
 		var payload SyncPayload
 		select {
@@ -327,10 +737,12 @@ func (c *ApitallyClient) sendSyncData() error {
 			// Got a payload to process
 		default:
 			// No more payloads in queue
-			return nil
+			return syncErr
 		}
 
-		if time.Since(time.Unix(int64(payload.Timestamp), 0)) > maxQueueTime {
+		if time.Since(time.Unix(int64(payload.Timestamp), 0)) > c.syncQueueRetention {
+			c.droppedPayloads.Add(1)
+			c.syncQueue.Remove(payload)
 			continue
 		}
 
@@ -339,29 +751,110 @@ func (c *ApitallyClient) sendSyncData() error {
 		}
 
 		c.logger.Debug("Synchronizing data with Apitally hub")
-		jsonData, err := json.Marshal(payload)
+		jsonData, err := hubSerializer.Marshal(payload)
 		if err != nil {
 			return fmt.Errorf("failed to marshal sync data: %w", err)
 		}
+		jsonData, ok := c.beforeSend("sync", jsonData)
+		if !ok {
+			c.logger.Debug("Skipping sync data vetoed by callback")
+			c.syncQueue.Remove(payload)
+			continue
+		}
 
 		url := c.getHubUrl("sync", "")
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+		req, err := retryablehttp.NewRequest("POST", url, jsonData)
 		if err != nil {
 			return fmt.Errorf("failed to create request: %w", err)
 		}
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", hubSerializer.ContentType())
 
-		status := c.sendHubRequest(req)
+		var hubResponse HubResponse
+		status := c.sendHubRequest("sync", req, &hubResponse)
+		c.applyHubResponse(&hubResponse)
+		if hubResponse.ResendStartupData {
+			c.resendStartupData()
+		}
 		if status == HubRequestStatusRetryableError {
-			// Put the payload back in the channel for retry
+			// Put the payload back in the channel and retry with the next sync
 			select {
 			case c.syncDataChan <- payload:
 				// Successfully requeued
 			default:
 				c.logger.Warn("Failed to requeue payload for retrying, channel full")
+				c.droppedPayloads.Add(1)
 			}
+			return status.Err()
+		} else if status != HubRequestStatusOK {
+			c.droppedPayloads.Add(1)
+			syncErr = status.Err()
+		} else {
+			c.remoteMutex.Lock()
+			c.lastSyncTime = time.Now()
+			c.remoteMutex.Unlock()
+		}
+		c.syncQueue.Remove(payload)
+	}
+}
+
+// isIdleSyncPayload reports whether the payload contains no activity, i.e. nothing
+// but resource usage and heartbeat jobs without heartbeats in the interval.
+func isIdleSyncPayload(payload SyncPayload) bool {
+	if len(payload.Requests) > 0 ||
+		len(payload.ValidationErrors) > 0 ||
+		len(payload.ServerErrors) > 0 ||
+		len(payload.Timeouts) > 0 ||
+		len(payload.UserAgents) > 0 ||
+		len(payload.RateLimits) > 0 ||
+		len(payload.Tasks) > 0 ||
+		len(payload.Messages) > 0 ||
+		len(payload.Consumers) > 0 ||
+		len(payload.DeletedConsumers) > 0 ||
+		payload.GoroutineLeak != nil ||
+		payload.SdkStats != nil {
+		return false
+	}
+	for _, heartbeat := range payload.Heartbeats {
+		if heartbeat.Count > 0 {
+			return false
 		}
 	}
+	return true
+}
+
+// getSdkStats returns the SDK counters accumulated since the previous call, or nil if
+// there were none.
+func (c *ApitallyClient) getSdkStats() *SdkStats {
+	current := SdkStats{
+		DroppedSyncPayloads: c.droppedPayloads.Load(),
+		DroppedLogItems:     c.RequestLogger.DroppedItems(),
+		SkippedLogItems:     c.RequestLogger.SkippedItems(),
+		ThrottledLogItems:   c.RequestLogger.ThrottledItems(),
+		RotatedLogFiles:     c.RequestLogger.RotatedFiles(),
+		DeletedLogFiles:     c.RequestLogger.DeletedFiles(),
+		HubRequestFailures:  c.hubRequestFailures.Load(),
+		EvictedConsumers:    c.ConsumerRegistry.Evictions(),
+	}
+
+	c.remoteMutex.Lock()
+	reported := c.reportedSdkStats
+	c.reportedSdkStats = current
+	c.remoteMutex.Unlock()
+
+	stats := SdkStats{
+		DroppedSyncPayloads: current.DroppedSyncPayloads - reported.DroppedSyncPayloads,
+		DroppedLogItems:     current.DroppedLogItems - reported.DroppedLogItems,
+		SkippedLogItems:     current.SkippedLogItems - reported.SkippedLogItems,
+		ThrottledLogItems:   current.ThrottledLogItems - reported.ThrottledLogItems,
+		RotatedLogFiles:     current.RotatedLogFiles - reported.RotatedLogFiles,
+		DeletedLogFiles:     current.DeletedLogFiles - reported.DeletedLogFiles,
+		HubRequestFailures:  current.HubRequestFailures - reported.HubRequestFailures,
+		EvictedConsumers:    current.EvictedConsumers - reported.EvictedConsumers,
+	}
+	if stats == (SdkStats{}) {
+		return nil
+	}
+	return &stats
 }
 
 func (c *ApitallyClient) sendLogData() error {
@@ -373,71 +866,239 @@ func (c *ApitallyClient) sendLogData() error {
 		return fmt.Errorf("failed to rotate log file: %w", err)
 	}
 
-	for i := 0; i < 10; i++ {
-		logFile := c.RequestLogger.GetFile()
-		if logFile == nil {
-			break
-		}
+	config := c.RequestLogger.getConfig()
+	concurrency := max(1, min(config.UploadConcurrency, maxLogUploadsPerSync))
+	c.logUploadLimiter.SetRate(config.UploadBandwidthLimit)
+
+	// Workers take uploads until the limit per sync is reached, there are no more files,
+	// or an upload fails, in which case the remaining files are sent with the next sync
+	var (
+		wg        sync.WaitGroup
+		remaining atomic.Int32
+		errMutex  sync.Mutex
+		sendErr   error
+	)
+	failed := func() bool {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		return sendErr != nil
+	}
+	remaining.Store(maxLogUploadsPerSync)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; !failed() && remaining.Add(-1) >= 0; i++ {
+				upload := c.getLogUpload()
+				if upload == nil {
+					return
+				}
+				if i > 0 && !c.RequestLogger.IsDryRun() {
+					c.randomDelay()
+				}
+				if err := c.sendLogUpload(upload); err != nil {
+					errMutex.Lock()
+					if sendErr == nil {
+						sendErr = err
+					}
+					errMutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
 
-		if i > 0 {
-			c.randomDelay()
-		}
+	return sendErr
+}
 
-		c.logger.Debug("Sending request log data to Apitally hub")
-		reader, err := logFile.GetReader()
-		if err != nil {
-			return fmt.Errorf("failed to get log file reader: %w", err)
+// sendLogUpload sends log files to the hub, or writes them to the dry run file. Returns
+// an error if the files should be sent again later, or no further files should be sent
+// with this sync.
+func (c *ApitallyClient) sendLogUpload(upload *logUpload) error {
+	if c.RequestLogger.IsDryRun() {
+		for _, logFile := range upload.files {
+			if err := c.RequestLogger.WriteDryRun(logFile); err != nil {
+				c.logger.Warn("Failed to write request log data for dry run", "error", err)
+			}
 		}
-		defer reader.Close()
+		upload.Delete()
+		return nil
+	}
 
-		url := c.getHubUrl("log", fmt.Sprintf("uuid=%s", logFile.uuid))
-		req, err := http.NewRequest("POST", url, reader)
-		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
+	c.logger.Debug("Sending request log data to Apitally hub", "files", len(upload.files))
+	body, ok, err := c.beforeSendLog(upload)
+	if errors.Is(err, errCorruptLogFile) {
+		c.logger.Warn("Deleting request log data that can't be decompressed", "error", err)
+		upload.Delete()
+		return nil
+	} else if err != nil {
+		for _, logFile := range upload.files {
+			c.RequestLogger.RetryFileLater(logFile)
 		}
+		return err
+	}
+	if !ok {
+		c.logger.Debug("Skipping request log data vetoed by callback")
+		upload.Delete()
+		return nil
+	}
 
-		status := c.sendHubRequest(req)
-		if status == HubRequestStatusRetryableError {
+	url := c.getHubUrl("log", fmt.Sprintf("uuid=%s", upload.UUID()))
+	req, err := newLogRequest(url, upload, body, c.logUploadLimiter)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", upload.ContentType())
+
+	var hubResponse HubResponse
+	status := c.sendHubRequest("log", req, &hubResponse)
+	if status == HubRequestStatusRetryableError {
+		for _, logFile := range upload.files {
 			c.RequestLogger.RetryFileLater(logFile)
-			break
-		} else if status == HubRequestStatusPaymentRequired {
-			logFile.Delete()
-			c.RequestLogger.SuspendFor(time.Hour)
-			break
-		} else {
-			logFile.Delete()
 		}
+		c.applyHubResponse(&hubResponse)
+		return status.Err()
+	} else if status == HubRequestStatusUnsupportedMediaType {
+		c.logger.Warn("Apitally hub doesn't accept request log encoding, falling back to JSON", "content_type", upload.ContentType())
+		for _, logFile := range upload.files {
+			c.RequestLogger.RejectFile(logFile)
+		}
+		c.applyHubResponse(&hubResponse)
+	} else if status == HubRequestStatusPaymentRequired {
+		upload.Delete()
+		c.RequestLogger.SuspendFor(time.Hour)
+		c.applyHubResponse(&hubResponse)
+		return status.Err()
+	} else {
+		upload.Delete()
+		c.applyHubResponse(&hubResponse)
 	}
-
 	return nil
 }
 
-func (c *ApitallyClient) sendHubRequest(req *http.Request) HubRequestStatus {
-	retryReq, err := retryablehttp.FromRequest(req)
+// getLogUpload returns the next log files to upload in a single request, or nil if there
+// are none.
+func (c *ApitallyClient) getLogUpload() *logUpload {
+	var files []*TempGzipFile
+	if c.RequestLogger.getConfig().BatchUploads {
+		files = c.RequestLogger.GetFiles(maxFileSize)
+	} else if file := c.RequestLogger.GetFile(); file != nil {
+		files = []*TempGzipFile{file}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return &logUpload{files: files}
+}
+
+// newLogRequest creates a request uploading log files, limited by the bandwidth limiter.
+// If body is nil, the compressed content is streamed from the files, which
+// are reopened for each retry.
+func newLogRequest(url string, upload *logUpload, body []byte, limiter *bandwidthLimiter) (*retryablehttp.Request, error) {
+	contentLength := int64(len(body))
+	getReader := func() (io.Reader, error) {
+		return limiter.Reader(io.NopCloser(bytes.NewReader(body))), nil
+	}
+	if body == nil {
+		if err := upload.Close(); err != nil {
+			return nil, err
+		}
+		contentLength = upload.ContentLength()
+		getReader = func() (io.Reader, error) {
+			reader, err := upload.GetReader()
+			if err != nil {
+				return nil, err
+			}
+			return limiter.Reader(reader), nil
+		}
+	}
+	req, err := retryablehttp.NewRequest("POST", url, retryablehttp.ReaderFunc(getReader))
 	if err != nil {
-		c.logger.Error("Error creating retryable request for Apitally hub", "error", err)
+		return nil, err
+	}
+	if contentLength >= 0 {
+		req.ContentLength = contentLength
+	}
+	return req, nil
+}
+
+// sendHubRequest sends a request to the hub, using the timeout and retries configured
+// for the type of payload. If result is not nil, the JSON body of the response is
+// decoded into it, including for error responses.
+func (c *ApitallyClient) sendHubRequest(payloadType string, req *retryablehttp.Request, result any) HubRequestStatus {
+	if retryAfter := c.getRetryAfter(); time.Now().Before(retryAfter) {
+		c.logger.Debug("Skipping request to Apitally hub as instructed", "retry_after", retryAfter)
+		return HubRequestStatusRetryableError
+	}
+
+	if !c.circuitBreaker.Allow() {
+		c.logger.Debug("Skipping request to Apitally hub while backing off")
 		return HubRequestStatusRetryableError
 	}
 
-	resp, err := c.httpClient.Do(retryReq)
+	httpClient, ok := c.hubHTTPClients[payloadType]
+	if !ok {
+		httpClient = c.httpClient
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		c.logger.Warn("Error sending request to Apitally hub", "error", err)
+		c.hubRequestFailures.Add(1)
+		c.recordHubFailure()
 		return HubRequestStatusRetryableError
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// Pause requests as long as the hub asks us to, instead of backing off on our own.
+		// The hub is reachable, so this also closes the circuit, which releases the probe
+		// if this was one.
+		if seconds, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && seconds > 0 {
+			c.pauseHubRequests(time.Duration(seconds) * time.Second)
+			c.circuitBreaker.RecordSuccess()
+		} else {
+			c.recordHubFailure()
+		}
+	} else if resp.StatusCode >= 500 {
+		c.recordHubFailure()
+	} else if c.circuitBreaker.RecordSuccess() {
+		c.logger.Info("Apitally hub reachable again")
+	}
+
+	if result != nil && resp.StatusCode != http.StatusNotFound {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil && err != io.EOF {
+			c.logger.Debug("Failed to parse response from Apitally hub", "error", err)
+		}
+	}
+
 	if resp.StatusCode >= 400 {
+		c.hubRequestFailures.Add(1)
 		switch resp.StatusCode {
 		case http.StatusNotFound:
-			c.logger.Error("Invalid Apitally client ID", "client_id", c.Config.ClientID)
-			c.enabled = false
+			c.configMutex.RLock()
+			clientID := c.Config.ClientID
+			c.configMutex.RUnlock()
+			c.logger.Error("Invalid Apitally client ID", "client_id", clientID)
+			c.enabled.Store(false)
 			c.stopSync()
 			return HubRequestStatusInvalidClientId
+		case http.StatusUnauthorized, http.StatusForbidden:
+			// Retrying won't help, so stop syncing like for an invalid client ID
+			c.configMutex.RLock()
+			clientID := c.Config.ClientID
+			c.configMutex.RUnlock()
+			c.logger.Error("Apitally hub rejected client ID as unauthorized, stopping sync", "client_id", clientID, "status_code", resp.StatusCode)
+			c.enabled.Store(false)
+			c.stopSync()
+			return HubRequestStatusUnauthorized
 		case http.StatusUnprocessableEntity:
 			c.logger.Warn("Received validation error from Apitally hub")
 			return HubRequestStatusValidationError
 		case http.StatusPaymentRequired:
 			return HubRequestStatusPaymentRequired
+		case http.StatusUnsupportedMediaType:
+			return HubRequestStatusUnsupportedMediaType
 		default:
 			c.logger.Warn("Received unexpected status code from Apitally hub", "status_code", resp.StatusCode)
 			return HubRequestStatusRetryableError
@@ -447,11 +1108,86 @@ func (c *ApitallyClient) sendHubRequest(req *http.Request) HubRequestStatus {
 	return HubRequestStatusOK
 }
 
+func (c *ApitallyClient) recordHubFailure() {
+	if backoff := c.circuitBreaker.RecordFailure(); backoff > 0 {
+		c.logger.Warn("Apitally hub unreachable, backing off", "backoff", backoff)
+	}
+}
+
+func (c *ApitallyClient) applyHubResponse(hubResponse *HubResponse) {
+	if hubResponse.RetryAfter != nil && *hubResponse.RetryAfter > 0 {
+		c.pauseHubRequests(time.Duration(*hubResponse.RetryAfter * float64(time.Second)))
+	}
+	if hubResponse.SuspendRequestLoggingUntil != nil {
+		suspendUntil := time.UnixMilli(int64(*hubResponse.SuspendRequestLoggingUntil * 1000))
+		if time.Now().Before(suspendUntil) {
+			c.logger.Info("Suspending request logging as instructed by Apitally hub", "until", suspendUntil)
+			c.RequestLogger.SuspendUntil(suspendUntil)
+		}
+	}
+	if hubResponse.Config != nil {
+		c.applyRemoteConfig(hubResponse.Config)
+	}
+}
+
+// pauseHubRequests skips all requests to the hub for the given duration, capped at
+// maxHubRetryAfter. Payloads are kept and sent once the pause has elapsed.
+func (c *ApitallyClient) pauseHubRequests(duration time.Duration) {
+	retryAfter := time.Now().Add(min(duration, maxHubRetryAfter))
+	c.logger.Info("Pausing requests to Apitally hub as instructed", "retry_after", retryAfter)
+	c.remoteMutex.Lock()
+	if retryAfter.After(c.retryAfter) {
+		c.retryAfter = retryAfter
+	}
+	c.remoteMutex.Unlock()
+}
+
+func (c *ApitallyClient) getRetryAfter() time.Time {
+	c.remoteMutex.Lock()
+	defer c.remoteMutex.Unlock()
+
+	return c.retryAfter
+}
+
+// applyRemoteConfig applies configuration directives from the hub, except for settings
+// marked for local precedence in the config.
+func (c *ApitallyClient) applyRemoteConfig(config *RemoteConfig) {
+	c.configMutex.RLock()
+	localPrecedence := c.Config.LocalPrecedence
+	c.configMutex.RUnlock()
+
+	if config.RequestLoggingEnabled != nil && !localPrecedence.RequestLogging {
+		if c.RequestLogger.IsEnabled() != *config.RequestLoggingEnabled {
+			c.logger.Info("Applying request logging config from Apitally hub", "enabled", *config.RequestLoggingEnabled)
+		}
+		c.RequestLogger.SetRemoteEnabled(*config.RequestLoggingEnabled)
+	}
+	if config.RequestLoggingSampleRate != nil && !localPrecedence.SampleRate {
+		c.logger.Debug("Applying request logging sample rate from Apitally hub", "sample_rate", *config.RequestLoggingSampleRate)
+		c.RequestLogger.SetSampleRate(*config.RequestLoggingSampleRate)
+	}
+	if config.SuspendRequestLoggingFor != nil && *config.SuspendRequestLoggingFor > 0 {
+		duration := time.Duration(*config.SuspendRequestLoggingFor * float64(time.Second))
+		c.logger.Info("Suspending request logging as instructed by Apitally hub", "duration", duration)
+		c.RequestLogger.SuspendFor(duration)
+	}
+	if config.SyncInterval != nil && !localPrecedence.SyncInterval {
+		interval := time.Duration(*config.SyncInterval * float64(time.Second))
+		interval = max(minSyncInterval, min(maxRemoteSyncInterval, interval))
+		c.remoteMutex.Lock()
+		if c.remoteSyncInterval != interval {
+			c.logger.Info("Applying sync interval from Apitally hub", "interval", interval)
+			c.remoteSyncInterval = interval
+		}
+		c.remoteMutex.Unlock()
+	}
+}
+
 func getHttpClient() *retryablehttp.Client {
 	retryClient := retryablehttp.NewClient()
 	retryClient.RetryMax = 3
 	retryClient.Logger = nil
-	retryClient.HTTPClient.Timeout = 10 * time.Second
+	retryClient.HTTPClient.Timeout = defaultHubRequestTimeout
 	retryClient.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
 		// Don't retry on context.Canceled or context.DeadlineExceeded
 		if ctx.Err() != nil {
@@ -459,6 +1195,11 @@ func getHttpClient() *retryablehttp.Client {
 		}
 
 		if resp != nil {
+			// Don't retry if rate limited with Retry-After, as requests are paused instead
+			if resp.StatusCode == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "" {
+				return false, nil
+			}
+
 			// Only retry on 429 or 5xx responses
 			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
 				return true, nil
@@ -473,19 +1214,58 @@ func getHttpClient() *retryablehttp.Client {
 	return retryClient
 }
 
-func (c *ApitallyClient) randomDelay() {
-	delay := time.Duration(100+rand.Float64()*400) * time.Millisecond
-	time.Sleep(delay)
+// tuneHubTransport applies the connection settings from the config to the transport.
+func tuneHubTransport(t *http.Transport, config common.HubTransportConfig) {
+	if config.MaxIdleConns > 0 {
+		t.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = config.IdleConnTimeout
+	}
+	if config.DisableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	t.DisableKeepAlives = config.DisableKeepAlives
 }
 
-func isValidClientId(clientID string) bool {
-	_, err := uuid.Parse(clientID)
-	return err == nil
+// newHubHTTPClient returns a client sharing the transport and retry policy of base, with
+// the timeout and number of retries configured for a type of payload.
+func newHubHTTPClient(base *retryablehttp.Client, settings common.HubRequestSettings, defaultTimeout time.Duration) *retryablehttp.Client {
+	httpClient := &http.Client{}
+	if base.HTTPClient != nil {
+		*httpClient = *base.HTTPClient
+	}
+	httpClient.Timeout = defaultTimeout
+	if settings.Timeout > 0 {
+		httpClient.Timeout = settings.Timeout
+	}
+
+	retryMax := base.RetryMax
+	if settings.MaxRetries > 0 {
+		retryMax = settings.MaxRetries
+	} else if settings.MaxRetries < 0 {
+		retryMax = 0
+	}
+
+	return &retryablehttp.Client{
+		HTTPClient:      httpClient,
+		Logger:          base.Logger,
+		RetryWaitMin:    base.RetryWaitMin,
+		RetryWaitMax:    base.RetryWaitMax,
+		RetryMax:        retryMax,
+		RequestLogHook:  base.RequestLogHook,
+		ResponseLogHook: base.ResponseLogHook,
+		CheckRetry:      base.CheckRetry,
+		Backoff:         base.Backoff,
+		ErrorHandler:    base.ErrorHandler,
+		PrepareRetry:    base.PrepareRetry,
+	}
 }
 
-func isValidEnv(env string) bool {
-	matched, _ := regexp.MatchString(`^[a-z0-9-]{1,32}$`, env)
-	return matched
+func (c *ApitallyClient) randomDelay() {
+	delay := time.Duration(100+rand.Float64()*400) * time.Millisecond
+	time.Sleep(delay)
 }
 
 func parseBoolEnv(key string) bool {
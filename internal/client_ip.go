@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// getClientIP determines the IP address of the client from the address of the direct
+// peer and, if the peer is a trusted proxy, the forwarding headers. Forwarded and
+// X-Forwarded-For are evaluated from right to left, skipping trusted proxies, so that
+// addresses prepended by the client can't be used to spoof the client IP.
+func getClientIP(remoteAddr string, headers [][2]string, trustedProxies []netip.Prefix, anonymize bool) string {
+	addr, ok := parseIP(remoteAddr)
+	if !ok {
+		return ""
+	}
+
+	if isTrustedProxy(addr, trustedProxies) {
+		if chain := getForwardedFor(headers); len(chain) > 0 {
+			addr = resolveForwardedChain(addr, chain, trustedProxies)
+		} else if chain := getHeaderValues(headers, "X-Forwarded-For"); len(chain) > 0 {
+			addr = resolveForwardedChain(addr, chain, trustedProxies)
+		} else if values := getHeaderValues(headers, "CF-Connecting-IP"); len(values) > 0 {
+			if cfAddr, ok := parseIP(values[0]); ok {
+				addr = cfAddr
+			}
+		}
+	}
+
+	if anonymize {
+		addr = anonymizeIP(addr)
+	}
+	return addr.String()
+}
+
+// resolveForwardedChain walks the chain of forwarded addresses from right to left,
+// starting at the direct peer, and returns the first address that isn't a trusted
+// proxy, or the leftmost valid address if all are trusted.
+func resolveForwardedChain(addr netip.Addr, chain []string, trustedProxies []netip.Prefix) netip.Addr {
+	for i := len(chain) - 1; i >= 0 && isTrustedProxy(addr, trustedProxies); i-- {
+		next, ok := parseIP(chain[i])
+		if !ok {
+			break
+		}
+		addr = next
+	}
+	return addr
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getForwardedFor returns the addresses of the "for" parameters of the Forwarded
+// header (RFC 7239), in order.
+func getForwardedFor(headers [][2]string) []string {
+	var addrs []string
+	for _, element := range getHeaderValues(headers, "Forwarded") {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(key, "for") {
+				addrs = append(addrs, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return addrs
+}
+
+// getHeaderValues returns the comma-separated values of all headers with the given name.
+func getHeaderValues(headers [][2]string, name string) []string {
+	var values []string
+	for _, header := range headers {
+		if strings.EqualFold(header[0], name) {
+			for _, value := range strings.Split(header[1], ",") {
+				if value = strings.TrimSpace(value); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// parseIP parses an IP address with an optional port, e.g. "192.0.2.1:1234" or
+// "[2001:db8::1]:1234". IPv4-mapped IPv6 addresses are converted to IPv4.
+func parseIP(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func anonymizeIP(addr netip.Addr) netip.Addr {
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr
+	}
+	return prefix.Addr()
+}
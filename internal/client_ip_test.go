@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClientIP(t *testing.T) {
+	trustedProxies := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    [][2]string
+		anonymize  bool
+		expected   string
+	}{
+		{"NoHeaders", "198.51.100.7:1234", nil, false, "198.51.100.7"},
+		{"UntrustedPeer", "198.51.100.7:1234", [][2]string{{"X-Forwarded-For", "203.0.113.1"}}, false, "198.51.100.7"},
+		{"XForwardedFor", "10.0.0.1:1234", [][2]string{{"X-Forwarded-For", "203.0.113.1"}}, false, "203.0.113.1"},
+		{"XForwardedForSpoofed", "10.0.0.1:1234", [][2]string{{"X-Forwarded-For", "1.2.3.4, 203.0.113.1, 10.0.0.2"}}, false, "203.0.113.1"},
+		{"XForwardedForMultipleHeaders", "10.0.0.1:1234", [][2]string{{"X-Forwarded-For", "203.0.113.1"}, {"x-forwarded-for", "10.0.0.2"}}, false, "203.0.113.1"},
+		{"XForwardedForAllTrusted", "10.0.0.1:1234", [][2]string{{"X-Forwarded-For", "10.0.0.3, 10.0.0.2"}}, false, "10.0.0.3"},
+		{"XForwardedForInvalid", "10.0.0.1:1234", [][2]string{{"X-Forwarded-For", "203.0.113.1, garbage"}}, false, "10.0.0.1"},
+		{"Forwarded", "10.0.0.1:1234", [][2]string{{"Forwarded", `for=203.0.113.1;proto=https, for="[2001:db8:cafe::17]:4711"`}}, false, "2001:db8:cafe::17"},
+		{"ForwardedPrecedence", "10.0.0.1:1234", [][2]string{{"X-Forwarded-For", "198.51.100.7"}, {"Forwarded", "for=203.0.113.1"}}, false, "203.0.113.1"},
+		{"CFConnectingIP", "10.0.0.1:1234", [][2]string{{"CF-Connecting-IP", "203.0.113.1"}}, false, "203.0.113.1"},
+		{"IPv6Peer", "[2001:db8:ffff::1]:1234", [][2]string{{"X-Forwarded-For", "203.0.113.1"}}, false, "203.0.113.1"},
+		{"IPv4Mapped", "[::ffff:198.51.100.7]:1234", nil, false, "198.51.100.7"},
+		{"AnonymizeIPv4", "198.51.100.7:1234", nil, true, "198.51.100.0"},
+		{"AnonymizeIPv6", "[2001:db8:cafe:1:2:3:4:5]:1234", nil, true, "2001:db8:cafe::"},
+		{"WithoutPort", "198.51.100.7", nil, false, "198.51.100.7"},
+		{"InvalidRemoteAddr", "pipe", nil, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, getClientIP(tt.remoteAddr, tt.headers, trustedProxies, tt.anonymize))
+		})
+	}
+}
@@ -1,7 +1,16 @@
 package internal
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"sync"
@@ -29,7 +38,12 @@ func TestApitallyClient(t *testing.T) {
 		client.SetStartupData([]common.PathInfo{}, map[string]string{}, "test")
 
 		// Add request to the counter
-		client.RequestCounter.AddRequest("GET", "/test", "test", 200, 123, 0, 0)
+		client.RequestCounter.AddRequest(RequestInfo{
+			Method:       "GET",
+			Path:         "/test",
+			StatusCode:   200,
+			ResponseTime: 123,
+		})
 
 		// Log request
 		timestamp := float64(time.Now().Unix())
@@ -47,7 +61,7 @@ func TestApitallyClient(t *testing.T) {
 			Headers:      [][2]string{},
 			Body:         []byte{},
 		}
-		client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		// Wait for request logger maintenance to run
 		time.Sleep(time.Millisecond * 1100)
@@ -81,6 +95,719 @@ func TestApitallyClient(t *testing.T) {
 		assert.False(t, client.IsEnabled())
 	})
 
+	t.Run("UpdateConfig", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		client.SetStartupData([]common.PathInfo{}, map[string]string{}, "test")
+		client.sendStartupData()
+		instanceUUID := client.getInstanceUUID()
+		assert.False(t, client.RequestLogger.IsEnabled())
+
+		// Invalid env is rejected
+		newConfig := *config
+		newConfig.Env = "invalid_env"
+		assert.ErrorIs(t, client.UpdateConfig(newConfig), common.ErrInvalidEnv)
+		assert.Equal(t, "test", client.Config.Env)
+
+		// Enable request logging and switch env
+		newConfig = *common.NewConfig(config.ClientID)
+		newConfig.Env = "test2"
+		newConfig.RequestLogging.Enabled = true
+		newConfig.RequestLogging.LogRequestBody = true
+		assert.NoError(t, client.UpdateConfig(newConfig))
+		assert.True(t, client.RequestLogger.IsEnabled())
+		assert.True(t, client.RequestLogger.ShouldLogRequestBody("application/json"))
+		assert.NotEqual(t, instanceUUID, client.getInstanceUUID())
+
+		// Startup data is sent again to the new env
+		client.sendStartupData()
+		client.sendSyncData()
+		recordedURLs := mockTransport.GetRecordedURLs()
+		assert.True(t, slices.ContainsFunc(recordedURLs, func(url string) bool {
+			return strings.HasSuffix(url, "/test2/startup")
+		}))
+		assert.True(t, slices.ContainsFunc(recordedURLs, func(url string) bool {
+			return strings.HasSuffix(url, "/test2/sync")
+		}))
+	})
+
+	t.Run("RemoteConfig", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+		assert.True(t, client.RequestLogger.IsEnabled())
+
+		// Hub disables request logging
+		mockTransport.SetResponseBody(`{"config": {"request_logging_enabled": false}}`)
+		client.sendSyncData()
+		assert.False(t, client.RequestLogger.IsEnabled())
+
+		// Hub re-enables request logging with sampling and then suspends it
+		mockTransport.SetResponseBody(`{"config": {"request_logging_enabled": true, "request_logging_sample_rate": 0.1}}`)
+		client.sendSyncData()
+		assert.True(t, client.RequestLogger.IsEnabled())
+		assert.Equal(t, 0.1, client.RequestLogger.sampleRate)
+		assert.False(t, client.RequestLogger.IsSuspended())
+
+		mockTransport.SetResponseBody(`{"config": {"suspend_request_logging_for": 3600}}`)
+		client.sendSyncData()
+		assert.True(t, client.RequestLogger.IsSuspended())
+
+		// Responses without config are ignored
+		mockTransport.SetResponseBody(`{}`)
+		client.sendSyncData()
+		assert.True(t, client.RequestLogger.IsEnabled())
+	})
+
+	t.Run("RemoteConfigLocalPrecedence", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.LocalPrecedence.RequestLogging = true
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+		assert.Equal(t, defaultSyncInterval, client.getSyncInterval(defaultSyncInterval))
+
+		mockTransport.SetResponseBody(`{"config": {"request_logging_enabled": false, "sync_interval": 1}}`)
+		client.sendSyncData()
+		assert.True(t, client.RequestLogger.IsEnabled())
+		assert.Equal(t, minSyncInterval, client.getSyncInterval(defaultSyncInterval))
+
+		mockTransport.SetResponseBody(`{"config": {"sync_interval": 120}}`)
+		client.sendSyncData()
+		assert.Equal(t, 2*time.Minute, client.getSyncInterval(defaultSyncInterval))
+	})
+
+	t.Run("SyncInterval", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.SyncIntervalSeconds = 300
+		client := InitApitallyClient(*config)
+		assert.Equal(t, 5*time.Minute, client.syncInterval)
+		client.Shutdown()
+
+		ResetApitallyClient()
+		config.SyncIntervalSeconds = 1
+		client = InitApitallyClient(*config)
+		assert.Equal(t, minSyncInterval, client.syncInterval)
+		client.Shutdown()
+	})
+
+	t.Run("HubResponseInstructions", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		suspendUntil := time.Now().Add(time.Hour).Unix()
+		mockTransport.SetResponseBody(fmt.Sprintf(`{"retry_after": 60, "suspend_request_logging_until": %d}`, suspendUntil))
+		client.sendSyncData()
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+		assert.True(t, client.RequestLogger.IsSuspended())
+
+		// Requests to the hub are paused and the payload is kept for later
+		err := client.sendSyncData()
+		assert.ErrorIs(t, err, common.ErrHubUnreachable)
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+		assert.Len(t, client.syncDataChan, 1)
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		client.SetStartupData([]common.PathInfo{}, map[string]string{}, "test")
+		assert.NoError(t, client.Flush())
+		recordedURLs := mockTransport.GetRecordedURLs()
+		assert.True(t, slices.ContainsFunc(recordedURLs, func(url string) bool {
+			return strings.HasSuffix(url, "/test/startup")
+		}))
+		assert.True(t, slices.ContainsFunc(recordedURLs, func(url string) bool {
+			return strings.HasSuffix(url, "/test/sync")
+		}))
+
+		// Full queue
+		for len(client.syncDataChan) < cap(client.syncDataChan) {
+			client.syncDataChan <- SyncPayload{}
+		}
+		assert.ErrorIs(t, client.Flush(), common.ErrQueueFull)
+	})
+
+	t.Run("PersistSyncData", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.SyncQueueDir = t.TempDir()
+		httpClient, _ := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+
+		// Simulate hub outage
+		client.retryAfter = time.Now().Add(time.Hour)
+		client.RequestCounter.AddRequest(RequestInfo{Method: "GET", Path: "/test", StatusCode: 200, ResponseTime: 10})
+		assert.ErrorIs(t, client.sendSyncData(), common.ErrHubUnreachable)
+		client.Shutdown()
+
+		// Payload is restored after restart and deleted once sent
+		ResetApitallyClient()
+		httpClient, mockTransport := createMockHTTPClient()
+		client = InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+		assert.Len(t, client.syncDataChan, 1)
+
+		assert.NoError(t, client.sendSyncData())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 2)
+		entries, err := os.ReadDir(config.SyncQueueDir)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 0)
+	})
+
+	t.Run("RetryAfter", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		// Rate limited requests are not retried, but paused for the given duration
+		mockTransport.SetStatusCode(http.StatusTooManyRequests)
+		mockTransport.SetResponseHeader("Retry-After", "30")
+		client.RequestCounter.AddRequest(RequestInfo{Method: "GET", Path: "/test", StatusCode: 200, ResponseTime: 10})
+		assert.ErrorIs(t, client.sendSyncData(), common.ErrHubUnreachable)
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+		assert.WithinDuration(t, time.Now().Add(30*time.Second), client.getRetryAfter(), time.Second)
+		assert.True(t, client.circuitBreaker.OpenUntil().IsZero())
+
+		// Payload is kept until the pause has elapsed
+		mockTransport.SetStatusCode(0)
+		assert.ErrorIs(t, client.sendSyncData(), common.ErrHubUnreachable)
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+		assert.Len(t, client.syncDataChan, 2)
+
+		// Pause is capped
+		client.pauseHubRequests(24 * time.Hour)
+		assert.WithinDuration(t, time.Now().Add(maxHubRetryAfter), client.getRetryAfter(), time.Second)
+	})
+
+	t.Run("RateLimitedProbe", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		// Open the circuit and simulate the backoff having elapsed
+		for i := 0; i < circuitBreakerThreshold; i++ {
+			client.circuitBreaker.RecordFailure()
+		}
+		client.circuitBreaker.mutex.Lock()
+		client.circuitBreaker.openUntil = time.Now().Add(-time.Second)
+		client.circuitBreaker.mutex.Unlock()
+
+		// Probe is rate limited
+		mockTransport.SetStatusCode(http.StatusTooManyRequests)
+		mockTransport.SetResponseHeader("Retry-After", "30")
+		assert.ErrorIs(t, client.sendSyncData(), common.ErrHubUnreachable)
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+
+		// Requests resume once the pause has elapsed, sending the kept and new payloads
+		client.remoteMutex.Lock()
+		client.retryAfter = time.Time{}
+		client.remoteMutex.Unlock()
+		mockTransport.SetStatusCode(0)
+		assert.NoError(t, client.sendSyncData())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 3)
+		assert.Len(t, client.syncDataChan, 0)
+	})
+
+	t.Run("HubRequestSettings", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.HubRequests.Sync.MaxRetries = 5
+		config.HubRequests.Log = common.HubRequestSettings{Timeout: time.Minute, MaxRetries: -1}
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		assert.Equal(t, defaultHubRequestTimeout, client.hubHTTPClients["startup"].HTTPClient.Timeout)
+		assert.Equal(t, 3, client.hubHTTPClients["startup"].RetryMax)
+		assert.Equal(t, defaultHubRequestTimeout, client.hubHTTPClients["sync"].HTTPClient.Timeout)
+		assert.Equal(t, 5, client.hubHTTPClients["sync"].RetryMax)
+		assert.Equal(t, time.Minute, client.hubHTTPClients["log"].HTTPClient.Timeout)
+		assert.Equal(t, 0, client.hubHTTPClients["log"].RetryMax)
+		assert.Equal(t, mockTransport, client.hubHTTPClients["log"].HTTPClient.Transport)
+
+		// Log files are streamed from disk with their content length set
+		request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+		response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+		client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		client.RequestLogger.writeToFile()
+		assert.NoError(t, client.RequestLogger.rotateFile())
+		logFile := client.RequestLogger.GetFile()
+		client.RequestLogger.RetryFileLater(logFile)
+		req, err := newLogRequest("http://test/log", &logUpload{files: []*TempGzipFile{logFile}}, nil, client.logUploadLimiter)
+		assert.NoError(t, err)
+		assert.Equal(t, logFile.DiskSize(), req.ContentLength)
+
+		assert.NoError(t, client.sendLogData())
+		assert.Equal(t, []int64{req.ContentLength}, mockTransport.GetRecordedBodySizes())
+	})
+
+	t.Run("HubTransport", func(t *testing.T) {
+		transport := getHttpClient().HTTPClient.Transport.(*http.Transport)
+		tuneHubTransport(transport, common.HubTransportConfig{})
+		assert.Equal(t, 100, transport.MaxIdleConns)
+		assert.True(t, transport.ForceAttemptHTTP2)
+		assert.False(t, transport.DisableKeepAlives)
+
+		tuneHubTransport(transport, common.HubTransportConfig{
+			MaxIdleConns:      10,
+			IdleConnTimeout:   time.Second,
+			DisableHTTP2:      true,
+			DisableKeepAlives: true,
+		})
+		assert.Equal(t, 10, transport.MaxIdleConns)
+		assert.Equal(t, time.Second, transport.IdleConnTimeout)
+		assert.False(t, transport.ForceAttemptHTTP2)
+		assert.NotNil(t, transport.TLSNextProto)
+		assert.True(t, transport.DisableKeepAlives)
+	})
+
+	t.Run("UnixSocket", func(t *testing.T) {
+		ResetApitallyClient()
+
+		var recordedPaths []string
+		var mutex sync.Mutex
+		listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "agent.sock"))
+		assert.NoError(t, err)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			recordedPaths = append(recordedPaths, r.URL.Path)
+			mutex.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		server.Listener = listener
+		server.Start()
+		defer server.Close()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.HubURL = "unix://" + listener.Addr().String()
+		client := InitApitallyClient(*config)
+		defer client.Shutdown()
+
+		client.RequestCounter.AddRequest(RequestInfo{Method: "GET", Path: "/test", StatusCode: 200, ResponseTime: 10})
+		assert.NoError(t, client.sendSyncData())
+		mutex.Lock()
+		assert.Equal(t, []string{"/v2/e117eb33-f6d2-4260-a71d-31eb49425893/test/sync"}, recordedPaths)
+		mutex.Unlock()
+	})
+
+	t.Run("BatchUploads", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.RequestLogging.BatchUploads = true
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		for i := 0; i < 3; i++ {
+			request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+			client.RequestLogger.writeToFile()
+			assert.NoError(t, client.RequestLogger.rotateFile())
+		}
+		assert.Equal(t, 3, client.RequestLogger.PendingFileCount())
+
+		assert.NoError(t, client.sendLogData())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+		assert.Equal(t, 0, client.RequestLogger.PendingFileCount())
+	})
+
+	t.Run("UploadConcurrency", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.RequestLogging.UploadConcurrency = 3
+		config.HubRequests.Log.MaxRetries = -1
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		for i := 0; i < 5; i++ {
+			request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+			client.RequestLogger.writeToFile()
+			assert.NoError(t, client.RequestLogger.rotateFile())
+		}
+
+		// Workers stop taking files once an upload fails
+		mockTransport.SetStatusCode(http.StatusInternalServerError)
+		assert.ErrorIs(t, client.sendLogData(), common.ErrHubUnreachable)
+		assert.LessOrEqual(t, len(mockTransport.GetRecordedURLs()), 3)
+		assert.Equal(t, 5, client.RequestLogger.PendingFileCount())
+
+		mockTransport.SetStatusCode(0)
+		client.circuitBreaker.RecordSuccess()
+		assert.NoError(t, client.sendLogData())
+		assert.Equal(t, 0, client.RequestLogger.PendingFileCount())
+	})
+
+	t.Run("ResendStartupData", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		client.SetStartupData([]common.PathInfo{{Method: "GET", Path: "/test"}}, map[string]string{"go": "1.21"}, "go:test")
+		assert.NoError(t, client.sendStartupData())
+		messageUUID := client.startupData.MessageUUID
+
+		// Startup data is sent again if requested in the response to a sync
+		mockTransport.SetResponseBody(`{"resend_startup_data": true}`)
+		assert.NoError(t, client.sendSyncData())
+		mockTransport.SetResponseBody("")
+		assert.NoError(t, client.sendStartupData())
+		assert.NotEqual(t, messageUUID, client.startupData.MessageUUID)
+
+		startupRequests := 0
+		for _, url := range mockTransport.GetRecordedURLs() {
+			if strings.HasSuffix(url, "/test/startup") {
+				startupRequests++
+			}
+		}
+		assert.Equal(t, 2, startupRequests)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		for _, statusCode := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+			ResetApitallyClient()
+
+			config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+			config.Env = "test"
+			httpClient, mockTransport := createMockHTTPClient()
+			client := InitApitallyClientWithHTTPClient(*config, httpClient)
+
+			// Auth failures are not retried and stop syncing
+			mockTransport.SetStatusCode(statusCode)
+			client.RequestCounter.AddRequest(RequestInfo{Method: "GET", Path: "/test", StatusCode: 200, ResponseTime: 10})
+			assert.ErrorIs(t, client.sendSyncData(), common.ErrUnauthorized)
+			assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+			assert.False(t, client.IsEnabled())
+			client.Shutdown()
+		}
+	})
+
+	t.Run("UnauthorizedConcurrently", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		// Senders receiving auth failures at the same time stop syncing only once
+		mockTransport.SetStatusCode(http.StatusForbidden)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, err := retryablehttp.NewRequest("POST", client.getHubUrl("log", ""), []byte("{}"))
+				assert.NoError(t, err)
+				assert.Equal(t, HubRequestStatusUnauthorized, client.sendHubRequest("log", req, nil))
+			}()
+		}
+		wg.Wait()
+		assert.False(t, client.IsEnabled())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 8)
+	})
+
+	t.Run("MsgpackEncoding", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.RequestLogging.MsgpackEncoding = true
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		logRequest := func() {
+			request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+			assert.NoError(t, client.RequestLogger.writeToFile())
+		}
+
+		// Hub rejects MessagePack, so the file is dropped and JSON is used from then on
+		logRequest()
+		mockTransport.SetStatusCode(http.StatusUnsupportedMediaType)
+		assert.NoError(t, client.sendLogData())
+		assert.Equal(t, int64(1), client.RequestLogger.DeletedFiles())
+
+		logRequest()
+		mockTransport.SetStatusCode(0)
+		assert.NoError(t, client.sendLogData())
+		assert.Equal(t, []string{"application/msgpack", "application/json"}, mockTransport.GetRecordedContentTypes())
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		ResetApitallyClient()
+
+		var callbackItems [][]byte
+		dryRunFile := filepath.Join(t.TempDir(), "requests.jsonl")
+		harPath := filepath.Join(t.TempDir(), "requests.har")
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.RequestLogging.LogRequestHeaders = true
+		config.RequestLogging.MsgpackEncoding = true
+		config.RequestLogging.DryRun = true
+		config.RequestLogging.DryRunFile = dryRunFile
+		config.RequestLogging.DryRunHARFile = harPath
+		config.RequestLogging.DryRunCallback = func(item []byte) {
+			callbackItems = append(callbackItems, item)
+		}
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		for i := 0; i < 2; i++ {
+			request := &common.Request{
+				Timestamp: float64(time.Now().Unix()),
+				Method:    "GET",
+				Path:      "/test",
+				URL:       "http://test/test",
+				Headers:   [][2]string{{"Authorization", "Bearer secret"}},
+			}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+			assert.NoError(t, client.RequestLogger.writeToFile())
+			assert.NoError(t, client.sendLogData())
+		}
+
+		// Nothing is sent to the hub, and items are written as JSON after masking
+		assert.Empty(t, mockTransport.GetRecordedURLs())
+		assert.Equal(t, 0, client.RequestLogger.PendingFileCount())
+		content, err := os.ReadFile(dryRunFile)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		assert.Len(t, lines, 2)
+		assert.Len(t, callbackItems, 2)
+		assert.Equal(t, lines[0], string(callbackItems[0]))
+
+		var item RequestLogItem
+		assert.NoError(t, json.Unmarshal(callbackItems[0], &item))
+		assert.Equal(t, "/test", item.Request.Path)
+		assert.Equal(t, [][2]string{{"Authorization", "******"}}, item.Request.Headers)
+
+		// Items of both syncs are added to the HAR file
+		content, err = os.ReadFile(harPath)
+		assert.NoError(t, err)
+		var har harFile
+		assert.NoError(t, json.Unmarshal(content, &har))
+		assert.Len(t, har.Log.Entries, 2)
+		assert.Equal(t, item.UUID, har.Log.Entries[0].Comment)
+	})
+
+	t.Run("BeforeSendCallback", func(t *testing.T) {
+		ResetApitallyClient()
+
+		var payloadTypes []string
+		var logBodies []string
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.BeforeSendCallback = func(payloadType string, body []byte) []byte {
+			payloadTypes = append(payloadTypes, payloadType)
+			switch payloadType {
+			case "startup":
+				return nil
+			case "log":
+				logBodies = append(logBodies, string(body))
+				if len(logBodies) == 1 {
+					return nil
+				}
+			}
+			return body
+		}
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		logRequest := func() {
+			request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+			assert.NoError(t, client.RequestLogger.writeToFile())
+		}
+
+		// Vetoed payloads aren't sent
+		client.SetStartupData([]common.PathInfo{}, map[string]string{}, "test")
+		logRequest()
+		assert.NoError(t, client.Flush())
+		logRequest()
+		assert.NoError(t, client.sendLogData())
+
+		assert.Equal(t, []string{"startup", "sync", "log", "log"}, payloadTypes)
+		assert.Len(t, logBodies, 2)
+		assert.Contains(t, logBodies[0], `"path":"/test"`)
+		urls := mockTransport.GetRecordedURLs()
+		assert.Len(t, urls, 2)
+		assert.Contains(t, urls[0], "/sync")
+		assert.Contains(t, urls[1], "/log")
+		assert.Equal(t, 0, client.RequestLogger.PendingFileCount())
+	})
+
+	t.Run("BeforeSendCallbackCorruptFile", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.BeforeSendCallback = func(payloadType string, body []byte) []byte {
+			return body
+		}
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+		response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+		client.RequestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		assert.NoError(t, client.RequestLogger.writeToFile())
+		assert.NoError(t, client.RequestLogger.rotateFile())
+
+		// Overwrite the file with data that isn't gzipped
+		logFile := client.RequestLogger.GetFile()
+		assert.NoError(t, os.WriteFile(logFile.filePath, []byte("corrupt"), 0o600))
+		client.RequestLogger.RetryFileLater(logFile)
+
+		// Files that can't be decompressed are deleted instead of being kept forever
+		assert.NoError(t, client.sendLogData())
+		assert.Empty(t, mockTransport.GetRecordedURLs())
+		assert.Equal(t, 0, client.RequestLogger.PendingFileCount())
+		assert.NoFileExists(t, logFile.filePath)
+	})
+
+	t.Run("SkipIdleSyncs", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.HeartbeatIntervals = map[string]time.Duration{"job": time.Hour}
+		httpClient, mockTransport := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+
+		// Idle instances sync by default
+		assert.NoError(t, client.sendSyncData())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+		client.Shutdown()
+
+		ResetApitallyClient()
+		config.SkipIdleSyncs = true
+		httpClient, mockTransport = createMockHTTPClient()
+		client = InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		assert.NoError(t, client.sendSyncData())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 0)
+
+		client.HeartbeatRegistry.AddHeartbeat("job")
+		assert.NoError(t, client.sendSyncData())
+		assert.Len(t, mockTransport.GetRecordedURLs(), 1)
+	})
+
+	t.Run("SdkStats", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, _ := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		assert.Nil(t, client.getSdkStats())
+
+		client.droppedPayloads.Add(2)
+		client.hubRequestFailures.Add(1)
+		stats := client.getSdkStats()
+		assert.NotNil(t, stats)
+		assert.Equal(t, int64(2), stats.DroppedSyncPayloads)
+		assert.Equal(t, int64(1), stats.HubRequestFailures)
+
+		// Only changes since the previous sync are reported
+		assert.Nil(t, client.getSdkStats())
+		client.droppedPayloads.Add(1)
+		stats = client.getSdkStats()
+		assert.Equal(t, int64(1), stats.DroppedSyncPayloads)
+		assert.Equal(t, int64(0), stats.HubRequestFailures)
+
+		client.ConsumerRegistry.SetLimits(1, 0)
+		client.ConsumerRegistry.AddOrUpdateConsumer(&common.Consumer{Identifier: "a", Name: "A"})
+		client.ConsumerRegistry.AddOrUpdateConsumer(&common.Consumer{Identifier: "b", Name: "B"})
+		stats = client.getSdkStats()
+		assert.Equal(t, int64(1), stats.EvictedConsumers)
+	})
+
+	t.Run("CustomLogger", func(t *testing.T) {
+		ResetApitallyClient()
+
+		var buf bytes.Buffer
+		config := common.NewConfig("invalid")
+		config.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+		client := InitApitallyClient(*config)
+		defer client.Shutdown()
+
+		assert.False(t, client.IsEnabled())
+		assert.Contains(t, buf.String(), "Invalid Apitally config")
+		assert.Contains(t, buf.String(), "component=apitally")
+	})
+
 	t.Run("GetAndResetApitallyClient", func(t *testing.T) {
 		ResetApitallyClient()
 
@@ -106,25 +833,85 @@ func createMockHTTPClient() (*retryablehttp.Client, *mockTransport) {
 }
 
 type mockTransport struct {
-	recordedURLs []string
-	mutex        sync.Mutex
+	recordedURLs         []string
+	recordedContentTypes []string
+	recordedBodySizes    []int64
+	responseBody         string
+	responseHeader       http.Header
+	statusCode           int
+	mutex                sync.Mutex
 }
 
 func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Record the request URL
 	m.mutex.Lock()
 	m.recordedURLs = append(m.recordedURLs, req.URL.String())
+	m.recordedContentTypes = append(m.recordedContentTypes, req.Header.Get("Content-Type"))
+	if req.Body != nil {
+		bodySize, _ := io.Copy(io.Discard, req.Body)
+		m.recordedBodySizes = append(m.recordedBodySizes, bodySize)
+	}
+	responseBody := m.responseBody
+	responseHeader := m.responseHeader.Clone()
+	statusCode := m.statusCode
 	m.mutex.Unlock()
 
-	// Always return 202 Accepted
+	// Return 202 Accepted unless another status code is set
+	if statusCode == 0 {
+		statusCode = http.StatusAccepted
+	}
 	resp := &http.Response{
-		StatusCode: http.StatusAccepted,
+		StatusCode: statusCode,
 		Body:       http.NoBody,
 		Header:     make(http.Header),
 	}
+	if responseHeader != nil {
+		resp.Header = responseHeader
+	}
+	if responseBody != "" {
+		resp.Body = io.NopCloser(strings.NewReader(responseBody))
+	}
 	return resp, nil
 }
 
+func (m *mockTransport) SetStatusCode(statusCode int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.statusCode = statusCode
+}
+
+func (m *mockTransport) GetRecordedContentTypes() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return slices.Clone(m.recordedContentTypes)
+}
+
+func (m *mockTransport) GetRecordedBodySizes() []int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return slices.Clone(m.recordedBodySizes)
+}
+
+func (m *mockTransport) SetResponseBody(body string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.responseBody = body
+}
+
+func (m *mockTransport) SetResponseHeader(key, value string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.responseHeader == nil {
+		m.responseHeader = make(http.Header)
+	}
+	m.responseHeader.Set(key, value)
+}
+
 func (m *mockTransport) GetRecordedURLs() []string {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
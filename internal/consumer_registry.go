@@ -1,8 +1,13 @@
 package internal
 
 import (
+	"container/list"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/apitally/apitally-go/common"
 )
@@ -37,6 +42,8 @@ func validateConsumer(consumer *common.Consumer) bool {
 		consumer.Group = group
 	}
 
+	consumer.Tags = NormalizeTags(consumer.Tags)
+
 	return true
 }
 
@@ -64,34 +71,142 @@ func ConsumerFromStringOrObject(consumer any) *common.Consumer {
 	}
 }
 
+// ConsumerFromStringOrObject is like the package function of the same name, but also
+// hashes the identifier if Config.ConsumerHashKey is set. The given consumer is never
+// modified, so it can safely be shared between requests.
+func (c *ApitallyClient) ConsumerFromStringOrObject(consumer any) *common.Consumer {
+	c.configMutex.RLock()
+	hashKey := c.Config.ConsumerHashKey
+	c.configMutex.RUnlock()
+
+	if len(hashKey) == 0 {
+		return ConsumerFromStringOrObject(consumer)
+	}
+	if v, ok := consumer.(*common.Consumer); ok && v != nil {
+		consumer = *v
+	}
+	result := ConsumerFromStringOrObject(consumer)
+	if result != nil {
+		result.Identifier = common.HashConsumerIdentifier(result.Identifier, hashKey)
+	}
+	return result
+}
+
+// ForgetConsumer removes the consumer with the given identifier from the registry and
+// requests the hub to delete it, e.g. to comply with a request for erasure. The
+// identifier is normalized and hashed like those of consumers of requests. Metrics
+// counted for the consumer since the last sync are not sent.
+func (c *ApitallyClient) ForgetConsumer(identifier string) {
+	if consumer := c.ConsumerFromStringOrObject(identifier); consumer != nil {
+		c.ConsumerRegistry.ForgetConsumer(consumer.Identifier)
+		c.RequestLogger.ForgetConsumer(consumer.Identifier)
+	}
+}
+
+// removeDeletedConsumers removes the metrics of consumers deleted in the same payload, so
+// that they aren't attributed to the identifier just deleted.
+func removeDeletedConsumers(payload *SyncPayload) {
+	if len(payload.DeletedConsumers) == 0 {
+		return
+	}
+	deleted := make(map[string]bool, len(payload.DeletedConsumers))
+	for _, identifier := range payload.DeletedConsumers {
+		deleted[identifier] = true
+	}
+	payload.Requests = slices.DeleteFunc(payload.Requests, func(item RequestsItem) bool { return deleted[item.Consumer] })
+	payload.ValidationErrors = slices.DeleteFunc(payload.ValidationErrors, func(item ValidationErrorsItem) bool { return deleted[item.Consumer] })
+	payload.ServerErrors = slices.DeleteFunc(payload.ServerErrors, func(item ServerErrorsItem) bool { return deleted[item.Consumer] })
+	payload.Timeouts = slices.DeleteFunc(payload.Timeouts, func(item TimeoutsItem) bool { return deleted[item.Consumer] })
+	payload.RateLimits = slices.DeleteFunc(payload.RateLimits, func(item RateLimitsItem) bool { return deleted[item.Consumer] })
+}
+
+const (
+	defaultMaxConsumers = 100_000
+
+	// Consumers seen again are sent to the hub at most this often to update their
+	// last-seen timestamp, unless they changed.
+	consumerLastSeenInterval = time.Hour
+)
+
+// ConsumersItem is a consumer in the sync payload, along with when it was first and
+// last seen since startup.
+type ConsumersItem struct {
+	*common.Consumer
+	FirstSeen float64 `json:"first_seen"`
+	LastSeen  float64 `json:"last_seen"`
+}
+
+type consumerEntry struct {
+	consumer         *common.Consumer
+	firstSeen        time.Time
+	lastSeen         time.Time
+	reportedLastSeen time.Time
+}
+
+// ConsumerRegistry keeps the consumers seen since startup, so that only changes need to
+// be sent to the hub. To keep memory bounded, the least recently seen consumers are
+// evicted once the maximum number is exceeded, and consumers not seen within the TTL
+// are evicted on sync. Evicted consumers are sent again when seen the next time.
 type ConsumerRegistry struct {
-	consumers map[string]*common.Consumer
+	consumers map[string]*list.Element
+	lru       *list.List
 	updated   map[string]bool
+	deleted   map[string]bool
+	maxSize   int
+	ttl       time.Duration
+	evictions atomic.Int64
 	mutex     sync.Mutex
 }
 
 func NewConsumerRegistry() *ConsumerRegistry {
 	return &ConsumerRegistry{
-		consumers: make(map[string]*common.Consumer),
+		consumers: make(map[string]*list.Element),
+		lru:       list.New(),
 		updated:   make(map[string]bool),
+		deleted:   make(map[string]bool),
+		maxSize:   defaultMaxConsumers,
 	}
 }
 
+// SetLimits sets the maximum number of consumers to keep, using the default if not
+// positive, and the TTL after which consumers not seen are evicted, if positive.
+func (r *ConsumerRegistry) SetLimits(maxSize int, ttl time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if maxSize <= 0 {
+		maxSize = defaultMaxConsumers
+	}
+	r.maxSize = maxSize
+	r.ttl = ttl
+	r.evictLocked()
+}
+
 func (r *ConsumerRegistry) AddOrUpdateConsumer(consumer *common.Consumer) {
-	if consumer == nil || (consumer.Name == "" && consumer.Group == "") {
+	if consumer == nil || (consumer.Name == "" && consumer.Group == "" && len(consumer.Tags) == 0) {
 		return
 	}
 
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	existing, exists := r.consumers[consumer.Identifier]
+	element, exists := r.consumers[consumer.Identifier]
 	if !exists {
-		r.consumers[consumer.Identifier] = consumer
+		now := time.Now()
+		r.consumers[consumer.Identifier] = r.lru.PushFront(&consumerEntry{consumer: consumer, firstSeen: now, lastSeen: now})
 		r.updated[consumer.Identifier] = true
+		r.evictLocked()
 		return
 	}
 
+	entry := element.Value.(*consumerEntry)
+	entry.lastSeen = time.Now()
+	r.lru.MoveToFront(element)
+	if entry.lastSeen.Sub(entry.reportedLastSeen) > consumerLastSeenInterval {
+		r.updated[consumer.Identifier] = true
+	}
+
+	existing := entry.consumer
 	if consumer.Name != "" && (existing.Name == "" || consumer.Name != existing.Name) {
 		existing.Name = consumer.Name
 		r.updated[consumer.Identifier] = true
@@ -100,18 +215,96 @@ func (r *ConsumerRegistry) AddOrUpdateConsumer(consumer *common.Consumer) {
 		existing.Group = consumer.Group
 		r.updated[consumer.Identifier] = true
 	}
+	if len(consumer.Tags) > 0 && !maps.Equal(consumer.Tags, existing.Tags) {
+		existing.Tags = consumer.Tags
+		r.updated[consumer.Identifier] = true
+	}
+}
+
+// ForgetConsumer removes the consumer with the given identifier, if registered, and
+// marks it as deleted, so that the hub deletes it with the next sync.
+func (r *ConsumerRegistry) ForgetConsumer(identifier string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if element, exists := r.consumers[identifier]; exists {
+		r.lru.Remove(element)
+		delete(r.consumers, identifier)
+	}
+	delete(r.updated, identifier)
+	r.deleted[identifier] = true
+}
+
+// GetAndResetDeletedConsumers returns the identifiers of consumers forgotten since the
+// previous call.
+func (r *ConsumerRegistry) GetAndResetDeletedConsumers() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data := make([]string, 0, len(r.deleted))
+	for identifier := range r.deleted {
+		data = append(data, identifier)
+	}
+	r.deleted = make(map[string]bool)
+	return data
+}
+
+// evictLocked removes the least recently seen consumers exceeding the maximum number
+// or the TTL.
+func (r *ConsumerRegistry) evictLocked() {
+	for {
+		element := r.lru.Back()
+		if element == nil {
+			return
+		}
+		entry := element.Value.(*consumerEntry)
+		if r.lru.Len() <= r.maxSize && (r.ttl <= 0 || time.Since(entry.lastSeen) <= r.ttl) {
+			return
+		}
+		r.lru.Remove(element)
+		delete(r.consumers, entry.consumer.Identifier)
+		delete(r.updated, entry.consumer.Identifier)
+		r.evictions.Add(1)
+	}
+}
+
+// Evictions returns the number of consumers evicted since startup.
+func (r *ConsumerRegistry) Evictions() int64 {
+	return r.evictions.Load()
 }
 
-func (r *ConsumerRegistry) GetAndResetUpdatedConsumers() []*common.Consumer {
+func (r *ConsumerRegistry) GetAndResetUpdatedConsumers() []ConsumersItem {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	data := make([]*common.Consumer, 0, len(r.updated))
+	r.evictLocked()
+	data := make([]ConsumersItem, 0, len(r.updated))
 	for identifier := range r.updated {
-		if consumer, exists := r.consumers[identifier]; exists {
-			data = append(data, consumer)
+		if element, exists := r.consumers[identifier]; exists {
+			entry := element.Value.(*consumerEntry)
+			entry.reportedLastSeen = entry.lastSeen
+			data = append(data, ConsumersItem{
+				Consumer:  entry.consumer,
+				FirstSeen: float64(entry.firstSeen.Unix()),
+				LastSeen:  float64(entry.lastSeen.Unix()),
+			})
 		}
 	}
 	r.updated = make(map[string]bool)
 	return data
 }
+
+// GetConsumers returns copies of all consumers registered since startup.
+func (r *ConsumerRegistry) GetConsumers() []common.Consumer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data := make([]common.Consumer, 0, len(r.consumers))
+	for _, element := range r.consumers {
+		consumer := element.Value.(*consumerEntry).consumer
+		c := *consumer
+		c.Tags = maps.Clone(consumer.Tags)
+		data = append(data, c)
+	}
+	return data
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/apitally/apitally-go/common"
 	"github.com/stretchr/testify/assert"
@@ -66,6 +67,45 @@ func TestConsumerRegistry(t *testing.T) {
 		assert.NotNil(t, consumer)
 		assert.Equal(t, 64, len(consumer.Name))
 		assert.Equal(t, 64, len(consumer.Group))
+
+		// Tags should be normalized
+		consumer = ConsumerFromStringOrObject(common.Consumer{
+			Identifier: "test",
+			Tags:       map[string]string{" tier ": " gold ", "region": ""},
+		})
+		assert.NotNil(t, consumer)
+		assert.Equal(t, map[string]string{"tier": "gold"}, consumer.Tags)
+	})
+
+	t.Run("ConsumerHashKey", func(t *testing.T) {
+		ResetApitallyClient()
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.ConsumerHashKey = []byte("secret")
+		httpClient, _ := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		// Identifiers are hashed, but names and groups are kept
+		original := &common.Consumer{Identifier: " user-1 ", Name: "User 1"}
+		consumer := client.ConsumerFromStringOrObject(original)
+		assert.NotNil(t, consumer)
+		assert.Equal(t, "1d16fd7e96e8a9681f283b8a251822ffdc71e2a5bda0c5267a044874fa21b82b", consumer.Identifier)
+		assert.Equal(t, "User 1", consumer.Name)
+		assert.Equal(t, " user-1 ", original.Identifier)
+
+		// Hashes are stable and computed after normalizing the identifier
+		assert.Equal(t, consumer.Identifier, client.ConsumerFromStringOrObject("user-1").Identifier)
+		assert.NotEqual(t, consumer.Identifier, client.ConsumerFromStringOrObject("user-2").Identifier)
+		assert.Nil(t, client.ConsumerFromStringOrObject(""))
+
+		// Hashes match those used to match consumers in conditions
+		request := &common.Request{Consumer: consumer.Identifier}
+		assert.True(t, common.ConsumerIn(common.HashConsumerIdentifier("user-1", config.ConsumerHashKey))(request, &common.Response{}))
+
+		// Forgotten consumers are deleted by their hashed identifier
+		client.ForgetConsumer("user-1")
+		assert.Equal(t, []string{consumer.Identifier}, client.ConsumerRegistry.GetAndResetDeletedConsumers())
 	})
 
 	t.Run("AddOrUpdateConsumer", func(t *testing.T) {
@@ -86,7 +126,7 @@ func TestConsumerRegistry(t *testing.T) {
 		registry.AddOrUpdateConsumer(testConsumer)
 		updatedConsumers := registry.GetAndResetUpdatedConsumers()
 		assert.Len(t, updatedConsumers, 1)
-		assert.Equal(t, testConsumer, updatedConsumers[0])
+		assert.Equal(t, testConsumer, updatedConsumers[0].Consumer)
 
 		// Adding consumer with same name should not update
 		registry.AddOrUpdateConsumer(&common.Consumer{
@@ -121,6 +161,106 @@ func TestConsumerRegistry(t *testing.T) {
 			Group:      "Test Group",
 		})
 		assert.Empty(t, registry.GetAndResetUpdatedConsumers())
+
+		// Adding consumer with tags should update
+		registry.AddOrUpdateConsumer(&common.Consumer{
+			Identifier: "test",
+			Tags:       map[string]string{"tier": "gold"},
+		})
+		updatedConsumers = registry.GetAndResetUpdatedConsumers()
+		assert.Len(t, updatedConsumers, 1)
+		assert.Equal(t, map[string]string{"tier": "gold"}, updatedConsumers[0].Tags)
+
+		// Adding consumer with same tags should not update
+		registry.AddOrUpdateConsumer(&common.Consumer{
+			Identifier: "test",
+			Tags:       map[string]string{"tier": "gold"},
+		})
+		assert.Empty(t, registry.GetAndResetUpdatedConsumers())
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		registry := NewConsumerRegistry()
+		registry.SetLimits(2, 0)
+
+		// The least recently seen consumer is evicted when the maximum is exceeded
+		for _, identifier := range []string{"a", "b", "a", "c"} {
+			registry.AddOrUpdateConsumer(&common.Consumer{Identifier: identifier, Name: identifier})
+		}
+		identifiers := []string{}
+		for _, consumer := range registry.GetConsumers() {
+			identifiers = append(identifiers, consumer.Identifier)
+		}
+		assert.ElementsMatch(t, []string{"a", "c"}, identifiers)
+		assert.Equal(t, int64(1), registry.Evictions())
+
+		// Consumers not seen within the TTL are evicted on sync
+		registry.SetLimits(0, 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		registry.AddOrUpdateConsumer(&common.Consumer{Identifier: "d", Name: "d"})
+		updatedConsumers := registry.GetAndResetUpdatedConsumers()
+		assert.Len(t, updatedConsumers, 1)
+		assert.Equal(t, "d", updatedConsumers[0].Identifier)
+		assert.Len(t, registry.GetConsumers(), 1)
+		assert.Equal(t, int64(3), registry.Evictions())
+	})
+
+	t.Run("ForgetConsumer", func(t *testing.T) {
+		registry := NewConsumerRegistry()
+		registry.AddOrUpdateConsumer(&common.Consumer{Identifier: "test1", Name: "Test 1"})
+		registry.AddOrUpdateConsumer(&common.Consumer{Identifier: "test2", Name: "Test 2"})
+
+		// Forgotten consumers are removed and reported as deleted, even if unknown
+		registry.ForgetConsumer("test1")
+		registry.ForgetConsumer("unknown")
+		assert.Len(t, registry.GetConsumers(), 1)
+		updatedConsumers := registry.GetAndResetUpdatedConsumers()
+		assert.Len(t, updatedConsumers, 1)
+		assert.Equal(t, "test2", updatedConsumers[0].Identifier)
+		assert.ElementsMatch(t, []string{"test1", "unknown"}, registry.GetAndResetDeletedConsumers())
+		assert.Empty(t, registry.GetAndResetDeletedConsumers())
+	})
+
+	t.Run("RemoveDeletedConsumers", func(t *testing.T) {
+		payload := SyncPayload{
+			Requests:         []RequestsItem{{Consumer: "test1", Method: "GET", Path: "/test"}, {Consumer: "test2", Method: "GET", Path: "/test"}, {Method: "GET", Path: "/test"}},
+			ValidationErrors: []ValidationErrorsItem{{Consumer: "test1", Method: "GET", Path: "/test"}},
+			ServerErrors:     []ServerErrorsItem{{Consumer: "test1", Method: "GET", Path: "/test"}},
+			Timeouts:         []TimeoutsItem{{Consumer: "test1", Method: "GET", Path: "/test"}},
+			RateLimits:       []RateLimitsItem{{Consumer: "test1"}, {Consumer: "test2"}},
+			DeletedConsumers: []string{"test1"},
+		}
+
+		// Metrics of deleted consumers are not sent under their identifier
+		removeDeletedConsumers(&payload)
+		assert.Len(t, payload.Requests, 2)
+		assert.Equal(t, "test2", payload.Requests[0].Consumer)
+		assert.Empty(t, payload.ValidationErrors)
+		assert.Empty(t, payload.ServerErrors)
+		assert.Empty(t, payload.Timeouts)
+		assert.Equal(t, []RateLimitsItem{{Consumer: "test2"}}, payload.RateLimits)
+		assert.Equal(t, []string{"test1"}, payload.DeletedConsumers)
+	})
+
+	t.Run("FirstAndLastSeen", func(t *testing.T) {
+		registry := NewConsumerRegistry()
+		start := float64(time.Now().Unix())
+		registry.AddOrUpdateConsumer(&common.Consumer{Identifier: "test", Name: "Test"})
+		updatedConsumers := registry.GetAndResetUpdatedConsumers()
+		assert.Len(t, updatedConsumers, 1)
+		assert.GreaterOrEqual(t, updatedConsumers[0].FirstSeen, start)
+		assert.Equal(t, updatedConsumers[0].FirstSeen, updatedConsumers[0].LastSeen)
+
+		// Unchanged consumers are sent again once the last-seen timestamp is outdated
+		registry.AddOrUpdateConsumer(&common.Consumer{Identifier: "test", Name: "Test"})
+		assert.Empty(t, registry.GetAndResetUpdatedConsumers())
+		entry := registry.consumers["test"].Value.(*consumerEntry)
+		entry.firstSeen = entry.firstSeen.Add(-2 * consumerLastSeenInterval)
+		entry.reportedLastSeen = entry.reportedLastSeen.Add(-2 * consumerLastSeenInterval)
+		registry.AddOrUpdateConsumer(&common.Consumer{Identifier: "test", Name: "Test"})
+		updatedConsumers = registry.GetAndResetUpdatedConsumers()
+		assert.Len(t, updatedConsumers, 1)
+		assert.Less(t, updatedConsumers[0].FirstSeen, updatedConsumers[0].LastSeen)
 	})
 
 	t.Run("GetAndResetUpdatedConsumers", func(t *testing.T) {
@@ -141,7 +281,7 @@ func TestConsumerRegistry(t *testing.T) {
 		updatedConsumers := registry.GetAndResetUpdatedConsumers()
 		assert.Len(t, updatedConsumers, 3)
 		for _, consumer := range updatedConsumers {
-			assert.Equal(t, consumerMap[consumer.Identifier], consumer)
+			assert.Equal(t, consumerMap[consumer.Identifier], consumer.Consumer)
 		}
 
 		// Get updated consumers again should return empty slice
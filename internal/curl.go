@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// ToCurl returns a cURL command reproducing the request of a log item, as logged, i.e.
+// after masking. Masked values must be replaced before running the command. The body
+// is omitted if it wasn't logged in full, e.g. because it was truncated or too large.
+func ToCurl(item *RequestLogItem) string {
+	request := item.Request
+	if request == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("curl")
+	hasBody := len(request.Body) > 0 &&
+		!request.BodyTruncated &&
+		request.BodyEncoding == "" &&
+		!bytes.Equal(request.Body, bodyTooLarge) &&
+		!bytes.Equal(request.Body, bodyMasked) &&
+		!bytes.Equal(request.Body, bodyCompressed) &&
+		utf8.Valid(request.Body)
+	if request.Method != "GET" || hasBody {
+		b.WriteString(" -X ")
+		b.WriteString(request.Method)
+	}
+	b.WriteString(" ")
+	b.WriteString(shellQuote(request.URL))
+	for _, header := range request.Headers {
+		// Set by cURL itself, and would be wrong if the body is omitted
+		if strings.EqualFold(header[0], "Content-Length") {
+			continue
+		}
+		b.WriteString(" -H ")
+		b.WriteString(shellQuote(header[0] + ": " + header[1]))
+	}
+	if hasBody {
+		b.WriteString(" --data-raw ")
+		b.WriteString(shellQuote(string(request.Body)))
+	}
+	return b.String()
+}
+
+// shellQuote quotes a string for POSIX shells, using single quotes, within which no
+// characters are special except the single quote itself.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
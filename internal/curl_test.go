@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToCurl(t *testing.T) {
+	t.Run("Get", func(t *testing.T) {
+		item := &RequestLogItem{Request: &common.Request{
+			Method:  "GET",
+			URL:     "http://localhost/items?page=2",
+			Headers: [][2]string{{"Accept", "application/json"}},
+		}}
+		assert.Equal(t, "curl 'http://localhost/items?page=2' -H 'Accept: application/json'", ToCurl(item))
+	})
+
+	t.Run("Post", func(t *testing.T) {
+		item := &RequestLogItem{Request: &common.Request{
+			Method:  "POST",
+			URL:     "http://localhost/items",
+			Headers: [][2]string{{"Content-Type", "application/json"}, {"Content-Length", "22"}},
+			Body:    []byte(`{"name":"O'Reilly"}`),
+		}}
+		assert.Equal(t, `curl -X POST 'http://localhost/items' -H 'Content-Type: application/json' --data-raw '{"name":"O'\''Reilly"}'`, ToCurl(item))
+	})
+
+	t.Run("BodyOmitted", func(t *testing.T) {
+		item := &RequestLogItem{Request: &common.Request{
+			Method:        "PUT",
+			URL:           "http://localhost/items/1",
+			Body:          []byte(`{"name":`),
+			BodyTruncated: true,
+		}}
+		assert.Equal(t, "curl -X PUT 'http://localhost/items/1'", ToCurl(item))
+
+		item.Request.Body = bodyMasked
+		item.Request.BodyTruncated = false
+		assert.Equal(t, "curl -X PUT 'http://localhost/items/1'", ToCurl(item))
+	})
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/apitally/apitally-go/common"
+)
+
+const apitallyPackagePrefix = "github.com/apitally/apitally-go/"
+
+// Recovery middleware is identified by name, e.g. chi's middleware.Recoverer, gin's
+// CustomRecoveryWithWriter (used by gin.Recovery) or Fiber's recover.New
+var recoveryMiddlewarePattern = regexp.MustCompile(`(?i)recover`)
+
+// SetMiddlewareCheck sets the function checking the order of middleware, which depends
+// on the framework and is therefore provided by the adapter.
+func (c *ApitallyClient) SetMiddlewareCheck(check func() []common.Finding) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.middlewareCheck = check
+}
+
+// Diagnose checks for common misconfigurations and returns the findings.
+func (c *ApitallyClient) Diagnose() []common.Finding {
+	c.mutex.Lock()
+	check := c.middlewareCheck
+	c.mutex.Unlock()
+
+	findings := []common.Finding{}
+	if check != nil {
+		findings = append(findings, check()...)
+	}
+	return findings
+}
+
+// LogDiagnostics logs a warning for each finding of Diagnose. It should be called once
+// all middleware is registered.
+func (c *ApitallyClient) LogDiagnostics() {
+	for _, finding := range c.Diagnose() {
+		c.logger.Warn(finding.Message, "code", finding.Code)
+	}
+}
+
+// FuncName returns the full name of a function, e.g.
+// "github.com/go-chi/chi/v5/middleware.Recoverer".
+func FuncName(fn any) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// CheckMiddlewareOrder checks the middleware of an application, given by the names of
+// their functions from outermost to innermost, for recovery middleware registered after
+// the Apitally middleware, so that panics never reach it, or for missing recovery
+// middleware, so that panics re-raised by the Apitally middleware aren't handled. The
+// recovery middleware of the framework is suggested in the messages. No findings are
+// returned if the Apitally middleware isn't found, e.g. if it's registered for a group
+// of routes only.
+func CheckMiddlewareOrder(names []string, recoveryMiddleware string) []common.Finding {
+	index := slices.IndexFunc(names, func(name string) bool {
+		return strings.HasPrefix(name, apitallyPackagePrefix)
+	})
+	if index < 0 {
+		return nil
+	}
+	for _, name := range names[index+1:] {
+		if recoveryMiddlewarePattern.MatchString(name) {
+			return []common.Finding{{
+				Code: common.FindingRecoveryAfterMiddleware,
+				Message: fmt.Sprintf(
+					"Recovery middleware %s is registered after the Apitally middleware, so panics are recovered before reaching it and aren't captured as server errors. Register it before the Apitally middleware instead.",
+					name,
+				),
+			}}
+		}
+	}
+	if !slices.ContainsFunc(names[:index], recoveryMiddlewarePattern.MatchString) {
+		return []common.Finding{{
+			Code: common.FindingMissingRecovery,
+			Message: fmt.Sprintf(
+				"No recovery middleware is registered before the Apitally middleware, so panics re-raised by it after being captured abort the request without a response or crash the application. Register %s before the Apitally middleware.",
+				recoveryMiddleware,
+			),
+		}}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMiddlewareOrder(t *testing.T) {
+	const (
+		apitally  = "github.com/apitally/apitally-go/chi-v5.Middleware.func2"
+		recoverer = "github.com/go-chi/chi/v5/middleware.Recoverer"
+		logger    = "github.com/go-chi/chi/v5/middleware.Logger"
+	)
+
+	t.Run("Valid", func(t *testing.T) {
+		assert.Empty(t, CheckMiddlewareOrder([]string{logger, recoverer, apitally}, "middleware.Recoverer"))
+	})
+
+	t.Run("RecoveryAfterMiddleware", func(t *testing.T) {
+		findings := CheckMiddlewareOrder([]string{apitally, logger, recoverer}, "middleware.Recoverer")
+		assert.Len(t, findings, 1)
+		assert.Equal(t, common.FindingRecoveryAfterMiddleware, findings[0].Code)
+		assert.Contains(t, findings[0].Message, recoverer)
+	})
+
+	t.Run("MissingRecovery", func(t *testing.T) {
+		findings := CheckMiddlewareOrder([]string{logger, apitally}, "middleware.Recoverer")
+		assert.Len(t, findings, 1)
+		assert.Equal(t, common.FindingMissingRecovery, findings[0].Code)
+		assert.Contains(t, findings[0].Message, "middleware.Recoverer")
+	})
+
+	t.Run("MiddlewareNotFound", func(t *testing.T) {
+		assert.Empty(t, CheckMiddlewareOrder([]string{logger}, "middleware.Recoverer"))
+	})
+}
+
+func TestFuncName(t *testing.T) {
+	assert.Equal(t, "github.com/apitally/apitally-go/internal.TestFuncName", FuncName(TestFuncName))
+	assert.Equal(t, "", FuncName("not a function"))
+	assert.Equal(t, "", FuncName((func())(nil)))
+}
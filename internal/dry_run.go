@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// IsDryRun returns whether logged requests are written to the configured dry run file or
+// callback instead of being sent to the hub.
+func (rl *RequestLogger) IsDryRun() bool {
+	return rl.getConfig().DryRun
+}
+
+// WriteDryRun writes the items of a log file to the configured dry run file and callback,
+// as lines of JSON, exactly as they would have been sent to the hub, and to the
+// configured HAR file.
+func (rl *RequestLogger) WriteDryRun(file *TempGzipFile) error {
+	config := rl.getConfig()
+	if config.DryRunFile == "" && config.DryRunCallback == nil && config.DryRunHARFile == "" {
+		return nil
+	}
+
+	reader, err := file.GetReader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return fmt.Errorf("failed to decompress log file: %w", err)
+	}
+	defer gzipReader.Close()
+
+	var output *os.File
+	if config.DryRunFile != "" {
+		output, err = os.OpenFile(config.DryRunFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open dry run file: %w", err)
+		}
+		defer output.Close()
+	}
+
+	var harEntries []harEntry
+	lineReader := bufio.NewReader(gzipReader)
+	for {
+		line, err := lineReader.ReadBytes('\n')
+		if len(line) > 0 {
+			if output != nil {
+				if _, err := output.Write(line); err != nil {
+					return fmt.Errorf("failed to write dry run file: %w", err)
+				}
+			}
+			if config.DryRunCallback != nil {
+				config.DryRunCallback(bytes.TrimSuffix(line, []byte("\n")))
+			}
+			if config.DryRunHARFile != "" {
+				var item RequestLogItem
+				if err := json.Unmarshal(line, &item); err == nil && item.Request != nil && item.Response != nil {
+					harEntries = append(harEntries, newHAREntry(&item))
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read log file: %w", err)
+		}
+	}
+
+	if len(harEntries) > 0 {
+		return appendHARFile(config.DryRunHARFile, harEntries)
+	}
+	return nil
+}
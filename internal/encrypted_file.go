@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	encryptedChunkSize = 64 * 1024
+	finalChunkFlag     = 1 << 31
+)
+
+// newFileCipher creates an AES-256-GCM cipher with a random key that is only kept
+// in memory, so the file can't be decrypted after the process exits.
+func newFileCipher() (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter encrypts data in chunks of up to 64 KiB. Each chunk is written with a
+// 4-byte header holding its length and a flag marking the final chunk, which protects
+// against truncation. Since the key is unique to the file, the chunk counter is used
+// as nonce.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+func newEncryptWriter(w io.Writer, aead cipher.AEAD) *encryptWriter {
+	return &encryptWriter{w: w, aead: aead, buf: make([]byte, 0, encryptedChunkSize)}
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		m := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+		if len(e.buf) == cap(e.buf) {
+			if err := e.writeChunk(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush encrypts and writes any buffered data as a chunk.
+func (e *encryptWriter) Flush() error {
+	if e.closed || len(e.buf) == 0 {
+		return nil
+	}
+	return e.writeChunk(false)
+}
+
+// Close writes the remaining data as the final chunk.
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.writeChunk(true)
+}
+
+func (e *encryptWriter) writeChunk(final bool) error {
+	header := uint32(len(e.buf) + e.aead.Overhead())
+	if final {
+		header |= finalChunkFlag
+	}
+	var headerBytes [4]byte
+	binary.BigEndian.PutUint32(headerBytes[:], header)
+
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.counter), e.buf, headerBytes[:])
+	e.counter++
+	e.buf = e.buf[:0]
+
+	if _, err := e.w.Write(headerBytes[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader reads chunks written by encryptWriter and returns the decrypted data.
+type decryptReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	final   bool
+}
+
+func newDecryptReader(r io.Reader, aead cipher.AEAD) *decryptReader {
+	return &decryptReader{r: r, aead: aead}
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.final {
+			return 0, io.EOF
+		}
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) readChunk() error {
+	var headerBytes [4]byte
+	if _, err := io.ReadFull(d.r, headerBytes[:]); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	header := binary.BigEndian.Uint32(headerBytes[:])
+	size := header &^ finalChunkFlag
+	if size < uint32(d.aead.Overhead()) || size > encryptedChunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("invalid encrypted chunk size")
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	plain, err := d.aead.Open(sealed[:0], chunkNonce(d.aead, d.counter), sealed, headerBytes[:])
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk: %w", err)
+	}
+	d.counter++
+	d.buf = plain
+	d.final = header&finalChunkFlag != 0
+	return nil
+}
+
+func chunkNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encryptData(t *testing.T, data []byte, flushAt int) ([]byte, *encryptWriter) {
+	t.Helper()
+	aead, err := newFileCipher()
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := newEncryptWriter(&buf, aead)
+	_, err = w.Write(data[:flushAt])
+	assert.NoError(t, err)
+	assert.NoError(t, w.Flush())
+	_, err = w.Write(data[flushAt:])
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes(), w
+}
+
+func TestEncryptedFile(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10_000)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		encrypted, w := encryptData(t, data, 100)
+		assert.False(t, bytes.Contains(encrypted, []byte("0123456789abcdef")))
+		assert.Greater(t, w.counter, uint64(2))
+
+		decrypted, err := io.ReadAll(newDecryptReader(bytes.NewReader(encrypted), w.aead))
+		assert.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		encrypted, w := encryptData(t, []byte{}, 0)
+
+		decrypted, err := io.ReadAll(newDecryptReader(bytes.NewReader(encrypted), w.aead))
+		assert.NoError(t, err)
+		assert.Empty(t, decrypted)
+	})
+
+	t.Run("DetectsTruncation", func(t *testing.T) {
+		encrypted, w := encryptData(t, data, 100)
+
+		// Drop the final chunk
+		truncated := encrypted[:len(encrypted)-(len(data)%encryptedChunkSize)-100-w.aead.Overhead()-4]
+		_, err := io.ReadAll(newDecryptReader(bytes.NewReader(truncated), w.aead))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("DetectsTampering", func(t *testing.T) {
+		encrypted, w := encryptData(t, data, 100)
+
+		encrypted[10] ^= 0xff
+		_, err := io.ReadAll(newDecryptReader(bytes.NewReader(encrypted), w.aead))
+		assert.Error(t, err)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		encrypted, _ := encryptData(t, data, 100)
+		aead, err := newFileCipher()
+		assert.NoError(t, err)
+
+		_, err = io.ReadAll(newDecryptReader(bytes.NewReader(encrypted), aead))
+		assert.Error(t, err)
+	})
+}
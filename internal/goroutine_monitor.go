@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"bytes"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	goroutineLeakWindow       = 5  // number of sync intervals
+	goroutineLeakMinGrowth    = 50 // goroutines within the window
+	maxGoroutineLeakCreators  = 5
+	maxGoroutineStackDumpSize = 8 << 20 // 8 MB
+)
+
+// GoroutineLeakItem is a warning about a possible goroutine leak in the sync payload,
+// along with the functions that created most of the running goroutines.
+type GoroutineLeakItem struct {
+	Count    int                    `json:"count"`
+	Growth   int                    `json:"growth"`
+	Creators []GoroutineCreatorItem `json:"creators"`
+}
+
+type GoroutineCreatorItem struct {
+	Function string `json:"function"`
+	Count    int    `json:"count"`
+}
+
+// GoroutineMonitor samples the number of goroutines every sync interval and detects
+// growth in each of the last intervals, which indicates a leak. Only then are the stacks
+// of all goroutines dumped to determine their creators, as that is expensive with many
+// goroutines.
+type GoroutineMonitor struct {
+	counts []int
+	mutex  sync.Mutex
+}
+
+func NewGoroutineMonitor() *GoroutineMonitor {
+	return &GoroutineMonitor{}
+}
+
+// Check samples the number of goroutines and returns a warning if it grew in each of
+// the last intervals, by at least goroutineLeakMinGrowth in total, or nil otherwise.
+func (m *GoroutineMonitor) Check() *GoroutineLeakItem {
+	if m == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	m.counts = append(m.counts, runtime.NumGoroutine())
+	if len(m.counts) > goroutineLeakWindow {
+		m.counts = m.counts[1:]
+	}
+	counts := m.counts
+	m.mutex.Unlock()
+
+	if len(counts) < goroutineLeakWindow {
+		return nil
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] <= counts[i-1] {
+			return nil
+		}
+	}
+	growth := counts[len(counts)-1] - counts[0]
+	if growth < goroutineLeakMinGrowth {
+		return nil
+	}
+
+	return &GoroutineLeakItem{
+		Count:    counts[len(counts)-1],
+		Growth:   growth,
+		Creators: getGoroutineCreators(),
+	}
+}
+
+// getGoroutineCreators dumps the stacks of all goroutines and returns the functions that
+// created most of them. With very many goroutines, the dump is truncated, so that only
+// a sample of goroutines is counted.
+func getGoroutineCreators() []GoroutineCreatorItem {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxGoroutineStackDumpSize {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	counts := make(map[string]int)
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		if creator, ok := bytes.CutPrefix(line, []byte("created by ")); ok {
+			function, _, _ := strings.Cut(string(creator), " in goroutine ")
+			counts[function]++
+		}
+	}
+
+	creators := make([]GoroutineCreatorItem, 0, len(counts))
+	for function, count := range counts {
+		creators = append(creators, GoroutineCreatorItem{Function: function, Count: count})
+	}
+	sort.Slice(creators, func(i, j int) bool {
+		if creators[i].Count != creators[j].Count {
+			return creators[i].Count > creators[j].Count
+		}
+		return creators[i].Function < creators[j].Function
+	})
+	if len(creators) > maxGoroutineLeakCreators {
+		creators = creators[:maxGoroutineLeakCreators]
+	}
+	return creators
+}
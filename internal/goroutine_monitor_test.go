@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func leakGoroutines(done chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			<-done
+		}()
+	}
+}
+
+func TestGoroutineMonitor(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var monitor *GoroutineMonitor
+		assert.Nil(t, monitor.Check())
+	})
+
+	t.Run("Stable", func(t *testing.T) {
+		monitor := NewGoroutineMonitor()
+		for i := 0; i < goroutineLeakWindow*2; i++ {
+			assert.Nil(t, monitor.Check())
+		}
+	})
+
+	t.Run("Leak", func(t *testing.T) {
+		done := make(chan struct{})
+		defer close(done)
+
+		monitor := NewGoroutineMonitor()
+		for i := 0; i < goroutineLeakWindow-1; i++ {
+			assert.Nil(t, monitor.Check())
+			leakGoroutines(done, 20)
+		}
+
+		leak := monitor.Check()
+		assert.NotNil(t, leak)
+		assert.GreaterOrEqual(t, leak.Growth, 80)
+		assert.GreaterOrEqual(t, leak.Count, leak.Growth)
+		assert.NotEmpty(t, leak.Creators)
+		assert.True(t, strings.HasPrefix(leak.Creators[0].Function, "github.com/apitally/apitally-go/internal.leakGoroutines"))
+		assert.GreaterOrEqual(t, leak.Creators[0].Count, 80)
+	})
+}
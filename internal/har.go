@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apitally/apitally-go/common"
+)
+
+// Types of the HTTP Archive (HAR) 1.2 format, limited to what can be derived from
+// logged requests. See http://www.softwareishard.com/blog/har-12-spec/.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// newHAREntry converts a logged request into a HAR entry. Sizes and timings not
+// captured by the request logger are set to -1 or 0, as allowed by the spec.
+func newHAREntry(item *RequestLogItem) harEntry {
+	request, response := item.Request, item.Response
+	seconds := int64(request.Timestamp)
+	nanoseconds := int64((request.Timestamp - float64(seconds)) * 1e9)
+	responseTimeMs := response.ResponseTime * 1000
+
+	entry := harEntry{
+		StartedDateTime: time.Unix(seconds, nanoseconds).UTC().Format(time.RFC3339Nano),
+		Time:            responseTimeMs,
+		Request: harRequest{
+			Method:      request.Method,
+			URL:         request.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(request.Headers),
+			QueryString: harQueryString(request.URL),
+			HeadersSize: -1,
+			BodySize:    harBodySize(request.Size, request.Body),
+		},
+		Response: harResponse{
+			Status:      response.StatusCode,
+			StatusText:  http.StatusText(response.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(response.Headers),
+			Content: harContent{
+				Size:     harBodySize(response.Size, response.Body),
+				MimeType: harHeaderValue(response.Headers, "Content-Type"),
+			},
+			HeadersSize: -1,
+			BodySize:    harBodySize(response.Size, response.Body),
+		},
+		Timings: harTimings{Wait: responseTimeMs},
+		Comment: item.UUID,
+	}
+
+	if len(request.Body) > 0 && utf8.Valid(request.Body) {
+		entry.Request.PostData = &harPostData{
+			MimeType: harHeaderValue(request.Headers, "Content-Type"),
+			Text:     string(request.Body),
+		}
+	}
+	if len(response.Body) > 0 {
+		if utf8.Valid(response.Body) {
+			entry.Response.Content.Text = string(response.Body)
+		} else {
+			entry.Response.Content.Text = base64.StdEncoding.EncodeToString(response.Body)
+			entry.Response.Content.Encoding = "base64"
+		}
+	}
+	return entry
+}
+
+func harHeaders(headers [][2]string) []harNameValue {
+	result := make([]harNameValue, 0, len(headers))
+	for _, header := range headers {
+		result = append(result, harNameValue{Name: header[0], Value: header[1]})
+	}
+	return result
+}
+
+func harHeaderValue(headers [][2]string, name string) string {
+	for _, header := range headers {
+		if strings.EqualFold(header[0], name) {
+			return header[1]
+		}
+	}
+	return ""
+}
+
+func harQueryString(rawURL string) []harNameValue {
+	result := []harNameValue{}
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return result
+	}
+	params, err := url.ParseQuery(parsedURL.RawQuery)
+	if err != nil {
+		return result
+	}
+	for name, values := range params {
+		for _, value := range values {
+			result = append(result, harNameValue{Name: name, Value: value})
+		}
+	}
+	return result
+}
+
+func harBodySize(size int64, body []byte) int64 {
+	if size > 0 {
+		return size
+	}
+	if len(body) > 0 {
+		return int64(len(body))
+	}
+	return -1
+}
+
+// appendHARFile adds the entries to the HAR file at the given path, creating it if it
+// doesn't exist yet. The file is replaced atomically, as a HAR file is a single JSON
+// document that can't be appended to.
+func appendHARFile(path string, entries []harEntry) error {
+	har := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "apitally-go", Version: common.Version},
+		Entries: []harEntry{},
+	}}
+	content, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(content, &har); err != nil {
+			return fmt.Errorf("failed to parse existing HAR file: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read HAR file: %w", err)
+	}
+	har.Log.Entries = append(har.Log.Entries, entries...)
+
+	content, err = json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode HAR file: %w", err)
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".apitally-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	if _, err := tempFile.Write(content); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
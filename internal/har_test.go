@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHAR(t *testing.T) {
+	t.Run("NewHAREntry", func(t *testing.T) {
+		item := &RequestLogItem{
+			UUID: "a6d8d8b5-5fb6-4a4b-9f0c-9b1a4b5b1b1b",
+			Request: &common.Request{
+				Timestamp: 1700000000.5,
+				Method:    "POST",
+				URL:       "http://localhost/items?page=2",
+				Headers:   [][2]string{{"Content-Type", "application/json"}},
+				Body:      []byte(`{"name":"test"}`),
+			},
+			Response: &common.Response{
+				StatusCode:   201,
+				ResponseTime: 0.25,
+				Headers:      [][2]string{{"Content-Type", "application/octet-stream"}},
+				Size:         1024,
+				Body:         []byte{0xff, 0xfe},
+			},
+		}
+
+		entry := newHAREntry(item)
+		assert.Equal(t, "2023-11-14T22:13:20.5Z", entry.StartedDateTime)
+		assert.Equal(t, 250.0, entry.Time)
+		assert.Equal(t, "POST", entry.Request.Method)
+		assert.Equal(t, []harNameValue{{Name: "page", Value: "2"}}, entry.Request.QueryString)
+		assert.Equal(t, &harPostData{MimeType: "application/json", Text: `{"name":"test"}`}, entry.Request.PostData)
+		assert.Equal(t, int64(15), entry.Request.BodySize)
+		assert.Equal(t, "Created", entry.Response.StatusText)
+		assert.Equal(t, int64(1024), entry.Response.BodySize)
+		assert.Equal(t, "application/octet-stream", entry.Response.Content.MimeType)
+		assert.Equal(t, "//4=", entry.Response.Content.Text)
+		assert.Equal(t, "base64", entry.Response.Content.Encoding)
+		assert.Equal(t, item.UUID, entry.Comment)
+	})
+
+	t.Run("AppendHARFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "requests.har")
+		entry := newHAREntry(&RequestLogItem{
+			Request:  &common.Request{Method: "GET", URL: "http://localhost/items"},
+			Response: &common.Response{StatusCode: 200},
+		})
+
+		assert.NoError(t, appendHARFile(path, []harEntry{entry}))
+		assert.NoError(t, appendHARFile(path, []harEntry{entry, entry}))
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		var har harFile
+		assert.NoError(t, json.Unmarshal(content, &har))
+		assert.Equal(t, "1.2", har.Log.Version)
+		assert.Equal(t, "apitally-go", har.Log.Creator.Name)
+		assert.Len(t, har.Log.Entries, 3)
+		assert.Nil(t, har.Log.Entries[0].Request.PostData)
+		assert.Equal(t, int64(-1), har.Log.Entries[0].Response.BodySize)
+
+		// An existing file that isn't a HAR file is not overwritten
+		assert.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+		assert.Error(t, appendHARFile(path, []harEntry{entry}))
+		content, _ = os.ReadFile(path)
+		assert.Equal(t, "not json", string(content))
+	})
+}
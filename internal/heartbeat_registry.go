@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxHeartbeatJobs       = 100
+	maxHeartbeatNameLength = 64
+)
+
+type HeartbeatsItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+
+	// Time of the last heartbeat as a Unix timestamp, if any was received since startup
+	LastTimestamp float64 `json:"last_timestamp,omitempty"`
+
+	// Expected interval between heartbeats in seconds, if configured
+	ExpectedInterval float64 `json:"expected_interval,omitempty"`
+}
+
+// HeartbeatRegistry keeps track of heartbeats of scheduled jobs (e.g. cron jobs), so
+// that missed runs can be detected. Jobs with an expected interval are always included
+// in the sync payload, even without heartbeats, along with the time of the last one.
+type HeartbeatRegistry struct {
+	counts    map[string]int
+	last      map[string]time.Time
+	intervals map[string]time.Duration
+	mutex     sync.Mutex
+}
+
+func NewHeartbeatRegistry() *HeartbeatRegistry {
+	return &HeartbeatRegistry{
+		counts:    make(map[string]int),
+		last:      make(map[string]time.Time),
+		intervals: make(map[string]time.Duration),
+	}
+}
+
+// SetExpectedIntervals sets the expected intervals between heartbeats, keyed by job name.
+func (hr *HeartbeatRegistry) SetExpectedIntervals(intervals map[string]time.Duration) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	hr.intervals = make(map[string]time.Duration, len(intervals))
+	for name, interval := range intervals {
+		if name = truncateHeartbeatName(name); name != "" && interval > 0 {
+			hr.intervals[name] = interval
+		}
+	}
+}
+
+func (hr *HeartbeatRegistry) AddHeartbeat(name string) {
+	name = truncateHeartbeatName(name)
+	if name == "" {
+		return
+	}
+
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	if _, ok := hr.last[name]; !ok && len(hr.last) >= maxHeartbeatJobs {
+		return
+	}
+	hr.counts[name]++
+	hr.last[name] = time.Now()
+}
+
+func (hr *HeartbeatRegistry) GetAndResetHeartbeats() []HeartbeatsItem {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	data := make([]HeartbeatsItem, 0, len(hr.last))
+	names := make(map[string]bool, len(hr.last)+len(hr.intervals))
+	for name := range hr.last {
+		names[name] = true
+	}
+	for name := range hr.intervals {
+		names[name] = true
+	}
+	for name := range names {
+		item := HeartbeatsItem{
+			Name:             name,
+			Count:            hr.counts[name],
+			ExpectedInterval: hr.intervals[name].Seconds(),
+		}
+		if last, ok := hr.last[name]; ok {
+			item.LastTimestamp = float64(last.UnixMilli()) / 1000.0
+		}
+		data = append(data, item)
+	}
+	hr.counts = make(map[string]int)
+	return data
+}
+
+func truncateHeartbeatName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) > maxHeartbeatNameLength {
+		name = name[:maxHeartbeatNameLength]
+	}
+	// The name may reference memory owned by the caller, e.g. a byte slice
+	return strings.Clone(name)
+}
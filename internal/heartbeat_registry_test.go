@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeatRegistry(t *testing.T) {
+	t.Run("Heartbeats", func(t *testing.T) {
+		hr := NewHeartbeatRegistry()
+		hr.SetExpectedIntervals(map[string]time.Duration{
+			"nightly-report": 24 * time.Hour,
+			"cleanup":        5 * time.Minute,
+		})
+
+		hr.AddHeartbeat("cleanup")
+		hr.AddHeartbeat("cleanup")
+		hr.AddHeartbeat("adhoc")
+		hr.AddHeartbeat(" ")
+
+		heartbeats := hr.GetAndResetHeartbeats()
+		assert.Len(t, heartbeats, 3)
+		items := map[string]HeartbeatsItem{}
+		for _, item := range heartbeats {
+			items[item.Name] = item
+		}
+		assert.Equal(t, 2, items["cleanup"].Count)
+		assert.Equal(t, 300.0, items["cleanup"].ExpectedInterval)
+		assert.InDelta(t, float64(time.Now().Unix()), items["cleanup"].LastTimestamp, 2)
+		assert.Equal(t, 1, items["adhoc"].Count)
+		assert.Equal(t, 0.0, items["adhoc"].ExpectedInterval)
+		assert.Equal(t, 0, items["nightly-report"].Count)
+		assert.Equal(t, 0.0, items["nightly-report"].LastTimestamp)
+
+		// Counts are reset, but the last heartbeat is kept
+		heartbeats = hr.GetAndResetHeartbeats()
+		assert.Len(t, heartbeats, 3)
+		for _, item := range heartbeats {
+			assert.Equal(t, 0, item.Count)
+			if item.Name == "cleanup" {
+				assert.Greater(t, item.LastTimestamp, 0.0)
+			}
+		}
+	})
+
+	t.Run("MaxJobs", func(t *testing.T) {
+		hr := NewHeartbeatRegistry()
+		for i := 0; i < maxHeartbeatJobs+5; i++ {
+			hr.AddHeartbeat("job" + strconv.Itoa(i))
+		}
+		assert.Len(t, hr.GetAndResetHeartbeats(), maxHeartbeatJobs)
+	})
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultHubBaseURL = "https://hub.apitally.io"
+
+// getHubBaseURL returns the base URL of the hub or local agent, given the URL from the
+// config. If the URL has the unix scheme, requests are sent to http://localhost and
+// the path of the Unix domain socket to connect to is returned as well.
+func getHubBaseURL(hubURL string) (baseURL string, socketPath string) {
+	if hubURL == "" {
+		hubURL = os.Getenv("APITALLY_HUB_BASE_URL")
+	}
+	if hubURL == "" {
+		return defaultHubBaseURL, ""
+	}
+	if path, ok := strings.CutPrefix(hubURL, "unix://"); ok {
+		return "http://localhost", path
+	}
+	return strings.TrimSuffix(hubURL, "/"), ""
+}
+
+// dialUnixSocket makes the transport connect to the Unix domain socket at the given
+// path, regardless of the host in request URLs.
+func dialUnixSocket(t *http.Transport, socketPath string) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", socketPath)
+	}
+	t.Proxy = nil
+}
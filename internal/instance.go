@@ -28,14 +28,16 @@ var lockDir = filepath.Join(os.TempDir(), "apitally")
 //   - Multiple workers get different UUIDs (different slots)
 //   - UUIDs persist across restarts and hot reloads
 //
-// Returns a tuple of (uuid, release) where release is a function that releases the lock.
-// The release function must be called when shutting down.
-func GetOrCreateInstanceUUID(clientID, env string) (string, func()) {
+// Returns a tuple of (uuid, slot, release) where slot is the number of the acquired slot,
+// which identifies the worker among the processes on the host, or -1 if no slot could be
+// acquired. Release is a function that releases the lock and must be called when
+// shutting down.
+func GetOrCreateInstanceUUID(clientID, env string) (string, int, func()) {
 	appEnvHash := getAppEnvHash(clientID, env)
 	now := time.Now()
 
 	if err := os.MkdirAll(lockDir, 0755); err != nil {
-		return uuid.New().String(), func() {}
+		return uuid.New().String(), -1, func() {}
 	}
 
 	for slot := 0; slot < maxSlots; slot++ {
@@ -60,7 +62,7 @@ func GetOrCreateInstanceUUID(clientID, env string) (string, func()) {
 		existingUUID := strings.TrimSpace(string(content))
 		tooOld := now.Sub(info.ModTime()).Seconds() > maxLockAgeSeconds
 		if isValidUUID(existingUUID) && !tooOld {
-			return existingUUID, func() { file.Close() }
+			return existingUUID, slot, func() { file.Close() }
 		}
 
 		newUUID := uuid.New().String()
@@ -71,10 +73,10 @@ func GetOrCreateInstanceUUID(clientID, env string) (string, func()) {
 			continue
 		}
 
-		return newUUID, func() { file.Close() }
+		return newUUID, slot, func() { file.Close() }
 	}
 
-	return uuid.New().String(), func() {}
+	return uuid.New().String(), -1, func() {}
 }
 
 func getAppEnvHash(clientID, env string) string {
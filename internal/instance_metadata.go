@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"bufio"
+	"maps"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Files from which instance metadata is detected, variables for testing
+var (
+	cgroupFile                  = "/proc/self/cgroup"
+	mountInfoFile               = "/proc/self/mountinfo"
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// Container IDs of Docker, containerd and CRI-O are 64 hex characters, which appear in
+// cgroup paths (cgroup v1) or the paths of mounted files like /etc/hostname (cgroup v2)
+var containerIDRegexp = regexp.MustCompile(`\b[0-9a-f]{64}\b`)
+
+// Environment variables containing the region, as set by common cloud platforms
+var regionEnvVars = []string{
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"GOOGLE_CLOUD_REGION",
+	"CLOUD_RUN_REGION",
+	"AZURE_REGION",
+	"REGION_NAME", // Azure App Service
+	"FLY_REGION",
+	"RAILWAY_REPLICA_REGION",
+	"RENDER_REGION",
+	"VERCEL_REGION",
+}
+
+// getInstanceMetadata detects metadata distinguishing this instance of the application,
+// with the given metadata taking precedence. Nil is returned if there is none.
+func getInstanceMetadata(overrides map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		metadata["hostname"] = hostname
+	}
+	if containerID := getContainerID(); containerID != "" {
+		metadata["container_id"] = containerID
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		// The pod name and namespace can be exposed using the downward API, otherwise
+		// the hostname is the pod name
+		metadata["k8s_pod"] = firstNonEmpty(os.Getenv("POD_NAME"), os.Getenv("K8S_POD_NAME"), metadata["hostname"])
+		metadata["k8s_namespace"] = firstNonEmpty(os.Getenv("POD_NAMESPACE"), os.Getenv("K8S_NAMESPACE"), readFileTrimmed(serviceAccountNamespaceFile))
+	}
+	for _, envVar := range regionEnvVars {
+		if region := os.Getenv(envVar); region != "" {
+			metadata["region"] = region
+			break
+		}
+	}
+	maps.Copy(metadata, overrides)
+	maps.DeleteFunc(metadata, func(key, value string) bool {
+		return value == ""
+	})
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// getContainerID returns the ID of the container the process runs in, if any.
+func getContainerID() string {
+	for _, path := range []string{cgroupFile, mountInfoFile} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := scanner.Text()
+			// Skip mounts that aren't specific to the container, e.g. on Kubernetes
+			if path == mountInfoFile && !strings.Contains(line, "/containers/") {
+				continue
+			}
+			if containerID := containerIDRegexp.FindString(line); containerID != "" {
+				file.Close()
+				return containerID
+			}
+		}
+		file.Close()
+	}
+	return ""
+}
+
+func readFileTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
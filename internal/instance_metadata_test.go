@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInstanceMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	containerID := "3f4e5d6c7b8a99887766554433221100ffeeddccbbaa99887766554433221100"
+
+	originalCgroupFile, originalMountInfoFile, originalNamespaceFile := cgroupFile, mountInfoFile, serviceAccountNamespaceFile
+	cgroupFile = filepath.Join(tmpDir, "cgroup")
+	mountInfoFile = filepath.Join(tmpDir, "mountinfo")
+	serviceAccountNamespaceFile = filepath.Join(tmpDir, "namespace")
+	defer func() {
+		cgroupFile, mountInfoFile, serviceAccountNamespaceFile = originalCgroupFile, originalMountInfoFile, originalNamespaceFile
+	}()
+
+	for _, envVar := range append([]string{"KUBERNETES_SERVICE_HOST", "POD_NAME", "K8S_POD_NAME", "POD_NAMESPACE", "K8S_NAMESPACE"}, regionEnvVars...) {
+		t.Setenv(envVar, "")
+	}
+
+	t.Run("ContainerIDFromCgroup", func(t *testing.T) {
+		os.WriteFile(cgroupFile, []byte("12:memory:/docker/"+containerID+"\n"), 0644)
+		defer os.Remove(cgroupFile)
+		assert.Equal(t, containerID, getContainerID())
+	})
+
+	t.Run("ContainerIDFromMountInfo", func(t *testing.T) {
+		os.WriteFile(cgroupFile, []byte("0::/\n"), 0644)
+		os.WriteFile(mountInfoFile, []byte(
+			"520 501 0:46 / / rw,relatime - overlay overlay rw\n"+
+				"531 520 254:1 /docker/containers/"+containerID+"/hostname /etc/hostname rw,relatime - ext4 /dev/vda1 rw\n",
+		), 0644)
+		defer os.Remove(cgroupFile)
+		defer os.Remove(mountInfoFile)
+		assert.Equal(t, containerID, getContainerID())
+	})
+
+	t.Run("NoContainer", func(t *testing.T) {
+		assert.Equal(t, "", getContainerID())
+	})
+
+	t.Run("Kubernetes", func(t *testing.T) {
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+		t.Setenv("POD_NAME", "api-5d8f7c9b4-x2v7k")
+		os.WriteFile(serviceAccountNamespaceFile, []byte("production\n"), 0644)
+		defer os.Remove(serviceAccountNamespaceFile)
+
+		metadata := getInstanceMetadata(nil)
+		assert.Equal(t, "api-5d8f7c9b4-x2v7k", metadata["k8s_pod"])
+		assert.Equal(t, "production", metadata["k8s_namespace"])
+	})
+
+	t.Run("RegionAndOverrides", func(t *testing.T) {
+		t.Setenv("FLY_REGION", "fra")
+
+		metadata := getInstanceMetadata(nil)
+		assert.NotEmpty(t, metadata["hostname"])
+		assert.Equal(t, "fra", metadata["region"])
+		assert.NotContains(t, metadata, "k8s_pod")
+
+		metadata = getInstanceMetadata(map[string]string{"region": "eu-central", "hostname": "", "zone": "a"})
+		assert.Equal(t, "eu-central", metadata["region"])
+		assert.Equal(t, "a", metadata["zone"])
+		assert.NotContains(t, metadata, "hostname")
+	})
+}
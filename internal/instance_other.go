@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || windows)
+
+package internal
+
+import "os"
+
+// tryAcquireLock always fails on platforms without file locking (e.g. WebAssembly), so
+// that a new instance UUID is generated rather than shared between processes.
+func tryAcquireLock(file *os.File) bool {
+	return false
+}
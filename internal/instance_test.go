@@ -22,7 +22,7 @@ func TestGetOrCreateInstanceUUID(t *testing.T) {
 		clientID := uuid.New().String()
 		env := "test"
 
-		instanceUUID, cleanup := GetOrCreateInstanceUUID(clientID, env)
+		instanceUUID, _, cleanup := GetOrCreateInstanceUUID(clientID, env)
 		defer cleanup()
 		assert.True(t, isValidUUID(instanceUUID))
 
@@ -38,11 +38,11 @@ func TestGetOrCreateInstanceUUID(t *testing.T) {
 		env := "test"
 
 		// First call creates UUID
-		uuid1, cleanup1 := GetOrCreateInstanceUUID(clientID, env)
+		uuid1, _, cleanup1 := GetOrCreateInstanceUUID(clientID, env)
 		cleanup1()
 
 		// Second call reuses same UUID
-		uuid2, cleanup2 := GetOrCreateInstanceUUID(clientID, env)
+		uuid2, _, cleanup2 := GetOrCreateInstanceUUID(clientID, env)
 		defer cleanup2()
 
 		assert.Equal(t, uuid1, uuid2)
@@ -51,8 +51,8 @@ func TestGetOrCreateInstanceUUID(t *testing.T) {
 	t.Run("DifferentEnvsGetDifferentUUIDs", func(t *testing.T) {
 		clientID := uuid.New().String()
 
-		uuid1, cleanup1 := GetOrCreateInstanceUUID(clientID, "env1")
-		uuid2, cleanup2 := GetOrCreateInstanceUUID(clientID, "env2")
+		uuid1, _, cleanup1 := GetOrCreateInstanceUUID(clientID, "env1")
+		uuid2, _, cleanup2 := GetOrCreateInstanceUUID(clientID, "env2")
 		defer cleanup1()
 		defer cleanup2()
 
@@ -73,9 +73,10 @@ func TestGetOrCreateInstanceUUID(t *testing.T) {
 
 		// Acquire multiple slots by holding locks
 		for i := 0; i < 3; i++ {
-			instanceUUID, cleanup := GetOrCreateInstanceUUID(clientID, env)
+			instanceUUID, slot, cleanup := GetOrCreateInstanceUUID(clientID, env)
 			cleanups = append(cleanups, cleanup)
 			uuids = append(uuids, instanceUUID)
+			assert.Equal(t, i, slot)
 		}
 
 		// All UUIDs should be different
@@ -107,7 +108,7 @@ func TestGetOrCreateInstanceUUID(t *testing.T) {
 		os.Chtimes(lockFile, oldTime, oldTime)
 
 		// Should get a new UUID, not the old one
-		instanceUUID, cleanup := GetOrCreateInstanceUUID(clientID, env)
+		instanceUUID, _, cleanup := GetOrCreateInstanceUUID(clientID, env)
 		defer cleanup()
 
 		assert.NotEqual(t, oldUUID, instanceUUID)
@@ -125,7 +126,7 @@ func TestGetOrCreateInstanceUUID(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Should get a new valid UUID
-		instanceUUID, cleanup := GetOrCreateInstanceUUID(clientID, env)
+		instanceUUID, _, cleanup := GetOrCreateInstanceUUID(clientID, env)
 		defer cleanup()
 		assert.True(t, isValidUUID(instanceUUID))
 
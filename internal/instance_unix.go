@@ -1,4 +1,4 @@
-//go:build !windows
+//go:build darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd
 
 package internal
 
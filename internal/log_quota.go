@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+const logQuotaInterval = time.Minute
+
+// logQuota counts logged requests per consumer in fixed intervals of one minute, so that
+// requests of consumers exceeding their quota can be skipped. Counts are reset at the
+// start of each interval, which also keeps the number of tracked consumers bounded.
+type logQuota struct {
+	counts      map[string]int
+	windowStart time.Time
+	mutex       sync.Mutex
+}
+
+func newLogQuota() *logQuota {
+	return &logQuota{counts: make(map[string]int)}
+}
+
+// Allow counts a request of the given consumer and returns whether it is within the
+// limit for the current interval.
+func (q *logQuota) Allow(consumer string, limit int) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if now := time.Now(); now.Sub(q.windowStart) >= logQuotaInterval {
+		q.counts = make(map[string]int)
+		q.windowStart = now
+	}
+	if q.counts[consumer] >= limit {
+		return false
+	}
+	q.counts[consumer]++
+	return true
+}
+
+// Forget removes the count of the given consumer, e.g. when it is deleted.
+func (q *logQuota) Forget(consumer string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.counts, consumer)
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolMaxSize = 1_000_000_000 // 1 GB (compressed)
+	defaultSpoolTTL     = 24 * time.Hour
+)
+
+// logSpool holds log files that don't fit in the queue of files waiting to be sent to the
+// hub, e.g. during extended hub outages, in a separate directory. Spooled files are older
+// than queued files, so they are sent first. They are deleted once their total size
+// exceeds the limit (oldest first) or they are older than the TTL. The spool is only
+// indexed in memory, as encrypted files can't be read after a restart anyway.
+type logSpool struct {
+	dir     string
+	maxSize int64
+	ttl     time.Duration
+	files   []*TempGzipFile
+	size    int64
+	mutex   sync.Mutex
+}
+
+func newLogSpool(dir string, maxSize int64, ttl time.Duration) (*logSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	if maxSize <= 0 {
+		maxSize = defaultSpoolMaxSize
+	}
+	if ttl <= 0 {
+		ttl = defaultSpoolTTL
+	}
+	return &logSpool{dir: dir, maxSize: maxSize, ttl: ttl}, nil
+}
+
+// Add moves the file into the spool directory. It returns the number of files deleted to
+// stay within the limits, or an error if the file couldn't be moved, in which case
+// the caller remains responsible for the file.
+func (s *logSpool) Add(file *TempGzipFile) (int, error) {
+	if err := file.MoveTo(s.dir); err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file.spooledAt = time.Now()
+	s.files = append(s.files, file)
+	s.size += file.DiskSize()
+	return s.evictLocked(), nil
+}
+
+// Requeue returns a file taken from the spool, e.g. because sending it failed, keeping
+// its position as the oldest file. It returns the number of files deleted to stay within
+// the limits.
+func (s *logSpool) Requeue(file *TempGzipFile) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.files = append([]*TempGzipFile{file}, s.files...)
+	s.size += file.DiskSize()
+	return s.evictLocked()
+}
+
+// Next takes the oldest file from the spool, or returns nil if it's empty.
+func (s *logSpool) Next() *TempGzipFile {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.files) == 0 {
+		return nil
+	}
+	next := s.files[0]
+	s.files = s.files[1:]
+	s.size -= next.DiskSize()
+	return next
+}
+
+// Evict deletes files exceeding the size limit or TTL and returns their number.
+func (s *logSpool) Evict() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.evictLocked()
+}
+
+func (s *logSpool) evictLocked() int {
+	deleted := 0
+	for len(s.files) > 0 && (s.size > s.maxSize || time.Since(s.files[0].spooledAt) > s.ttl) {
+		s.size -= s.files[0].DiskSize()
+		_ = s.files[0].Delete()
+		s.files = s.files[1:]
+		deleted++
+	}
+	return deleted
+}
+
+// Clear deletes all spooled files and returns their number.
+func (s *logSpool) Clear() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deleted := len(s.files)
+	for _, file := range s.files {
+		_ = file.Delete()
+	}
+	s.files = nil
+	s.size = 0
+	return deleted
+}
+
+func (s *logSpool) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.files)
+}
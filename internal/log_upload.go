@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+// logUpload is a batch of log files with the same content type, which are uploaded to
+// the hub in a single request. Concatenated gzip streams are a valid gzip stream, so the
+// compressed content of the files is simply sent one after another.
+type logUpload struct {
+	files []*TempGzipFile
+}
+
+// UUID returns the UUID of the upload, which is the UUID of the file for single files,
+// and derived from the UUIDs of all files otherwise, so retries of the same batch can
+// be deduplicated by the hub.
+func (u logUpload) UUID() string {
+	if len(u.files) == 1 {
+		return u.files[0].uuid
+	}
+	hash := sha256.New()
+	for _, file := range u.files {
+		hash.Write([]byte(file.uuid))
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+func (u logUpload) ContentType() string {
+	return u.files[0].ContentType()
+}
+
+// ContentLength returns the number of bytes returned by GetReader, or -1 if it's unknown
+// because files are encrypted.
+func (u logUpload) ContentLength() int64 {
+	var length int64
+	for _, file := range u.files {
+		fileLength := file.ContentLength()
+		if fileLength < 0 {
+			return -1
+		}
+		length += fileLength
+	}
+	return length
+}
+
+// GetReader returns a reader for the concatenated compressed content of all files.
+func (u logUpload) GetReader() (io.ReadCloser, error) {
+	readers := make([]io.Reader, 0, len(u.files))
+	closers := make(multiCloser, 0, len(u.files))
+	for _, file := range u.files {
+		reader, err := file.GetReader()
+		if err != nil {
+			closers.Close()
+			return nil, err
+		}
+		readers = append(readers, reader)
+		closers = append(closers, reader)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(readers...), closers}, nil
+}
+
+// Close closes all files, so their content length is final.
+func (u logUpload) Close() error {
+	var errs []error
+	for _, file := range u.files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (u logUpload) Delete() {
+	for _, file := range u.files {
+		file.Delete()
+	}
+}
+
+type multiCloser []io.Closer
+
+func (m multiCloser) Close() error {
+	var errs []error
+	for _, closer := range m {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,25 @@
+package internal
+
+const (
+	degradedSampleRate  = 0.1
+	memoryRecoveryRatio = 0.9
+)
+
+// checkMemoryPressure degrades request logging while the resident memory of the process
+// exceeds RequestLoggingConfig.DegradeAboveMemory, and restores it once memory usage has
+// dropped sufficiently below the threshold, so that it doesn't flap around it.
+func (c *ApitallyClient) checkMemoryPressure(resources *ResourceUsage) {
+	if resources == nil {
+		return
+	}
+	threshold := c.RequestLogger.getConfig().DegradeAboveMemory
+
+	degraded := c.RequestLogger.IsDegraded()
+	if !degraded && threshold > 0 && resources.MemoryRss > threshold {
+		c.RequestLogger.SetDegraded(true)
+		c.logger.Warn("Memory usage above threshold, degrading request logging", "memory_rss", resources.MemoryRss, "threshold", threshold)
+	} else if degraded && (threshold <= 0 || float64(resources.MemoryRss) < float64(threshold)*memoryRecoveryRatio) {
+		c.RequestLogger.SetDegraded(false)
+		c.logger.Info("Memory usage back below threshold, restoring request logging", "memory_rss", resources.MemoryRss)
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxMessageKeys       = 100
+	maxMessageNameLength = 64
+)
+
+type MessagesItem struct {
+	System       string      `json:"system"`
+	Queue        string      `json:"queue"`
+	MessageCount int         `json:"message_count"`
+	FailureCount int         `json:"failure_count"`
+	PanicCount   int         `json:"panic_count"`
+	DurationSum  float64     `json:"duration_sum"`
+	Durations    map[int]int `json:"durations"`
+}
+
+type messageKey struct {
+	System string
+	Queue  string
+}
+
+// MessageCounter aggregates messages processed by consumers of message queues (e.g.
+// Kafka topics, NATS subjects or SQS queues) by system and queue. Durations are in
+// milliseconds and binned like those of tasks. Once the maximum number of distinct
+// queues is reached, further messages are counted under the queue "__other__".
+type MessageCounter struct {
+	messages map[messageKey]*MessagesItem
+	mutex    sync.Mutex
+}
+
+func NewMessageCounter() *MessageCounter {
+	return &MessageCounter{
+		messages: make(map[messageKey]*MessagesItem),
+	}
+}
+
+// Track runs fn to process a message and counts it for the given system and queue.
+// Errors returned by fn are counted as failures. Panics are counted and re-raised.
+func (mc *MessageCounter) Track(ctx context.Context, system, queue string, fn func(ctx context.Context) error) (err error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		if r := recover(); r != nil {
+			mc.AddMessage(system, queue, duration, false, true)
+			panic(r)
+		}
+		mc.AddMessage(system, queue, duration, err == nil, false)
+	}()
+	return fn(ctx)
+}
+
+func (mc *MessageCounter) AddMessage(system, queue string, duration time.Duration, succeeded, panicked bool) {
+	key := messageKey{
+		// The names may reference memory owned by the consumer library
+		System: strings.Clone(truncateMessageName(system)),
+		Queue:  strings.Clone(truncateMessageName(queue)),
+	}
+	durationMs := duration.Seconds() * 1000.0
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	item, ok := mc.messages[key]
+	if !ok {
+		if len(mc.messages) >= maxMessageKeys {
+			key.Queue = overflowKeyValue
+			item = mc.messages[key]
+		}
+		if item == nil {
+			item = &MessagesItem{System: key.System, Queue: key.Queue, Durations: make(map[int]int)}
+			mc.messages[key] = item
+		}
+	}
+
+	item.MessageCount++
+	if !succeeded {
+		item.FailureCount++
+	}
+	if panicked {
+		item.PanicCount++
+	}
+	item.DurationSum += durationMs
+	item.Durations[getTaskDurationBin(durationMs)]++
+}
+
+func (mc *MessageCounter) GetAndResetMessages() []MessagesItem {
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	data := make([]MessagesItem, 0, len(mc.messages))
+	for _, item := range mc.messages {
+		data = append(data, *item)
+	}
+	mc.messages = make(map[messageKey]*MessagesItem)
+	return data
+}
+
+func truncateMessageName(name string) string {
+	name = strings.TrimSpace(name)
+	if len(name) > maxMessageNameLength {
+		name = name[:maxMessageNameLength]
+	}
+	return name
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCounter(t *testing.T) {
+	t.Run("Track", func(t *testing.T) {
+		mc := NewMessageCounter()
+		ctx := context.Background()
+
+		for i := 0; i < 3; i++ {
+			mc.Track(ctx, "kafka", "orders", func(ctx context.Context) error { return nil })
+		}
+		err := mc.Track(ctx, "kafka", "orders", func(ctx context.Context) error { return errors.New("invalid message") })
+		assert.EqualError(t, err, "invalid message")
+		assert.Panics(t, func() {
+			mc.Track(ctx, "sqs", "emails", func(ctx context.Context) error { panic("boom") })
+		})
+
+		messages := mc.GetAndResetMessages()
+		assert.Len(t, messages, 2)
+		items := map[string]MessagesItem{}
+		for _, item := range messages {
+			items[item.System+":"+item.Queue] = item
+		}
+		assert.Equal(t, 4, items["kafka:orders"].MessageCount)
+		assert.Equal(t, 1, items["kafka:orders"].FailureCount)
+		assert.Equal(t, 0, items["kafka:orders"].PanicCount)
+		assert.Equal(t, 1, items["sqs:emails"].MessageCount)
+		assert.Equal(t, 1, items["sqs:emails"].PanicCount)
+
+		assert.Empty(t, mc.GetAndResetMessages())
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		mc := NewMessageCounter()
+		for i := 0; i < maxMessageKeys+3; i++ {
+			mc.AddMessage("nats", "subject"+strconv.Itoa(i), time.Millisecond, true, false)
+		}
+
+		messages := mc.GetAndResetMessages()
+		assert.Len(t, messages, maxMessageKeys+1)
+		for _, item := range messages {
+			if item.Queue == overflowKeyValue {
+				assert.Equal(t, "nats", item.System)
+				assert.Equal(t, 3, item.MessageCount)
+			}
+		}
+	})
+}
@@ -0,0 +1,367 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// msgpackSerializer encodes values as MessagePack, which is faster to produce and more
+// compact than JSON. Values are encoded like encoding/json would encode them, using the
+// same field names and omitempty rules, except that byte slices are encoded as binary
+// data instead of base64 strings.
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackSerializer) Marshal(v any) ([]byte, error) {
+	return appendMsgpack(nil, reflect.ValueOf(v))
+}
+
+func (msgpackSerializer) NewEncoder(w io.Writer) encoder {
+	return &msgpackEncoder{w: w}
+}
+
+// msgpackEncoder writes a stream of MessagePack values, which need no delimiter.
+type msgpackEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (e *msgpackEncoder) Encode(v any) error {
+	buf, err := appendMsgpack(e.buf[:0], reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	e.buf = buf
+	_, err = e.w.Write(buf)
+	return err
+}
+
+type msgpackField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+const (
+	msgpackNil      = 0xc0
+	msgpackFalse    = 0xc2
+	msgpackTrue     = 0xc3
+	maxMsgpackDepth = 100
+)
+
+var (
+	msgpackFieldCache sync.Map // map[reflect.Type][]msgpackField
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	errMsgpackTooDeep = errors.New("msgpack: nesting too deep")
+)
+
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	return appendMsgpackValue(b, v, 0)
+}
+
+func appendMsgpackValue(b []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > maxMsgpackDepth {
+		return b, errMsgpackTooDeep
+	}
+	if !v.IsValid() {
+		return append(b, msgpackNil), nil
+	}
+	if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface && v.Type().Implements(jsonMarshalerType) {
+		return appendMsgpackJSONMarshaler(b, v, depth)
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(b, msgpackNil), nil
+		}
+		if v.Kind() == reflect.Pointer && v.Type().Implements(jsonMarshalerType) {
+			return appendMsgpackJSONMarshaler(b, v, depth)
+		}
+		return appendMsgpackValue(b, v.Elem(), depth+1)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, msgpackTrue), nil
+		}
+		return append(b, msgpackFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackUint(b, v.Uint()), nil
+	case reflect.Float32:
+		return appendMsgpackUint32(append(b, 0xca), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return appendMsgpackUint64(append(b, 0xcb), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendMsgpackString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, msgpackNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendMsgpackBytes(b, v.Bytes()), nil
+		}
+		return appendMsgpackArray(b, v, depth)
+	case reflect.Array:
+		return appendMsgpackArray(b, v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, msgpackNil), nil
+		}
+		return appendMsgpackMap(b, v, depth)
+	case reflect.Struct:
+		return appendMsgpackStruct(b, v, depth)
+	default:
+		return b, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+}
+
+// appendMsgpackJSONMarshaler encodes values with custom JSON encoding by decoding their
+// JSON representation and encoding the result.
+func appendMsgpackJSONMarshaler(b []byte, v reflect.Value, depth int) ([]byte, error) {
+	data, err := v.Interface().(json.Marshaler).MarshalJSON()
+	if err != nil {
+		return b, err
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return b, err
+	}
+	return appendMsgpackValue(b, reflect.ValueOf(decoded), depth+1)
+}
+
+func appendMsgpackArray(b []byte, v reflect.Value, depth int) ([]byte, error) {
+	n := v.Len()
+	b = appendMsgpackHeader(b, n, 0x90, 0xdc, 0xdd)
+	var err error
+	for i := 0; i < n; i++ {
+		if b, err = appendMsgpackValue(b, v.Index(i), depth+1); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func appendMsgpackMap(b []byte, v reflect.Value, depth int) ([]byte, error) {
+	b = appendMsgpackHeader(b, v.Len(), 0x80, 0xde, 0xdf)
+	var err error
+	iter := v.MapRange()
+	for iter.Next() {
+		if b, err = appendMsgpackMapKey(b, iter.Key()); err != nil {
+			return b, err
+		}
+		if b, err = appendMsgpackValue(b, iter.Value(), depth+1); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// appendMsgpackMapKey encodes map keys as strings, like encoding/json does.
+func appendMsgpackMapKey(b []byte, key reflect.Value) ([]byte, error) {
+	switch key.Kind() {
+	case reflect.String:
+		return appendMsgpackString(b, key.String()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackString(b, strconv.FormatInt(key.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackString(b, strconv.FormatUint(key.Uint(), 10)), nil
+	default:
+		return b, fmt.Errorf("msgpack: unsupported map key type %s", key.Type())
+	}
+}
+
+func appendMsgpackStruct(b []byte, v reflect.Value, depth int) ([]byte, error) {
+	fields := getMsgpackFields(v.Type())
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, field := range fields {
+		fv, ok := fieldByIndex(v, field.index)
+		if !ok || (field.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		values = append(values, fv)
+		names = append(names, field.name)
+	}
+
+	b = appendMsgpackHeader(b, len(values), 0x80, 0xde, 0xdf)
+	var err error
+	for i, fv := range values {
+		b = appendMsgpackString(b, names[i])
+		if b, err = appendMsgpackValue(b, fv, depth+1); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// fieldByIndex returns the field at the given index path, or false if it is part of an
+// embedded struct pointer that is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func getMsgpackFields(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFieldCache.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+	fields := collectMsgpackFields(t, nil)
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+func collectMsgpackFields(t reflect.Type, index []int) []msgpackField {
+	fields := []msgpackField{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, collectMsgpackFields(ft, fieldIndex)...)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, msgpackField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+		})
+	}
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+func appendMsgpackHeader(b []byte, n int, fix byte, code16 byte, code32 byte) []byte {
+	switch {
+	case n <= 15:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return appendMsgpackUint16(append(b, code16), uint16(n))
+	default:
+		return appendMsgpackUint32(append(b, code32), uint32(n))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = appendMsgpackUint16(append(b, 0xda), uint16(n))
+	default:
+		b = appendMsgpackUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackBytes(b []byte, data []byte) []byte {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = appendMsgpackUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = appendMsgpackUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, data...)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return appendMsgpackUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return appendMsgpackUint32(append(b, 0xd2), uint32(i))
+	default:
+		return appendMsgpackUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func appendMsgpackUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 127:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return appendMsgpackUint16(append(b, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return appendMsgpackUint32(append(b, 0xce), uint32(u))
+	default:
+		return appendMsgpackUint64(append(b, 0xcf), u)
+	}
+}
+
+func appendMsgpackUint16(b []byte, u uint16) []byte {
+	return append(b, byte(u>>8), byte(u))
+}
+
+func appendMsgpackUint32(b []byte, u uint32) []byte {
+	return append(b, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendMsgpackUint64(b []byte, u uint64) []byte {
+	return append(b, byte(u>>56), byte(u>>48), byte(u>>40), byte(u>>32), byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMsgpackSerializer(t *testing.T) {
+	s := msgpackSerializer{}
+
+	t.Run("Scalars", func(t *testing.T) {
+		tests := []struct {
+			value    any
+			expected []byte
+		}{
+			{nil, []byte{0xc0}},
+			{true, []byte{0xc3}},
+			{false, []byte{0xc2}},
+			{0, []byte{0x00}},
+			{127, []byte{0x7f}},
+			{200, []byte{0xcc, 0xc8}},
+			{65535, []byte{0xcd, 0xff, 0xff}},
+			{int64(1 << 32), []byte{0xcf, 0, 0, 0, 1, 0, 0, 0, 0}},
+			{-1, []byte{0xff}},
+			{-33, []byte{0xd0, 0xdf}},
+			{-1000, []byte{0xd1, 0xfc, 0x18}},
+			{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+			{float32(1.5), []byte{0xca, 0x3f, 0xc0, 0, 0}},
+			{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+			{[]byte{1, 2}, []byte{0xc4, 0x02, 1, 2}},
+			{[]string(nil), []byte{0xc0}},
+			{[2]string{"a", "b"}, []byte{0x92, 0xa1, 'a', 0xa1, 'b'}},
+			{map[int]int{10: 1}, []byte{0x81, 0xa2, '1', '0', 0x01}},
+		}
+		for _, tt := range tests {
+			data, err := s.Marshal(tt.value)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, data, "%#v", tt.value)
+		}
+	})
+
+	t.Run("LongValues", func(t *testing.T) {
+		data, err := s.Marshal(strings.Repeat("a", 300))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xda, 0x01, 0x2c}, data[:3])
+		assert.Len(t, data, 303)
+
+		data, err = s.Marshal(make([]int, 20))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xdc, 0x00, 0x14}, data[:3])
+		assert.Len(t, data, 23)
+	})
+
+	t.Run("Structs", func(t *testing.T) {
+		type embedded struct {
+			C int `json:"c"`
+		}
+		type item struct {
+			embedded
+			A       string  `json:"a"`
+			B       *string `json:"b,omitempty"`
+			Skipped string  `json:"-"`
+			Plain   bool
+			private int
+		}
+		data, err := s.Marshal(item{embedded: embedded{C: 1}, A: "x", Skipped: "y", private: 2})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x83, 0xa1, 'c', 0x01, 0xa1, 'a', 0xa1, 'x', 0xa5, 'P', 'l', 'a', 'i', 'n', 0xc2}, data)
+	})
+
+	t.Run("JSONMarshaler", func(t *testing.T) {
+		data, err := s.Marshal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+		assert.NoError(t, err)
+		assert.Equal(t, append([]byte{0xb4}, "2024-01-02T03:04:05Z"...), data)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := s.Marshal(func() {})
+		assert.Error(t, err)
+		_, err = s.Marshal(map[bool]int{true: 1})
+		assert.Error(t, err)
+	})
+
+	t.Run("Encoder", func(t *testing.T) {
+		var buf bytes.Buffer
+		encoder := s.NewEncoder(&buf)
+		assert.NoError(t, encoder.Encode(1))
+		assert.NoError(t, encoder.Encode("a"))
+		assert.Error(t, encoder.Encode(func() {}))
+		assert.Equal(t, []byte{0x01, 0xa1, 'a'}, buf.Bytes())
+	})
+
+	t.Run("RequestLogItemSize", func(t *testing.T) {
+		item := RequestLogItem{SchemaVersion: requestLogItemSchemaVersion, UUID: "00000000-0000-0000-0000-000000000003"}
+		msgpackData, err := s.Marshal(item)
+		assert.NoError(t, err)
+		jsonData, err := jsonSerializer{}.Marshal(item)
+		assert.NoError(t, err)
+		assert.Less(t, len(msgpackData), len(jsonData))
+	})
+}
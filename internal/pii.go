@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	emailPattern      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	ibanPattern       = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]){11,30}\b`)
+	cardNumberPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	phonePattern      = regexp.MustCompile(`\+\d{1,3}[ .-]?(?:\(\d{1,4}\)[ .-]?)?\d{1,4}(?:[ .-]?\d{2,4}){2,4}\b|\(\d{3}\) ?\d{3}-\d{4}\b`)
+)
+
+// maskPII replaces email addresses, IBANs, credit card numbers and phone numbers in
+// the given string with placeholders. IBANs and credit card numbers are only replaced
+// if their checksum is valid, to avoid masking other long numbers.
+func maskPII(s string) string {
+	s = emailPattern.ReplaceAllString(s, "<email>")
+	s = ibanPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if isValidIBAN(match) {
+			return "<iban>"
+		}
+		return match
+	})
+	s = cardNumberPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if isValidLuhn(match) {
+			return "<card_number>"
+		}
+		return match
+	})
+	s = phonePattern.ReplaceAllString(s, "<phone>")
+	return s
+}
+
+func isValidLuhn(s string) bool {
+	sum := 0
+	digits := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+func isValidIBAN(s string) bool {
+	s = strings.ReplaceAll(s, " ", "")
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+
+	// Move the first four characters to the end, convert letters to numbers (A = 10,
+	// B = 11, ...) and compute the remainder of the resulting number divided by 97
+	remainder := 0
+	for _, c := range s[4:] + s[:4] {
+		if c >= 'A' && c <= 'Z' {
+			remainder = (remainder*100 + int(c-'A'+10)) % 97
+		} else {
+			remainder = (remainder*10 + int(c-'0')) % 97
+		}
+	}
+	return remainder == 1
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskPII(t *testing.T) {
+	t.Run("Patterns", func(t *testing.T) {
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"contact john.doe+test@example.co.uk now", "contact <email> now"},
+			{"card 4111 1111 1111 1111", "card <card_number>"},
+			{"card 4111-1111-1111-1111", "card <card_number>"},
+			{"card 4111111111111111", "card <card_number>"},
+			{"iban DE89 3704 0044 0532 0130 00", "iban <iban>"},
+			{"iban GB82WEST12345698765432", "iban <iban>"},
+			{"call +44 20 7946 0958", "call <phone>"},
+			{"call +1 (555) 123-4567", "call <phone>"},
+			{"call (555) 123-4567", "call <phone>"},
+
+			// Not PII
+			{"order 4111111111111112", "order 4111111111111112"},
+			{"iban GB82WEST12345698765433", "iban GB82WEST12345698765433"},
+			{"timestamp 1700000000", "timestamp 1700000000"},
+			{"Mozilla/5.0 Chrome/120.0.6099.109", "Mozilla/5.0 Chrome/120.0.6099.109"},
+		}
+		for _, tt := range tests {
+			assert.Equal(t, tt.expected, maskPII(tt.input), tt.input)
+		}
+	})
+
+	t.Run("RequestLogger", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestHeaders = true
+		config.LogRequestBody = true
+		config.LogResponseBody = true
+		config.MaskPII = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Method: "POST",
+			Path:   "/test",
+			URL:    "http://localhost:8000/test",
+			Headers: [][2]string{
+				{"Content-Type", "application/x-www-form-urlencoded"},
+				{"X-User-Email", "jane@example.com"},
+			},
+			Body: []byte("email=jane%40example.com&name=Jane"),
+		}
+		response := &common.Response{
+			StatusCode: 200,
+			Headers:    [][2]string{{"Content-Type", "application/json"}},
+			Body:       []byte(`{"user":{"email":"jane@example.com","cards":["4111 1111 1111 1111"]},"id":1}`),
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		assert.NoError(t, requestLogger.writeToFile())
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		loggedRequest := items[0]["request"].(map[string]any)
+		loggedResponse := items[0]["response"].(map[string]any)
+
+		assert.Contains(t, loggedRequest["headers"], []any{"X-User-Email", "<email>"})
+
+		requestBody, err := base64.StdEncoding.DecodeString(loggedRequest["body"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, "email=%3Cemail%3E&name=Jane", string(requestBody))
+
+		responseBody, err := base64.StdEncoding.DecodeString(loggedResponse["body"].(string))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"id":1,"user":{"cards":["<card_number>"],"email":"<email>"}}`, string(responseBody))
+	})
+}
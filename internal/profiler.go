@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProfileInterval = time.Minute
+	maxCPUProfileDuration  = 10 * time.Second
+)
+
+// ProfileData holds pprof profiles captured while a slow request was running, encoded
+// as gzipped protocol buffers, as written by runtime/pprof. They can be viewed as flame
+// graphs using go tool pprof.
+type ProfileData struct {
+	Timestamp  float64 `json:"timestamp"`
+	Goroutines []byte  `json:"goroutines,omitempty"`
+	CPU        []byte  `json:"cpu,omitempty"`
+}
+
+// ProfileHandle captures profiles once its request exceeds the configured threshold.
+// All methods can be called on a nil handle, which is returned if profiling is disabled.
+type ProfileHandle struct {
+	rl       *RequestLogger
+	timer    *time.Timer
+	cpu      bool
+	cpuBuf   *bytes.Buffer
+	cpuTimer *time.Timer
+	profile  *ProfileData
+	ended    bool
+	mutex    sync.Mutex
+}
+
+// StartProfile starts watching a request, so that profiles are captured if it is still
+// running after RequestLoggingConfig.ProfileThreshold. Returns nil if disabled.
+func (rl *RequestLogger) StartProfile() *ProfileHandle {
+	config := rl.getConfig()
+	if config.ProfileThreshold <= 0 || !rl.IsEnabled() {
+		return nil
+	}
+	h := &ProfileHandle{rl: rl, cpu: config.ProfileCPU}
+	h.timer = time.AfterFunc(config.ProfileThreshold, h.capture)
+	return h
+}
+
+// allowProfile returns whether a profile may be captured now, according to the
+// configured interval, and if so, records the time of the capture.
+func (rl *RequestLogger) allowProfile() bool {
+	interval := rl.getConfig().ProfileInterval
+	if interval <= 0 {
+		interval = defaultProfileInterval
+	}
+	now := time.Now().UnixNano()
+	last := rl.lastProfileAt.Load()
+	if last != 0 && now-last < interval.Nanoseconds() {
+		return false
+	}
+	return rl.lastProfileAt.CompareAndSwap(last, now)
+}
+
+func (h *ProfileHandle) capture() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.ended || !h.rl.allowProfile() {
+		return
+	}
+
+	h.profile = &ProfileData{Timestamp: float64(time.Now().UnixMilli()) / 1000.0}
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 0); err == nil {
+		h.profile.Goroutines = buf.Bytes()
+	}
+
+	// Only one CPU profile can be recorded at a time, so this fails if another one is
+	// being recorded, e.g. using net/http/pprof
+	if h.cpu {
+		cpuBuf := &bytes.Buffer{}
+		if err := pprof.StartCPUProfile(cpuBuf); err == nil {
+			h.cpuBuf = cpuBuf
+			h.cpuTimer = time.AfterFunc(maxCPUProfileDuration, func() {
+				h.mutex.Lock()
+				defer h.mutex.Unlock()
+				h.stopCPUProfile()
+			})
+		}
+	}
+}
+
+// stopCPUProfile stops recording the CPU profile, if started by this handle. The mutex
+// must be held.
+func (h *ProfileHandle) stopCPUProfile() {
+	if h.cpuBuf == nil || h.profile.CPU != nil {
+		return
+	}
+	pprof.StopCPUProfile()
+	h.profile.CPU = h.cpuBuf.Bytes()
+}
+
+// End stops watching the request and returns the captured profiles, or nil if none
+// were captured.
+func (h *ProfileHandle) End() *ProfileData {
+	if h == nil {
+		return nil
+	}
+	h.timer.Stop()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.ended = true
+	if h.cpuTimer != nil {
+		h.cpuTimer.Stop()
+		h.stopCPUProfile()
+	}
+	return h.profile
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfiler(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		handle := requestLogger.StartProfile()
+		assert.Nil(t, handle)
+		assert.Nil(t, handle.End())
+	})
+
+	t.Run("SlowRequest", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.ProfileThreshold = 10 * time.Millisecond
+		config.ProfileCPU = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		// Fast requests are not profiled
+		handle := requestLogger.StartProfile()
+		assert.Nil(t, handle.End())
+
+		handle = requestLogger.StartProfile()
+		time.Sleep(50 * time.Millisecond)
+		profile := handle.End()
+		assert.NotNil(t, profile)
+		assert.Greater(t, profile.Timestamp, 0.0)
+		assert.Equal(t, []byte{0x1f, 0x8b}, profile.Goroutines[:2])
+		assert.Equal(t, []byte{0x1f, 0x8b}, profile.CPU[:2])
+
+		// Profiles are captured at most once per interval
+		handle = requestLogger.StartProfile()
+		time.Sleep(50 * time.Millisecond)
+		assert.Nil(t, handle.End())
+	})
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Upper bounds of the response time histogram buckets in milliseconds. These line up
+// with the response time bins of the RequestCounter, which are 1ms wide below 100ms
+// and 10ms wide above.
+var prometheusResponseTimeBuckets = []int{10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+type prometheusRequestKey struct {
+	Method     string
+	Path       string
+	StatusCode int
+}
+
+type prometheusEndpointKey struct {
+	Method string
+	Path   string
+}
+
+type prometheusHistogram struct {
+	buckets []int64
+	count   int64
+	sumMs   int64
+}
+
+// PrometheusTextfileWriter accumulates the data of each sync interval and writes it
+// to a file in the Prometheus text exposition format, so it can be picked up by the
+// textfile collector of node_exporter. Counters are cumulative since startup.
+type PrometheusTextfileWriter struct {
+	path             string
+	requestCounts    map[prometheusRequestKey]int64
+	requestSizeSums  map[prometheusRequestKey]int64
+	responseSizeSums map[prometheusRequestKey]int64
+	responseTimes    map[prometheusEndpointKey]*prometheusHistogram
+	validationErrors map[prometheusEndpointKey]int64
+	serverErrors     map[prometheusEndpointKey]int64
+	timeouts         map[prometheusEndpointKey]int64
+	resources        *ResourceUsage
+	lastTimestamp    float64
+	mutex            sync.Mutex
+}
+
+func NewPrometheusTextfileWriter(path string) *PrometheusTextfileWriter {
+	return &PrometheusTextfileWriter{
+		path:             path,
+		requestCounts:    make(map[prometheusRequestKey]int64),
+		requestSizeSums:  make(map[prometheusRequestKey]int64),
+		responseSizeSums: make(map[prometheusRequestKey]int64),
+		responseTimes:    make(map[prometheusEndpointKey]*prometheusHistogram),
+		validationErrors: make(map[prometheusEndpointKey]int64),
+		serverErrors:     make(map[prometheusEndpointKey]int64),
+		timeouts:         make(map[prometheusEndpointKey]int64),
+	}
+}
+
+// Write adds the data of the given sync payload to the counters and atomically
+// replaces the output file.
+func (w *PrometheusTextfileWriter) Write(payload SyncPayload) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.add(payload)
+	return w.writeFile([]byte(w.render()))
+}
+
+func (w *PrometheusTextfileWriter) add(payload SyncPayload) {
+	for _, item := range payload.Requests {
+		key := prometheusRequestKey{Method: item.Method, Path: item.Path, StatusCode: item.StatusCode}
+		w.requestCounts[key] += int64(item.RequestCount)
+		w.requestSizeSums[key] += item.RequestSizeSum
+		w.responseSizeSums[key] += item.ResponseSizeSum
+
+		endpointKey := prometheusEndpointKey{Method: item.Method, Path: item.Path}
+		histogram := w.responseTimes[endpointKey]
+		if histogram == nil {
+			histogram = &prometheusHistogram{buckets: make([]int64, len(prometheusResponseTimeBuckets))}
+			w.responseTimes[endpointKey] = histogram
+		}
+		for binMs, count := range item.ResponseTimes {
+			for i, upperBoundMs := range prometheusResponseTimeBuckets {
+				if binMs+getResponseTimeBinWidth(binMs) <= upperBoundMs {
+					histogram.buckets[i] += int64(count)
+				}
+			}
+			histogram.count += int64(count)
+			histogram.sumMs += int64(binMs * count)
+		}
+	}
+	for _, item := range payload.ValidationErrors {
+		w.validationErrors[prometheusEndpointKey{Method: item.Method, Path: item.Path}] += int64(item.ErrorCount)
+	}
+	for _, item := range payload.ServerErrors {
+		w.serverErrors[prometheusEndpointKey{Method: item.Method, Path: item.Path}] += int64(item.ErrorCount)
+	}
+	for _, item := range payload.Timeouts {
+		w.timeouts[prometheusEndpointKey{Method: item.Method, Path: item.Path}] += int64(item.TimeoutCount)
+	}
+	if payload.Resources != nil {
+		w.resources = payload.Resources
+	}
+	w.lastTimestamp = payload.Timestamp
+}
+
+func (w *PrometheusTextfileWriter) render() string {
+	var b strings.Builder
+
+	requestKeys := make([]prometheusRequestKey, 0, len(w.requestCounts))
+	for key := range w.requestCounts {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.StatusCode < b.StatusCode
+	})
+
+	writePrometheusHeader(&b, "apitally_requests_total", "counter", "Total number of requests.")
+	for _, key := range requestKeys {
+		writePrometheusSample(&b, "apitally_requests_total", requestLabels(key), strconv.FormatInt(w.requestCounts[key], 10))
+	}
+	writePrometheusHeader(&b, "apitally_request_size_bytes_total", "counter", "Total size of request bodies in bytes.")
+	for _, key := range requestKeys {
+		writePrometheusSample(&b, "apitally_request_size_bytes_total", requestLabels(key), strconv.FormatInt(w.requestSizeSums[key], 10))
+	}
+	writePrometheusHeader(&b, "apitally_response_size_bytes_total", "counter", "Total size of response bodies in bytes.")
+	for _, key := range requestKeys {
+		writePrometheusSample(&b, "apitally_response_size_bytes_total", requestLabels(key), strconv.FormatInt(w.responseSizeSums[key], 10))
+	}
+
+	writePrometheusHeader(&b, "apitally_response_time_seconds", "histogram", "Response times in seconds (1ms resolution below 100ms, 10ms above).")
+	for _, key := range sortedEndpointKeys(w.responseTimes) {
+		histogram := w.responseTimes[key]
+		labels := endpointLabels(key)
+		for i, upperBoundMs := range prometheusResponseTimeBuckets {
+			le := strconv.FormatFloat(float64(upperBoundMs)/1000, 'f', -1, 64)
+			writePrometheusSample(&b, "apitally_response_time_seconds_bucket", append(labels, [2]string{"le", le}), strconv.FormatInt(histogram.buckets[i], 10))
+		}
+		writePrometheusSample(&b, "apitally_response_time_seconds_bucket", append(labels, [2]string{"le", "+Inf"}), strconv.FormatInt(histogram.count, 10))
+		writePrometheusSample(&b, "apitally_response_time_seconds_sum", labels, strconv.FormatFloat(float64(histogram.sumMs)/1000, 'f', -1, 64))
+		writePrometheusSample(&b, "apitally_response_time_seconds_count", labels, strconv.FormatInt(histogram.count, 10))
+	}
+
+	writePrometheusHeader(&b, "apitally_validation_errors_total", "counter", "Total number of validation errors.")
+	for _, key := range sortedEndpointKeys(w.validationErrors) {
+		writePrometheusSample(&b, "apitally_validation_errors_total", endpointLabels(key), strconv.FormatInt(w.validationErrors[key], 10))
+	}
+	writePrometheusHeader(&b, "apitally_server_errors_total", "counter", "Total number of unhandled server errors.")
+	for _, key := range sortedEndpointKeys(w.serverErrors) {
+		writePrometheusSample(&b, "apitally_server_errors_total", endpointLabels(key), strconv.FormatInt(w.serverErrors[key], 10))
+	}
+	writePrometheusHeader(&b, "apitally_timeouts_total", "counter", "Total number of requests whose context deadline was exceeded.")
+	for _, key := range sortedEndpointKeys(w.timeouts) {
+		writePrometheusSample(&b, "apitally_timeouts_total", endpointLabels(key), strconv.FormatInt(w.timeouts[key], 10))
+	}
+
+	if w.resources != nil {
+		writePrometheusHeader(&b, "apitally_cpu_percent", "gauge", "CPU usage of the process in percent.")
+		writePrometheusSample(&b, "apitally_cpu_percent", nil, strconv.FormatFloat(w.resources.CpuPercent, 'f', -1, 64))
+		writePrometheusHeader(&b, "apitally_memory_rss_bytes", "gauge", "Resident memory size of the process in bytes.")
+		writePrometheusSample(&b, "apitally_memory_rss_bytes", nil, strconv.FormatInt(w.resources.MemoryRss, 10))
+	}
+
+	writePrometheusHeader(&b, "apitally_last_interval_timestamp_seconds", "gauge", "Unix timestamp of the last aggregation interval.")
+	writePrometheusSample(&b, "apitally_last_interval_timestamp_seconds", nil, strconv.FormatFloat(w.lastTimestamp, 'f', -1, 64))
+
+	return b.String()
+}
+
+// writeFile writes to a temporary file in the same directory first and then renames
+// it, so the textfile collector never reads a partially written file. The temporary
+// file name must not end with .prom, otherwise the collector would pick it up.
+func (w *PrometheusTextfileWriter) writeFile(content []byte) error {
+	dir := filepath.Dir(w.path)
+	tempFile, err := os.CreateTemp(dir, ".apitally-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	if _, err := tempFile.Write(content); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Chmod(tempPath, 0644); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := os.Rename(tempPath, w.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+func sortedEndpointKeys[V any](m map[prometheusEndpointKey]V) []prometheusEndpointKey {
+	keys := make([]prometheusEndpointKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Path != keys[j].Path {
+			return keys[i].Path < keys[j].Path
+		}
+		return keys[i].Method < keys[j].Method
+	})
+	return keys
+}
+
+func requestLabels(key prometheusRequestKey) [][2]string {
+	return [][2]string{
+		{"method", key.Method},
+		{"path", key.Path},
+		{"status_code", strconv.Itoa(key.StatusCode)},
+	}
+}
+
+func endpointLabels(key prometheusEndpointKey) [][2]string {
+	return [][2]string{
+		{"method", key.Method},
+		{"path", key.Path},
+	}
+}
+
+func writePrometheusHeader(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, metricType)
+}
+
+func writePrometheusSample(b *strings.Builder, name string, labels [][2]string, value string) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label[0])
+			b.WriteString(`="`)
+			b.WriteString(escapePrometheusLabelValue(label[1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+var prometheusLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapePrometheusLabelValue(value string) string {
+	return prometheusLabelValueReplacer.Replace(value)
+}
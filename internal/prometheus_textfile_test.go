@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusTextfileWriter(t *testing.T) {
+	t.Run("WriteCumulativeMetrics", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "apitally.prom")
+		writer := NewPrometheusTextfileWriter(path)
+
+		payload := SyncPayload{
+			Timestamp: 1700000000,
+			Requests: []RequestsItem{
+				{
+					Method:          "GET",
+					Path:            "/items",
+					StatusCode:      200,
+					RequestCount:    3,
+					ResponseSizeSum: 300,
+					ResponseTimes:   map[int]int{0: 1, 40: 1, 1200: 1},
+				},
+			},
+			ValidationErrors: []ValidationErrorsItem{
+				{Method: "POST", Path: "/items", ErrorCount: 2},
+			},
+			Timeouts: []TimeoutsItem{
+				{Method: "GET", Path: "/items", TimeoutCount: 1},
+			},
+			Resources: &ResourceUsage{CpuPercent: 12.5, MemoryRss: 1024},
+		}
+		err := writer.Write(payload)
+		assert.NoError(t, err)
+
+		payload.Timestamp = 1700000060
+		payload.Resources = nil
+		err = writer.Write(payload)
+		assert.NoError(t, err)
+
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		text := string(content)
+
+		assert.Contains(t, text, "# TYPE apitally_requests_total counter\n")
+		assert.Contains(t, text, `apitally_requests_total{method="GET",path="/items",status_code="200"} 6`)
+		assert.Contains(t, text, `apitally_response_size_bytes_total{method="GET",path="/items",status_code="200"} 600`)
+		assert.Contains(t, text, `apitally_response_time_seconds_bucket{method="GET",path="/items",le="0.01"} 2`)
+		assert.Contains(t, text, `apitally_response_time_seconds_bucket{method="GET",path="/items",le="0.05"} 4`)
+		assert.Contains(t, text, `apitally_response_time_seconds_bucket{method="GET",path="/items",le="2.5"} 6`)
+		assert.Contains(t, text, `apitally_response_time_seconds_bucket{method="GET",path="/items",le="+Inf"} 6`)
+		assert.Contains(t, text, `apitally_response_time_seconds_count{method="GET",path="/items"} 6`)
+		assert.Contains(t, text, `apitally_validation_errors_total{method="POST",path="/items"} 4`)
+		assert.Contains(t, text, `apitally_timeouts_total{method="GET",path="/items"} 2`)
+		assert.Contains(t, text, "apitally_memory_rss_bytes 1024\n")
+		assert.Contains(t, text, "apitally_last_interval_timestamp_seconds 1700000060\n")
+
+		// No temp files should be left behind
+		entries, err := os.ReadDir(filepath.Dir(path))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("EscapeLabelValues", func(t *testing.T) {
+		assert.Equal(t, `/a\"b\\c\n`, escapePrometheusLabelValue("/a\"b\\c\n"))
+	})
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxRateLimitKeys = 1_000
+
+type RateLimitsItem struct {
+	Consumer string `json:"consumer,omitempty"`
+
+	// Number of responses with rate limit headers or status 429
+	RequestCount int `json:"request_count"`
+
+	// Number of responses with status 429 (Too Many Requests)
+	LimitedCount int `json:"limited_count"`
+
+	// Lowest remaining quota and longest Retry-After in seconds observed, if any
+	MinRemaining  *int64 `json:"min_remaining,omitempty"`
+	MaxRetryAfter *int64 `json:"max_retry_after,omitempty"`
+}
+
+// RateLimitCounter aggregates rate limit response headers per consumer, to see which
+// consumers are hitting rate limits. Once the maximum number of distinct consumers is
+// reached, further consumers are counted under "__other__".
+type RateLimitCounter struct {
+	rateLimits map[string]*RateLimitsItem
+	mutex      sync.Mutex
+}
+
+func NewRateLimitCounter() *RateLimitCounter {
+	return &RateLimitCounter{
+		rateLimits: make(map[string]*RateLimitsItem),
+	}
+}
+
+// AddRateLimit counts the response if it has status 429 or any of the X-RateLimit-Remaining,
+// RateLimit-Remaining and Retry-After headers, which are read using getHeader.
+func (rc *RateLimitCounter) AddRateLimit(consumer string, statusCode int, getHeader func(string) string) {
+	remaining, hasRemaining := parseRateLimitRemaining(getHeader("X-RateLimit-Remaining"))
+	if !hasRemaining {
+		remaining, hasRemaining = parseRateLimitRemaining(getHeader("RateLimit-Remaining"))
+	}
+	retryAfter, hasRetryAfter := parseRetryAfter(getHeader("Retry-After"), time.Now())
+	limited := statusCode == http.StatusTooManyRequests
+	if !limited && !hasRemaining && !hasRetryAfter {
+		return
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	item, ok := rc.rateLimits[consumer]
+	if !ok {
+		if len(rc.rateLimits) >= maxRateLimitKeys {
+			consumer = overflowKeyValue
+			item = rc.rateLimits[consumer]
+		}
+		if item == nil {
+			// The consumer may reference memory owned by the framework, e.g. with Fiber
+			item = &RateLimitsItem{Consumer: strings.Clone(consumer)}
+			rc.rateLimits[item.Consumer] = item
+		}
+	}
+
+	item.RequestCount++
+	if limited {
+		item.LimitedCount++
+	}
+	if hasRemaining && (item.MinRemaining == nil || remaining < *item.MinRemaining) {
+		item.MinRemaining = &remaining
+	}
+	if hasRetryAfter && (item.MaxRetryAfter == nil || retryAfter > *item.MaxRetryAfter) {
+		item.MaxRetryAfter = &retryAfter
+	}
+}
+
+func (rc *RateLimitCounter) GetAndResetRateLimits() []RateLimitsItem {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	data := make([]RateLimitsItem, 0, len(rc.rateLimits))
+	for _, item := range rc.rateLimits {
+		data = append(data, *item)
+	}
+	rc.rateLimits = make(map[string]*RateLimitsItem)
+	return data
+}
+
+// parseRateLimitRemaining parses the value of a remaining quota header. Some servers
+// send a value per policy, separated by commas, in which case the lowest is used.
+func parseRateLimitRemaining(value string) (int64, bool) {
+	remaining, found := int64(math.MaxInt64), false
+	for _, part := range strings.Split(value, ",") {
+		if n, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil && n >= 0 {
+			remaining, found = min(remaining, n), true
+		}
+	}
+	return remaining, found
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number
+// of seconds or an HTTP date, and returns the number of seconds.
+func parseRetryAfter(value string, now time.Time) (int64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		return seconds, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(int64(math.Ceil(date.Sub(now).Seconds())), 0), true
+	}
+	return 0, false
+}
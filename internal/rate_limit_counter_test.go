@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitCounter(t *testing.T) {
+	headers := func(kv ...string) func(string) string {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h.Get
+	}
+
+	t.Run("Aggregation", func(t *testing.T) {
+		rc := NewRateLimitCounter()
+		rc.AddRateLimit("consumer1", 200, headers("X-RateLimit-Remaining", "10"))
+		rc.AddRateLimit("consumer1", 200, headers("X-RateLimit-Remaining", "3"))
+		rc.AddRateLimit("consumer1", 429, headers("X-RateLimit-Remaining", "0", "Retry-After", "30"))
+		rc.AddRateLimit("consumer1", 429, headers("Retry-After", "5"))
+		rc.AddRateLimit("consumer2", 200, headers("RateLimit-Remaining", "50, 7"))
+		rc.AddRateLimit("consumer2", 200, headers())
+		rc.AddRateLimit("", 429, headers())
+
+		rateLimits := rc.GetAndResetRateLimits()
+		assert.Len(t, rateLimits, 3)
+		items := map[string]RateLimitsItem{}
+		for _, item := range rateLimits {
+			items[item.Consumer] = item
+		}
+
+		item1 := items["consumer1"]
+		assert.Equal(t, 4, item1.RequestCount)
+		assert.Equal(t, 2, item1.LimitedCount)
+		assert.Equal(t, int64(0), *item1.MinRemaining)
+		assert.Equal(t, int64(30), *item1.MaxRetryAfter)
+
+		item2 := items["consumer2"]
+		assert.Equal(t, 1, item2.RequestCount)
+		assert.Equal(t, 0, item2.LimitedCount)
+		assert.Equal(t, int64(7), *item2.MinRemaining)
+		assert.Nil(t, item2.MaxRetryAfter)
+
+		item3 := items[""]
+		assert.Equal(t, 1, item3.LimitedCount)
+		assert.Nil(t, item3.MinRemaining)
+
+		assert.Empty(t, rc.GetAndResetRateLimits())
+	})
+
+	t.Run("ParseRetryAfter", func(t *testing.T) {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		tests := []struct {
+			value   string
+			seconds int64
+			ok      bool
+		}{
+			{"120", 120, true},
+			{"Mon, 01 Jan 2024 12:01:30 GMT", 90, true},
+			{"Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+			{"-1", 0, false},
+			{"soon", 0, false},
+			{"", 0, false},
+		}
+		for _, tt := range tests {
+			seconds, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.seconds, seconds, tt.value)
+			assert.Equal(t, tt.ok, ok, tt.value)
+		}
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		rc := NewRateLimitCounter()
+		for i := 0; i < maxRateLimitKeys+5; i++ {
+			rc.AddRateLimit("consumer"+strconv.Itoa(i), 429, headers())
+		}
+
+		rateLimits := rc.GetAndResetRateLimits()
+		assert.Len(t, rateLimits, maxRateLimitKeys+1)
+		for _, item := range rateLimits {
+			if item.Consumer == overflowKeyValue {
+				assert.Equal(t, 5, item.LimitedCount)
+			}
+		}
+	})
+}
@@ -1,112 +1,409 @@
 package internal
 
 import (
+	"hash/maphash"
+	"maps"
 	"math"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const responseTimeFineBinLimit = 100 // ms
+
 type requestKey struct {
-	Consumer   string
-	Method     string
-	Path       string
-	StatusCode int
+	Consumer       string
+	Method         string
+	Path           string
+	StatusCode     int
+	RejectionStage string
+	Tags           string
+	APIVersion     string
+	TrafficClass   string
+}
+
+// RequestInfo describes a handled request to be counted by the RequestCounter.
+// ResponseTime is in milliseconds. Sizes are -1 if unknown.
+type RequestInfo struct {
+	Consumer     string
+	Method       string
+	Path         string
+	StatusCode   int
+	ResponseTime float64
+	RequestSize  int64
+	ResponseSize int64
+
+	// Stage at which the request was rejected before reaching the handler (e.g. "auth"), if any
+	RejectionStage string
+
+	// Tags of the request (e.g. tenant tier or region), normalized using NormalizeTags
+	Tags map[string]string
+
+	// API version of the request, if determined using Config.APIVersionCallback
+	APIVersion string
+
+	// Class of synthetic traffic (e.g. "bot"), determined using ClassifyTraffic
+	TrafficClass string
 }
 
 type RequestsItem struct {
-	Consumer        string      `json:"consumer,omitempty"`
-	Method          string      `json:"method"`
-	Path            string      `json:"path"`
-	StatusCode      int         `json:"status_code"`
-	RequestCount    int         `json:"request_count"`
-	RequestSizeSum  int64       `json:"request_size_sum"`
-	ResponseSizeSum int64       `json:"response_size_sum"`
-	ResponseTimes   map[int]int `json:"response_times"`
-	RequestSizes    map[int]int `json:"request_sizes"`
-	ResponseSizes   map[int]int `json:"response_sizes"`
+	Consumer        string            `json:"consumer,omitempty"`
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	StatusCode      int               `json:"status_code"`
+	RejectionStage  string            `json:"rejection_stage,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	APIVersion      string            `json:"api_version,omitempty"`
+	TrafficClass    string            `json:"traffic_class,omitempty"`
+	RequestCount    int               `json:"request_count"`
+	RequestSizeSum  int64             `json:"request_size_sum"`
+	ResponseSizeSum int64             `json:"response_size_sum"`
+	ResponseTimes   map[int]int       `json:"response_times"`
+	RequestSizes    map[int]int       `json:"request_sizes"`
+	ResponseSizes   map[int]int       `json:"response_sizes"`
+	Apdex           *ApdexItem        `json:"apdex,omitempty"`
+
+	ResponseTimePercentiles *PercentilesItem `json:"response_time_percentiles,omitempty"`
 }
 
-type RequestCounter struct {
+// PercentilesItem summarizes the response time distribution in milliseconds,
+// estimated from the response time bins.
+type PercentilesItem struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// ApdexItem counts requests by how their response time compares to the performance
+// target T of the endpoint: satisfied up to T, tolerating up to 4T, and frustrated
+// above 4T. Server errors are always counted as frustrated.
+type ApdexItem struct {
+	TargetMs   int64 `json:"target_ms"`
+	Satisfied  int   `json:"satisfied"`
+	Tolerating int   `json:"tolerating"`
+	Frustrated int   `json:"frustrated"`
+}
+
+// requestCounterShards is the number of independently locked shards the request counts
+// are spread across, to reduce lock contention under high concurrency.
+const requestCounterShards = 16
+
+// maxRequestCounterKeys caps the number of distinct (consumer, method, path, status code)
+// combinations counted per sync interval, so that high cardinality (e.g. unbounded paths
+// or consumers) can't exhaust memory. Requests beyond the cap are counted under an
+// overflow key with the consumer and path set to overflowKeyValue.
+const (
+	maxRequestCounterKeys = 10_000
+	overflowKeyValue      = "__other__"
+)
+
+type requestCounterShard struct {
 	requestCounts    map[requestKey]int
 	requestSizeSums  map[requestKey]int64
 	responseSizeSums map[requestKey]int64
 	responseTimes    map[requestKey]map[int]int
 	requestSizes     map[requestKey]map[int]int
 	responseSizes    map[requestKey]map[int]int
+	apdex            map[requestKey]*ApdexItem
+	tags             map[requestKey]map[string]string
 	mutex            sync.Mutex
 }
 
+func (s *requestCounterShard) reset() {
+	s.requestCounts = make(map[requestKey]int)
+	s.requestSizeSums = make(map[requestKey]int64)
+	s.responseSizeSums = make(map[requestKey]int64)
+	s.responseTimes = make(map[requestKey]map[int]int)
+	s.requestSizes = make(map[requestKey]map[int]int)
+	s.responseSizes = make(map[requestKey]map[int]int)
+	s.apdex = make(map[requestKey]*ApdexItem)
+	s.tags = make(map[requestKey]map[string]string)
+}
+
+type RequestCounter struct {
+	shards        [requestCounterShards]requestCounterShard
+	seed          maphash.Seed
+	targets       atomic.Pointer[map[string]time.Duration]
+	keyCount      atomic.Int64
+	overflowCount atomic.Int64
+}
+
 func NewRequestCounter() *RequestCounter {
-	return &RequestCounter{
-		requestCounts:    make(map[requestKey]int),
-		requestSizeSums:  make(map[requestKey]int64),
-		responseSizeSums: make(map[requestKey]int64),
-		responseTimes:    make(map[requestKey]map[int]int),
-		requestSizes:     make(map[requestKey]map[int]int),
-		responseSizes:    make(map[requestKey]map[int]int),
+	rc := &RequestCounter{seed: maphash.MakeSeed()}
+	for i := range rc.shards {
+		rc.shards[i].reset()
+	}
+	return rc
+}
+
+// SetPerformanceTargets sets the response time targets per endpoint, keyed by method
+// and path (e.g. "GET /items/{id}") or by path only, for all methods.
+func (rc *RequestCounter) SetPerformanceTargets(targets map[string]time.Duration) {
+	rc.targets.Store(&targets)
+}
+
+func (rc *RequestCounter) getPerformanceTarget(method, path string) time.Duration {
+	targets := rc.targets.Load()
+	if targets == nil {
+		return 0
+	}
+	if target, ok := (*targets)[method+" "+path]; ok {
+		return target
+	}
+	return (*targets)[path]
+}
+
+func (rc *RequestCounter) getShard(key requestKey) *requestCounterShard {
+	var h maphash.Hash
+	h.SetSeed(rc.seed)
+	h.WriteString(key.Consumer)
+	h.WriteString(key.Method)
+	h.WriteString(key.Path)
+	h.WriteString(key.RejectionStage)
+	h.WriteString(key.Tags)
+	h.WriteString(key.APIVersion)
+	h.WriteString(key.TrafficClass)
+	return &rc.shards[(h.Sum64()+uint64(key.StatusCode))%requestCounterShards]
+}
+
+// lockShard locks and returns the shard for the key. If the key is new and the maximum
+// number of keys has been reached, the overflow key is locked and returned instead.
+func (rc *RequestCounter) lockShard(key requestKey) (requestKey, *requestCounterShard) {
+	shard := rc.getShard(key)
+	shard.mutex.Lock()
+	if _, ok := shard.requestCounts[key]; ok {
+		return key, shard
+	}
+	if key.Path == overflowKeyValue {
+		rc.keyCount.Add(1)
+		return key, shard
 	}
+	if rc.reserveKey() {
+		return key, shard
+	}
+	shard.mutex.Unlock()
+
+	rc.overflowCount.Add(1)
+	key.Path = overflowKeyValue
+	if key.Consumer != "" {
+		key.Consumer = overflowKeyValue
+	}
+	key.Tags = ""
+	return rc.lockShard(key)
 }
 
-func (rc *RequestCounter) AddRequest(consumer, method, path string, statusCode int, responseTime float64, requestSize, responseSize int64) {
+// reserveKey counts a new key and returns true, or returns false if the maximum number
+// of keys has been reached. Shards are locked independently, so the count is updated
+// atomically to enforce the maximum across them.
+func (rc *RequestCounter) reserveKey() bool {
+	for {
+		count := rc.keyCount.Load()
+		if count >= maxRequestCounterKeys {
+			return false
+		}
+		if rc.keyCount.CompareAndSwap(count, count+1) {
+			return true
+		}
+	}
+}
+
+// GetAndResetOverflowCount returns the number of requests counted under the overflow
+// key since the last call.
+func (rc *RequestCounter) GetAndResetOverflowCount() int64 {
+	return rc.overflowCount.Swap(0)
+}
+
+func (rc *RequestCounter) AddRequest(request RequestInfo) {
 	// Generate key
 	key := requestKey{
-		Consumer:   consumer,
-		Method:     method,
-		Path:       path,
-		StatusCode: statusCode,
+		Consumer:       request.Consumer,
+		Method:         request.Method,
+		Path:           request.Path,
+		StatusCode:     request.StatusCode,
+		RejectionStage: request.RejectionStage,
+		Tags:           tagsKey(request.Tags),
+		APIVersion:     request.APIVersion,
+		TrafficClass:   request.TrafficClass,
 	}
+	responseTime := request.ResponseTime
+	requestSize := request.RequestSize
+	responseSize := request.ResponseSize
+	target := rc.getPerformanceTarget(request.Method, request.Path)
 
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
+	key, shard := rc.lockShard(key)
+	defer shard.mutex.Unlock()
 
 	// Increment request count
-	rc.requestCounts[key]++
+	shard.requestCounts[key]++
+	if key.Tags != "" && shard.tags[key] == nil {
+		shard.tags[key] = request.Tags
+	}
 
 	// Add response time
-	if rc.responseTimes[key] == nil {
-		rc.responseTimes[key] = make(map[int]int)
+	if shard.responseTimes[key] == nil {
+		shard.responseTimes[key] = make(map[int]int)
 	}
-	responseTimeMsBin := int(math.Floor(responseTime/10) * 10) // Rounded to nearest 10ms
-	rc.responseTimes[key][responseTimeMsBin]++
+	shard.responseTimes[key][getResponseTimeBin(responseTime)]++
 
 	// Add request size
 	if requestSize >= 0 {
-		rc.requestSizeSums[key] += int64(requestSize)
-		if rc.requestSizes[key] == nil {
-			rc.requestSizes[key] = make(map[int]int)
+		shard.requestSizeSums[key] += int64(requestSize)
+		if shard.requestSizes[key] == nil {
+			shard.requestSizes[key] = make(map[int]int)
 		}
 		requestSizeKbBin := int(math.Floor(float64(requestSize) / 1000)) // Rounded down to nearest KB
-		rc.requestSizes[key][requestSizeKbBin]++
+		shard.requestSizes[key][requestSizeKbBin]++
 	}
 
 	// Add response size
 	if responseSize >= 0 {
-		rc.responseSizeSums[key] += int64(responseSize)
-		if rc.responseSizes[key] == nil {
-			rc.responseSizes[key] = make(map[int]int)
+		shard.responseSizeSums[key] += int64(responseSize)
+		if shard.responseSizes[key] == nil {
+			shard.responseSizes[key] = make(map[int]int)
 		}
 		responseSizeKbBin := int(math.Floor(float64(responseSize) / 1000)) // Rounded down to nearest KB
-		rc.responseSizes[key][responseSizeKbBin]++
+		shard.responseSizes[key][responseSizeKbBin]++
+	}
+
+	// Add to Apdex counts if a performance target is set for the endpoint
+	if target > 0 {
+		if shard.apdex[key] == nil {
+			shard.apdex[key] = &ApdexItem{TargetMs: target.Milliseconds()}
+		}
+		targetMs := float64(target) / float64(time.Millisecond)
+		switch {
+		case request.StatusCode >= http.StatusInternalServerError || responseTime > 4*targetMs:
+			shard.apdex[key].Frustrated++
+		case responseTime > targetMs:
+			shard.apdex[key].Tolerating++
+		default:
+			shard.apdex[key].Satisfied++
+		}
+	}
+}
+
+// getResponseTimeBin rounds the response time in milliseconds down to the nearest 1ms
+// below 100ms and to the nearest 10ms above, so that fast endpoints can be told apart
+// while the number of bins stays bounded.
+func getResponseTimeBin(responseTime float64) int {
+	if responseTime < responseTimeFineBinLimit {
+		return int(math.Floor(responseTime))
+	}
+	return int(math.Floor(responseTime/10) * 10)
+}
+
+// getResponseTimeBinWidth returns the width in milliseconds of the given bin.
+func getResponseTimeBinWidth(bin int) int {
+	if bin < responseTimeFineBinLimit {
+		return 1
+	}
+	return 10
+}
+
+// getResponseTimePercentiles estimates the p50, p95 and p99 response times from the
+// given bins, interpolating linearly within the bin a percentile falls into.
+func getResponseTimePercentiles(responseTimes map[int]int) *PercentilesItem {
+	total := 0
+	bins := make([]int, 0, len(responseTimes))
+	for bin, count := range responseTimes {
+		total += count
+		bins = append(bins, bin)
+	}
+	if total == 0 {
+		return nil
+	}
+	slices.Sort(bins)
+
+	percentile := func(q float64) float64 {
+		rank := math.Ceil(q * float64(total))
+		cumulative := 0
+		for _, bin := range bins {
+			count := responseTimes[bin]
+			if float64(cumulative+count) >= rank {
+				fraction := (rank - float64(cumulative)) / float64(count)
+				return float64(bin) + fraction*float64(getResponseTimeBinWidth(bin))
+			}
+			cumulative += count
+		}
+		last := bins[len(bins)-1]
+		return float64(last + getResponseTimeBinWidth(last))
+	}
+
+	return &PercentilesItem{
+		P50: percentile(0.5),
+		P95: percentile(0.95),
+		P99: percentile(0.99),
 	}
 }
 
 func (rc *RequestCounter) GetAndResetRequests() []RequestsItem {
-	rc.mutex.Lock()
-	defer rc.mutex.Unlock()
+	data := make([]RequestsItem, 0)
+	for i := range rc.shards {
+		items := rc.shards[i].getAndReset()
+		rc.keyCount.Add(-int64(len(items)))
+		data = append(data, items...)
+	}
+	return data
+}
 
-	data := make([]RequestsItem, 0, len(rc.requestCounts))
+// GetRequests returns the requests counted since the last reset, without resetting them.
+func (rc *RequestCounter) GetRequests() []RequestsItem {
+	data := make([]RequestsItem, 0)
+	for i := range rc.shards {
+		data = append(data, rc.shards[i].get()...)
+	}
+	return data
+}
+
+func (s *requestCounterShard) get() []RequestsItem {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Maps are cloned, as they continue to be updated
+	data := s.items()
+	for i := range data {
+		data[i].Tags = maps.Clone(data[i].Tags)
+		data[i].ResponseTimes = maps.Clone(data[i].ResponseTimes)
+		data[i].RequestSizes = maps.Clone(data[i].RequestSizes)
+		data[i].ResponseSizes = maps.Clone(data[i].ResponseSizes)
+		if data[i].Apdex != nil {
+			apdex := *data[i].Apdex
+			data[i].Apdex = &apdex
+		}
+	}
+	return data
+}
+
+func (s *requestCounterShard) getAndReset() []RequestsItem {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data := s.items()
 
-	for key, count := range rc.requestCounts {
-		responseTimes := rc.responseTimes[key]
+	// Reset all maps
+	s.reset()
+
+	return data
+}
+
+// items returns the requests counted in the shard. The caller must hold the mutex.
+func (s *requestCounterShard) items() []RequestsItem {
+	data := make([]RequestsItem, 0, len(s.requestCounts))
+
+	for key, count := range s.requestCounts {
+		responseTimes := s.responseTimes[key]
 		if responseTimes == nil {
 			responseTimes = make(map[int]int)
 		}
 
-		requestSizes := rc.requestSizes[key]
+		requestSizes := s.requestSizes[key]
 		if requestSizes == nil {
 			requestSizes = make(map[int]int)
 		}
 
-		responseSizes := rc.responseSizes[key]
+		responseSizes := s.responseSizes[key]
 		if responseSizes == nil {
 			responseSizes = make(map[int]int)
 		}
@@ -116,23 +413,21 @@ func (rc *RequestCounter) GetAndResetRequests() []RequestsItem {
 			Method:          key.Method,
 			Path:            key.Path,
 			StatusCode:      key.StatusCode,
+			RejectionStage:  key.RejectionStage,
+			Tags:            s.tags[key],
+			APIVersion:      key.APIVersion,
+			TrafficClass:    key.TrafficClass,
 			RequestCount:    count,
-			RequestSizeSum:  rc.requestSizeSums[key],
-			ResponseSizeSum: rc.responseSizeSums[key],
+			RequestSizeSum:  s.requestSizeSums[key],
+			ResponseSizeSum: s.responseSizeSums[key],
 			ResponseTimes:   responseTimes,
 			RequestSizes:    requestSizes,
 			ResponseSizes:   responseSizes,
+			Apdex:           s.apdex[key],
+
+			ResponseTimePercentiles: getResponseTimePercentiles(responseTimes),
 		}
 		data = append(data, item)
 	}
-
-	// Reset all maps
-	rc.requestCounts = make(map[requestKey]int)
-	rc.requestSizeSums = make(map[requestKey]int64)
-	rc.responseSizeSums = make(map[requestKey]int64)
-	rc.responseTimes = make(map[requestKey]map[int]int)
-	rc.requestSizes = make(map[requestKey]map[int]int)
-	rc.responseSizes = make(map[requestKey]map[int]int)
-
 	return data
 }
@@ -2,7 +2,9 @@ package internal
 
 import (
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,9 +15,25 @@ func TestRequestCounter(t *testing.T) {
 
 		// Add some requests
 		for i := 0; i < 3; i++ {
-			rc.AddRequest("consumer1", "GET", "/test", 200, 45.7, 0, 3789)
+			rc.AddRequest(RequestInfo{
+				Consumer:     "consumer1",
+				Method:       "GET",
+				Path:         "/test",
+				StatusCode:   200,
+				ResponseTime: 45.7,
+				RequestSize:  0,
+				ResponseSize: 3789,
+			})
 		}
-		rc.AddRequest("consumer2", "POST", "/test", 201, 60.1, 2123, 0)
+		rc.AddRequest(RequestInfo{
+			Consumer:     "consumer2",
+			Method:       "POST",
+			Path:         "/test",
+			StatusCode:   201,
+			ResponseTime: 60.1,
+			RequestSize:  2123,
+			ResponseSize: 0,
+		})
 
 		// Get aggregated requests
 		requests := rc.GetAndResetRequests()
@@ -35,7 +53,7 @@ func TestRequestCounter(t *testing.T) {
 		assert.Equal(t, 3, item1.RequestCount)
 		assert.Equal(t, int64(11367), item1.ResponseSizeSum)
 		assert.Equal(t, 3, item1.ResponseSizes[3])
-		assert.Equal(t, 3, item1.ResponseTimes[40])
+		assert.Equal(t, 3, item1.ResponseTimes[45])
 
 		item2 := requestMap["consumer2:POST:/test:201"]
 		assert.Equal(t, 1, item2.RequestCount)
@@ -47,4 +65,204 @@ func TestRequestCounter(t *testing.T) {
 		requests2 := rc.GetAndResetRequests()
 		assert.Len(t, requests2, 0)
 	})
+
+	t.Run("RejectionStage", func(t *testing.T) {
+		rc := NewRequestCounter()
+
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/test", StatusCode: 401, RequestSize: -1, ResponseSize: -1, RejectionStage: "auth"})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/test", StatusCode: 401, RequestSize: -1, ResponseSize: -1})
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 2)
+		for _, item := range requests {
+			assert.Equal(t, 1, item.RequestCount)
+		}
+		assert.ElementsMatch(t, []string{"auth", ""}, []string{requests[0].RejectionStage, requests[1].RejectionStage})
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		rc := NewRequestCounter()
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, Tags: map[string]string{"tier": "gold", "region": "eu"}})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, Tags: map[string]string{"region": "eu", "tier": "gold"}})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, Tags: map[string]string{"tier": "free"}})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1})
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 3)
+		counts := map[string]int{}
+		for _, item := range requests {
+			counts[item.Tags["tier"]] = item.RequestCount
+		}
+		assert.Equal(t, map[string]int{"gold": 2, "free": 1, "": 1}, counts)
+	})
+
+	t.Run("APIVersion", func(t *testing.T) {
+		rc := NewRequestCounter()
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, APIVersion: "v1"})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, APIVersion: "v2"})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, APIVersion: "v2"})
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 2)
+		counts := map[string]int{}
+		for _, item := range requests {
+			counts[item.APIVersion] = item.RequestCount
+		}
+		assert.Equal(t, map[string]int{"v1": 1, "v2": 2}, counts)
+	})
+
+	t.Run("TrafficClass", func(t *testing.T) {
+		rc := NewRequestCounter()
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, TrafficClass: TrafficClassBot})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, RequestSize: -1, ResponseSize: -1, TrafficClass: TrafficClassBot})
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 2)
+		counts := map[string]int{}
+		for _, item := range requests {
+			counts[item.TrafficClass] = item.RequestCount
+		}
+		assert.Equal(t, map[string]int{"": 1, "bot": 2}, counts)
+	})
+
+	t.Run("ResponseTimeBins", func(t *testing.T) {
+		rc := NewRequestCounter()
+		for _, responseTime := range []float64{0.4, 3.2, 3.9, 99.9, 100, 109.9, 1234.5} {
+			rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, ResponseTime: responseTime, RequestSize: -1, ResponseSize: -1})
+		}
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, map[int]int{0: 1, 3: 2, 99: 1, 100: 2, 1230: 1}, requests[0].ResponseTimes)
+	})
+
+	t.Run("ResponseTimePercentiles", func(t *testing.T) {
+		rc := NewRequestCounter()
+		for i := 0; i < 100; i++ {
+			rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, ResponseTime: float64(i), RequestSize: -1, ResponseSize: -1})
+		}
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, ResponseTime: 2500, RequestSize: -1, ResponseSize: -1})
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		percentiles := requests[0].ResponseTimePercentiles
+		assert.NotNil(t, percentiles)
+		assert.Equal(t, 51.0, percentiles.P50)
+		assert.Equal(t, 96.0, percentiles.P95)
+		assert.Equal(t, 100.0, percentiles.P99)
+
+		assert.Nil(t, getResponseTimePercentiles(map[int]int{}))
+	})
+
+	t.Run("Apdex", func(t *testing.T) {
+		rc := NewRequestCounter()
+		rc.SetPerformanceTargets(map[string]time.Duration{
+			"GET /items": 100 * time.Millisecond,
+			"/items":     500 * time.Millisecond,
+		})
+
+		for _, responseTime := range []float64{50, 100, 150, 400, 401} {
+			rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 200, ResponseTime: responseTime, RequestSize: -1, ResponseSize: -1})
+		}
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items", StatusCode: 500, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+		rc.AddRequest(RequestInfo{Method: "POST", Path: "/items", StatusCode: 201, ResponseTime: 600, RequestSize: -1, ResponseSize: -1})
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/other", StatusCode: 200, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 4)
+		apdex := map[string]*ApdexItem{}
+		for _, item := range requests {
+			apdex[item.Method+" "+item.Path+" "+strconv.Itoa(item.StatusCode)] = item.Apdex
+		}
+		assert.Equal(t, &ApdexItem{TargetMs: 100, Satisfied: 2, Tolerating: 2, Frustrated: 1}, apdex["GET /items 200"])
+		assert.Equal(t, &ApdexItem{TargetMs: 100, Frustrated: 1}, apdex["GET /items 500"])
+		assert.Equal(t, &ApdexItem{TargetMs: 500, Tolerating: 1}, apdex["POST /items 201"])
+		assert.Nil(t, apdex["GET /other 200"])
+	})
+	t.Run("Overflow", func(t *testing.T) {
+		rc := NewRequestCounter()
+		for i := 0; i < maxRequestCounterKeys+10; i++ {
+			rc.AddRequest(RequestInfo{Consumer: "c" + strconv.Itoa(i%2), Method: "GET", Path: "/items/" + strconv.Itoa(i), StatusCode: 200, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+		}
+		rc.AddRequest(RequestInfo{Consumer: "c0", Method: "GET", Path: "/items/0", StatusCode: 200, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+		assert.Equal(t, int64(10), rc.GetAndResetOverflowCount())
+		assert.Equal(t, int64(0), rc.GetAndResetOverflowCount())
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, maxRequestCounterKeys+1)
+		for _, item := range requests {
+			if item.Path == overflowKeyValue {
+				assert.Equal(t, overflowKeyValue, item.Consumer)
+				assert.Equal(t, 10, item.RequestCount)
+			} else if item.Path == "/items/0" {
+				assert.Equal(t, 2, item.RequestCount)
+			}
+		}
+
+		// Key count is reset along with the counts
+		rc.AddRequest(RequestInfo{Method: "GET", Path: "/items/new", StatusCode: 200, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+		requests = rc.GetAndResetRequests()
+		assert.Len(t, requests, 1)
+		assert.Equal(t, "/items/new", requests[0].Path)
+	})
+
+	t.Run("OverflowConcurrent", func(t *testing.T) {
+		rc := NewRequestCounter()
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < maxRequestCounterKeys/8; j++ {
+					rc.AddRequest(RequestInfo{Method: "GET", Path: "/items/" + strconv.Itoa(i) + "/" + strconv.Itoa(j), StatusCode: 200, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// The maximum number of keys is enforced across shards, plus the overflow key
+		assert.LessOrEqual(t, len(rc.GetAndResetRequests()), maxRequestCounterKeys+1)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		rc := NewRequestCounter()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					rc.AddRequest(RequestInfo{Method: "GET", Path: "/items/" + strconv.Itoa(j%50), StatusCode: 200 + i%2, ResponseTime: 10, RequestSize: -1, ResponseSize: -1})
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		requests := rc.GetAndResetRequests()
+		assert.Len(t, requests, 100)
+		total := 0
+		for _, item := range requests {
+			total += item.RequestCount
+		}
+		assert.Equal(t, 8000, total)
+		assert.Empty(t, rc.GetAndResetRequests())
+	})
+}
+
+func BenchmarkRequestCounterAddRequest(b *testing.B) {
+	rc := NewRequestCounter()
+	paths := make([]string, 64)
+	for i := range paths {
+		paths[i] = "/items/" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			rc.AddRequest(RequestInfo{Method: "GET", Path: paths[i%len(paths)], StatusCode: 200, ResponseTime: 12.3, RequestSize: 100, ResponseSize: 2000})
+			i++
+		}
+	})
 }
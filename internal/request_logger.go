@@ -3,11 +3,17 @@ package internal
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"io"
+	"math/rand"
 	"net/url"
 	"regexp"
 	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/apitally/apitally-go/common"
 	"github.com/google/uuid"
@@ -16,22 +22,31 @@ import (
 const (
 	maxFileSize      = 1_000_000 // 1 MB (compressed)
 	maxFiles         = 50
+	maxDiskUsage     = 100_000_000 // 100 MB (compressed)
 	maxPendingWrites = 100
+	maxWriteWorkers  = 8
+	maxHeaders       = 100
+	maxHeaderLength  = 2048
+	writeInterval    = time.Second
+	saturationPeriod = 10 * time.Second
 	masked           = "******"
 )
 
 var (
 	bodyTooLarge        = []byte("<body too large>")
 	bodyMasked          = []byte("<masked>")
-	allowedContentTypes = []string{"application/json", "text/plain"}
-
-	excludePathPatterns = []*regexp.Regexp{
-		regexp.MustCompile(`(?i)/_?healthz?$`),
-		regexp.MustCompile(`(?i)/_?health[_-]?checks?$`),
-		regexp.MustCompile(`(?i)/_?heart[_-]?beats?$`),
-		regexp.MustCompile(`(?i)/ping$`),
-		regexp.MustCompile(`(?i)/ready$`),
-		regexp.MustCompile(`(?i)/live$`),
+	truncatedMarker     = "...<truncated>"
+	allowedContentTypes = []string{
+		"application/json",
+		"application/problem+json",
+		"application/x-ndjson",
+		"application/x-www-form-urlencoded",
+		"application/xml",
+		"text/plain",
+		"text/xml",
+	}
+
+	excludePathPatterns = append(slices.Clone(healthCheckPathPatterns),
 		regexp.MustCompile(`/favicon(?:-[\w-]+)?\.(ico|png|svg)$`),
 		regexp.MustCompile(`/apple-touch-icon(?:-[\w-]+)?\.png$`),
 		regexp.MustCompile(`/robots\.txt$`),
@@ -41,13 +56,7 @@ var (
 		regexp.MustCompile(`/service-worker\.js$`),
 		regexp.MustCompile(`/sw\.js$`),
 		regexp.MustCompile(`/\.well-known/`),
-	}
-	excludeUserAgentPatterns = []*regexp.Regexp{
-		regexp.MustCompile(`(?i)health[-_ ]?check`),
-		regexp.MustCompile(`(?i)microsoft-azure-application-lb`),
-		regexp.MustCompile(`(?i)googlehc`),
-		regexp.MustCompile(`(?i)kube-probe`),
-	}
+	)
 	maskQueryParamPatterns = []*regexp.Regexp{
 		regexp.MustCompile(`(?i)auth`),
 		regexp.MustCompile(`(?i)api-?key`),
@@ -56,6 +65,9 @@ var (
 		regexp.MustCompile(`(?i)password`),
 		regexp.MustCompile(`(?i)pwd`),
 	}
+	maskPathSegmentPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]{2,}$`),
+	}
 	maskHeaderPatterns = []*regexp.Regexp{
 		regexp.MustCompile(`(?i)auth`),
 		regexp.MustCompile(`(?i)api-?key`),
@@ -73,7 +85,11 @@ var (
 		regexp.MustCompile(`(?i)ccv`),
 		regexp.MustCompile(`(?i)ssn`),
 	}
-	jsonContentTypePattern = regexp.MustCompile(`(?i)\bjson\b`)
+	jsonContentTypePattern   = regexp.MustCompile(`(?i)\bjson\b`)
+	ndjsonContentTypePattern = regexp.MustCompile(`(?i)\b(x-)?ndjson\b`)
+	formContentTypePattern   = regexp.MustCompile(`(?i)^application/x-www-form-urlencoded\b`)
+	xmlContentTypePattern    = regexp.MustCompile(`(?i)\bxml\b`)
+	jsonStringFieldPattern   = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"\s*:\s*"((?:[^"\\]|\\.)*)"?`)
 )
 
 type RequestLogger struct {
@@ -81,27 +97,52 @@ type RequestLogger struct {
 	enabled          bool
 	enabledMutex     sync.Mutex
 	suspendUntil     *time.Time
+	remoteDisabled   bool
+	sampleRate       float64
+	maintaining      bool
 	pendingWrites    chan RequestLogItem
+	writeSignal      chan struct{}
 	currentFile      *TempGzipFile
 	currentFileMutex sync.Mutex
 	files            chan *TempGzipFile
+	nextFile         *TempGzipFile // put back by GetFiles, returned before queued files
+	nextFileMutex    sync.Mutex
+	filesDiskSize    atomic.Int64
+	spool            *logSpool
+	droppedItems     atomic.Int64
+	skippedItems     atomic.Int64
+	throttledItems   atomic.Int64
+	consumerQuota    *logQuota
+	lastProfileAt    atomic.Int64
+	saturatedAt      atomic.Int64
+	rotatedFiles     atomic.Int64
+	deletedFiles     atomic.Int64
+	msgpackRejected  atomic.Bool
+	degraded         atomic.Bool
+	logger           common.Logger
 	done             chan struct{}
 }
 
 type RequestLogItem struct {
-	UUID      string           `json:"uuid"`
-	Request   *common.Request  `json:"request"`
-	Response  *common.Response `json:"response"`
-	Exception *ExceptionInfo   `json:"exception,omitempty"`
-	Logs      []LogRecord      `json:"logs,omitempty"`
-	Spans     []SpanData       `json:"spans,omitempty"`
-	TraceID   string           `json:"trace_id,omitempty"`
+	SchemaVersion int              `json:"schema_version"`
+	UUID          string           `json:"uuid"`
+	Request       *common.Request  `json:"request"`
+	Response      *common.Response `json:"response"`
+	Exception     *ExceptionInfo   `json:"exception,omitempty"`
+	Logs          []LogRecord      `json:"logs,omitempty"`
+	Spans         []SpanData       `json:"spans,omitempty"`
+	TraceID       string           `json:"trace_id,omitempty"`
+	CurlCommand   string           `json:"curl_command,omitempty"`
+	Profile       *ProfileData     `json:"profile,omitempty"`
 }
 
 type ExceptionInfo struct {
 	Type       string `json:"type"`
 	Message    string `json:"message"`
 	StackTrace string `json:"stacktrace"`
+
+	// Frames of the stack trace, excluding frames according to the config
+	Frames []StackFrame `json:"frames,omitempty"`
 }
 
 func NewRequestLogger(config *common.RequestLoggingConfig) *RequestLogger {
@@ -111,17 +152,104 @@ func NewRequestLogger(config *common.RequestLoggingConfig) *RequestLogger {
 	logger := &RequestLogger{
 		config:        config,
 		enabled:       config.Enabled,
+		sampleRate:    1,
 		pendingWrites: make(chan RequestLogItem, maxPendingWrites),
+		writeSignal:   make(chan struct{}, 1),
 		files:         make(chan *TempGzipFile, maxFiles),
+		consumerQuota: newLogQuota(),
 	}
 	return logger
 }
 
+// InitSpool sets up the directory for log files that don't fit in the queue, if
+// configured.
+func (rl *RequestLogger) InitSpool() error {
+	config := rl.getConfig()
+	if config.SpoolDir == "" {
+		return nil
+	}
+	spool, err := newLogSpool(config.SpoolDir, config.SpoolMaxSize, config.SpoolTTL)
+	if err != nil {
+		return err
+	}
+	rl.spool = spool
+	return nil
+}
+
+// UpdateConfig replaces the request logging config at runtime. Changes to
+// CaptureLogs, CaptureTraces, WriteInterval and WriteBatchSize only take effect
+// after a restart.
+func (rl *RequestLogger) UpdateConfig(config *common.RequestLoggingConfig) {
+	if config == nil {
+		config = &common.RequestLoggingConfig{}
+	}
+
+	rl.enabledMutex.Lock()
+	defer rl.enabledMutex.Unlock()
+
+	rl.config = config
+	rl.enabled = config.Enabled
+	if rl.enabled && rl.maintaining && rl.done == nil {
+		rl.done = make(chan struct{})
+		go rl.maintain(rl.done)
+	}
+}
+
+func (rl *RequestLogger) getConfig() *common.RequestLoggingConfig {
+	rl.enabledMutex.Lock()
+	defer rl.enabledMutex.Unlock()
+
+	return rl.config
+}
+
 func (rl *RequestLogger) IsEnabled() bool {
 	rl.enabledMutex.Lock()
 	defer rl.enabledMutex.Unlock()
 
-	return rl.enabled
+	return rl.enabled && !rl.remoteDisabled
+}
+
+// SetRemoteEnabled applies a directive from the hub to disable or re-enable request
+// logging. It can't enable request logging if it is disabled in the config.
+func (rl *RequestLogger) SetRemoteEnabled(enabled bool) {
+	rl.enabledMutex.Lock()
+	defer rl.enabledMutex.Unlock()
+
+	rl.remoteDisabled = !enabled
+}
+
+// SetSampleRate sets the fraction of requests to log, between 0 and 1.
+func (rl *RequestLogger) SetSampleRate(rate float64) {
+	rl.enabledMutex.Lock()
+	defer rl.enabledMutex.Unlock()
+
+	rl.sampleRate = max(0, min(1, rate))
+}
+
+func (rl *RequestLogger) isSampled() bool {
+	rl.enabledMutex.Lock()
+	sampleRate := rl.sampleRate
+	rl.enabledMutex.Unlock()
+
+	if rl.IsDegraded() {
+		sampleRate *= degradedSampleRate
+	}
+	return sampleRate >= 1 || rand.Float64() < sampleRate
+}
+
+// SetLogger sets the logger used to report errors writing items in the background.
+func (rl *RequestLogger) SetLogger(logger common.Logger) {
+	rl.logger = logger
+}
+
+// IsDegraded returns whether request logging is degraded due to memory pressure, in
+// which case bodies are not captured and only a fraction of requests is logged.
+func (rl *RequestLogger) IsDegraded() bool {
+	return rl.degraded.Load()
+}
+
+func (rl *RequestLogger) SetDegraded(degraded bool) {
+	rl.degraded.Store(degraded)
 }
 
 func (rl *RequestLogger) IsSuspended() bool {
@@ -132,26 +260,103 @@ func (rl *RequestLogger) IsSuspended() bool {
 }
 
 func (rl *RequestLogger) SuspendFor(duration time.Duration) {
-	rl.enabledMutex.Lock()
-	defer rl.enabledMutex.Unlock()
+	rl.SuspendUntil(time.Now().Add(duration))
+}
 
-	suspendTime := time.Now().Add(duration)
+func (rl *RequestLogger) SuspendUntil(suspendTime time.Time) {
+	rl.enabledMutex.Lock()
 	rl.suspendUntil = &suspendTime
+	rl.enabledMutex.Unlock()
+
+	// Must not hold enabledMutex here, as writing to the current file reads the config
+	// while holding currentFileMutex
 	rl.Clear()
 }
 
 func (rl *RequestLogger) StartMaintenance() {
-	if rl.IsEnabled() {
+	rl.enabledMutex.Lock()
+	defer rl.enabledMutex.Unlock()
+
+	rl.maintaining = true
+	if rl.enabled && rl.done == nil {
 		rl.done = make(chan struct{})
-		go rl.maintain()
+		go rl.maintain(rl.done)
 	}
 }
 
-func (rl *RequestLogger) LogRequest(request *common.Request, response *common.Response, handlerError error, stackTrace string, logs []LogRecord, spans []SpanData, traceID string) {
-	if !rl.IsEnabled() || rl.IsSuspended() || request == nil || response == nil {
+// IsSaturated returns whether the buffer of pending items overflowed recently. It
+// remains saturated until the buffer has been drained and no items were dropped for
+// 10 seconds.
+func (rl *RequestLogger) IsSaturated() bool {
+	saturatedAt := rl.saturatedAt.Load()
+	if saturatedAt == 0 {
+		return false
+	}
+	if len(rl.pendingWrites) == 0 && time.Since(time.Unix(0, saturatedAt)) >= saturationPeriod {
+		rl.saturatedAt.CompareAndSwap(saturatedAt, 0)
+		return false
+	}
+	return true
+}
+
+// ShouldLogRequest returns whether a request should be logged. While the logger is
+// saturated, requests are only counted in metrics, to relieve the logging pipeline.
+func (rl *RequestLogger) ShouldLogRequest() bool {
+	if !rl.IsEnabled() {
+		return false
+	}
+	if rl.IsSaturated() {
+		rl.skippedItems.Add(1)
+		return false
+	}
+	return true
+}
+
+// ShouldLogRequestBody returns whether request bodies with the given content type should be captured.
+func (rl *RequestLogger) ShouldLogRequestBody(contentType string) bool {
+	return rl.IsEnabled() && !rl.IsSaturated() && !rl.IsDegraded() && rl.getConfig().LogRequestBody && rl.IsSupportedContentType(contentType)
+}
+
+// MaxBodySize returns the maximum size of logged request and response bodies.
+func (rl *RequestLogger) MaxBodySize() int {
+	return rl.getConfig().GetMaxBodySize()
+}
+
+// TruncateLargeBodies returns whether the beginning of bodies exceeding the maximum
+// size should be logged.
+func (rl *RequestLogger) TruncateLargeBodies() bool {
+	return rl.getConfig().TruncateLargeBodies
+}
+
+// ShouldLogResponseBody returns whether response bodies should be captured.
+func (rl *RequestLogger) ShouldLogResponseBody() bool {
+	return rl.IsEnabled() && !rl.IsSaturated() && !rl.IsDegraded() && rl.getConfig().LogResponseBody
+}
+
+// LogRequest queues the request for logging. The given request and response are not
+// modified, as masking is applied to copies. Body buffers attached to them using
+// SetBodyBuffer are returned to the pool once the item has been written or discarded,
+// so the bodies must not be used by the caller afterwards.
+func (rl *RequestLogger) LogRequest(request *common.Request, response *common.Response, handlerError error, stackTrace string, logs []LogRecord, spans []SpanData, traceID string, profile *ProfileData) {
+	if request == nil || response == nil {
 		return
 	}
 
+	// Masking replaces fields rather than modifying them in place, so shallow copies
+	// are sufficient to keep changes from leaking back to the caller
+	requestCopy, responseCopy := *request, *response
+	request, response = &requestCopy, &responseCopy
+	if !rl.IsEnabled() || rl.IsSuspended() || !rl.isSampled() {
+		releaseBodies(request, response)
+		return
+	}
+
+	config := rl.getConfig()
+
+	if config.LogClientIP {
+		request.ClientIP = getClientIP(request.RemoteAddr, request.Headers, config.TrustedProxies, config.AnonymizeClientIP)
+	}
+
 	var userAgent string
 	for _, header := range request.Headers {
 		if header[0] == "User-Agent" {
@@ -168,50 +373,151 @@ func (rl *RequestLogger) LogRequest(request *common.Request, response *common.Re
 	}
 
 	if rl.shouldExcludePath(path) || rl.shouldExcludeUserAgent(userAgent) {
+		releaseBodies(request, response)
+		return
+	}
+	if config.ExcludeCallback != nil && config.ExcludeCallback(request, response) {
+		releaseBodies(request, response)
+		return
+	}
+	if config.LogCondition != nil && !config.LogCondition(request, response) {
+		releaseBodies(request, response)
 		return
 	}
-	if rl.config.ExcludeCallback != nil && rl.config.ExcludeCallback(request, response) {
+	if config.MaxRequestsPerConsumer > 0 && request.Consumer != "" && !rl.consumerQuota.Allow(request.Consumer, config.MaxRequestsPerConsumer) {
+		rl.throttledItems.Add(1)
+		releaseBodies(request, response)
 		return
 	}
 
-	if !rl.config.LogRequestBody || !rl.hasSupportedContentType(request.Headers) {
-		request.Body = nil
+	if config.TailCaptureThreshold > 0 && response.StatusCode < 500 && response.ResponseTime <= config.TailCaptureThreshold.Seconds() {
+		logs, spans = nil, nil
+	}
+	if !config.LogRequestBody || !rl.hasSupportedContentType(request.Headers) {
+		request.ReleaseBody()
 	}
-	if !rl.config.LogResponseBody || !rl.hasSupportedContentType(response.Headers) {
-		response.Body = nil
+	if !config.LogResponseBody || !(rl.hasSupportedContentType(response.Headers) || common.IsStreamingContentType(getContentType(response.Headers))) {
+		response.ReleaseBody()
 	}
 
 	item := RequestLogItem{
-		UUID:     uuid.New().String(),
-		Request:  request,
-		Response: response,
-		Logs:     logs,
-		Spans:    spans,
-		TraceID:  traceID,
+		SchemaVersion: requestLogItemSchemaVersion,
+		UUID:          uuid.New().String(),
+		Request:       request,
+		Response:      response,
+		Logs:          logs,
+		Spans:         spans,
+		TraceID:       traceID,
+		Profile:       profile,
 	}
 
-	if handlerError != nil && rl.config.LogPanic {
+	if handlerError != nil && config.LogPanic {
 		errorType := getErrorType(handlerError)
 		errorMessage := handlerError.Error()
 		item.Exception = &ExceptionInfo{
 			Type:       errorType,
 			Message:    truncateExceptionMessage(errorMessage),
 			StackTrace: truncateExceptionStackTrace(stackTrace),
+			Frames:     rl.getStackFrames(stackTrace),
 		}
 	}
 
 	select {
 	case rl.pendingWrites <- item:
+		if batchSize := min(config.WriteBatchSize, maxPendingWrites); batchSize > 0 && len(rl.pendingWrites) >= batchSize {
+			// Signal the maintenance goroutine to write the batch without waiting for the ticker
+			select {
+			case rl.writeSignal <- struct{}{}:
+			default:
+			}
+		}
 	default:
 		// Channel is full, drop the oldest item and try again
+		rl.droppedItems.Add(1)
+		rl.saturatedAt.Store(time.Now().UnixNano())
 		select {
-		case <-rl.pendingWrites:
+		case droppedItem := <-rl.pendingWrites:
+			releaseBodies(droppedItem.Request, droppedItem.Response)
 			rl.pendingWrites <- item
 		default:
+			releaseBodies(request, response)
 		}
 	}
 }
 
+func releaseBodies(request *common.Request, response *common.Response) {
+	request.ReleaseBody()
+	response.ReleaseBody()
+}
+
+// DroppedItems returns the number of request log items dropped because the buffer
+// was full or they couldn't be written since startup.
+func (rl *RequestLogger) DroppedItems() int64 {
+	return rl.droppedItems.Load()
+}
+
+// SkippedItems returns the number of requests not logged because the logger was
+// saturated since startup.
+func (rl *RequestLogger) SkippedItems() int64 {
+	return rl.skippedItems.Load()
+}
+
+// ForgetConsumer removes any state kept for the consumer with the given identifier.
+func (rl *RequestLogger) ForgetConsumer(identifier string) {
+	rl.consumerQuota.Forget(identifier)
+}
+
+// ThrottledItems returns the number of requests not logged because their consumer
+// exceeded MaxRequestsPerConsumer since startup.
+func (rl *RequestLogger) ThrottledItems() int64 {
+	return rl.throttledItems.Load()
+}
+
+// RotatedFiles returns the number of log files rotated since startup.
+func (rl *RequestLogger) RotatedFiles() int64 {
+	return rl.rotatedFiles.Load()
+}
+
+// DeletedFiles returns the number of log files deleted without being sent to the hub
+// since startup, e.g. because too many files were pending.
+func (rl *RequestLogger) DeletedFiles() int64 {
+	return rl.deletedFiles.Load()
+}
+
+// DiskUsage returns the total size of the current and pending log files on disk.
+func (rl *RequestLogger) DiskUsage() int64 {
+	rl.currentFileMutex.Lock()
+	defer rl.currentFileMutex.Unlock()
+
+	usage := rl.filesDiskSize.Load()
+	if rl.currentFile != nil {
+		usage += rl.currentFile.DiskSize()
+	}
+	return usage
+}
+
+func (rl *RequestLogger) getMaxDiskUsage() int64 {
+	if config := rl.getConfig(); config.MaxDiskUsage > 0 {
+		return config.MaxDiskUsage
+	}
+	return maxDiskUsage
+}
+
+// PendingFileCount returns the number of log files waiting to be sent to the hub,
+// including spooled files.
+func (rl *RequestLogger) PendingFileCount() int {
+	count := len(rl.files)
+	rl.nextFileMutex.Lock()
+	if rl.nextFile != nil {
+		count++
+	}
+	rl.nextFileMutex.Unlock()
+	if rl.spool != nil {
+		count += rl.spool.Len()
+	}
+	return count
+}
+
 // For testing purposes
 func (rl *RequestLogger) GetPendingWrites() []RequestLogItem {
 	result := make([]RequestLogItem, 0, len(rl.pendingWrites))
@@ -229,6 +535,7 @@ func (rl *RequestLogger) writeToFile() error {
 	rl.currentFileMutex.Lock()
 	defer rl.currentFileMutex.Unlock()
 
+	written := false
 	for {
 		select {
 		case item, ok := <-rl.pendingWrites:
@@ -237,7 +544,8 @@ func (rl *RequestLogger) writeToFile() error {
 			}
 			if rl.currentFile == nil {
 				var err error
-				rl.currentFile, err = NewTempGzipFile()
+				config := rl.getConfig()
+				rl.currentFile, err = NewTempGzipFile(config.TempDir, config.EncryptTempFiles, rl.getSerializer(config))
 				if err != nil {
 					return err
 				}
@@ -245,27 +553,102 @@ func (rl *RequestLogger) writeToFile() error {
 
 			rl.applyMasking(&item)
 
-			jsonData, err := json.Marshal(item)
+			err := rl.currentFile.WriteItem(item)
+			releaseBodies(item.Request, item.Response)
 			if err != nil {
 				return err
 			}
-			if err := rl.currentFile.WriteLine(jsonData); err != nil {
-				return err
-			}
+			written = true
 		default:
 			// No more items to write
+			if written && rl.getConfig().SyncOnWrite {
+				return rl.currentFile.Sync()
+			}
 			return nil
 		}
 	}
 }
 
+// writeWorker masks, encodes and writes pending items as soon as they are buffered,
+// so that only writing to the file is serialized across workers.
+func (rl *RequestLogger) writeWorker(done chan struct{}) {
+	var buf bytes.Buffer
+	for {
+		select {
+		case item := <-rl.pendingWrites:
+			rl.applyMasking(&item)
+
+			config := rl.getConfig()
+			serializer := rl.getSerializer(config)
+			buf.Reset()
+			err := serializer.NewEncoder(&buf).Encode(item)
+			releaseBodies(item.Request, item.Response)
+			if err == nil {
+				err = rl.writeEncoded(buf.Bytes(), serializer, config)
+			}
+			if err != nil {
+				rl.droppedItems.Add(1)
+				if rl.logger != nil {
+					rl.logger.Debug("Failed to write request log item", "error", err)
+				}
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// writeEncoded writes an encoded item to the current file, rotating it first if it
+// was created with a different serializer.
+func (rl *RequestLogger) writeEncoded(data []byte, serializer serializer, config *common.RequestLoggingConfig) error {
+	rl.currentFileMutex.Lock()
+	defer rl.currentFileMutex.Unlock()
+
+	if rl.currentFile != nil && rl.currentFile.ContentType() != serializer.ContentType() {
+		if err := rl.rotateFileLocked(); err != nil {
+			return err
+		}
+	}
+	if rl.currentFile == nil {
+		var err error
+		rl.currentFile, err = NewTempGzipFile(config.TempDir, config.EncryptTempFiles, serializer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := rl.currentFile.WriteEncoded(data); err != nil {
+		return err
+	}
+	if config.SyncOnWrite && len(rl.pendingWrites) == 0 {
+		return rl.currentFile.Sync()
+	}
+	return nil
+}
+
 func (rl *RequestLogger) applyMasking(item *RequestLogItem) {
+	config := rl.getConfig()
 	request := item.Request
 	response := item.Response
 
+	// Decompress request and response bodies
+	if request.Body != nil && !bytes.Equal(request.Body, bodyTooLarge) {
+		if encoding := getContentEncoding(request.Headers); encoding != "" {
+			request.Body = decompressBody(request.Body, encoding, config.GetMaxBodySize())
+			request.BodyEncoding = encoding
+		}
+	}
+	if response.Body != nil && !bytes.Equal(response.Body, bodyTooLarge) {
+		if encoding := getContentEncoding(response.Headers); encoding != "" {
+			response.Body = decompressBody(response.Body, encoding, config.GetMaxBodySize())
+			response.BodyEncoding = encoding
+		}
+	}
+
 	// Apply user-provided MaskRequestBodyCallback function
-	if rl.config.MaskRequestBodyCallback != nil && request.Body != nil && !bytes.Equal(request.Body, bodyTooLarge) {
-		maskedBody := rl.config.MaskRequestBodyCallback(request)
+	if config.MaskRequestBodyCallback != nil && request.Body != nil && !bytes.Equal(request.Body, bodyTooLarge) && !bytes.Equal(request.Body, bodyCompressed) {
+		maskedBody := config.MaskRequestBodyCallback(request)
 		if maskedBody == nil {
 			request.Body = bodyMasked
 		} else {
@@ -274,8 +657,8 @@ func (rl *RequestLogger) applyMasking(item *RequestLogItem) {
 	}
 
 	// Apply user-provided MaskResponseBodyCallback function
-	if rl.config.MaskResponseBodyCallback != nil && response.Body != nil && !bytes.Equal(response.Body, bodyTooLarge) {
-		maskedBody := rl.config.MaskResponseBodyCallback(request, response)
+	if config.MaskResponseBodyCallback != nil && response.Body != nil && !bytes.Equal(response.Body, bodyTooLarge) && !bytes.Equal(response.Body, bodyCompressed) {
+		maskedBody := config.MaskResponseBodyCallback(request, response)
 		if maskedBody == nil {
 			response.Body = bodyMasked
 		} else {
@@ -284,87 +667,214 @@ func (rl *RequestLogger) applyMasking(item *RequestLogItem) {
 	}
 
 	// Check request and response body sizes
-	if request.Body != nil && len(request.Body) > common.MaxBodySize {
-		request.Body = bodyTooLarge
-	}
-	if response.Body != nil && len(response.Body) > common.MaxBodySize {
-		response.Body = bodyTooLarge
+	request.Body, request.BodyTruncated = limitBodySize(request.Body, request.Size, config)
+	responseSize := response.Size
+	if response.Streaming {
+		// Only the beginning of streaming responses is captured anyway
+		responseSize = -1
 	}
+	response.Body, response.BodyTruncated = limitBodySize(response.Body, responseSize, config)
 
 	// Mask request and response body fields
 	if request.Body != nil && !bytes.Equal(request.Body, bodyTooLarge) && !bytes.Equal(request.Body, bodyMasked) {
-		if rl.hasJSONContentType(request.Headers) {
-			request.Body = rl.maskJSONBody(request.Body)
+		if request.BodyTruncated {
+			request.Body = rl.maskTruncatedBody(request.Body, request.Headers)
+		} else {
+			request.Body = rl.maskBody(request.Body, request.Headers)
 		}
 	}
 	if response.Body != nil && !bytes.Equal(response.Body, bodyTooLarge) && !bytes.Equal(response.Body, bodyMasked) {
-		if rl.hasJSONContentType(response.Headers) {
-			response.Body = rl.maskJSONBody(response.Body)
+		if response.BodyTruncated {
+			response.Body = rl.maskTruncatedBody(response.Body, response.Headers)
+		} else {
+			response.Body = rl.maskBody(response.Body, response.Headers)
 		}
 	}
 
 	// Mask request and response headers
-	if !rl.config.LogRequestHeaders {
+	if !config.LogRequestHeaders {
 		request.Headers = nil
 	} else if request.Headers != nil {
-		request.Headers = rl.maskHeaders(request.Headers)
+		request.Headers = rl.limitHeaders(rl.maskHeaders(includeHeaders(request.Headers, config.IncludeRequestHeaders)))
 	}
-	if !rl.config.LogResponseHeaders {
+	if !config.LogResponseHeaders {
 		response.Headers = nil
 	} else if response.Headers != nil {
-		response.Headers = rl.maskHeaders(response.Headers)
+		response.Headers = rl.limitHeaders(rl.maskHeaders(includeHeaders(response.Headers, config.IncludeResponseHeaders)))
 	}
 
-	// Mask query params
+	// Mask path segments and query params
 	parsedURL, err := url.Parse(request.URL)
 	if err == nil {
-		if rl.config.LogQueryParams {
+		path := parsedURL.Path
+		if config.MaskPathSegmentsCallback != nil {
+			if maskedPath := config.MaskPathSegmentsCallback(request); maskedPath != "" {
+				path = maskedPath
+			}
+		}
+		path = rl.maskPathSegments(path)
+		if path != parsedURL.Path {
+			parsedURL.Path = path
+			parsedURL.RawPath = ""
+		}
+		if config.LogQueryParams {
 			parsedURL.RawQuery = rl.maskQueryParams(parsedURL.RawQuery)
 		} else {
 			parsedURL.RawQuery = ""
 		}
 		request.URL = parsedURL.String()
 	}
+
+	if config.IncludeCurlCommand {
+		item.CurlCommand = ToCurl(item)
+	}
 }
 
+// GetFile takes the next file to send to the hub, starting with spooled files, which
+// are older than queued files.
 func (rl *RequestLogger) GetFile() *TempGzipFile {
+	if rl.spool != nil {
+		if file := rl.spool.Next(); file != nil {
+			return file
+		}
+	}
+	rl.nextFileMutex.Lock()
+	file := rl.nextFile
+	rl.nextFile = nil
+	rl.nextFileMutex.Unlock()
+	if file != nil {
+		rl.filesDiskSize.Add(-file.DiskSize())
+		return file
+	}
 	select {
 	case file := <-rl.files:
+		rl.filesDiskSize.Add(-file.DiskSize())
 		return file
 	default:
 		return nil
 	}
 }
 
+// putBackFile returns a file taken from the queue without sending it, so that it's
+// the next file returned by GetFile, keeping the order of files.
+func (rl *RequestLogger) putBackFile(file *TempGzipFile) {
+	if file.spooledAt.IsZero() {
+		rl.nextFileMutex.Lock()
+		if rl.nextFile == nil {
+			rl.nextFile = file
+			rl.filesDiskSize.Add(file.DiskSize())
+			rl.nextFileMutex.Unlock()
+			return
+		}
+		rl.nextFileMutex.Unlock()
+	}
+	rl.RetryFileLater(file)
+}
+
+// GetFiles returns queued files with the same content type and a combined size of up to
+// maxSize, to upload them in a single request. The first file that doesn't fit is put
+// back at the front of the queue. Returns a single file if it exceeds maxSize on its own,
+// or nil if there are no queued files.
+func (rl *RequestLogger) GetFiles(maxSize int64) []*TempGzipFile {
+	first := rl.GetFile()
+	if first == nil {
+		return nil
+	}
+	files := []*TempGzipFile{first}
+	size := first.DiskSize()
+	for {
+		file := rl.GetFile()
+		if file == nil {
+			break
+		}
+		if file.ContentType() != first.ContentType() || size+file.DiskSize() > maxSize {
+			rl.putBackFile(file)
+			break
+		}
+		files = append(files, file)
+		size += file.DiskSize()
+	}
+	return files
+}
+
+// getSerializer returns the serializer for new log files, which is MessagePack if
+// enabled and not rejected by the hub, and JSON otherwise.
+func (rl *RequestLogger) getSerializer(config *common.RequestLoggingConfig) serializer {
+	if config.MsgpackEncoding && !config.DryRun && !rl.msgpackRejected.Load() {
+		return msgpackSerializer{}
+	}
+	return jsonSerializer{}
+}
+
+// RejectFile deletes a file the hub didn't accept due to its encoding, and falls back
+// to JSON for new files.
+func (rl *RequestLogger) RejectFile(file *TempGzipFile) {
+	if file.ContentType() != (jsonSerializer{}).ContentType() {
+		rl.msgpackRejected.Store(true)
+	}
+	rl.deletedFiles.Add(1)
+	_ = file.Delete()
+}
+
 func (rl *RequestLogger) RetryFileLater(file *TempGzipFile) {
+	if rl.spool != nil && !file.spooledAt.IsZero() {
+		rl.deletedFiles.Add(int64(rl.spool.Requeue(file)))
+		return
+	}
+
 	// Non-blocking send to channel
 	select {
 	case rl.files <- file:
+		rl.filesDiskSize.Add(file.DiskSize())
 	default:
-		// If channel is full, delete the file
-		_ = file.Delete()
+		// If channel is full, spool or delete the file
+		rl.discardFile(file)
+	}
+}
+
+// discardFile moves a file that doesn't fit in the queue to the spool, or deletes it if
+// there is no spool or the file can't be moved.
+func (rl *RequestLogger) discardFile(file *TempGzipFile) {
+	if rl.spool != nil {
+		deleted, err := rl.spool.Add(file)
+		rl.deletedFiles.Add(int64(deleted))
+		if err == nil {
+			return
+		}
 	}
+	rl.deletedFiles.Add(1)
+	_ = file.Delete()
 }
 
 func (rl *RequestLogger) rotateFile() error {
 	rl.currentFileMutex.Lock()
 	defer rl.currentFileMutex.Unlock()
 
+	return rl.rotateFileLocked()
+}
+
+// rotateFileLocked closes the current file and queues it for sending. The caller must
+// hold currentFileMutex.
+func (rl *RequestLogger) rotateFileLocked() error {
 	if rl.currentFile != nil {
 		if err := rl.currentFile.Close(); err != nil {
 			return err
 		}
+		rl.rotatedFiles.Add(1)
 
 		select {
 		case rl.files <- rl.currentFile:
+			rl.filesDiskSize.Add(rl.currentFile.DiskSize())
 		default:
-			// If channel is full, delete the oldest file and try again
+			// If channel is full, spool or delete the oldest file and try again
 			select {
 			case oldFile := <-rl.files:
-				_ = oldFile.Delete()
+				rl.filesDiskSize.Add(-oldFile.DiskSize())
+				rl.discardFile(oldFile)
 				rl.files <- rl.currentFile
+				rl.filesDiskSize.Add(rl.currentFile.DiskSize())
 			default:
-				_ = rl.currentFile.Delete()
+				rl.discardFile(rl.currentFile)
 			}
 		}
 		rl.currentFile = nil
@@ -372,16 +882,34 @@ func (rl *RequestLogger) rotateFile() error {
 	return nil
 }
 
-func (rl *RequestLogger) maintain() {
-	ticker := time.NewTicker(time.Second)
+func (rl *RequestLogger) maintain(done chan struct{}) {
+	config := rl.getConfig()
+	interval := writeInterval
+	if config.WriteInterval > 0 {
+		interval = config.WriteInterval
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	workers := min(config.WriteWorkers, maxWriteWorkers)
+	for i := 0; i < workers; i++ {
+		go rl.writeWorker(done)
+	}
+
 	for {
 		select {
+		case <-rl.writeSignal:
+			// Write a full batch of pending items
+			if workers == 0 {
+				rl.writeToFile()
+			}
+
 		case <-ticker.C:
 			// Write any pending items to the current file
-			if err := rl.writeToFile(); err != nil {
-				continue
+			if workers == 0 {
+				if err := rl.writeToFile(); err != nil {
+					continue
+				}
 			}
 
 			// Check if the current file is too large and rotate if necessary
@@ -395,10 +923,14 @@ func (rl *RequestLogger) maintain() {
 				}
 			}
 
-			// Clean up excess files
-			for len(rl.files) > maxFiles {
+			// Clean up excess files, oldest first
+			for len(rl.files) > maxFiles || (len(rl.files) > 0 && rl.DiskUsage() > rl.getMaxDiskUsage()) {
 				file := <-rl.files
-				_ = file.Delete()
+				rl.filesDiskSize.Add(-file.DiskSize())
+				rl.discardFile(file)
+			}
+			if rl.spool != nil {
+				rl.deletedFiles.Add(int64(rl.spool.Evict()))
 			}
 
 			// Check if the logger is suspended and resume if necessary
@@ -408,7 +940,7 @@ func (rl *RequestLogger) maintain() {
 			}
 			rl.enabledMutex.Unlock()
 
-		case <-rl.done:
+		case <-done:
 			return
 		}
 	}
@@ -417,7 +949,8 @@ func (rl *RequestLogger) maintain() {
 func (rl *RequestLogger) Clear() error {
 	// Drain and delete all pending writes
 	for len(rl.pendingWrites) > 0 {
-		<-rl.pendingWrites
+		item := <-rl.pendingWrites
+		releaseBodies(item.Request, item.Response)
 	}
 
 	// Rotate the file to ensure it's closed
@@ -426,8 +959,24 @@ func (rl *RequestLogger) Clear() error {
 	}
 
 	// Drain and delete all files
+	if rl.spool != nil {
+		rl.deletedFiles.Add(int64(rl.spool.Clear()))
+	}
+	rl.nextFileMutex.Lock()
+	nextFile := rl.nextFile
+	rl.nextFile = nil
+	rl.nextFileMutex.Unlock()
+	if nextFile != nil {
+		rl.filesDiskSize.Add(-nextFile.DiskSize())
+		rl.deletedFiles.Add(1)
+		if err := nextFile.Delete(); err != nil {
+			return err
+		}
+	}
 	for len(rl.files) > 0 {
 		file := <-rl.files
+		rl.filesDiskSize.Add(-file.DiskSize())
+		rl.deletedFiles.Add(1)
 		if err := file.Delete(); err != nil {
 			return err
 		}
@@ -436,22 +985,23 @@ func (rl *RequestLogger) Clear() error {
 }
 
 func (rl *RequestLogger) Close() error {
-	if rl.IsEnabled() {
-		rl.enabledMutex.Lock()
-		defer rl.enabledMutex.Unlock()
-
-		rl.enabled = false
-		if rl.done != nil {
-			close(rl.done)
-		}
+	rl.enabledMutex.Lock()
+	rl.enabled = false
+	rl.maintaining = false
+	if rl.done != nil {
+		close(rl.done)
+		rl.done = nil
 	}
+	rl.enabledMutex.Unlock()
+
 	return rl.Clear()
 }
 
 func (rl *RequestLogger) shouldExcludePath(urlPath string) bool {
-	patterns := slices.Clone(excludePathPatterns)
-	if rl.config.ExcludePaths != nil {
-		patterns = append(patterns, rl.config.ExcludePaths...)
+	config := rl.getConfig()
+	patterns := config.ExcludePaths
+	if !config.DisableDefaultExclusions {
+		patterns = append(slices.Clone(excludePathPatterns), patterns...)
 	}
 	for _, pattern := range patterns {
 		if pattern.MatchString(urlPath) {
@@ -465,7 +1015,12 @@ func (rl *RequestLogger) shouldExcludeUserAgent(userAgent string) bool {
 	if userAgent == "" {
 		return false
 	}
-	for _, pattern := range excludeUserAgentPatterns {
+	config := rl.getConfig()
+	patterns := config.ExcludeUserAgents
+	if !config.DisableDefaultExclusions {
+		patterns = append(slices.Clone(healthCheckUserAgentPatterns), patterns...)
+	}
+	for _, pattern := range patterns {
 		if pattern.MatchString(userAgent) {
 			return true
 		}
@@ -475,8 +1030,8 @@ func (rl *RequestLogger) shouldExcludeUserAgent(userAgent string) bool {
 
 func (rl *RequestLogger) shouldMaskQueryParam(name string) bool {
 	patterns := slices.Clone(maskQueryParamPatterns)
-	if rl.config.MaskQueryParams != nil {
-		patterns = append(patterns, rl.config.MaskQueryParams...)
+	if config := rl.getConfig(); config.MaskQueryParams != nil {
+		patterns = append(patterns, config.MaskQueryParams...)
 	}
 	for _, pattern := range patterns {
 		if pattern.MatchString(name) {
@@ -486,10 +1041,23 @@ func (rl *RequestLogger) shouldMaskQueryParam(name string) bool {
 	return false
 }
 
+func (rl *RequestLogger) shouldMaskPathSegment(segment string) bool {
+	patterns := slices.Clone(maskPathSegmentPatterns)
+	if config := rl.getConfig(); config.MaskPathSegments != nil {
+		patterns = append(patterns, config.MaskPathSegments...)
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(segment) {
+			return true
+		}
+	}
+	return false
+}
+
 func (rl *RequestLogger) shouldMaskHeader(name string) bool {
 	patterns := slices.Clone(maskHeaderPatterns)
-	if rl.config.MaskHeaders != nil {
-		patterns = append(patterns, rl.config.MaskHeaders...)
+	if config := rl.getConfig(); config.MaskHeaders != nil {
+		patterns = append(patterns, config.MaskHeaders...)
 	}
 	for _, pattern := range patterns {
 		if pattern.MatchString(name) {
@@ -501,8 +1069,8 @@ func (rl *RequestLogger) shouldMaskHeader(name string) bool {
 
 func (rl *RequestLogger) shouldMaskBodyField(fieldName string) bool {
 	patterns := slices.Clone(maskBodyFieldPatterns)
-	if rl.config.MaskBodyFields != nil {
-		patterns = append(patterns, rl.config.MaskBodyFields...)
+	if config := rl.getConfig(); config.MaskBodyFields != nil {
+		patterns = append(patterns, config.MaskBodyFields...)
 	}
 	for _, pattern := range patterns {
 		if pattern.MatchString(fieldName) {
@@ -522,20 +1090,30 @@ func (rl *RequestLogger) hasSupportedContentType(headers [][2]string) bool {
 }
 
 func (rl *RequestLogger) hasJSONContentType(headers [][2]string) bool {
+	return jsonContentTypePattern.MatchString(getContentType(headers))
+}
+
+func getContentType(headers [][2]string) string {
 	for _, header := range headers {
 		if header[0] == "Content-Type" {
-			return jsonContentTypePattern.MatchString(header[1])
+			return header[1]
 		}
 	}
-	return false
+	return ""
 }
 
 func (rl *RequestLogger) IsSupportedContentType(contentType string) bool {
 	if contentType == "" {
 		return false
 	}
+	contentType = strings.ToLower(contentType)
 	for _, allowed := range allowedContentTypes {
-		if bytes.HasPrefix([]byte(contentType), []byte(allowed)) {
+		if strings.HasPrefix(contentType, allowed) {
+			return true
+		}
+	}
+	for _, allowed := range rl.getConfig().AllowedContentTypes {
+		if allowed != "" && strings.HasPrefix(contentType, strings.ToLower(allowed)) {
 			return true
 		}
 	}
@@ -547,19 +1125,35 @@ func (rl *RequestLogger) maskQueryParams(search string) string {
 	if err != nil {
 		return search
 	}
+	allowed := rl.getConfig().LogOnlyQueryParams
 	for key := range params {
-		if rl.shouldMaskQueryParam(key) {
+		if allowed != nil && !slices.Contains(allowed, key) {
+			params.Del(key)
+		} else if rl.shouldMaskQueryParam(key) {
 			params.Set(key, masked)
 		}
 	}
 	return params.Encode()
 }
 
+func (rl *RequestLogger) maskPathSegments(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment != "" && segment != masked && rl.shouldMaskPathSegment(segment) {
+			segments[i] = masked
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
 func (rl *RequestLogger) maskHeaders(headers [][2]string) [][2]string {
+	maskPIIEnabled := rl.getConfig().MaskPII
 	result := make([][2]string, len(headers))
 	for i, header := range headers {
 		if rl.shouldMaskHeader(header[0]) {
 			result[i] = [2]string{header[0], masked}
+		} else if maskPIIEnabled {
+			result[i] = [2]string{header[0], maskPII(header[1])}
 		} else {
 			result[i] = header
 		}
@@ -567,6 +1161,49 @@ func (rl *RequestLogger) maskHeaders(headers [][2]string) [][2]string {
 	return result
 }
 
+// includeHeaders returns the headers with names matching any of the patterns, or all
+// headers if there are no patterns.
+func includeHeaders(headers [][2]string, patterns []*regexp.Regexp) [][2]string {
+	if len(patterns) == 0 {
+		return headers
+	}
+	result := make([][2]string, 0, len(headers))
+	for _, header := range headers {
+		for _, pattern := range patterns {
+			if pattern.MatchString(header[0]) {
+				result = append(result, header)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// limitHeaders drops headers beyond the maximum count and truncates long values, such
+// as large cookies or JWTs. Modifies the given headers in place.
+func (rl *RequestLogger) limitHeaders(headers [][2]string) [][2]string {
+	config := rl.getConfig()
+	maxCount := maxHeaders
+	if config.MaxHeaders > 0 {
+		maxCount = config.MaxHeaders
+	}
+	maxLength := maxHeaderLength
+	if config.MaxHeaderLength > 0 {
+		maxLength = config.MaxHeaderLength
+	}
+
+	if len(headers) > maxCount {
+		headers = headers[:maxCount]
+	}
+	for i, header := range headers {
+		if len(header[1]) > maxLength {
+			// Drop any multi-byte character that was cut in half
+			headers[i][1] = strings.ToValidUTF8(header[1][:maxLength], "") + truncatedMarker
+		}
+	}
+	return headers
+}
+
 func (rl *RequestLogger) maskBodyFields(data any) any {
 	switch v := data.(type) {
 	case map[string]any:
@@ -585,11 +1222,178 @@ func (rl *RequestLogger) maskBodyFields(data any) any {
 			v[i] = rl.maskBodyFields(item)
 		}
 		return v
+	case string:
+		if rl.getConfig().MaskPII {
+			return maskPII(v)
+		}
+		return v
 	default:
 		return v
 	}
 }
 
+// limitBodySize replaces a body exceeding the maximum size with a placeholder, or
+// truncates it if enabled. Bodies that were only captured partially because their
+// original size exceeds the maximum are reported as truncated, too.
+func limitBodySize(body []byte, size int64, config *common.RequestLoggingConfig) ([]byte, bool) {
+	maxBodySize := config.GetMaxBodySize()
+	if body == nil || bytes.Equal(body, bodyMasked) || bytes.Equal(body, bodyCompressed) {
+		return body, false
+	}
+	if len(body) > maxBodySize {
+		if !config.TruncateLargeBodies {
+			return bodyTooLarge, false
+		}
+		return truncateBody(body, maxBodySize), true
+	}
+	return body, config.TruncateLargeBodies && len(body) == maxBodySize && size > int64(maxBodySize)
+}
+
+// truncateBody cuts the body to at most maxSize bytes, without splitting a UTF-8
+// encoded character.
+func truncateBody(body []byte, maxSize int) []byte {
+	body = body[:maxSize]
+	for i := 0; i < utf8.UTFMax-1 && len(body) > 0; i++ {
+		if r, size := utf8.DecodeLastRune(body); r != utf8.RuneError || size != 1 {
+			break
+		}
+		body = body[:len(body)-1]
+	}
+	return body
+}
+
+// maskTruncatedBody masks a truncated body, which can't be parsed as a whole, and
+// appends the truncation marker. String fields of JSON bodies are matched by pattern,
+// and XML bodies are cut after the last complete tag.
+func (rl *RequestLogger) maskTruncatedBody(body []byte, headers [][2]string) []byte {
+	contentType := getContentType(headers)
+	switch {
+	case jsonContentTypePattern.MatchString(contentType) || ndjsonContentTypePattern.MatchString(contentType):
+		body = rl.maskPIIInBody(rl.maskJSONStringFields(body))
+	case xmlContentTypePattern.MatchString(contentType):
+		body = body[:bytes.LastIndexByte(body, '>')+1]
+		body = rl.maskPIIInBody(rl.maskXMLBody(body))
+	default:
+		body = rl.maskBody(body, headers)
+	}
+	return append(body[:len(body):len(body)], truncatedMarker...)
+}
+
+// maskJSONStringFields masks the values of string fields matching a body field pattern,
+// without parsing the JSON document, so that it works on incomplete documents.
+func (rl *RequestLogger) maskJSONStringFields(body []byte) []byte {
+	matches := jsonStringFieldPattern.FindAllSubmatchIndex(body, -1)
+	maskedBody := make([]byte, 0, len(body))
+	pos := 0
+	for _, m := range matches {
+		if !rl.shouldMaskBodyField(string(body[m[2]:m[3]])) {
+			continue
+		}
+		maskedBody = append(maskedBody, body[pos:m[4]]...)
+		maskedBody = append(maskedBody, masked...)
+		pos = m[5]
+	}
+	return append(maskedBody, body[pos:]...)
+}
+
+// maskBody masks fields in the body according to its content type. Bodies of other
+// content types are returned unchanged.
+func (rl *RequestLogger) maskBody(body []byte, headers [][2]string) []byte {
+	contentType := getContentType(headers)
+	switch {
+	case ndjsonContentTypePattern.MatchString(contentType):
+		return rl.maskNDJSONBody(body)
+	case jsonContentTypePattern.MatchString(contentType):
+		return rl.maskJSONBody(body)
+	case formContentTypePattern.MatchString(contentType):
+		return rl.maskFormBody(body)
+	case xmlContentTypePattern.MatchString(contentType):
+		return rl.maskPIIInBody(rl.maskXMLBody(body))
+	default:
+		return rl.maskPIIInBody(body)
+	}
+}
+
+// maskPIIInBody masks PII anywhere in a text body, if enabled. Structured bodies are
+// masked field by field instead.
+func (rl *RequestLogger) maskPIIInBody(body []byte) []byte {
+	if !rl.getConfig().MaskPII {
+		return body
+	}
+	return []byte(maskPII(string(body)))
+}
+
+func (rl *RequestLogger) maskNDJSONBody(body []byte) []byte {
+	lines := bytes.Split(body, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines[i] = rl.maskJSONBody(line)
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func (rl *RequestLogger) maskFormBody(body []byte) []byte {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return body
+	}
+	maskPIIEnabled := rl.getConfig().MaskPII
+	for key := range values {
+		for i, value := range values[key] {
+			if rl.shouldMaskBodyField(key) {
+				values[key][i] = masked
+			} else if maskPIIEnabled {
+				values[key][i] = maskPII(value)
+			}
+		}
+	}
+	return []byte(values.Encode())
+}
+
+// maskXMLBody masks the text content of elements whose name matches a body field
+// pattern. The rest of the document is left unchanged, byte for byte.
+func (rl *RequestLogger) maskXMLBody(body []byte) []byte {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+
+	type span struct{ start, end int64 }
+	var spans []span
+	var elements []string
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return body
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			elements = append(elements, t.Name.Local)
+		case xml.EndElement:
+			if len(elements) > 0 {
+				elements = elements[:len(elements)-1]
+			}
+		case xml.CharData:
+			if len(elements) > 0 && len(bytes.TrimSpace(t)) > 0 && rl.shouldMaskBodyField(elements[len(elements)-1]) {
+				spans = append(spans, span{start, decoder.InputOffset()})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return body
+	}
+
+	maskedBody := make([]byte, 0, len(body))
+	var pos int64
+	for _, s := range spans {
+		maskedBody = append(maskedBody, body[pos:s.start]...)
+		maskedBody = append(maskedBody, masked...)
+		pos = s.end
+	}
+	return append(maskedBody, body[pos:]...)
+}
+
 func (rl *RequestLogger) maskJSONBody(body []byte) []byte {
 	var data any
 	if err := json.Unmarshal(body, &data); err != nil {
@@ -7,6 +7,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
@@ -97,7 +101,7 @@ func TestRequestLogger(t *testing.T) {
 			{Timestamp: timestamp, Logger: "main", Level: "INFO", Message: "Processing request"},
 			{Timestamp: timestamp + 0.05, Logger: "db", Level: "DEBUG", Message: "Query executed"},
 		}
-		requestLogger.LogRequest(request, response, errors.New("test"), "", logs, spans, traceID)
+		requestLogger.LogRequest(request, response, errors.New("test"), "", logs, spans, traceID, nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 1)
@@ -186,7 +190,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{{"Content-Type", "application/json"}},
 			Body:         []byte(`{"key": "value"}`),
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 1)
@@ -226,12 +230,119 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{},
 			Body:         []byte(`{"items": []}`),
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 0)
 	})
 
+	t.Run("LogCondition", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogCondition = common.LogIf(common.SlowerThan(500*time.Millisecond), common.Not(common.ConsumerIn("monitoring")))
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		for _, tc := range []struct {
+			consumer     string
+			responseTime float64
+		}{
+			{"tester", 0.1},
+			{"tester", 0.8},
+			{"monitoring", 0.8},
+		} {
+			request := &common.Request{
+				Timestamp: float64(time.Now().Unix()),
+				Consumer:  tc.consumer,
+				Method:    "GET",
+				Path:      "/items",
+				URL:       "http://test/items",
+			}
+			response := &common.Response{StatusCode: 200, ResponseTime: tc.responseTime}
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+
+		// Only the slow request of a consumer other than monitoring is logged
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		assert.Equal(t, 0.8, items[0]["response"].(map[string]any)["response_time"])
+	})
+
+	t.Run("TailCaptureThreshold", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.CaptureLogs = true
+		config.CaptureTraces = true
+		config.TailCaptureThreshold = 500 * time.Millisecond
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		for _, tc := range []struct {
+			statusCode   int
+			responseTime float64
+		}{
+			{200, 0.1},
+			{200, 0.8},
+			{503, 0.1},
+		} {
+			request := &common.Request{
+				Timestamp: float64(time.Now().Unix()),
+				Method:    "GET",
+				Path:      "/items",
+				URL:       "http://test/items",
+			}
+			response := &common.Response{StatusCode: tc.statusCode, ResponseTime: tc.responseTime}
+			logs := []LogRecord{{Timestamp: request.Timestamp, Level: "INFO", Message: "test"}}
+			spans := []SpanData{{SpanID: "0000000000000001", Name: "test", Kind: "INTERNAL"}}
+			requestLogger.LogRequest(request, response, nil, "", logs, spans, "00000000000000000000000000000001", nil)
+		}
+
+		// Logs and spans are only kept for the slow request and the server error
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 3)
+		assert.NotContains(t, items[0], "logs")
+		assert.NotContains(t, items[0], "spans")
+		assert.Equal(t, "00000000000000000000000000000001", items[0]["trace_id"])
+		assert.Contains(t, items[1], "logs")
+		assert.Contains(t, items[1], "spans")
+		assert.Contains(t, items[2], "logs")
+		assert.Contains(t, items[2], "spans")
+	})
+
+	t.Run("MaxRequestsPerConsumer", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.MaxRequestsPerConsumer = 2
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		for _, consumer := range []string{"noisy", "noisy", "noisy", "quiet", "", "", ""} {
+			request := &common.Request{
+				Timestamp: float64(time.Now().Unix()),
+				Consumer:  consumer,
+				Method:    "GET",
+				Path:      "/items",
+				URL:       "http://test/items",
+			}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.123}
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+
+		// Only requests of the noisy consumer exceeding the quota are skipped
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 6)
+		assert.Equal(t, int64(1), requestLogger.ThrottledItems())
+
+		// The quota is reset for forgotten consumers
+		assert.False(t, requestLogger.consumerQuota.Allow("noisy", 2))
+		requestLogger.ForgetConsumer("noisy")
+		assert.True(t, requestLogger.consumerQuota.Allow("noisy", 2))
+
+		// The quota is reset at the start of the next interval
+		requestLogger.consumerQuota.windowStart = time.Now().Add(-logQuotaInterval)
+		assert.True(t, requestLogger.consumerQuota.Allow("noisy", 2))
+	})
+
 	t.Run("ExcludeBasedOnPath", func(t *testing.T) {
 		config := common.NewRequestLoggingConfig()
 		config.Enabled = true
@@ -254,7 +365,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{},
 			Body:         []byte(`{"healthy": true}`),
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		request = &common.Request{
 			Timestamp: timestamp,
@@ -264,12 +375,41 @@ func TestRequestLogger(t *testing.T) {
 			Headers:   [][2]string{},
 			Body:      []byte{},
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 0)
 	})
 
+	t.Run("DisableDefaultExclusions", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.DisableDefaultExclusions = true
+		config.ExcludePaths = []*regexp.Regexp{regexp.MustCompile(`/healthz$`)}
+		config.ExcludeUserAgents = []*regexp.Regexp{regexp.MustCompile(`^internal-monitor/`)}
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{},
+		}
+		for _, request := range []*common.Request{
+			{Method: "GET", Path: "/ready", URL: "http://test/ready", Headers: [][2]string{}},
+			{Method: "GET", Path: "/", URL: "http://test/", Headers: [][2]string{{"User-Agent", "kube-probe/1.29"}}},
+			{Method: "GET", Path: "/healthz", URL: "http://test/healthz", Headers: [][2]string{}},
+			{Method: "GET", Path: "/", URL: "http://test/", Headers: [][2]string{{"User-Agent", "internal-monitor/1.0"}}},
+		} {
+			request.Timestamp = float64(time.Now().Unix())
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 2)
+		assert.Equal(t, "/ready", items[0]["request"].(map[string]any)["path"])
+	})
+
 	t.Run("ExcludeHealthCheckUserAgent", func(t *testing.T) {
 		config := common.NewRequestLoggingConfig()
 		config.Enabled = true
@@ -291,7 +431,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{},
 			Body:         []byte{},
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 0)
@@ -325,7 +465,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{{"Content-Type", "text/plain"}},
 			Body:         []byte("test"),
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 1)
@@ -353,6 +493,116 @@ func TestRequestLogger(t *testing.T) {
 		assert.True(t, acceptNotMasked, "Accept header should not be masked")
 	})
 
+	t.Run("LimitHeaders", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestHeaders = true
+		config.MaxHeaders = 2
+		config.MaxHeaderLength = 10
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test",
+			Headers: [][2]string{
+				{"Accept", "text/plain"},
+				{"X-Long", "abcdefghijklmnop"},
+				{"X-Dropped", "value"},
+			},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{{"X-Unicode", "aéééééé"}},
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqHeaders := items[0]["request"].(map[string]any)["headers"].([]any)
+		assert.Equal(t, []any{
+			[]any{"Accept", "text/plain"},
+			[]any{"X-Long", "abcdefghij...<truncated>"},
+		}, reqHeaders)
+		respHeaders := items[0]["response"].(map[string]any)["headers"].([]any)
+		assert.Equal(t, []any{[]any{"X-Unicode", "aéééé...<truncated>"}}, respHeaders)
+		assert.Len(t, request.Headers, 3)
+	})
+
+	t.Run("IncludeHeaders", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestHeaders = true
+		config.IncludeRequestHeaders = []*regexp.Regexp{regexp.MustCompile(`(?i)^(content-type|authorization)$`)}
+		config.IncludeResponseHeaders = []*regexp.Regexp{regexp.MustCompile(`(?i)^x-ratelimit-`)}
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test",
+			Headers: [][2]string{
+				{"Content-Type", "application/json"},
+				{"Authorization", "Bearer 123456"},
+				{"X-Forwarded-For", "198.51.100.7"},
+			},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers: [][2]string{
+				{"Content-Type", "application/json"},
+				{"X-Ratelimit-Remaining", "99"},
+			},
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqHeaders := items[0]["request"].(map[string]any)["headers"].([]any)
+		assert.Equal(t, []any{
+			[]any{"Content-Type", "application/json"},
+			[]any{"Authorization", "******"},
+		}, reqHeaders)
+		respHeaders := items[0]["response"].(map[string]any)["headers"].([]any)
+		assert.Equal(t, []any{[]any{"X-Ratelimit-Remaining", "99"}}, respHeaders)
+	})
+
+	t.Run("ClientIP", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogClientIP = true
+		config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+		config.AnonymizeClientIP = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp:  float64(time.Now().Unix()),
+			Method:     "GET",
+			Path:       "/test",
+			URL:        "http://localhost/test",
+			Headers:    [][2]string{{"X-Forwarded-For", "198.51.100.7"}},
+			RemoteAddr: "10.0.0.1:4321",
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqMap := items[0]["request"].(map[string]any)
+		assert.Equal(t, "198.51.100.0", reqMap["client_ip"])
+		assert.NotContains(t, reqMap, "RemoteAddr")
+	})
+
 	t.Run("MaskQueryParams", func(t *testing.T) {
 		config := common.NewRequestLoggingConfig()
 		config.Enabled = true
@@ -375,7 +625,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{{"Content-Type", "text/plain"}},
 			Body:         []byte("test"),
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 1)
@@ -388,6 +638,96 @@ func TestRequestLogger(t *testing.T) {
 		assert.Contains(t, url, "other=abcdef")
 	})
 
+	t.Run("IncludeCurlCommand", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestHeaders = true
+		config.IncludeCurlCommand = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test?token=abc",
+			Headers:   [][2]string{{"Authorization", "Bearer secret"}},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+
+		// Check that the command is built from the masked request
+		assert.Equal(t, "curl 'http://localhost/test?token=%2A%2A%2A%2A%2A%2A' -H 'Authorization: ******'", items[0]["curl_command"])
+	})
+
+	t.Run("LogOnlyQueryParams", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogOnlyQueryParams = []string{"page", "token"}
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test?page=2&email=test%40example.com&token=abc&Page=3",
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqData := items[0]["request"].(map[string]any)
+
+		// Check that only allowed query params are kept, and still masked if needed
+		assert.Equal(t, "http://localhost/test?page=2&token=%2A%2A%2A%2A%2A%2A", reqData["url"])
+	})
+
+	t.Run("MaskPathSegments", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.MaskPathSegments = []*regexp.Regexp{regexp.MustCompile(`^acct_`)}
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/users/{email}/accounts/{accountId}",
+			URL:       "http://localhost/users/john%40example.com/accounts/acct_123?page=1",
+		}
+		response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		requestLogger.writeToFile()
+
+		// Path segments are masked with the callback, in addition to patterns
+		config = common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.MaskPathSegmentsCallback = func(request *common.Request) string {
+			return "/users/******/accounts/123"
+		}
+		requestLogger.UpdateConfig(config)
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 2)
+		reqData := items[0]["request"].(map[string]any)
+		assert.Equal(t, "http://localhost/users/%2A%2A%2A%2A%2A%2A/accounts/%2A%2A%2A%2A%2A%2A?page=1", reqData["url"])
+		assert.Equal(t, "/users/{email}/accounts/{accountId}", reqData["path"])
+		reqData = items[1]["request"].(map[string]any)
+		assert.Equal(t, "http://localhost/users/%2A%2A%2A%2A%2A%2A/accounts/123?page=1", reqData["url"])
+	})
+
 	t.Run("MaskBodyCallbacks", func(t *testing.T) {
 		config := common.NewRequestLoggingConfig()
 		config.Enabled = true
@@ -423,7 +763,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{{"Content-Type", "application/json"}},
 			Body:         []byte("test"),
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 1)
@@ -490,7 +830,7 @@ func TestRequestLogger(t *testing.T) {
 			Headers:      [][2]string{{"Content-Type", "application/json"}},
 			Body:         responseBodyJSON,
 		}
-		requestLogger.LogRequest(request, response, nil, "", nil, nil, "")
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
 
 		items := getLoggedItems(t, requestLogger)
 		assert.Len(t, items, 1)
@@ -529,6 +869,405 @@ func TestRequestLogger(t *testing.T) {
 		assert.Equal(t, "success", maskedResponseBody["status"])
 	})
 
+	t.Run("MaskBodyContentTypes", func(t *testing.T) {
+		requestLogger := NewRequestLogger(common.NewRequestLoggingConfig())
+		defer requestLogger.Close()
+
+		tests := []struct {
+			contentType string
+			body        string
+			expected    string
+		}{
+			{
+				"application/problem+json",
+				`{"detail":"x","token":"abc"}`,
+				`{"detail":"x","token":"******"}`,
+			},
+			{
+				"application/x-ndjson",
+				"{\"password\":\"a\"}\n{\"name\":\"b\"}\n",
+				"{\"password\":\"******\"}\n{\"name\":\"b\"}\n",
+			},
+			{
+				"application/x-www-form-urlencoded",
+				"username=john&password=secret",
+				"password=%2A%2A%2A%2A%2A%2A&username=john",
+			},
+			{
+				"application/xml; charset=utf-8",
+				`<?xml version="1.0"?><login><user>john</user><ns:password>s&amp;cret</ns:password><auth><![CDATA[tok]]></auth></login>`,
+				`<?xml version="1.0"?><login><user>john</user><ns:password>******</ns:password><auth>******</auth></login>`,
+			},
+			{
+				"text/xml",
+				`<password>secret</password><`,
+				`<password>secret</password><`,
+			},
+			{
+				"text/plain",
+				"password=secret",
+				"password=secret",
+			},
+		}
+		for _, tt := range tests {
+			headers := [][2]string{{"Content-Type", tt.contentType}}
+			assert.Equal(t, tt.expected, string(requestLogger.maskBody([]byte(tt.body), headers)), tt.contentType)
+		}
+	})
+
+	t.Run("UpdateConfig", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		newConfig := common.NewRequestLoggingConfig()
+		newConfig.Enabled = true
+		newConfig.MaskQueryParams = []*regexp.Regexp{regexp.MustCompile(`(?i)test`)}
+		requestLogger.UpdateConfig(newConfig)
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test?test=123456",
+			Headers:   [][2]string{},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{},
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqData := items[0]["request"].(map[string]any)
+		assert.Contains(t, reqData["url"], "test=%2A%2A%2A%2A%2A%2A")
+
+		// Disabling request logging at runtime
+		requestLogger.UpdateConfig(nil)
+		assert.False(t, requestLogger.IsEnabled())
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		assert.Len(t, requestLogger.GetPendingWrites(), 0)
+	})
+
+	t.Run("RemoteConfig", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test",
+			Headers:   [][2]string{},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{},
+		}
+
+		// Sample rate of 0 drops all requests
+		requestLogger.SetSampleRate(0)
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		assert.Len(t, requestLogger.GetPendingWrites(), 0)
+
+		requestLogger.SetSampleRate(1.5)
+		assert.Equal(t, 1.0, requestLogger.sampleRate)
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		assert.Len(t, requestLogger.GetPendingWrites(), 1)
+
+		// Remote disable takes precedence, but can't enable logging disabled in config
+		requestLogger.SetRemoteEnabled(false)
+		assert.False(t, requestLogger.IsEnabled())
+		requestLogger.SetRemoteEnabled(true)
+		assert.True(t, requestLogger.IsEnabled())
+		requestLogger.UpdateConfig(nil)
+		requestLogger.SetRemoteEnabled(true)
+		assert.False(t, requestLogger.IsEnabled())
+	})
+
+	t.Run("ReleaseBodyBuffers", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestBody = true
+		config.LogResponseBody = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		requestBody := common.GetBodyBuffer()
+		requestBody.WriteString(`{"key":"value"}`)
+		responseBody := common.GetBodyBuffer()
+		responseBody.WriteString(`{"key":"value"}`)
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "POST",
+			Path:      "/test",
+			URL:       "http://localhost/test",
+			Headers:   [][2]string{{"Content-Type", "application/json"}},
+		}
+		request.SetBodyBuffer(requestBody)
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{{"Content-Type", "application/json"}},
+		}
+		response.SetBodyBuffer(responseBody)
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		// Buffers are returned to the pool once the item has been written
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqData := items[0]["request"].(map[string]any)
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte(`{"key":"value"}`)), reqData["body"])
+		assert.Equal(t, 0, requestBody.Len())
+		assert.Equal(t, 0, responseBody.Len())
+	})
+
+	t.Run("DoesNotModifyCaller", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.MaskQueryParams = []*regexp.Regexp{regexp.MustCompile(`(?i)secret`)}
+		config.MaskRequestBodyCallback = func(request *common.Request) []byte {
+			return nil
+		}
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "POST",
+			Path:      "/test",
+			URL:       "http://localhost/test?secret=123456",
+			Headers:   [][2]string{{"Authorization", "Bearer 123456"}, {"Content-Type", "application/json"}},
+			Body:      []byte(`{"key":"value"}`),
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{{"Content-Type", "application/json"}},
+			Body:         []byte(`{"key":"value"}`),
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		reqData := items[0]["request"].(map[string]any)
+		assert.NotContains(t, reqData["url"], "123456")
+		assert.Nil(t, reqData["body"])
+
+		assert.Equal(t, "http://localhost/test?secret=123456", request.URL)
+		assert.Equal(t, "Bearer 123456", request.Headers[0][1])
+		assert.Equal(t, []byte(`{"key":"value"}`), request.Body)
+		assert.Equal(t, []byte(`{"key":"value"}`), response.Body)
+	})
+
+	t.Run("WriteBatchSize", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.WriteInterval = time.Hour
+		config.WriteBatchSize = 2
+		config.SyncOnWrite = true
+		requestLogger := NewRequestLogger(config)
+		requestLogger.StartMaintenance()
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test",
+			Headers:   [][2]string{},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{},
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		// The batch is written without waiting for the write interval
+		assert.Eventually(t, func() bool {
+			requestLogger.currentFileMutex.Lock()
+			defer requestLogger.currentFileMutex.Unlock()
+			return len(requestLogger.pendingWrites) == 0 && requestLogger.currentFile != nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("WriteWorkers", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestHeaders = true
+		config.WriteInterval = time.Hour
+		config.WriteWorkers = 4
+		requestLogger := NewRequestLogger(config)
+		requestLogger.StartMaintenance()
+		defer requestLogger.Close()
+
+		for i := 0; i < 20; i++ {
+			request := &common.Request{
+				Timestamp: float64(time.Now().Unix()),
+				Method:    "GET",
+				Path:      "/test",
+				URL:       "http://localhost/test",
+				Headers:   [][2]string{{"Authorization", "Bearer secret"}},
+			}
+			response := &common.Response{
+				StatusCode:   200,
+				ResponseTime: 0.1,
+				Headers:      [][2]string{},
+			}
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+
+		// Items are written without waiting for the write interval
+		assert.Eventually(t, func() bool {
+			requestLogger.currentFileMutex.Lock()
+			defer requestLogger.currentFileMutex.Unlock()
+			return len(requestLogger.pendingWrites) == 0 && requestLogger.currentFile != nil
+		}, time.Second, 10*time.Millisecond)
+
+		// Give the workers time to finish writing items they have taken off the buffer
+		time.Sleep(50 * time.Millisecond)
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 20)
+		for _, item := range items {
+			headers := item["request"].(map[string]any)["headers"].([]any)
+			assert.Equal(t, []any{"Authorization", "******"}, headers[0])
+		}
+	})
+
+	t.Run("WriteWorkersError", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		notDir := filepath.Join(t.TempDir(), "file")
+		assert.NoError(t, os.WriteFile(notDir, nil, 0o600))
+		config.TempDir = filepath.Join(notDir, "logs")
+		config.WriteInterval = time.Hour
+		config.WriteWorkers = 2
+		requestLogger := NewRequestLogger(config)
+		requestLogger.StartMaintenance()
+		defer requestLogger.Close()
+
+		for i := 0; i < 5; i++ {
+			request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+
+		// Items that can't be written are counted as dropped
+		assert.Eventually(t, func() bool {
+			return requestLogger.DroppedItems() == 5
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("MaxBodySize", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestBody = true
+		config.LogResponseBody = true
+		config.MaxBodySize = 10
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+		assert.Equal(t, 10, requestLogger.MaxBodySize())
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "POST",
+			Path:      "/items",
+			URL:       "http://localhost/items",
+			Headers:   [][2]string{{"Content-Type", "text/plain"}},
+			Body:      []byte("short"),
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{{"Content-Type", "text/plain"}},
+			Body:         []byte("longer than ten bytes"),
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		requestBody, _ := base64.StdEncoding.DecodeString(items[0]["request"].(map[string]any)["body"].(string))
+		assert.Equal(t, "short", string(requestBody))
+		responseBody, _ := base64.StdEncoding.DecodeString(items[0]["response"].(map[string]any)["body"].(string))
+		assert.Equal(t, bodyTooLarge, responseBody)
+	})
+
+	t.Run("TruncateLargeBodies", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogRequestBody = true
+		config.LogResponseBody = true
+		config.MaxBodySize = 40
+		config.TruncateLargeBodies = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+		assert.True(t, requestLogger.TruncateLargeBodies())
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "POST",
+			Path:      "/login",
+			URL:       "http://localhost/login",
+			Headers:   [][2]string{{"Content-Type", "application/json"}},
+			Size:      100,
+			Body:      []byte(`{"username":"john","password":"secret123`),
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{{"Content-Type", "text/plain"}},
+			Size:         60,
+			Body:         []byte("this response body is longer than forty bytes"),
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+
+		items := getLoggedItems(t, requestLogger)
+		assert.Len(t, items, 1)
+		requestItem := items[0]["request"].(map[string]any)
+		requestBody, _ := base64.StdEncoding.DecodeString(requestItem["body"].(string))
+		assert.Equal(t, `{"username":"john","password":"******`+truncatedMarker, string(requestBody))
+		assert.Equal(t, true, requestItem["body_truncated"])
+		assert.Equal(t, float64(100), requestItem["size"])
+		responseItem := items[0]["response"].(map[string]any)
+		responseBody, _ := base64.StdEncoding.DecodeString(responseItem["body"].(string))
+		assert.Equal(t, "this response body is longer than forty "+truncatedMarker, string(responseBody))
+		assert.Equal(t, true, responseItem["body_truncated"])
+	})
+
+	t.Run("DroppedItems", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "GET",
+			Path:      "/test",
+			URL:       "http://localhost/test",
+			Headers:   [][2]string{},
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{},
+		}
+		for i := 0; i < maxPendingWrites+5; i++ {
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+		assert.Equal(t, int64(5), requestLogger.DroppedItems())
+		assert.Len(t, requestLogger.GetPendingWrites(), maxPendingWrites)
+	})
+
 	t.Run("Suspend", func(t *testing.T) {
 		config := common.NewRequestLoggingConfig()
 		config.Enabled = true
@@ -539,13 +1278,86 @@ func TestRequestLogger(t *testing.T) {
 		assert.True(t, requestLogger.IsSuspended())
 	})
 
+	t.Run("SuspendWhileWriting", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		requestLogger := NewRequestLogger(config)
+
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				case requestLogger.pendingWrites <- RequestLogItem{
+					UUID:     "test",
+					Request:  &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"},
+					Response: &common.Response{StatusCode: 200, ResponseTime: 0.1},
+				}:
+				}
+				requestLogger.writeToFile()
+			}
+		}()
+
+		suspended := make(chan struct{})
+		go func() {
+			defer close(suspended)
+			for i := 0; i < 500; i++ {
+				requestLogger.SuspendFor(time.Second)
+			}
+		}()
+
+		select {
+		case <-suspended:
+		case <-time.After(10 * time.Second):
+			// Not closing the logger, as that would block too
+			t.Fatal("suspending deadlocked with writing to file")
+		}
+		close(stop)
+		<-done
+		assert.True(t, requestLogger.IsSuspended())
+		requestLogger.Close()
+	})
+
+	t.Run("Saturation", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.LogResponseBody = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		assert.False(t, requestLogger.IsSaturated())
+		assert.True(t, requestLogger.ShouldLogRequest())
+
+		// Overflow the buffer of pending items without writing them
+		for i := 0; i <= maxPendingWrites; i++ {
+			request := &common.Request{Timestamp: float64(time.Now().Unix()), Method: "GET", Path: "/test", URL: "http://test/test"}
+			response := &common.Response{StatusCode: 200, ResponseTime: 0.1}
+			requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		}
+		assert.Equal(t, int64(1), requestLogger.DroppedItems())
+		assert.True(t, requestLogger.IsSaturated())
+		assert.False(t, requestLogger.ShouldLogRequest())
+		assert.False(t, requestLogger.ShouldLogResponseBody())
+		assert.Equal(t, int64(1), requestLogger.SkippedItems())
+
+		// Still saturated after draining until no items were dropped for a while
+		requestLogger.GetPendingWrites()
+		assert.True(t, requestLogger.IsSaturated())
+		requestLogger.saturatedAt.Store(time.Now().Add(-saturationPeriod).UnixNano())
+		assert.False(t, requestLogger.IsSaturated())
+		assert.True(t, requestLogger.ShouldLogRequest())
+	})
+
 	t.Run("RetryFileLater", func(t *testing.T) {
 		config := common.NewRequestLoggingConfig()
 		config.Enabled = true
 		requestLogger := NewRequestLogger(config)
 		defer requestLogger.Close()
 
-		tempFile, _ := NewTempGzipFile()
+		tempFile, _ := NewTempGzipFile("", false, jsonSerializer{})
 		tempFile.WriteLine([]byte("test"))
 		tempFile.Close()
 
@@ -559,7 +1371,7 @@ func TestRequestLogger(t *testing.T) {
 
 		// Fill the channel to capacity (maxFiles = 50)
 		for i := 0; i < 50; i++ {
-			file, err := NewTempGzipFile()
+			file, err := NewTempGzipFile("", false, jsonSerializer{})
 			assert.NoError(t, err)
 			err = file.Close()
 			assert.NoError(t, err)
@@ -567,7 +1379,7 @@ func TestRequestLogger(t *testing.T) {
 		}
 
 		// Create another file to retry when channel is full
-		tempFile, _ = NewTempGzipFile()
+		tempFile, _ = NewTempGzipFile("", false, jsonSerializer{})
 		tempFile.WriteLine([]byte("test"))
 		tempFile.Close()
 
@@ -582,6 +1394,162 @@ func TestRequestLogger(t *testing.T) {
 		requestLogger.Clear()
 	})
 
+	t.Run("MaxDiskUsage", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.TempDir = t.TempDir()
+		config.WriteInterval = 10 * time.Millisecond
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		files := make([]*TempGzipFile, 3)
+		for i := range files {
+			file, err := NewTempGzipFile(config.TempDir, false, jsonSerializer{})
+			assert.NoError(t, err)
+			file.WriteLine([]byte("test"))
+			file.Close()
+			files[i] = file
+			requestLogger.RetryFileLater(file)
+		}
+		assert.Equal(t, 3*files[0].DiskSize(), requestLogger.DiskUsage())
+
+		// Budget fits only two files, so the oldest one gets deleted
+		config.MaxDiskUsage = 2 * files[0].DiskSize()
+		requestLogger.StartMaintenance()
+
+		assert.Eventually(t, func() bool {
+			return requestLogger.PendingFileCount() == 2
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, int64(1), requestLogger.DeletedFiles())
+		assert.Equal(t, files[1], requestLogger.GetFile())
+		assert.Equal(t, files[0].DiskSize(), requestLogger.DiskUsage())
+	})
+
+	t.Run("GetFiles", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		newFile := func(serializer serializer) *TempGzipFile {
+			file, err := NewTempGzipFile(t.TempDir(), false, serializer)
+			assert.NoError(t, err)
+			file.WriteLine([]byte("test"))
+			file.Close()
+			requestLogger.RetryFileLater(file)
+			return file
+		}
+		jsonFile1 := newFile(jsonSerializer{})
+		jsonFile2 := newFile(jsonSerializer{})
+		jsonFile3 := newFile(jsonSerializer{})
+		msgpackFile := newFile(msgpackSerializer{})
+		jsonFile4 := newFile(jsonSerializer{})
+
+		// Files are batched up to the size limit and only with the same content type.
+		// Files that don't fit are put back at the front of the queue, keeping the order.
+		assert.Equal(t, []*TempGzipFile{jsonFile1}, requestLogger.GetFiles(jsonFile1.DiskSize()))
+		assert.Equal(t, 4, requestLogger.PendingFileCount())
+		files := requestLogger.GetFiles(maxFileSize)
+		assert.Equal(t, []*TempGzipFile{jsonFile2, jsonFile3}, files)
+		assert.Equal(t, []*TempGzipFile{msgpackFile}, requestLogger.GetFiles(maxFileSize))
+		assert.Equal(t, []*TempGzipFile{jsonFile4}, requestLogger.GetFiles(maxFileSize))
+		assert.Nil(t, requestLogger.GetFiles(maxFileSize))
+		assert.Equal(t, int64(0), requestLogger.DiskUsage())
+		jsonFile1.Delete()
+		msgpackFile.Delete()
+		jsonFile4.Delete()
+
+		// Concatenated files can be read as a single gzip stream
+		upload := logUpload{files: files}
+		assert.Equal(t, jsonFile2.DiskSize()+jsonFile3.DiskSize(), upload.ContentLength())
+		assert.NotEqual(t, jsonFile2.uuid, upload.UUID())
+		assert.Equal(t, upload.UUID(), logUpload{files: []*TempGzipFile{jsonFile2, jsonFile3}}.UUID())
+		reader, err := upload.GetReader()
+		assert.NoError(t, err)
+		gzipReader, err := gzip.NewReader(reader)
+		assert.NoError(t, err)
+		content, err := io.ReadAll(gzipReader)
+		assert.NoError(t, err)
+		assert.Equal(t, "test\ntest\n", string(content))
+		assert.NoError(t, reader.Close())
+		upload.Delete()
+	})
+
+	t.Run("Spool", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.Enabled = true
+		config.TempDir = t.TempDir()
+		config.SpoolDir = t.TempDir()
+		requestLogger := NewRequestLogger(config)
+		assert.NoError(t, requestLogger.InitSpool())
+		defer requestLogger.Close()
+
+		newFile := func() *TempGzipFile {
+			file, err := NewTempGzipFile(config.TempDir, false, jsonSerializer{})
+			assert.NoError(t, err)
+			file.WriteLine([]byte("test"))
+			file.Close()
+			return file
+		}
+
+		// Fill the channel to capacity, so that the next file is spooled
+		for i := 0; i < maxFiles; i++ {
+			requestLogger.RetryFileLater(newFile())
+		}
+		spooledFile := newFile()
+		requestLogger.RetryFileLater(spooledFile)
+		assert.Equal(t, int64(0), requestLogger.DeletedFiles())
+		assert.Equal(t, maxFiles+1, requestLogger.PendingFileCount())
+		assert.Equal(t, config.SpoolDir, filepath.Dir(spooledFile.filePath))
+
+		// Spooled files are sent first and keep their position if sending fails
+		assert.Equal(t, spooledFile, requestLogger.GetFile())
+		requestLogger.RetryFileLater(spooledFile)
+		assert.Equal(t, maxFiles+1, requestLogger.PendingFileCount())
+		assert.Equal(t, spooledFile, requestLogger.GetFile())
+		content, err := spooledFile.GetContent()
+		assert.NoError(t, err)
+		assert.NotEmpty(t, content)
+		spooledFile.Delete()
+
+		requestLogger.Clear()
+		assert.Equal(t, 0, requestLogger.PendingFileCount())
+		entries, err := os.ReadDir(config.SpoolDir)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("SpoolLimits", func(t *testing.T) {
+		dir := t.TempDir()
+		files := make([]*TempGzipFile, 3)
+		for i := range files {
+			file, err := NewTempGzipFile(t.TempDir(), false, jsonSerializer{})
+			assert.NoError(t, err)
+			file.WriteLine([]byte("test"))
+			file.Close()
+			files[i] = file
+		}
+
+		// Size limit fits only two files, so the oldest one gets deleted
+		spool, err := newLogSpool(dir, 2*files[0].DiskSize(), time.Hour)
+		assert.NoError(t, err)
+		for i, file := range files {
+			deleted, err := spool.Add(file)
+			assert.NoError(t, err)
+			assert.Equal(t, max(i-1, 0), deleted)
+		}
+		assert.Equal(t, 2, spool.Len())
+		assert.Equal(t, files[1], spool.Next())
+		assert.Equal(t, 0, spool.Requeue(files[1]))
+		assert.Equal(t, 2, spool.Len())
+
+		// Files older than the TTL get deleted
+		spool.ttl = time.Millisecond
+		time.Sleep(2 * time.Millisecond)
+		assert.Equal(t, 2, spool.Evict())
+		assert.Nil(t, spool.Next())
+	})
+
 	t.Run("IsSupportedContentType", func(t *testing.T) {
 		requestLogger := NewRequestLogger(common.NewRequestLoggingConfig())
 		defer requestLogger.Close()
@@ -590,9 +1558,63 @@ func TestRequestLogger(t *testing.T) {
 		assert.True(t, requestLogger.IsSupportedContentType("application/json"))
 		assert.True(t, requestLogger.IsSupportedContentType("application/json; charset=utf-8"))
 		assert.True(t, requestLogger.IsSupportedContentType("text/plain"))
+		assert.True(t, requestLogger.IsSupportedContentType("Application/JSON"))
+		assert.True(t, requestLogger.IsSupportedContentType("application/problem+json"))
+		assert.True(t, requestLogger.IsSupportedContentType("application/x-ndjson"))
+		assert.True(t, requestLogger.IsSupportedContentType("application/x-www-form-urlencoded"))
+		assert.True(t, requestLogger.IsSupportedContentType("application/xml"))
+		assert.True(t, requestLogger.IsSupportedContentType("text/xml; charset=utf-8"))
 
 		// Unsupported content types
 		assert.False(t, requestLogger.IsSupportedContentType("multipart/form-data"))
+		assert.False(t, requestLogger.IsSupportedContentType("text/csv"))
 		assert.False(t, requestLogger.IsSupportedContentType(""))
+
+		// Additional content types from config
+		config := common.NewRequestLoggingConfig()
+		config.AllowedContentTypes = []string{"text/csv"}
+		requestLogger.UpdateConfig(config)
+		assert.True(t, requestLogger.IsSupportedContentType("text/csv; charset=utf-8"))
 	})
 }
+
+func BenchmarkRequestLoggerWriteToFile(b *testing.B) {
+	config := common.NewRequestLoggingConfig()
+	config.Enabled = true
+	config.LogRequestHeaders = true
+	config.LogRequestBody = true
+	config.LogResponseBody = true
+	requestLogger := NewRequestLogger(config)
+	defer requestLogger.Close()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		request := &common.Request{
+			Timestamp: float64(time.Now().Unix()),
+			Method:    "POST",
+			Path:      "/items",
+			URL:       "http://localhost/items?page=1",
+			Headers:   [][2]string{{"Content-Type", "application/json"}, {"User-Agent", "bench"}},
+			Body:      []byte(`{"name":"item","tags":["a","b"]}`),
+		}
+		response := &common.Response{
+			StatusCode:   200,
+			ResponseTime: 0.1,
+			Headers:      [][2]string{{"Content-Type", "application/json"}},
+			Body:         []byte(`{"id":1,"name":"item"}`),
+		}
+		requestLogger.LogRequest(request, response, nil, "", nil, nil, "", nil)
+		if i%maxPendingWrites == 0 {
+			requestLogger.writeToFile()
+		}
+	}
+	requestLogger.writeToFile()
+}
+
+func TestTruncateBody(t *testing.T) {
+	assert.Equal(t, []byte("abc"), truncateBody([]byte("abcdef"), 3))
+	// Multi-byte characters are not split
+	assert.Equal(t, []byte("a"), truncateBody([]byte("aé"), 2))
+	assert.Equal(t, []byte("a€"), truncateBody([]byte("a€b"), 4))
+	assert.Equal(t, []byte("a"), truncateBody([]byte("a€b"), 3))
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Schema versions of the payloads sent to the hub, so it can keep ingesting data from
+// older clients as the schema evolves. Adding optional fields doesn't require a new
+// version, but renaming or removing fields, or changing their meaning, does.
+const (
+	syncPayloadSchemaVersion    = 1
+	requestLogItemSchemaVersion = 1
+)
+
+// serializer encodes payloads sent to the hub.
+type serializer interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	NewEncoder(w io.Writer) encoder
+}
+
+// encoder writes a stream of encoded values to a writer.
+type encoder interface {
+	Encode(v any) error
+}
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) ContentType() string {
+	return "application/json"
+}
+
+func (jsonSerializer) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonSerializer) NewEncoder(w io.Writer) encoder {
+	return json.NewEncoder(w)
+}
+
+var hubSerializer serializer = jsonSerializer{}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// assertGolden compares the serialized value with a golden file in testdata, which
+// documents the schema expected by the hub. Run go test with -update to regenerate
+// golden files after intentional changes, and increment the schema version if the
+// change isn't backwards compatible.
+func assertGolden(t *testing.T, name string, v any) {
+	data, err := hubSerializer.Marshal(v)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, json.Indent(&buf, data, "", "  "))
+	buf.WriteByte('\n')
+
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll("testdata", 0755))
+		assert.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	}
+	golden, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(golden), buf.String())
+}
+
+func TestSerializer(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) {
+		assert.Equal(t, "application/json", hubSerializer.ContentType())
+
+		var buf bytes.Buffer
+		encoder := hubSerializer.NewEncoder(&buf)
+		assert.NoError(t, encoder.Encode(map[string]int{"a": 1}))
+		assert.NoError(t, encoder.Encode(map[string]int{"b": 2}))
+		assert.Equal(t, "{\"a\":1}\n{\"b\":2}\n", buf.String())
+	})
+
+	t.Run("SyncPayloadGolden", func(t *testing.T) {
+		workerSlot := 0
+		minRemaining := int64(5)
+		payload := SyncPayload{
+			SchemaVersion: syncPayloadSchemaVersion,
+			Timestamp:     1700000000,
+			InstanceUUID:  "00000000-0000-0000-0000-000000000001",
+			MessageUUID:   "00000000-0000-0000-0000-000000000002",
+			WorkerSlot:    &workerSlot,
+			Uptime:        3600,
+			Requests: []RequestsItem{{
+				Consumer:        "consumer1",
+				Method:          "GET",
+				Path:            "/items/{id}",
+				StatusCode:      200,
+				Tags:            map[string]string{"tenant": "acme"},
+				APIVersion:      "v1",
+				TrafficClass:    "bot",
+				RequestCount:    2,
+				ResponseSizeSum: 200,
+				ResponseTimes:   map[int]int{10: 2},
+				RequestSizes:    map[int]int{},
+				ResponseSizes:   map[int]int{0: 2},
+			}},
+			ValidationErrors: []ValidationErrorsItem{{
+				Consumer:   "consumer1",
+				Method:     "POST",
+				Path:       "/items",
+				Loc:        []string{"body", "name"},
+				Msg:        "required",
+				Type:       "missing",
+				ErrorCount: 1,
+			}},
+			ServerErrors: []ServerErrorsItem{{
+				Consumer:   "consumer1",
+				Method:     "GET",
+				Path:       "/items/{id}",
+				Type:       "*errors.errorString",
+				Message:    "failed",
+				StackTrace: "main.handler()",
+				ErrorCount: 1,
+			}},
+			Timeouts:   []TimeoutsItem{{Method: "GET", Path: "/slow", TimeoutCount: 1}},
+			UserAgents: []UserAgentsItem{{Method: "GET", Path: "/items/{id}", Category: "client", Family: "curl", RequestCount: 2}},
+			RateLimits: []RateLimitsItem{{Consumer: "consumer1", RequestCount: 2, LimitedCount: 1, MinRemaining: &minRemaining}},
+			Tasks:      []TasksItem{{Name: "cleanup", ExecutionCount: 1, DurationSum: 15, Durations: map[int]int{15: 1}}},
+			Messages:   []MessagesItem{{System: "kafka", Queue: "orders", MessageCount: 1, DurationSum: 8, Durations: map[int]int{8: 1}}},
+			Heartbeats: []HeartbeatsItem{{Name: "nightly", Count: 1, LastTimestamp: 1699999990, ExpectedInterval: 86400}},
+			Consumers:  []ConsumersItem{{Consumer: &common.Consumer{Identifier: "consumer1", Name: "Consumer 1", Group: "Group 1"}, FirstSeen: 1699999000, LastSeen: 1699999990}},
+			Resources:  &ResourceUsage{CpuPercent: 12.5, MemoryRss: 104857600},
+			SdkStats:   &SdkStats{DroppedSyncPayloads: 1},
+		}
+		assertGolden(t, "sync_payload.json", payload)
+	})
+
+	t.Run("RequestLogItemGolden", func(t *testing.T) {
+		item := RequestLogItem{
+			SchemaVersion: requestLogItemSchemaVersion,
+			UUID:          "00000000-0000-0000-0000-000000000003",
+			Request: &common.Request{
+				Timestamp: 1700000000,
+				Method:    "POST",
+				Path:      "/items",
+				URL:       "https://example.com/items",
+				Headers:   [][2]string{{"Content-Type", "application/json"}},
+				Size:      13,
+				Consumer:  "consumer1",
+				Body:      []byte(`{"name":"a"}`),
+			},
+			Response: &common.Response{
+				StatusCode:   500,
+				ResponseTime: 0.123,
+				Headers:      [][2]string{{"Content-Type", "application/json"}},
+				Size:         2,
+				Body:         []byte(`{}`),
+			},
+			Exception: &ExceptionInfo{
+				Type:       "*errors.errorString",
+				Message:    "failed",
+				StackTrace: "main.handler()",
+				Frames:     []StackFrame{{Function: "main.handler", File: "main.go", Line: 10}},
+			},
+			Logs: []LogRecord{{Timestamp: 1700000000.1, Logger: "app", Level: "ERROR", Message: "failed"}},
+			Spans: []SpanData{{
+				SpanID:     "0000000000000001",
+				Name:       "db.query",
+				Kind:       "CLIENT",
+				StartTime:  1700000000000000000,
+				EndTime:    1700000000100000000,
+				Attributes: map[string]any{"db.system": "postgresql"},
+			}},
+			TraceID: "00000000000000000000000000000001",
+		}
+		assertGolden(t, "request_log_item.json", item)
+	})
+}
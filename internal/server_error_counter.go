@@ -8,6 +8,10 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apitally/apitally-go/common"
 )
 
 const (
@@ -15,6 +19,16 @@ const (
 	maxStacktraceLength = 65536
 )
 
+// maxServerErrorKeys caps the number of distinct server errors with details captured
+// per sync interval, so that a storm of different panics can't exhaust memory or bloat
+// the sync payload. Excess errors are still counted, per method, under an overflow key
+// without details. The stack trace of the same error is captured at most once per
+// stackTraceCaptureInterval, as it's usually unchanged.
+const (
+	maxServerErrorKeys        = 100
+	stackTraceCaptureInterval = 10 * time.Minute
+)
+
 var hexAddressRegex = regexp.MustCompile(`0x[0-9a-fA-F]+`)
 var goRoutineRegex = regexp.MustCompile(`goroutine \d+`)
 
@@ -30,30 +44,61 @@ type ServerErrorsItem struct {
 }
 
 type ServerErrorCounter struct {
-	errorCounts  map[string]int
-	errorDetails map[string]ServerErrorsItem
-	mutex        sync.Mutex
+	errorCounts          map[string]int
+	errorDetails         map[string]ServerErrorsItem
+	stackTraceCapturedAt map[string]time.Time
+	overflowCount        int64
+	fingerprintCallback  atomic.Pointer[func(err error, stackTrace string) string]
+	mutex                sync.Mutex
 }
 
 func NewServerErrorCounter() *ServerErrorCounter {
 	return &ServerErrorCounter{
-		errorCounts:  make(map[string]int),
-		errorDetails: make(map[string]ServerErrorsItem),
+		errorCounts:          make(map[string]int),
+		errorDetails:         make(map[string]ServerErrorsItem),
+		stackTraceCapturedAt: make(map[string]time.Time),
+	}
+}
+
+// SetFingerprintCallback sets the function returning the fingerprint by which errors
+// are grouped instead of their type, message and stack trace, unless it's empty.
+func (sc *ServerErrorCounter) SetFingerprintCallback(callback func(err error, stackTrace string) string) {
+	if callback == nil {
+		sc.fingerprintCallback.Store(nil)
+		return
+	}
+	sc.fingerprintCallback.Store(&callback)
+}
+
+func (sc *ServerErrorCounter) getFingerprint(handlerError error, stackTrace string) string {
+	callback := sc.fingerprintCallback.Load()
+	if callback == nil {
+		return ""
 	}
+	return (*callback)(handlerError, stackTrace)
 }
 
 func (sc *ServerErrorCounter) AddServerError(consumer, method, path string, handlerError error, stackTrace string) {
 	errorType := getErrorType(handlerError)
 	errorMessage := handlerError.Error()
 
-	// Generate key using MD5 hash of error details
-	hashInput := fmt.Sprintf("%s|%s|%s|%s|%s|%s",
-		consumer,
-		strings.ToUpper(method),
-		path,
-		errorType,
-		errorMessage,
-		stripStackTraceForHashing(stackTrace))
+	// Generate key using MD5 hash of the fingerprint or error details
+	var hashInput string
+	if fingerprint := sc.getFingerprint(handlerError, stackTrace); fingerprint != "" {
+		hashInput = fmt.Sprintf("fingerprint|%s|%s|%s|%s",
+			consumer,
+			strings.ToUpper(method),
+			path,
+			fingerprint)
+	} else {
+		hashInput = fmt.Sprintf("%s|%s|%s|%s|%s|%s",
+			consumer,
+			strings.ToUpper(method),
+			path,
+			errorType,
+			errorMessage,
+			stripStackTraceForHashing(stackTrace[:min(len(stackTrace), maxStacktraceLength)]))
+	}
 	key := fmt.Sprintf("%x", md5.Sum([]byte(hashInput)))
 
 	sc.mutex.Lock()
@@ -61,13 +106,31 @@ func (sc *ServerErrorCounter) AddServerError(consumer, method, path string, hand
 
 	// Store error details if not already present
 	if _, exists := sc.errorDetails[key]; !exists {
-		sc.errorDetails[key] = ServerErrorsItem{
-			Consumer:   consumer,
-			Method:     method,
-			Path:       path,
-			Type:       errorType,
-			Message:    truncateExceptionMessage(errorMessage),
-			StackTrace: truncateExceptionStackTrace(stackTrace),
+		if len(sc.errorDetails) >= maxServerErrorKeys {
+			sc.overflowCount++
+			key = overflowKeyValue + "|" + strings.ToUpper(method)
+			if _, exists := sc.errorDetails[key]; !exists {
+				sc.errorDetails[key] = ServerErrorsItem{
+					Method: method,
+					Path:   overflowKeyValue,
+					Type:   overflowKeyValue,
+				}
+			}
+		} else {
+			now := time.Now()
+			if capturedAt, ok := sc.stackTraceCapturedAt[key]; ok && now.Sub(capturedAt) < stackTraceCaptureInterval {
+				stackTrace = ""
+			} else {
+				sc.stackTraceCapturedAt[key] = now
+			}
+			sc.errorDetails[key] = ServerErrorsItem{
+				Consumer:   consumer,
+				Method:     method,
+				Path:       path,
+				Type:       errorType,
+				Message:    truncateExceptionMessage(errorMessage),
+				StackTrace: truncateExceptionStackTrace(stackTrace),
+			}
 		}
 	}
 
@@ -75,12 +138,36 @@ func (sc *ServerErrorCounter) AddServerError(consumer, method, path string, hand
 	sc.errorCounts[key]++
 }
 
+// GetServerErrors returns the server errors counted since the last reset, without
+// resetting them.
+func (sc *ServerErrorCounter) GetServerErrors() []ServerErrorsItem {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	return sc.items()
+}
+
 func (sc *ServerErrorCounter) GetAndResetServerErrors() []ServerErrorsItem {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 
-	data := make([]ServerErrorsItem, 0, len(sc.errorCounts))
+	data := sc.items()
 
+	// Reset all maps, but remember recently captured stack traces
+	sc.errorCounts = make(map[string]int)
+	sc.errorDetails = make(map[string]ServerErrorsItem)
+	for key, capturedAt := range sc.stackTraceCapturedAt {
+		if time.Since(capturedAt) >= stackTraceCaptureInterval {
+			delete(sc.stackTraceCapturedAt, key)
+		}
+	}
+
+	return data
+}
+
+// items returns the counted server errors. The caller must hold the mutex.
+func (sc *ServerErrorCounter) items() []ServerErrorsItem {
+	data := make([]ServerErrorsItem, 0, len(sc.errorCounts))
 	for key, count := range sc.errorCounts {
 		if details, exists := sc.errorDetails[key]; exists {
 			item := details
@@ -88,15 +175,25 @@ func (sc *ServerErrorCounter) GetAndResetServerErrors() []ServerErrorsItem {
 			data = append(data, item)
 		}
 	}
-
-	// Reset all maps
-	sc.errorCounts = make(map[string]int)
-	sc.errorDetails = make(map[string]ServerErrorsItem)
-
 	return data
 }
 
+// GetAndResetOverflowCount returns the number of server errors counted under an
+// overflow key since the last call.
+func (sc *ServerErrorCounter) GetAndResetOverflowCount() int64 {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	count := sc.overflowCount
+	sc.overflowCount = 0
+	return count
+}
+
+// getErrorType returns the type name of the error, or of the original panic value if
+// the error is a common.PanicError.
 func getErrorType(err error) string {
+	if panicErr, ok := err.(*common.PanicError); ok {
+		return panicErr.TypeName()
+	}
 	errorType := reflect.TypeOf(err)
 	if errorType.Kind() == reflect.Ptr {
 		errorType = errorType.Elem()
@@ -2,9 +2,12 @@ package internal
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/apitally/apitally-go/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -65,4 +68,98 @@ func TestServerErrorCounter(t *testing.T) {
 		assert.Equal(t, 3, errorCounts["test error 1"])
 		assert.Equal(t, 1, errorCounts["test error 2"])
 	})
+
+	t.Run("PanicValueType", func(t *testing.T) {
+		type customPanic struct{ Code int }
+		serverErrorCounter := NewServerErrorCounter()
+		serverErrorCounter.AddServerError("", "GET", "/test", common.NewPanicError("test panic"), "")
+		serverErrorCounter.AddServerError("", "GET", "/test", common.NewPanicError(customPanic{Code: 1}), "")
+		serverErrorCounter.AddServerError("", "GET", "/test", common.NewPanicError(errors.New("test error")), "")
+
+		serverErrors := serverErrorCounter.GetAndResetServerErrors()
+		assert.Len(t, serverErrors, 3)
+		errorTypes := make(map[string]string)
+		for _, e := range serverErrors {
+			errorTypes[e.Message] = e.Type
+		}
+		assert.Equal(t, map[string]string{
+			"test panic": "string",
+			"{Code:1}":   "internal.customPanic",
+			"test error": "errors.errorString",
+		}, errorTypes)
+	})
+
+	t.Run("FingerprintCallback", func(t *testing.T) {
+		serverErrorCounter := NewServerErrorCounter()
+		serverErrorCounter.SetFingerprintCallback(func(err error, stackTrace string) string {
+			if strings.HasPrefix(err.Error(), "db timeout") {
+				return "db-timeout"
+			}
+			return ""
+		})
+
+		serverErrorCounter.AddServerError("", "GET", "/items", errors.New("db timeout: SELECT * FROM items"), "stack 1")
+		serverErrorCounter.AddServerError("", "GET", "/items", errors.New("db timeout: SELECT * FROM users"), "stack 2")
+		serverErrorCounter.AddServerError("", "GET", "/other", errors.New("db timeout: SELECT 1"), "stack 1")
+		serverErrorCounter.AddServerError("", "GET", "/items", errors.New("other error 1"), "stack 1")
+		serverErrorCounter.AddServerError("", "GET", "/items", errors.New("other error 2"), "stack 1")
+
+		serverErrors := serverErrorCounter.GetAndResetServerErrors()
+		assert.Len(t, serverErrors, 4)
+		errorCounts := make(map[string]int)
+		for _, e := range serverErrors {
+			errorCounts[e.Path+" "+e.Message] = e.ErrorCount
+		}
+		assert.Equal(t, 2, errorCounts["/items db timeout: SELECT * FROM items"])
+		assert.Equal(t, 1, errorCounts["/other db timeout: SELECT 1"])
+		assert.Equal(t, 1, errorCounts["/items other error 1"])
+		assert.Equal(t, 1, errorCounts["/items other error 2"])
+	})
+
+	t.Run("MaxKeys", func(t *testing.T) {
+		serverErrorCounter := NewServerErrorCounter()
+		for i := 0; i < maxServerErrorKeys+10; i++ {
+			serverErrorCounter.AddServerError("", "GET", "/test", fmt.Errorf("error %d", i), "stack")
+		}
+		serverErrorCounter.AddServerError("", "POST", "/test", errors.New("another error"), "stack")
+
+		serverErrors := serverErrorCounter.GetAndResetServerErrors()
+		assert.Len(t, serverErrors, maxServerErrorKeys+2)
+		overflowCounts := make(map[string]int)
+		for _, e := range serverErrors {
+			if e.Path == overflowKeyValue {
+				assert.Equal(t, overflowKeyValue, e.Type)
+				assert.Empty(t, e.StackTrace)
+				overflowCounts[e.Method] = e.ErrorCount
+			}
+		}
+		assert.Equal(t, map[string]int{"GET": 10, "POST": 1}, overflowCounts)
+		assert.Equal(t, int64(11), serverErrorCounter.GetAndResetOverflowCount())
+		assert.Equal(t, int64(0), serverErrorCounter.GetAndResetOverflowCount())
+	})
+
+	t.Run("StackTraceCaptureInterval", func(t *testing.T) {
+		serverErrorCounter := NewServerErrorCounter()
+		err := errors.New("test error")
+
+		serverErrorCounter.AddServerError("", "GET", "/test", err, "stack")
+		serverErrors := serverErrorCounter.GetAndResetServerErrors()
+		assert.Len(t, serverErrors, 1)
+		assert.Equal(t, "stack", serverErrors[0].StackTrace)
+
+		// The same error is still counted, but its stack trace isn't captured again
+		serverErrorCounter.AddServerError("", "GET", "/test", err, "stack")
+		serverErrors = serverErrorCounter.GetAndResetServerErrors()
+		assert.Len(t, serverErrors, 1)
+		assert.Equal(t, 1, serverErrors[0].ErrorCount)
+		assert.Empty(t, serverErrors[0].StackTrace)
+
+		// Once the interval has passed, the stack trace is captured again
+		for key := range serverErrorCounter.stackTraceCapturedAt {
+			serverErrorCounter.stackTraceCapturedAt[key] = time.Now().Add(-stackTraceCaptureInterval)
+		}
+		serverErrorCounter.AddServerError("", "GET", "/test", err, "stack")
+		serverErrors = serverErrorCounter.GetAndResetServerErrors()
+		assert.Equal(t, "stack", serverErrors[0].StackTrace)
+	})
 }
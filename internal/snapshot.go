@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"github.com/apitally/apitally-go/common"
+)
+
+// Snapshot returns a copy of the metrics aggregated since the last sync and of the
+// consumers seen since startup, without resetting them, e.g. to show recent traffic on
+// an admin page.
+func (c *ApitallyClient) Snapshot() common.Snapshot {
+	c.remoteMutex.Lock()
+	since := c.lastResetTime
+	c.remoteMutex.Unlock()
+
+	snapshot := common.Snapshot{
+		Since:            since,
+		Requests:         []common.RequestMetrics{},
+		ValidationErrors: []common.ValidationErrorMetrics{},
+		ServerErrors:     []common.ServerErrorMetrics{},
+		Consumers:        c.ConsumerRegistry.GetConsumers(),
+	}
+	for _, item := range c.RequestCounter.GetRequests() {
+		metrics := common.RequestMetrics{
+			Consumer:        item.Consumer,
+			Method:          item.Method,
+			Path:            item.Path,
+			StatusCode:      item.StatusCode,
+			TrafficClass:    item.TrafficClass,
+			RequestCount:    item.RequestCount,
+			RequestSizeSum:  item.RequestSizeSum,
+			ResponseSizeSum: item.ResponseSizeSum,
+		}
+		if percentiles := item.ResponseTimePercentiles; percentiles != nil {
+			metrics.ResponseTimeP50 = percentiles.P50
+			metrics.ResponseTimeP95 = percentiles.P95
+			metrics.ResponseTimeP99 = percentiles.P99
+		}
+		snapshot.Requests = append(snapshot.Requests, metrics)
+	}
+	for _, item := range c.ValidationErrorCounter.GetValidationErrors() {
+		snapshot.ValidationErrors = append(snapshot.ValidationErrors, common.ValidationErrorMetrics{
+			Consumer:   item.Consumer,
+			Method:     item.Method,
+			Path:       item.Path,
+			Location:   item.Loc,
+			Message:    item.Msg,
+			Type:       item.Type,
+			ErrorCount: item.ErrorCount,
+		})
+	}
+	for _, item := range c.ServerErrorCounter.GetServerErrors() {
+		snapshot.ServerErrors = append(snapshot.ServerErrors, common.ServerErrorMetrics{
+			Consumer:   item.Consumer,
+			Method:     item.Method,
+			Path:       item.Path,
+			Type:       item.Type,
+			Message:    item.Message,
+			ErrorCount: item.ErrorCount,
+		})
+	}
+	return snapshot
+}
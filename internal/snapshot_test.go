@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	ResetApitallyClient()
+
+	config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+	config.Env = "test"
+	httpClient, _ := createMockHTTPClient()
+	client := InitApitallyClientWithHTTPClient(*config, httpClient)
+	defer client.Shutdown()
+
+	client.RequestCounter.AddRequest(RequestInfo{
+		Consumer:     "tester",
+		Method:       "GET",
+		Path:         "/items",
+		StatusCode:   200,
+		ResponseTime: 0.05,
+		ResponseSize: 100,
+	})
+	client.ValidationErrorCounter.AddValidationError("tester", "POST", "/items", "body.name", "required", "missing")
+	client.ServerErrorCounter.AddServerError("tester", "GET", "/items", errors.New("test"), "")
+	client.ConsumerRegistry.AddOrUpdateConsumer(&common.Consumer{Identifier: "tester", Name: "Tester"})
+
+	snapshot := client.Snapshot()
+	assert.False(t, snapshot.Since.IsZero())
+	assert.Len(t, snapshot.Requests, 1)
+	assert.Equal(t, "GET", snapshot.Requests[0].Method)
+	assert.Equal(t, "/items", snapshot.Requests[0].Path)
+	assert.Equal(t, 1, snapshot.Requests[0].RequestCount)
+	assert.Equal(t, int64(100), snapshot.Requests[0].ResponseSizeSum)
+	assert.Greater(t, snapshot.Requests[0].ResponseTimeP50, 0.0)
+	assert.Len(t, snapshot.ValidationErrors, 1)
+	assert.Equal(t, []string{"body", "name"}, snapshot.ValidationErrors[0].Location)
+	assert.Len(t, snapshot.ServerErrors, 1)
+	assert.Equal(t, "errors.errorString", snapshot.ServerErrors[0].Type)
+	assert.Equal(t, []common.Consumer{{Identifier: "tester", Name: "Tester"}}, snapshot.Consumers)
+
+	// Taking a snapshot doesn't reset the counters
+	client.RequestCounter.AddRequest(RequestInfo{Consumer: "tester", Method: "GET", Path: "/items", StatusCode: 200, ResponseTime: 0.05})
+	assert.Equal(t, 2, client.Snapshot().Requests[0].RequestCount)
+	assert.Equal(t, 1, snapshot.Requests[0].RequestCount)
+
+	// Syncing resets the counters, but consumers are kept
+	client.sendSyncData()
+	snapshot2 := client.Snapshot()
+	assert.True(t, snapshot2.Since.After(snapshot.Since))
+	assert.Empty(t, snapshot2.Requests)
+	assert.Empty(t, snapshot2.ValidationErrors)
+	assert.Empty(t, snapshot2.ServerErrors)
+	assert.Len(t, snapshot2.Consumers, 1)
+}
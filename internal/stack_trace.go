@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"path"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const maxStackFrames = 100
+
+// StackFrame is a frame of a stack trace. Function names and file paths in the main
+// module are relative to the module path.
+type StackFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+var (
+	stackFrameFileRegex = regexp.MustCompile(`^\t(.+):(\d+)(?: \+0x[0-9a-f]+)?$`)
+
+	// Frames of the Go runtime, net/http and the Apitally middleware recovering and
+	// re-panicking are not helpful to locate the cause of a panic
+	excludeStackFramePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^runtime(/debug)?\.`),
+		regexp.MustCompile(`^panic$`),
+		regexp.MustCompile(`^net/http\.`),
+		regexp.MustCompile(`^github\.com/apitally/apitally-go/`),
+	}
+)
+
+var getMainModulePath = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	return info.Main.Path
+})
+
+// getStackFrames parses a stack trace formatted by debug.Stack into frames, excluding
+// frames according to the config.
+func (rl *RequestLogger) getStackFrames(stackTrace string) []StackFrame {
+	config := rl.getConfig()
+	patterns := config.ExcludeStackFrames
+	if !config.DisableDefaultStackFrameExclusions {
+		patterns = append(slices.Clone(excludeStackFramePatterns), patterns...)
+	}
+	modulePath := getMainModulePath()
+
+	var frames []StackFrame
+	for _, frame := range parseStackTrace(stackTrace) {
+		if len(frames) >= maxStackFrames {
+			break
+		}
+		if slices.ContainsFunc(patterns, func(pattern *regexp.Regexp) bool { return pattern.MatchString(frame.Function) }) {
+			continue
+		}
+		frames = append(frames, elideModulePath(frame, modulePath))
+	}
+	return frames
+}
+
+// parseStackTrace parses a stack trace formatted by debug.Stack, which consists of
+// pairs of lines with the function and its arguments, followed by the file and line.
+func parseStackTrace(stackTrace string) []StackFrame {
+	lines := strings.Split(stackTrace, "\n")
+	var frames []StackFrame
+	for i := 0; i+1 < len(lines); i++ {
+		match := stackFrameFileRegex.FindStringSubmatch(lines[i+1])
+		if match == nil || strings.HasPrefix(lines[i], "\t") {
+			continue
+		}
+		function := strings.TrimPrefix(lines[i], "created by ")
+		if j := strings.Index(function, " in goroutine "); j >= 0 {
+			function = function[:j]
+		}
+		line, _ := strconv.Atoi(match[2])
+		frames = append(frames, StackFrame{
+			Function: trimFunctionArgs(function),
+			File:     match[1],
+			Line:     line,
+		})
+		i++
+	}
+	return frames
+}
+
+// trimFunctionArgs removes the arguments from a function in a stack trace, e.g.
+// "main.(*T).Method(0xc000010000, {0x1, 0x2})" becomes "main.(*T).Method".
+func trimFunctionArgs(function string) string {
+	if !strings.HasSuffix(function, ")") {
+		return function
+	}
+	depth := 0
+	for i := len(function) - 1; i >= 0; i-- {
+		switch function[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				return function[:i]
+			}
+		}
+	}
+	return function
+}
+
+// elideModulePath removes the main module path from the function name and file path
+// of a frame in the main module, e.g. "github.com/acme/app/handlers.GetItem" in
+// "/src/app/handlers/items.go" becomes "handlers.GetItem" in "handlers/items.go".
+func elideModulePath(frame StackFrame, modulePath string) StackFrame {
+	if modulePath == "" {
+		return frame
+	}
+	rest, ok := strings.CutPrefix(frame.Function, modulePath)
+	if !ok || rest == "" {
+		return frame
+	}
+	switch rest[0] {
+	case '.':
+		// Package at the root of the module
+		frame.Function = path.Base(modulePath) + rest
+		frame.File = path.Base(frame.File)
+	case '/':
+		frame.Function = rest[1:]
+		// The package path ends at the first dot after the last slash, ignoring type
+		// parameters and receivers, which may contain slashes themselves
+		packagePath := frame.Function
+		if i := strings.IndexAny(packagePath, "[("); i >= 0 {
+			packagePath = packagePath[:i]
+		}
+		lastSlash := strings.LastIndex(packagePath, "/")
+		if i := strings.Index(packagePath[lastSlash+1:], "."); i >= 0 {
+			packagePath = packagePath[:lastSlash+1+i]
+		}
+		frame.File = packagePath + "/" + path.Base(frame.File)
+	}
+	return frame
+}
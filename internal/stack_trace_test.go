@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const testStackTrace = `goroutine 7 [running]:
+runtime/debug.Stack()
+	/usr/local/go/src/runtime/debug/stack.go:26 +0x5e
+github.com/apitally/apitally-go/chi-v5.Middleware.func1.1.1()
+	/go/pkg/mod/github.com/apitally/apitally-go/chi-v5@v1.0.0/middleware.go:150 +0x1ab
+panic({0x8a5f20?, 0xa1b2c0?})
+	/usr/local/go/src/runtime/panic.go:770 +0x132
+github.com/acme/app/handlers.(*ItemHandler).Get(0xc000010000, {0xa1c2d0, 0xc0000a8000}, 0xc0000b4000)
+	/src/app/handlers/items.go:42 +0x1d
+github.com/acme/app/handlers.Map[...](...)
+	/src/app/handlers/generic.go:10
+net/http.HandlerFunc.ServeHTTP(0xc000012345?, {0xa1c2d0?, 0xc0000a8000?}, 0x0?)
+	/usr/local/go/src/net/http/server.go:2166 +0x29
+created by net/http.(*Server).Serve in goroutine 1
+	/usr/local/go/src/net/http/server.go:3285 +0x4b4
+`
+
+func TestParseStackTrace(t *testing.T) {
+	frames := parseStackTrace(testStackTrace)
+	assert.Len(t, frames, 7)
+	assert.Equal(t, StackFrame{Function: "runtime/debug.Stack", File: "/usr/local/go/src/runtime/debug/stack.go", Line: 26}, frames[0])
+	assert.Equal(t, StackFrame{Function: "panic", File: "/usr/local/go/src/runtime/panic.go", Line: 770}, frames[2])
+	assert.Equal(t, StackFrame{Function: "github.com/acme/app/handlers.(*ItemHandler).Get", File: "/src/app/handlers/items.go", Line: 42}, frames[3])
+	assert.Equal(t, StackFrame{Function: "github.com/acme/app/handlers.Map[...]", File: "/src/app/handlers/generic.go", Line: 10}, frames[4])
+	assert.Equal(t, StackFrame{Function: "net/http.(*Server).Serve", File: "/usr/local/go/src/net/http/server.go", Line: 3285}, frames[6])
+}
+
+func TestElideModulePath(t *testing.T) {
+	frame := elideModulePath(StackFrame{Function: "github.com/acme/app/handlers.(*ItemHandler).Get", File: "/src/app/handlers/items.go", Line: 42}, "github.com/acme/app")
+	assert.Equal(t, StackFrame{Function: "handlers.(*ItemHandler).Get", File: "handlers/items.go", Line: 42}, frame)
+
+	frame = elideModulePath(StackFrame{Function: "github.com/acme/app/internal/db.Query[...]", File: "github.com/acme/app/internal/db/query.go", Line: 7}, "github.com/acme/app")
+	assert.Equal(t, StackFrame{Function: "internal/db.Query[...]", File: "internal/db/query.go", Line: 7}, frame)
+
+	frame = elideModulePath(StackFrame{Function: "github.com/acme/app.Run", File: "/src/app/app.go", Line: 3}, "github.com/acme/app")
+	assert.Equal(t, StackFrame{Function: "app.Run", File: "app.go", Line: 3}, frame)
+
+	frame = elideModulePath(StackFrame{Function: "github.com/acme/application.Run", File: "/src/application/app.go", Line: 3}, "github.com/acme/app")
+	assert.Equal(t, "github.com/acme/application.Run", frame.Function)
+
+	frame = elideModulePath(StackFrame{Function: "main.main", File: "/src/app/main.go", Line: 3}, "")
+	assert.Equal(t, "main.main", frame.Function)
+}
+
+func TestGetStackFrames(t *testing.T) {
+	t.Run("DefaultExclusions", func(t *testing.T) {
+		requestLogger := NewRequestLogger(common.NewRequestLoggingConfig())
+		defer requestLogger.Close()
+
+		frames := requestLogger.getStackFrames(testStackTrace)
+		functions := make([]string, len(frames))
+		for i, frame := range frames {
+			functions[i] = frame.Function
+		}
+		assert.Equal(t, []string{
+			"github.com/acme/app/handlers.(*ItemHandler).Get",
+			"github.com/acme/app/handlers.Map[...]",
+		}, functions)
+	})
+
+	t.Run("CustomExclusions", func(t *testing.T) {
+		config := common.NewRequestLoggingConfig()
+		config.ExcludeStackFrames = []*regexp.Regexp{regexp.MustCompile(`\.Map\[`)}
+		config.DisableDefaultStackFrameExclusions = true
+		requestLogger := NewRequestLogger(config)
+		defer requestLogger.Close()
+
+		frames := requestLogger.getStackFrames(testStackTrace)
+		assert.Len(t, frames, 6)
+		assert.Equal(t, "runtime/debug.Stack", frames[0].Function)
+	})
+
+	t.Run("DebugStack", func(t *testing.T) {
+		requestLogger := NewRequestLogger(common.NewRequestLoggingConfig())
+		defer requestLogger.Close()
+
+		// Frames of this package are excluded, as it's part of the Apitally SDK
+		frames := requestLogger.getStackFrames(string(debug.Stack()))
+		assert.NotEmpty(t, frames)
+		assert.Equal(t, "testing.tRunner", frames[0].Function)
+		assert.True(t, strings.HasSuffix(frames[0].File, "testing.go"), frames[0].File)
+		assert.Greater(t, frames[0].Line, 0)
+	})
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/apitally/apitally-go/common"
+)
+
+// Status returns a snapshot of the client's state, e.g. to monitor the SDK's health.
+func (c *ApitallyClient) Status() common.Status {
+	c.remoteMutex.Lock()
+	lastSyncTime := c.lastSyncTime
+	c.remoteMutex.Unlock()
+
+	status := common.Status{
+		Enabled:                 c.IsEnabled(),
+		QueuedSyncPayloads:      len(c.syncDataChan),
+		PendingLogFiles:         c.RequestLogger.PendingFileCount(),
+		RequestLoggingEnabled:   c.RequestLogger.IsEnabled(),
+		RequestLoggingSuspended: c.RequestLogger.IsSuspended(),
+		RequestLoggingSaturated: c.RequestLogger.IsSaturated(),
+		RequestLoggingDegraded:  c.RequestLogger.IsDegraded(),
+		HubReachable:            c.circuitBreaker.OpenUntil().IsZero() && !time.Now().Before(c.getRetryAfter()),
+		DroppedSyncPayloads:     c.droppedPayloads.Load(),
+		DroppedLogItems:         c.RequestLogger.DroppedItems(),
+		SkippedLogItems:         c.RequestLogger.SkippedItems(),
+	}
+	if !lastSyncTime.IsZero() {
+		status.LastSuccessfulSync = &lastSyncTime
+	}
+	return status
+}
+
+// StatusHandler returns an http.Handler responding with the status of the client as
+// JSON, or with 503 Service Unavailable if the client hasn't been initialized.
+func StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := GetApitallyClient()
+		if client == nil {
+			http.Error(w, common.ErrNotInitialized.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(client.Status())
+	})
+}
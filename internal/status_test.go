@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	t.Run("Status", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		httpClient, _ := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		status := client.Status()
+		assert.True(t, status.Enabled)
+		assert.Nil(t, status.LastSuccessfulSync)
+		assert.True(t, status.HubReachable)
+
+		client.sendSyncData()
+		status = client.Status()
+		assert.NotNil(t, status.LastSuccessfulSync)
+		assert.Equal(t, 0, status.QueuedSyncPayloads)
+
+		for i := 0; i < circuitBreakerThreshold; i++ {
+			client.circuitBreaker.RecordFailure()
+		}
+		status = client.Status()
+		assert.False(t, status.HubReachable)
+	})
+
+	t.Run("MemoryPressure", func(t *testing.T) {
+		ResetApitallyClient()
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		config.RequestLogging.Enabled = true
+		config.RequestLogging.LogResponseBody = true
+		config.RequestLogging.DegradeAboveMemory = 100_000_000
+		httpClient, _ := createMockHTTPClient()
+		client := InitApitallyClientWithHTTPClient(*config, httpClient)
+		defer client.Shutdown()
+
+		client.checkMemoryPressure(&ResourceUsage{MemoryRss: 95_000_000})
+		assert.False(t, client.Status().RequestLoggingDegraded)
+		assert.True(t, client.RequestLogger.ShouldLogResponseBody())
+
+		client.checkMemoryPressure(&ResourceUsage{MemoryRss: 120_000_000})
+		assert.True(t, client.Status().RequestLoggingDegraded)
+		assert.False(t, client.RequestLogger.ShouldLogResponseBody())
+
+		// Stays degraded until memory usage drops below 90% of the threshold
+		client.checkMemoryPressure(&ResourceUsage{MemoryRss: 95_000_000})
+		assert.True(t, client.Status().RequestLoggingDegraded)
+
+		client.checkMemoryPressure(&ResourceUsage{MemoryRss: 85_000_000})
+		assert.False(t, client.Status().RequestLoggingDegraded)
+		assert.True(t, client.RequestLogger.ShouldLogResponseBody())
+	})
+
+	t.Run("StatusHandler", func(t *testing.T) {
+		ResetApitallyClient()
+
+		w := httptest.NewRecorder()
+		StatusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		config := common.NewConfig("e117eb33-f6d2-4260-a71d-31eb49425893")
+		config.Env = "test"
+		client := InitApitallyClient(*config)
+		defer client.Shutdown()
+
+		w = httptest.NewRecorder()
+		StatusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var status common.Status
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+		assert.True(t, status.Enabled)
+	})
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// splitSyncPayload splits a payload whose JSON encoding exceeds maxSize bytes into
+// multiple chunks, each with its own message UUID, so the hub can deduplicate them
+// independently when they are retried. Resource usage, SDK stats and goroutine leak
+// warnings are only included in the first chunk. Single items larger than maxSize are
+// sent in a chunk of their own.
+func splitSyncPayload(payload SyncPayload, maxSize int) []SyncPayload {
+	data, err := json.Marshal(payload)
+	if err != nil || len(data) <= maxSize {
+		return []SyncPayload{payload}
+	}
+
+	chunks := []SyncPayload{}
+	newChunk := func() (SyncPayload, int) {
+		chunk := SyncPayload{
+			SchemaVersion: payload.SchemaVersion,
+			Timestamp:     payload.Timestamp,
+			InstanceUUID:  payload.InstanceUUID,
+			MessageUUID:   payload.MessageUUID,
+			WorkerSlot:    payload.WorkerSlot,
+			Uptime:        payload.Uptime,
+			Requests:      []RequestsItem{},
+		}
+		if len(chunks) == 0 {
+			chunk.Resources = payload.Resources
+			chunk.SdkStats = payload.SdkStats
+			chunk.GoroutineLeak = payload.GoroutineLeak
+		} else {
+			chunk.MessageUUID = uuid.New().String()
+		}
+		// Account for the keys of lists that are omitted while empty
+		return chunk, getJSONSize(chunk) + len(`,"validation_errors":[],"server_errors":[],"timeouts":[],"user_agents":[],"rate_limits":[],"tasks":[],"messages":[],"heartbeats":[],"consumers":[],"deleted_consumers":[]`)
+	}
+
+	chunk, chunkSize := newChunk()
+	itemCount := 0
+	add := func(item any, apply func(chunk *SyncPayload)) {
+		itemSize := getJSONSize(item) + 1
+		if itemCount > 0 && chunkSize+itemSize > maxSize {
+			chunks = append(chunks, chunk)
+			chunk, chunkSize = newChunk()
+			itemCount = 0
+		}
+		apply(&chunk)
+		chunkSize += itemSize
+		itemCount++
+	}
+
+	for _, item := range payload.Requests {
+		add(item, func(chunk *SyncPayload) { chunk.Requests = append(chunk.Requests, item) })
+	}
+	for _, item := range payload.ValidationErrors {
+		add(item, func(chunk *SyncPayload) { chunk.ValidationErrors = append(chunk.ValidationErrors, item) })
+	}
+	for _, item := range payload.ServerErrors {
+		add(item, func(chunk *SyncPayload) { chunk.ServerErrors = append(chunk.ServerErrors, item) })
+	}
+	for _, item := range payload.Timeouts {
+		add(item, func(chunk *SyncPayload) { chunk.Timeouts = append(chunk.Timeouts, item) })
+	}
+	for _, item := range payload.UserAgents {
+		add(item, func(chunk *SyncPayload) { chunk.UserAgents = append(chunk.UserAgents, item) })
+	}
+	for _, item := range payload.RateLimits {
+		add(item, func(chunk *SyncPayload) { chunk.RateLimits = append(chunk.RateLimits, item) })
+	}
+	for _, item := range payload.Tasks {
+		add(item, func(chunk *SyncPayload) { chunk.Tasks = append(chunk.Tasks, item) })
+	}
+	for _, item := range payload.Messages {
+		add(item, func(chunk *SyncPayload) { chunk.Messages = append(chunk.Messages, item) })
+	}
+	for _, item := range payload.Heartbeats {
+		add(item, func(chunk *SyncPayload) { chunk.Heartbeats = append(chunk.Heartbeats, item) })
+	}
+	for _, item := range payload.Consumers {
+		add(item, func(chunk *SyncPayload) { chunk.Consumers = append(chunk.Consumers, item) })
+	}
+	for _, item := range payload.DeletedConsumers {
+		add(item, func(chunk *SyncPayload) { chunk.DeletedConsumers = append(chunk.DeletedConsumers, item) })
+	}
+
+	return append(chunks, chunk)
+}
+
+func getJSONSize(v any) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
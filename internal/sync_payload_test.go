@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitSyncPayload(t *testing.T) {
+	t.Run("SmallPayload", func(t *testing.T) {
+		payload := SyncPayload{
+			MessageUUID: "message1",
+			Requests:    []RequestsItem{{Method: "GET", Path: "/test", StatusCode: 200}},
+		}
+		chunks := splitSyncPayload(payload, 1024)
+		assert.Len(t, chunks, 1)
+		assert.Equal(t, payload, chunks[0])
+	})
+
+	t.Run("LargePayload", func(t *testing.T) {
+		workerSlot := 2
+		payload := SyncPayload{
+			Timestamp:    1,
+			InstanceUUID: "instance1",
+			MessageUUID:  "message1",
+			WorkerSlot:   &workerSlot,
+			Uptime:       120,
+			Resources:    &ResourceUsage{CpuPercent: 1, MemoryRss: 1},
+			GoroutineLeak: &GoroutineLeakItem{
+				Count:    500,
+				Growth:   100,
+				Creators: []GoroutineCreatorItem{{Function: "main.worker", Count: 400}},
+			},
+		}
+		for i := 0; i < 100; i++ {
+			payload.Requests = append(payload.Requests, RequestsItem{Method: "GET", Path: fmt.Sprintf("/test/%d", i), StatusCode: 200})
+			payload.ServerErrors = append(payload.ServerErrors, ServerErrorsItem{Method: "GET", Path: "/test", Type: "error", Message: fmt.Sprintf("error %d", i)})
+			payload.Timeouts = append(payload.Timeouts, TimeoutsItem{Method: "GET", Path: fmt.Sprintf("/test/%d", i), TimeoutCount: 1})
+			payload.UserAgents = append(payload.UserAgents, UserAgentsItem{Method: "GET", Path: fmt.Sprintf("/test/%d", i), Category: "client", Family: "curl", RequestCount: 1})
+			payload.RateLimits = append(payload.RateLimits, RateLimitsItem{Consumer: fmt.Sprintf("consumer%d", i), RequestCount: 1, LimitedCount: 1})
+			payload.Tasks = append(payload.Tasks, TasksItem{Name: fmt.Sprintf("task%d", i), ExecutionCount: 1, Durations: map[int]int{10: 1}})
+			payload.Messages = append(payload.Messages, MessagesItem{System: "kafka", Queue: fmt.Sprintf("topic%d", i), MessageCount: 1, Durations: map[int]int{10: 1}})
+			payload.Consumers = append(payload.Consumers, ConsumersItem{Consumer: &common.Consumer{Identifier: fmt.Sprintf("consumer%d", i)}})
+			payload.DeletedConsumers = append(payload.DeletedConsumers, fmt.Sprintf("deleted%d", i))
+		}
+
+		maxSize := 2000
+		chunks := splitSyncPayload(payload, maxSize)
+		assert.Greater(t, len(chunks), 1)
+
+		messageUUIDs := map[string]bool{}
+		requestCount, serverErrorCount, timeoutCount, userAgentCount, rateLimitCount, taskCount, messageCount, consumerCount, deletedConsumerCount := 0, 0, 0, 0, 0, 0, 0, 0, 0
+		for i, chunk := range chunks {
+			data, err := json.Marshal(chunk)
+			assert.NoError(t, err)
+			assert.LessOrEqual(t, len(data), maxSize)
+			assert.Equal(t, "instance1", chunk.InstanceUUID)
+			assert.Equal(t, &workerSlot, chunk.WorkerSlot)
+			assert.Equal(t, float64(120), chunk.Uptime)
+			assert.NotNil(t, chunk.Requests)
+			assert.Equal(t, i == 0, chunk.Resources != nil)
+			assert.Equal(t, i == 0, chunk.GoroutineLeak != nil)
+			messageUUIDs[chunk.MessageUUID] = true
+			requestCount += len(chunk.Requests)
+			serverErrorCount += len(chunk.ServerErrors)
+			timeoutCount += len(chunk.Timeouts)
+			userAgentCount += len(chunk.UserAgents)
+			rateLimitCount += len(chunk.RateLimits)
+			taskCount += len(chunk.Tasks)
+			messageCount += len(chunk.Messages)
+			consumerCount += len(chunk.Consumers)
+			deletedConsumerCount += len(chunk.DeletedConsumers)
+		}
+		assert.Equal(t, "message1", chunks[0].MessageUUID)
+		assert.Len(t, messageUUIDs, len(chunks))
+		assert.Equal(t, 100, requestCount)
+		assert.Equal(t, 100, serverErrorCount)
+		assert.Equal(t, 100, timeoutCount)
+		assert.Equal(t, 100, userAgentCount)
+		assert.Equal(t, 100, rateLimitCount)
+		assert.Equal(t, 100, taskCount)
+		assert.Equal(t, 100, messageCount)
+		assert.Equal(t, 100, consumerCount)
+		assert.Equal(t, 100, deletedConsumerCount)
+	})
+}
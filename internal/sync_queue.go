@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncQueue persists sync payloads on disk until they have been sent to the hub, so
+// aggregated metrics survive restarts and extended hub outages. Each payload is stored
+// in a separate file, named after the instance and message UUID.
+type SyncQueue struct {
+	dir       string
+	retention time.Duration
+}
+
+func NewSyncQueue(dir string, retention time.Duration) (*SyncQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sync queue directory: %w", err)
+	}
+	return &SyncQueue{dir: dir, retention: retention}, nil
+}
+
+// Add writes the payload to a file. The file is written to a temporary path first
+// and then renamed, so a crash never leaves a partially written payload behind.
+func (q *SyncQueue) Add(payload SyncPayload) error {
+	if q == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync data: %w", err)
+	}
+
+	tempFile, err := os.CreateTemp(q.dir, ".sync-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempPath := tempFile.Name()
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tempFile.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tempPath, q.getPath(payload)); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the file of the payload, if it exists.
+func (q *SyncQueue) Remove(payload SyncPayload) error {
+	if q == nil {
+		return nil
+	}
+
+	if err := os.Remove(q.getPath(payload)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// Load returns the persisted payloads of the given instance, oldest first. Files older
+// than the retention period are deleted, including those of other instances.
+func (q *SyncQueue) Load(instanceUUID string) ([]SyncPayload, error) {
+	if q == nil {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync queue directory: %w", err)
+	}
+
+	payloads := []SyncPayload{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "sync_") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		path := filepath.Join(q.dir, name)
+		if info, err := entry.Info(); err != nil || time.Since(info.ModTime()) > q.retention {
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasPrefix(name, fmt.Sprintf("sync_%s_", instanceUUID)) {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var payload SyncPayload
+		if err := json.Unmarshal(data, &payload); err != nil {
+			os.Remove(path)
+			continue
+		}
+		payloads = append(payloads, payload)
+	}
+
+	sort.Slice(payloads, func(i, j int) bool {
+		return payloads[i].Timestamp < payloads[j].Timestamp
+	})
+	return payloads, nil
+}
+
+func (q *SyncQueue) getPath(payload SyncPayload) string {
+	return filepath.Join(q.dir, fmt.Sprintf("sync_%s_%s.json", payload.InstanceUUID, payload.MessageUUID))
+}
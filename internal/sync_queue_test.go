@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncQueue(t *testing.T) {
+	t.Run("AddLoadRemove", func(t *testing.T) {
+		dir := t.TempDir()
+		syncQueue, err := NewSyncQueue(dir, time.Hour)
+		assert.NoError(t, err)
+
+		payload1 := SyncPayload{Timestamp: 2, InstanceUUID: "instance1", MessageUUID: "message1"}
+		payload2 := SyncPayload{Timestamp: 1, InstanceUUID: "instance1", MessageUUID: "message2"}
+		payload3 := SyncPayload{Timestamp: 1, InstanceUUID: "instance2", MessageUUID: "message3"}
+		for _, payload := range []SyncPayload{payload1, payload2, payload3} {
+			assert.NoError(t, syncQueue.Add(payload))
+		}
+
+		payloads, err := syncQueue.Load("instance1")
+		assert.NoError(t, err)
+		assert.Len(t, payloads, 2)
+		assert.Equal(t, "message2", payloads[0].MessageUUID)
+		assert.Equal(t, "message1", payloads[1].MessageUUID)
+
+		assert.NoError(t, syncQueue.Remove(payload1))
+		assert.NoError(t, syncQueue.Remove(payload1))
+		payloads, err = syncQueue.Load("instance1")
+		assert.NoError(t, err)
+		assert.Len(t, payloads, 1)
+	})
+
+	t.Run("DiscardExpired", func(t *testing.T) {
+		dir := t.TempDir()
+		syncQueue, err := NewSyncQueue(dir, time.Hour)
+		assert.NoError(t, err)
+
+		payload := SyncPayload{Timestamp: 1, InstanceUUID: "instance1", MessageUUID: "message1"}
+		assert.NoError(t, syncQueue.Add(payload))
+		oldTime := time.Now().Add(-2 * time.Hour)
+		os.Chtimes(syncQueue.getPath(payload), oldTime, oldTime)
+
+		payloads, err := syncQueue.Load("instance2")
+		assert.NoError(t, err)
+		assert.Len(t, payloads, 0)
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 0)
+	})
+
+	t.Run("NilQueue", func(t *testing.T) {
+		var syncQueue *SyncQueue
+		assert.NoError(t, syncQueue.Add(SyncPayload{}))
+		assert.NoError(t, syncQueue.Remove(SyncPayload{}))
+	})
+
+	t.Run("CreateDirectory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "a", "b")
+		_, err := NewSyncQueue(dir, time.Hour)
+		assert.NoError(t, err)
+		assert.DirExists(t, dir)
+	})
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Limits for tags of requests and consumers, so that their cardinality stays bounded.
+// Tags beyond maxTags are dropped, in order of their keys.
+const (
+	maxTags           = 10
+	maxTagKeyLength   = 32
+	maxTagValueLength = 64
+)
+
+// NormalizeTags trims and truncates the keys and values of the tags, and drops tags with
+// an empty key or value as well as tags beyond the maximum number. Nil is returned if
+// no tags remain.
+func NormalizeTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := sortedTagKeys(tags)
+	normalized := make(map[string]string, min(len(tags), maxTags))
+	for _, key := range keys {
+		value := normalizeTagPart(tags[key], maxTagValueLength)
+		key = normalizeTagPart(key, maxTagKeyLength)
+		if key == "" || value == "" {
+			continue
+		}
+		normalized[key] = value
+		if len(normalized) >= maxTags {
+			break
+		}
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// MergeTags returns the normalized union of the given tags, with later tags taking
+// precedence. The given maps are not modified.
+func MergeTags(existing, tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(existing)+len(tags))
+	maps.Copy(merged, existing)
+	maps.Copy(merged, tags)
+	return NormalizeTags(merged)
+}
+
+func normalizeTagPart(s string, maxLength int) string {
+	s = strings.Map(func(r rune) rune {
+		// Control characters are used as separators in tagsKey
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if len(s) > maxLength {
+		s = s[:maxLength]
+	}
+	// The tags may reference memory owned by the framework, e.g. with Fiber
+	return strings.Clone(s)
+}
+
+// tagsKey encodes normalized tags as a string that is unique for the set of tags, so
+// that they can be part of a map key.
+func tagsKey(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, key := range sortedTagKeys(tags) {
+		b.WriteString(key)
+		b.WriteByte(0)
+		b.WriteString(tags[key])
+		b.WriteByte(1)
+	}
+	return b.String()
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
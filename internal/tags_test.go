@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	t.Run("NormalizeTags", func(t *testing.T) {
+		assert.Nil(t, NormalizeTags(nil))
+		assert.Nil(t, NormalizeTags(map[string]string{"": "value", "key": " "}))
+
+		tags := NormalizeTags(map[string]string{
+			" tier ":                "gold\n",
+			strings.Repeat("k", 40): strings.Repeat("v", 100),
+		})
+		assert.Equal(t, map[string]string{
+			"tier":                  "gold",
+			strings.Repeat("k", 32): strings.Repeat("v", 64),
+		}, tags)
+
+		many := map[string]string{}
+		for i := 0; i < 20; i++ {
+			many["key"+strconv.Itoa(i)] = "value"
+		}
+		assert.Len(t, NormalizeTags(many), maxTags)
+	})
+
+	t.Run("MergeTags", func(t *testing.T) {
+		existing := map[string]string{"tier": "free", "region": "eu"}
+		merged := MergeTags(existing, map[string]string{"tier": "gold"})
+		assert.Equal(t, map[string]string{"tier": "gold", "region": "eu"}, merged)
+		assert.Equal(t, "free", existing["tier"])
+	})
+
+	t.Run("TagsKey", func(t *testing.T) {
+		assert.Equal(t, "", tagsKey(nil))
+		assert.Equal(t, tagsKey(map[string]string{"a": "1", "b": "2"}), tagsKey(map[string]string{"b": "2", "a": "1"}))
+		assert.NotEqual(t, tagsKey(map[string]string{"a": "1"}), tagsKey(map[string]string{"a": "2"}))
+	})
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxTaskKeys       = 100
+	maxTaskNameLength = 64
+)
+
+type TasksItem struct {
+	Name           string      `json:"name"`
+	ExecutionCount int         `json:"execution_count"`
+	FailureCount   int         `json:"failure_count"`
+	PanicCount     int         `json:"panic_count"`
+	DurationSum    float64     `json:"duration_sum"`
+	Durations      map[int]int `json:"durations"`
+}
+
+// TaskCounter aggregates executions of background tasks (e.g. cron jobs or queue
+// consumers) by name. Durations are in milliseconds and binned to two significant
+// digits, as tasks may run for anything from milliseconds to hours. Once the maximum
+// number of distinct names is reached, further tasks are counted under "__other__".
+type TaskCounter struct {
+	tasks map[string]*TasksItem
+	mutex sync.Mutex
+}
+
+func NewTaskCounter() *TaskCounter {
+	return &TaskCounter{
+		tasks: make(map[string]*TasksItem),
+	}
+}
+
+// Track runs fn and counts it as an execution of the task with the given name. Errors
+// returned by fn are counted as failures. Panics are counted and re-raised.
+func (tc *TaskCounter) Track(ctx context.Context, name string, fn func(ctx context.Context) error) (err error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		if r := recover(); r != nil {
+			tc.AddTask(name, duration, false, true)
+			panic(r)
+		}
+		tc.AddTask(name, duration, err == nil, false)
+	}()
+	return fn(ctx)
+}
+
+func (tc *TaskCounter) AddTask(name string, duration time.Duration, succeeded, panicked bool) {
+	name = strings.TrimSpace(name)
+	if len(name) > maxTaskNameLength {
+		name = name[:maxTaskNameLength]
+	}
+	durationMs := duration.Seconds() * 1000.0
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	item, ok := tc.tasks[name]
+	if !ok {
+		if len(tc.tasks) >= maxTaskKeys {
+			name = overflowKeyValue
+			item = tc.tasks[name]
+		}
+		if item == nil {
+			item = &TasksItem{Name: name, Durations: make(map[int]int)}
+			tc.tasks[name] = item
+		}
+	}
+
+	item.ExecutionCount++
+	if !succeeded {
+		item.FailureCount++
+	}
+	if panicked {
+		item.PanicCount++
+	}
+	item.DurationSum += durationMs
+	item.Durations[getTaskDurationBin(durationMs)]++
+}
+
+func (tc *TaskCounter) GetAndResetTasks() []TasksItem {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	data := make([]TasksItem, 0, len(tc.tasks))
+	for _, item := range tc.tasks {
+		data = append(data, *item)
+	}
+	tc.tasks = make(map[string]*TasksItem)
+	return data
+}
+
+// getTaskDurationBin rounds the duration in milliseconds down to two significant digits
+// (e.g. 1234 to 1200), so the number of bins stays bounded for any duration.
+func getTaskDurationBin(durationMs float64) int {
+	if durationMs < 100 {
+		return int(math.Floor(durationMs))
+	}
+	scale := math.Pow(10, math.Floor(math.Log10(durationMs))-1)
+	return int(math.Floor(durationMs/scale) * scale)
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskCounter(t *testing.T) {
+	t.Run("Track", func(t *testing.T) {
+		tc := NewTaskCounter()
+		ctx := context.Background()
+
+		err := tc.Track(ctx, "send-emails", func(ctx context.Context) error { return nil })
+		assert.NoError(t, err)
+		err = tc.Track(ctx, "send-emails", func(ctx context.Context) error { return errors.New("smtp unavailable") })
+		assert.EqualError(t, err, "smtp unavailable")
+		assert.Panics(t, func() {
+			tc.Track(ctx, "send-emails", func(ctx context.Context) error { panic("boom") })
+		})
+
+		tasks := tc.GetAndResetTasks()
+		assert.Len(t, tasks, 1)
+		assert.Equal(t, "send-emails", tasks[0].Name)
+		assert.Equal(t, 3, tasks[0].ExecutionCount)
+		assert.Equal(t, 2, tasks[0].FailureCount)
+		assert.Equal(t, 1, tasks[0].PanicCount)
+		assert.Equal(t, 3, tasks[0].Durations[0])
+
+		assert.Empty(t, tc.GetAndResetTasks())
+	})
+
+	t.Run("Durations", func(t *testing.T) {
+		tc := NewTaskCounter()
+		for _, duration := range []time.Duration{
+			50 * time.Millisecond,
+			1234 * time.Millisecond,
+			1299 * time.Millisecond,
+			3*time.Minute + 45*time.Second,
+		} {
+			tc.AddTask("import", duration, true, false)
+		}
+
+		tasks := tc.GetAndResetTasks()
+		assert.Len(t, tasks, 1)
+		assert.Equal(t, map[int]int{50: 1, 1200: 2, 220000: 1}, tasks[0].Durations)
+		assert.InDelta(t, 227583.0, tasks[0].DurationSum, 0.001)
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		tc := NewTaskCounter()
+		for i := 0; i < maxTaskKeys+3; i++ {
+			tc.AddTask("task"+strconv.Itoa(i), time.Millisecond, true, false)
+		}
+
+		tasks := tc.GetAndResetTasks()
+		assert.Len(t, tasks, maxTaskKeys+1)
+		for _, item := range tasks {
+			if item.Name == overflowKeyValue {
+				assert.Equal(t, 3, item.ExecutionCount)
+			}
+		}
+	})
+}
@@ -2,45 +2,99 @@ package internal
 
 import (
 	"compress/gzip"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type TempGzipFile struct {
 	uuid       string
 	filePath   string
 	gzipWriter *gzip.Writer
+	aead       cipher.AEAD
+	encrypter  *encryptWriter
 	file       *os.File
 	size       int64
+	diskSize   int64
 	closed     bool
+	serializer serializer
+	encoder    encoder
+	spooledAt  time.Time
 }
 
-func NewTempGzipFile() (*TempGzipFile, error) {
+// NewTempGzipFile creates a new file in the given directory, or in the default
+// directory for temporary files if dir is empty. Items are encoded using the given
+// serializer. If encrypt is true, the compressed data is encrypted with a key that is
+// only kept in memory.
+func NewTempGzipFile(dir string, encrypt bool, serializer serializer) (*TempGzipFile, error) {
 	uuidBytes := make([]byte, 16)
 	if _, err := rand.Read(uuidBytes); err != nil {
 		return nil, fmt.Errorf("failed to generate UUID: %w", err)
 	}
 	uuid := hex.EncodeToString(uuidBytes)
 
-	filePath := filepath.Join(os.TempDir(), fmt.Sprintf("apitally-%s.gz", uuid))
+	if dir == "" {
+		dir = os.TempDir()
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	filePath := filepath.Join(dir, fmt.Sprintf("apitally-%s.gz", uuid))
 	file, err := os.Create(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 
-	gzipWriter := gzip.NewWriter(file)
-
-	return &TempGzipFile{
+	t := &TempGzipFile{
 		uuid:       uuid,
 		filePath:   filePath,
-		gzipWriter: gzipWriter,
 		file:       file,
 		size:       0,
 		closed:     false,
-	}, nil
+		serializer: serializer,
+	}
+	var w io.Writer = tempGzipFileDiskWriter{t}
+	if encrypt {
+		aead, err := newFileCipher()
+		if err != nil {
+			file.Close()
+			os.Remove(filePath)
+			return nil, err
+		}
+		t.aead = aead
+		t.encrypter = newEncryptWriter(w, aead)
+		w = t.encrypter
+	}
+	t.gzipWriter = gzip.NewWriter(w)
+	t.encoder = serializer.NewEncoder(tempGzipFileWriter{t})
+	return t, nil
+}
+
+// tempGzipFileWriter writes to the gzip writer and keeps track of the uncompressed size.
+type tempGzipFileWriter struct {
+	t *TempGzipFile
+}
+
+func (w tempGzipFileWriter) Write(p []byte) (int, error) {
+	n, err := w.t.gzipWriter.Write(p)
+	w.t.size += int64(n)
+	return n, err
+}
+
+// tempGzipFileDiskWriter writes to the file and keeps track of the compressed size.
+type tempGzipFileDiskWriter struct {
+	t *TempGzipFile
+}
+
+func (w tempGzipFileDiskWriter) Write(p []byte) (int, error) {
+	n, err := w.t.file.Write(p)
+	w.t.diskSize += int64(n)
+	return n, err
 }
 
 func (t *TempGzipFile) WriteLine(data []byte) error {
@@ -51,11 +105,66 @@ func (t *TempGzipFile) WriteLine(data []byte) error {
 	return nil
 }
 
+// WriteItem encodes v directly into the gzip writer using the serializer of the file.
+// This avoids allocating a new byte slice for each item.
+func (t *TempGzipFile) WriteItem(v any) error {
+	if err := t.encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write line: %w", err)
+	}
+	return nil
+}
+
+// WriteEncoded writes an item that was already encoded using the serializer of the file.
+func (t *TempGzipFile) WriteEncoded(data []byte) error {
+	if _, err := (tempGzipFileWriter{t}).Write(data); err != nil {
+		return fmt.Errorf("failed to write item: %w", err)
+	}
+	return nil
+}
+
+// Sync flushes data buffered by the gzip writer to the file and commits the file to
+// stable storage.
+func (t *TempGzipFile) Sync() error {
+	if err := t.gzipWriter.Flush(); err != nil {
+		return fmt.Errorf("failed to flush gzip writer: %w", err)
+	}
+	if t.encrypter != nil {
+		if err := t.encrypter.Flush(); err != nil {
+			return fmt.Errorf("failed to flush encrypted data: %w", err)
+		}
+	}
+	if err := t.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+	return nil
+}
+
+// ContentType returns the content type of the items in the file.
+func (t *TempGzipFile) ContentType() string {
+	return t.serializer.ContentType()
+}
+
 func (t *TempGzipFile) Size() int64 {
 	return t.size
 }
 
-func (t *TempGzipFile) GetReader() (*os.File, error) {
+// DiskSize returns the number of compressed bytes written to disk so far.
+func (t *TempGzipFile) DiskSize() int64 {
+	return t.diskSize
+}
+
+// ContentLength returns the number of bytes returned by GetReader once the file is
+// closed, or -1 if it's unknown because the file is encrypted.
+func (t *TempGzipFile) ContentLength() int64 {
+	if t.aead != nil {
+		return -1
+	}
+	return t.diskSize
+}
+
+// GetReader closes the file and returns a reader for the compressed content. If the
+// file is encrypted, the content is decrypted while reading.
+func (t *TempGzipFile) GetReader() (io.ReadCloser, error) {
 	if err := t.Close(); err != nil {
 		return nil, err
 	}
@@ -64,15 +173,23 @@ func (t *TempGzipFile) GetReader() (*os.File, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file for reading: %w", err)
 	}
+	if t.aead != nil {
+		return struct {
+			io.Reader
+			io.Closer
+		}{newDecryptReader(file, t.aead), file}, nil
+	}
 	return file, nil
 }
 
 func (t *TempGzipFile) GetContent() ([]byte, error) {
-	if err := t.Close(); err != nil {
+	reader, err := t.GetReader()
+	if err != nil {
 		return nil, err
 	}
+	defer reader.Close()
 
-	content, err := os.ReadFile(t.filePath)
+	content, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
@@ -87,6 +204,11 @@ func (t *TempGzipFile) Close() error {
 	if err := t.gzipWriter.Close(); err != nil {
 		return fmt.Errorf("failed to close gzip writer: %w", err)
 	}
+	if t.encrypter != nil {
+		if err := t.encrypter.Close(); err != nil {
+			return fmt.Errorf("failed to close encrypt writer: %w", err)
+		}
+	}
 	if err := t.file.Close(); err != nil {
 		return fmt.Errorf("failed to close file: %w", err)
 	}
@@ -103,3 +225,38 @@ func (t *TempGzipFile) Delete() error {
 	}
 	return nil
 }
+
+// MoveTo closes the file and moves it to the given directory. Files are copied if they
+// can't be renamed, e.g. across file systems.
+func (t *TempGzipFile) MoveTo(dir string) error {
+	if err := t.Close(); err != nil {
+		return err
+	}
+	newPath := filepath.Join(dir, filepath.Base(t.filePath))
+	if err := os.Rename(t.filePath, newPath); err == nil {
+		t.filePath = newPath
+		return nil
+	}
+
+	src, err := os.Open(t.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file for moving: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.Create(newPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(newPath)
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	os.Remove(t.filePath)
+	t.filePath = newPath
+	return nil
+}
@@ -11,7 +11,7 @@ import (
 
 func createTempFile(t *testing.T) *TempGzipFile {
 	t.Helper()
-	file, err := NewTempGzipFile()
+	file, err := NewTempGzipFile("", false, jsonSerializer{})
 	if err != nil {
 		t.Fatalf("Failed to create temp file: %v", err)
 	}
@@ -37,6 +37,73 @@ func TestTempGzipFile(t *testing.T) {
 		}
 	})
 
+	t.Run("CustomDirectory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "logs")
+		file, err := NewTempGzipFile(dir, false, jsonSerializer{})
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer file.Delete()
+
+		if filepath.Dir(file.filePath) != dir {
+			t.Errorf("Expected file in %s, got %s", dir, file.filePath)
+		}
+		if err := file.WriteLine([]byte("test line")); err != nil {
+			t.Fatalf("Failed to write line: %v", err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("Failed to close file: %v", err)
+		}
+		info, err := os.Stat(file.filePath)
+		if err != nil {
+			t.Fatalf("Failed to stat file: %v", err)
+		}
+		if file.DiskSize() != info.Size() {
+			t.Errorf("Expected disk size %d, got %d", info.Size(), file.DiskSize())
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		file, err := NewTempGzipFile("", true, jsonSerializer{})
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer file.Delete()
+
+		if err := file.WriteLine([]byte("secret line")); err != nil {
+			t.Fatalf("Failed to write line: %v", err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatalf("Failed to close file: %v", err)
+		}
+
+		// The data on disk is not a readable gzip stream
+		raw, err := os.ReadFile(file.filePath)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		if _, err := gzip.NewReader(bytes.NewReader(raw)); err == nil {
+			t.Error("Encrypted file should not be readable as gzip")
+		}
+
+		// The content is decrypted when read
+		content, err := file.GetContent()
+		if err != nil {
+			t.Fatalf("Failed to get content: %v", err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to create gzip reader: %v", err)
+		}
+		decompressed, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to decompress content: %v", err)
+		}
+		if !bytes.Equal(decompressed, []byte("secret line\n")) {
+			t.Errorf("Expected content %q, got %q", "secret line\n", decompressed)
+		}
+	})
+
 	t.Run("WriteAndVerifyContent", func(t *testing.T) {
 		file := createTempFile(t)
 		defer file.Delete()
@@ -81,6 +148,67 @@ func TestTempGzipFile(t *testing.T) {
 		}
 	})
 
+	t.Run("WriteItem", func(t *testing.T) {
+		file := createTempFile(t)
+		defer file.Delete()
+
+		if err := file.WriteItem(map[string]string{"key": "value"}); err != nil {
+			t.Fatalf("Failed to write line: %v", err)
+		}
+		if err := file.WriteItem(func() {}); err == nil {
+			t.Error("Expected error when writing unsupported value")
+		}
+
+		expected := []byte("{\"key\":\"value\"}\n")
+		if file.Size() != int64(len(expected)) {
+			t.Errorf("Expected size %d, got %d", len(expected), file.Size())
+		}
+
+		content, err := file.GetContent()
+		if err != nil {
+			t.Fatalf("Failed to get content: %v", err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to create gzip reader: %v", err)
+		}
+		defer reader.Close()
+
+		decompressed, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to read decompressed content: %v", err)
+		}
+		if !bytes.Equal(decompressed, expected) {
+			t.Errorf("Expected content %q, got %q", expected, decompressed)
+		}
+	})
+
+	t.Run("SyncWritesToDisk", func(t *testing.T) {
+		file := createTempFile(t)
+		defer file.Delete()
+
+		if err := file.WriteLine([]byte("first line")); err != nil {
+			t.Fatalf("Failed to write line: %v", err)
+		}
+		if err := file.Sync(); err != nil {
+			t.Fatalf("Failed to sync file: %v", err)
+		}
+
+		// The data is readable from disk before the file is closed
+		content, err := os.ReadFile(file.filePath)
+		if err != nil {
+			t.Fatalf("Failed to read file: %v", err)
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to create gzip reader: %v", err)
+		}
+		decompressed, _ := io.ReadAll(reader)
+		if !bytes.Equal(decompressed, []byte("first line\n")) {
+			t.Errorf("Expected content %q, got %q", "first line\n", decompressed)
+		}
+	})
+
 	t.Run("ErrorOnWriteAfterClose", func(t *testing.T) {
 		file := createTempFile(t)
 		defer file.Delete()
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"sync"
+)
+
+type TimeoutsItem struct {
+	Consumer     string `json:"consumer,omitempty"`
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	TimeoutCount int    `json:"timeout_count"`
+}
+
+type timeoutKey struct {
+	Consumer string
+	Method   string
+	Path     string
+}
+
+// TimeoutCounter counts requests per endpoint whose context deadline was exceeded
+// before the handler finished, separately from server errors.
+type TimeoutCounter struct {
+	timeoutCounts map[timeoutKey]int
+	mutex         sync.Mutex
+}
+
+func NewTimeoutCounter() *TimeoutCounter {
+	return &TimeoutCounter{
+		timeoutCounts: make(map[timeoutKey]int),
+	}
+}
+
+func (tc *TimeoutCounter) AddTimeout(consumer, method, path string) {
+	key := timeoutKey{
+		Consumer: consumer,
+		Method:   method,
+		Path:     path,
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	tc.timeoutCounts[key]++
+}
+
+func (tc *TimeoutCounter) GetAndResetTimeouts() []TimeoutsItem {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	data := make([]TimeoutsItem, 0, len(tc.timeoutCounts))
+	for key, count := range tc.timeoutCounts {
+		data = append(data, TimeoutsItem{
+			Consumer:     key.Consumer,
+			Method:       key.Method,
+			Path:         key.Path,
+			TimeoutCount: count,
+		})
+	}
+
+	tc.timeoutCounts = make(map[timeoutKey]int)
+	return data
+}
@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutCounter(t *testing.T) {
+	t.Run("Aggregation", func(t *testing.T) {
+		timeoutCounter := NewTimeoutCounter()
+
+		timeoutCounter.AddTimeout("test", "GET", "/test")
+		timeoutCounter.AddTimeout("test", "GET", "/test")
+		timeoutCounter.AddTimeout("", "POST", "/test")
+
+		timeouts := timeoutCounter.GetAndResetTimeouts()
+		assert.Len(t, timeouts, 2)
+		assert.ElementsMatch(t, []TimeoutsItem{
+			{Consumer: "test", Method: "GET", Path: "/test", TimeoutCount: 2},
+			{Method: "POST", Path: "/test", TimeoutCount: 1},
+		}, timeouts)
+
+		// Counts are reset
+		assert.Empty(t, timeoutCounter.GetAndResetTimeouts())
+	})
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/apitally/apitally-go/common"
+)
+
+// Classes of synthetic traffic, which dashboards can filter from real usage. Requests
+// without a class are considered real usage.
+const (
+	TrafficClassPreflight   = "preflight"
+	TrafficClassBot         = "bot"
+	TrafficClassHealthCheck = "health_check"
+)
+
+var (
+	healthCheckPathPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)/_?healthz?$`),
+		regexp.MustCompile(`(?i)/_?health[_-]?checks?$`),
+		regexp.MustCompile(`(?i)/_?heart[_-]?beats?$`),
+		regexp.MustCompile(`(?i)/ping$`),
+		regexp.MustCompile(`(?i)/ready$`),
+		regexp.MustCompile(`(?i)/live$`),
+	}
+	healthCheckUserAgentPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)health[-_ ]?check`),
+		regexp.MustCompile(`(?i)microsoft-azure-application-lb`),
+		regexp.MustCompile(`(?i)googlehc`),
+		regexp.MustCompile(`(?i)kube-probe`),
+	}
+)
+
+// ClassifyTraffic returns the traffic class of a request to the given route pattern,
+// or an empty string for real usage. The rules of the config are applied first, then
+// CORS preflight requests, health checks and bots are detected.
+func ClassifyTraffic(config *common.Config, method, path string, header func(key string) string) string {
+	userAgent := header("User-Agent")
+	for _, rule := range config.TrafficClassRules {
+		if rule.Matches(method, path, userAgent) {
+			return rule.Class
+		}
+	}
+
+	if method == http.MethodOptions && header("Origin") != "" && header("Access-Control-Request-Method") != "" {
+		return TrafficClassPreflight
+	}
+	for _, pattern := range healthCheckPathPatterns {
+		if pattern.MatchString(path) {
+			return TrafficClassHealthCheck
+		}
+	}
+	for _, pattern := range healthCheckUserAgentPatterns {
+		if pattern.MatchString(userAgent) {
+			return TrafficClassHealthCheck
+		}
+	}
+	lowerUserAgent := strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if strings.Contains(lowerUserAgent, marker) {
+			return TrafficClassBot
+		}
+	}
+	return ""
+}
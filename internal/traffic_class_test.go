@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/apitally/apitally-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyTraffic(t *testing.T) {
+	classify := func(config *common.Config, method, path string, header http.Header) string {
+		return ClassifyTraffic(config, method, path, header.Get)
+	}
+	config := &common.Config{}
+
+	t.Run("RealUsage", func(t *testing.T) {
+		header := http.Header{"User-Agent": {"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"}}
+		assert.Equal(t, "", classify(config, "GET", "/items", header))
+		assert.Equal(t, "", classify(config, "OPTIONS", "/items", http.Header{}))
+	})
+
+	t.Run("Preflight", func(t *testing.T) {
+		header := http.Header{"Origin": {"https://example.com"}, "Access-Control-Request-Method": {"POST"}}
+		assert.Equal(t, TrafficClassPreflight, classify(config, "OPTIONS", "/items", header))
+	})
+
+	t.Run("HealthCheck", func(t *testing.T) {
+		assert.Equal(t, TrafficClassHealthCheck, classify(config, "GET", "/healthz", http.Header{}))
+		assert.Equal(t, TrafficClassHealthCheck, classify(config, "GET", "/", http.Header{"User-Agent": {"kube-probe/1.29"}}))
+	})
+
+	t.Run("Bot", func(t *testing.T) {
+		header := http.Header{"User-Agent": {"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"}}
+		assert.Equal(t, TrafficClassBot, classify(config, "GET", "/items", header))
+	})
+
+	t.Run("Rules", func(t *testing.T) {
+		config := &common.Config{
+			TrafficClassRules: []common.TrafficClassRule{
+				{Class: "synthetic", UserAgent: regexp.MustCompile(`^Checkly/`)},
+				{Class: "", Method: "get", Path: regexp.MustCompile(`^/ping$`)},
+			},
+		}
+		assert.Equal(t, "synthetic", classify(config, "GET", "/items", http.Header{"User-Agent": {"Checkly/1.0"}}))
+		assert.Equal(t, "", classify(config, "GET", "/ping", http.Header{}))
+		assert.Equal(t, TrafficClassHealthCheck, classify(config, "HEAD", "/ping", http.Header{}))
+	})
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	maxUserAgentKeys          = 1_000
+	maxUserAgentVersionLength = 32
+)
+
+// User agent categories
+const (
+	userAgentBrowser = "browser"
+	userAgentBot     = "bot"
+	userAgentClient  = "client"
+	userAgentOther   = "other"
+)
+
+type UserAgentsItem struct {
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	Category     string `json:"category"`
+	Family       string `json:"family"`
+	Version      string `json:"version,omitempty"`
+	RequestCount int    `json:"request_count"`
+}
+
+type userAgentKey struct {
+	Method   string
+	Path     string
+	Category string
+	Family   string
+	Version  string
+}
+
+// UserAgentCounter counts requests per endpoint by user agent family (e.g. Chrome,
+// curl or an API client SDK) and version. Browser versions are reduced to the major
+// version. Once the maximum number of distinct keys is reached, further user agents
+// are counted under the family "__other__".
+type UserAgentCounter struct {
+	userAgentCounts map[userAgentKey]int
+	mutex           sync.Mutex
+}
+
+func NewUserAgentCounter() *UserAgentCounter {
+	return &UserAgentCounter{
+		userAgentCounts: make(map[userAgentKey]int),
+	}
+}
+
+func (uc *UserAgentCounter) AddUserAgent(method, path, userAgent string) {
+	category, family, version := parseUserAgent(userAgent)
+	key := userAgentKey{
+		Method:   method,
+		Path:     path,
+		Category: category,
+		// The user agent may reference memory owned by the framework, e.g. with Fiber
+		Family:  strings.Clone(family),
+		Version: strings.Clone(version),
+	}
+
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	if _, ok := uc.userAgentCounts[key]; !ok && len(uc.userAgentCounts) >= maxUserAgentKeys {
+		key = userAgentKey{Method: method, Path: path, Category: userAgentOther, Family: overflowKeyValue}
+	}
+	uc.userAgentCounts[key]++
+}
+
+func (uc *UserAgentCounter) GetAndResetUserAgents() []UserAgentsItem {
+	uc.mutex.Lock()
+	defer uc.mutex.Unlock()
+
+	data := make([]UserAgentsItem, 0, len(uc.userAgentCounts))
+	for key, count := range uc.userAgentCounts {
+		data = append(data, UserAgentsItem{
+			Method:       key.Method,
+			Path:         key.Path,
+			Category:     key.Category,
+			Family:       key.Family,
+			Version:      key.Version,
+			RequestCount: count,
+		})
+	}
+
+	uc.userAgentCounts = make(map[userAgentKey]int)
+	return data
+}
+
+var (
+	botMarkers = []string{"bot", "crawler", "spider", "slurp", "scraper"}
+
+	// Browsers are identified by the first matching product token, as most browsers
+	// also include the tokens of the browsers they are based on
+	browserTokens = []struct {
+		token  string
+		family string
+	}{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Version/", "Safari"},
+	}
+)
+
+// parseUserAgent returns the category, family and version of a User-Agent header,
+// using simple heuristics rather than a full database of user agents.
+func parseUserAgent(userAgent string) (category, family, version string) {
+	userAgent = strings.TrimSpace(userAgent)
+	if userAgent == "" {
+		return userAgentOther, "", ""
+	}
+
+	lowerUserAgent := strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if i := strings.Index(lowerUserAgent, marker); i >= 0 {
+			family, version = getProductAt(userAgent, i)
+			return userAgentBot, family, version
+		}
+	}
+
+	if strings.HasPrefix(userAgent, "Mozilla/") {
+		for _, browser := range browserTokens {
+			if i := strings.Index(userAgent, browser.token); i >= 0 {
+				_, version = getProductAt(userAgent, i)
+				major, _, _ := strings.Cut(version, ".")
+				return userAgentBrowser, browser.family, major
+			}
+		}
+		return userAgentOther, "Mozilla", ""
+	}
+
+	// Other user agents usually start with the name and version of the client library
+	// or SDK, e.g. "curl/8.4.0" or "python-requests/2.31.0"
+	family, version = getProductAt(userAgent, 0)
+	return userAgentClient, family, version
+}
+
+// getProductAt returns the name and version of the product token ("name/version")
+// that contains the given index.
+func getProductAt(userAgent string, i int) (name, version string) {
+	start := strings.LastIndexAny(userAgent[:i], " ;(,") + 1
+	end := len(userAgent)
+	if j := strings.IndexAny(userAgent[i:], " ;)(,"); j >= 0 {
+		end = i + j
+	}
+	name, version, _ = strings.Cut(userAgent[start:end], "/")
+	if len(version) > maxUserAgentVersionLength {
+		version = version[:maxUserAgentVersionLength]
+	}
+	return name, version
+}
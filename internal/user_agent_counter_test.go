@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentCounter(t *testing.T) {
+	t.Run("ParseUserAgent", func(t *testing.T) {
+		tests := []struct {
+			userAgent string
+			category  string
+			family    string
+			version   string
+		}{
+			{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36", "browser", "Chrome", "120"},
+			{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.77", "browser", "Edge", "120"},
+			{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "browser", "Firefox", "121"},
+			{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15", "browser", "Safari", "17"},
+			{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot", "Googlebot", "2.1"},
+			{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php) crawler", "bot", "crawler", ""},
+			{"curl/8.4.0", "client", "curl", "8.4.0"},
+			{"python-requests/2.31.0", "client", "python-requests", "2.31.0"},
+			{"Go-http-client/1.1", "client", "Go-http-client", "1.1"},
+			{"my-sdk/1.2.3 (linux; go1.22)", "client", "my-sdk", "1.2.3"},
+			{"SomeClient", "client", "SomeClient", ""},
+			{"", "other", "", ""},
+		}
+
+		for _, tt := range tests {
+			category, family, version := parseUserAgent(tt.userAgent)
+			assert.Equal(t, tt.category, category, tt.userAgent)
+			assert.Equal(t, tt.family, family, tt.userAgent)
+			assert.Equal(t, tt.version, version, tt.userAgent)
+		}
+	})
+
+	t.Run("Aggregation", func(t *testing.T) {
+		userAgentCounter := NewUserAgentCounter()
+
+		userAgentCounter.AddUserAgent("GET", "/test", "curl/8.4.0")
+		userAgentCounter.AddUserAgent("GET", "/test", "curl/8.4.0")
+		userAgentCounter.AddUserAgent("POST", "/test", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+
+		userAgents := userAgentCounter.GetAndResetUserAgents()
+		assert.ElementsMatch(t, []UserAgentsItem{
+			{Method: "GET", Path: "/test", Category: "client", Family: "curl", Version: "8.4.0", RequestCount: 2},
+			{Method: "POST", Path: "/test", Category: "browser", Family: "Firefox", Version: "121", RequestCount: 1},
+		}, userAgents)
+
+		// Counts are reset
+		assert.Empty(t, userAgentCounter.GetAndResetUserAgents())
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		userAgentCounter := NewUserAgentCounter()
+		for i := 0; i < maxUserAgentKeys+5; i++ {
+			userAgentCounter.AddUserAgent("GET", "/test", "client/"+strconv.Itoa(i))
+		}
+
+		userAgents := userAgentCounter.GetAndResetUserAgents()
+		assert.Len(t, userAgents, maxUserAgentKeys+1)
+		for _, item := range userAgents {
+			if item.Family == overflowKeyValue {
+				assert.Equal(t, 5, item.RequestCount)
+			}
+		}
+	})
+}
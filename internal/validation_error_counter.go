@@ -60,12 +60,31 @@ func (vc *ValidationErrorCounter) AddValidationError(consumer, method, path stri
 	vc.errorCounts[key]++
 }
 
+// GetValidationErrors returns the validation errors counted since the last reset,
+// without resetting them.
+func (vc *ValidationErrorCounter) GetValidationErrors() []ValidationErrorsItem {
+	vc.mutex.Lock()
+	defer vc.mutex.Unlock()
+
+	return vc.items()
+}
+
 func (vc *ValidationErrorCounter) GetAndResetValidationErrors() []ValidationErrorsItem {
 	vc.mutex.Lock()
 	defer vc.mutex.Unlock()
 
-	data := make([]ValidationErrorsItem, 0, len(vc.errorCounts))
+	data := vc.items()
+
+	// Reset all maps
+	vc.errorCounts = make(map[string]int)
+	vc.errorDetails = make(map[string]ValidationErrorsItem)
 
+	return data
+}
+
+// items returns the counted validation errors. The caller must hold the mutex.
+func (vc *ValidationErrorCounter) items() []ValidationErrorsItem {
+	data := make([]ValidationErrorsItem, 0, len(vc.errorCounts))
 	for key, count := range vc.errorCounts {
 		if details, exists := vc.errorDetails[key]; exists {
 			item := details
@@ -73,10 +92,5 @@ func (vc *ValidationErrorCounter) GetAndResetValidationErrors() []ValidationErro
 			data = append(data, item)
 		}
 	}
-
-	// Reset all maps
-	vc.errorCounts = make(map[string]int)
-	vc.errorDetails = make(map[string]ValidationErrorsItem)
-
 	return data
 }